@@ -15,6 +15,7 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/sekerez/polka/balancer/src/service"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
@@ -83,8 +84,20 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Load the API keys of participants, checked before forwarding their requests
+	keys, err := utils.LoadKeyring(os.Getenv("KEYSPATH"))
+	if err != nil {
+		logger.Fatalf("Could not load API keys: %s", err)
+	}
+
+	// Load the certificates for mutual TLS, if configured
+	tlsFiles, err := utils.TLSFromEnv()
+	if err != nil {
+		logger.Fatalf("Could not load TLS certificates: %s", err)
+	}
+
 	// Initialize service
-	s, err := service.New(u, apiUrls, ctx)
+	s, err := service.New(u, apiUrls, ctx, keys, tlsFiles)
 	if err != nil {
 		logger.Fatalf("Failed to initialize service: %s", err)
 	}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"log"
 	"net"
@@ -38,9 +39,14 @@ type apiPool struct {
 	counter  uint64 // The number of transactions forwarded
 }
 
-// add adds an api node to the apiPool.
-func (pool *apiPool) add(apiUrl *url.URL) {
+// add adds an api node to the apiPool, reached over TLS if tlsConfig isn't nil.
+func (pool *apiPool) add(apiUrl *url.URL, tlsConfig *tls.Config) {
 	proxy := httputil.NewSingleHostReverseProxy(apiUrl)
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		proxy.Transport = transport
+	}
 	// proxy.ErrorHandler = proxyErrorFunc(proxy, apiUrl)
 	log.Printf("%+v", apiUrl)
 
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -10,8 +12,12 @@ import (
 	"os"
 	"sync/atomic"
 	"time"
+
+	"github.com/sekerez/polka/utils"
 )
 
+const helloPath = "/hello"
+
 const (
 	checkTimeout       = 2 * time.Second
 	Attempts     uint8 = iota
@@ -20,6 +26,9 @@ const (
 
 var pool *apiPool
 
+// errUnavailable is answered when no receiver can take a request.
+var errUnavailable = errors.New("service not available")
+
 // Service manages the main application functions.
 type Service struct {
 	logger      *log.Logger
@@ -30,8 +39,11 @@ type Service struct {
 	checkIsDone chan struct{}
 }
 
-// New returns an uninitialized http service.
-func New(lbUrl *url.URL, apiUrls []*url.URL, ctx context.Context) (*Service, error) {
+// New returns an uninitialized http service. Only requests signed with
+// the keys of the keyring are forwarded, except for hello requests. With TLS
+// files, connections are only accepted from banks and operators over mutual
+// TLS, and requests are forwarded to the receivers over mutual TLS.
+func New(lbUrl *url.URL, apiUrls []*url.URL, ctx context.Context, keys *utils.Keyring, tlsFiles *utils.TLSFiles) (*Service, error) {
 
 	logger := log.New(os.Stderr, "[service] ", log.LstdFlags|log.Lshortfile)
 
@@ -42,7 +54,7 @@ func New(lbUrl *url.URL, apiUrls []*url.URL, ctx context.Context) (*Service, err
 
 	// Set up server
 	server := &http.Server{
-		Handler: http.HandlerFunc(handle),
+		Handler: utils.RequestIds(keys.Middleware(http.HandlerFunc(handle), helloPath)),
 		Addr:    port,
 	}
 
@@ -57,12 +69,15 @@ func New(lbUrl *url.URL, apiUrls []*url.URL, ctx context.Context) (*Service, err
 	if err != nil {
 		return nil, err
 	}
+	if tlsFiles != nil {
+		listener = tls.NewListener(listener, tlsFiles.ServerConfig(utils.RoleBank, utils.RoleOperator))
+	}
 
 	// Set up api servers after initializing apiPool
 	pool = &apiPool{}
 	for _, u := range apiUrls {
 		logger.Printf("url: host: %v, port: %v", u.Host, u.Port())
-		pool.add(u)
+		pool.add(u, tlsFiles.ClientConfig(utils.PeerReceiver))
 	}
 
 	// Where is the channel created?
@@ -83,20 +98,20 @@ func New(lbUrl *url.URL, apiUrls []*url.URL, ctx context.Context) (*Service, err
 func handle(w http.ResponseWriter, r *http.Request) {
 	if len(pool.apiNodes) == 0 {
 		log.Printf("Tried to handle request without any apis available.")
-		http.Error(w, "Service not available", http.StatusServiceUnavailable)
+		utils.WriteError(w, r, http.StatusServiceUnavailable, errUnavailable)
 		return
 	}
 
 	attempts := getAttempts(r)
 	if attempts > 3 {
 		log.Printf("%s(%s) Max attempts reached, terminating\n", r.RemoteAddr, r.URL.Path)
-		http.Error(w, "Service not available", http.StatusServiceUnavailable)
+		utils.WriteError(w, r, http.StatusServiceUnavailable, errUnavailable)
 		return
 	}
 
 	api, err := pool.nextApi()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		utils.WriteError(w, r, http.StatusServiceUnavailable, err)
 		log.Printf("Cannot provide service: %s", err.Error())
 		return
 	}
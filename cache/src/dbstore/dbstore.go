@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
@@ -12,7 +14,12 @@ import (
 	"github.com/sekerez/polka/utils"
 )
 
-const envPath = "env/postgres.env"
+const (
+	envPath             = "env/postgres.env"
+	bankRefreshInterval = 5 * time.Second
+	pruneInterval       = time.Hour
+	dedupWindow         = 72 * time.Hour // How long applied ids are kept, as in the memstore
+)
 
 var db *DB // Create singleton DB
 
@@ -23,17 +30,18 @@ type DB struct {
 	conn     *pgxpool.Pool
 	quit     chan bool
 	bankId   map[string]uint16
-	bankChan <-chan *utils.BankBalance
-	accChan  <-chan *utils.Balance
+	backups  <-chan *utils.Backup
+	newBanks chan<- *utils.BankBalance
 }
 
 func New(
 	ctx context.Context,
 	bankNumChan chan<- uint16,
-	bankChan <-chan *utils.BankBalance,
-	accChan <-chan *utils.Balance,
+	backupChan <-chan *utils.Backup,
 	bankRetChan chan<- *utils.BankBalance,
 	accRetChan chan<- *utils.Balance,
+	appliedRetChan chan<- *utils.Applied,
+	newBankChan chan<- *utils.BankBalance,
 ) error {
 
 	var (
@@ -41,8 +49,10 @@ func New(
 		bankId      uint16
 		bankNum     uint16
 		account     uint32
-		bankBalance int64
-		accBalance  int32
+		bankBalance utils.Money
+		accBalance  utils.Money
+		accStatus   string
+		currency    string
 	)
 
 	logger := log.New(os.Stderr, "[postgres] ", log.LstdFlags|log.Lshortfile)
@@ -75,8 +85,8 @@ func New(
 		path:     path,
 		conn:     conn,
 		logger:   logger,
-		accChan:  accChan,
-		bankChan: bankChan,
+		backups:  backupChan,
+		newBanks: newBankChan,
 		quit:     make(chan bool),
 	}
 
@@ -102,16 +112,17 @@ func New(
 	}
 	// Iterate through banks rows and send to memcache through channel
 	for rows.Next() {
-		err = rows.Scan(&bankId, &bankName, &bankBalance)
+		err = rows.Scan(&bankId, &bankName, &currency, &bankBalance)
 		if err != nil {
 			db.logger.Printf("Could not retrieve bank balance row: %s", err)
 			return err
 		}
 
 		bankRetChan <- &utils.BankBalance{
-			Name:    bankName,
-			BankId:  bankId,
-			Balance: bankBalance,
+			Name:     bankName,
+			BankId:   bankId,
+			Currency: currency,
+			Balance:  bankBalance,
 		}
 	}
 	close(bankRetChan)
@@ -127,7 +138,7 @@ func New(
 	}
 	// Iterate through accounts rows and send to memcache through channel
 	for rows.Next() {
-		err = rows.Scan(&bankName, &account, &accBalance)
+		err = rows.Scan(&bankName, &account, &currency, &accBalance, &accStatus)
 		if err != nil {
 			db.logger.Printf("Could not retrieve account balance row: %s", err)
 			return err
@@ -136,54 +147,205 @@ func New(
 		accRetChan <- &utils.Balance{
 			BankName: bankName,
 			Account:  account,
+			Currency: currency,
 			Balance:  accBalance,
+			Status:   accStatus,
 		}
 	}
 	close(accRetChan)
 
+	// Restore the ids of the updates applied in the dedup window, and of those not settled
+	applied, err := retrieveApplied()
+	if err != nil {
+		db.logger.Fatalf("Could not retrieve applied updates: %s", err)
+		return err
+	}
+	appliedRetChan <- applied
+
 	// Set up periodic update
 	go updateDatabase()
 
+	// Look for banks registered after startup
+	go refreshBanks()
+
 	return nil
 }
 
-// updateDatabase is a goroutine that awaits bank and account backups from memstore
-// and submits them to the database.
+// refreshBanks is a goroutine that periodically sends all banks to the memstore,
+// which allocates the ones it doesn't know yet. It runs apart from updateDatabase,
+// since the memstore might be blocked sending it backups.
+func refreshBanks() {
+	var (
+		bankId   uint16
+		bankName string
+	)
+
+	ticker := time.NewTicker(bankRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.quit:
+			return
+		case <-ticker.C:
+		}
+
+		rows, err := db.conn.Query(db.ctx, bankListQ)
+		if err != nil {
+			db.logger.Printf("Could not refresh banks: %s", err)
+			continue
+		}
+		banks := make([]*utils.BankBalance, 0)
+		for rows.Next() {
+			if err = rows.Scan(&bankId, &bankName); err != nil {
+				db.logger.Printf("Could not refresh bank row: %s", err)
+				break
+			}
+			banks = append(banks, &utils.BankBalance{BankId: bankId, Name: bankName})
+		}
+		rows.Close()
+
+		for _, bnk := range banks {
+			select {
+			case db.newBanks <- bnk:
+			case <-db.quit:
+				return
+			}
+		}
+	}
+}
+
+// updateDatabase is a goroutine that awaits backups from memstore and
+// submits them to the database, pruning applied ids out of the dedup window.
 func updateDatabase() {
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
 	for {
 		select {
 		// In case of a quit message, end the goroutine
 		case <-db.quit:
 			return
-		// In case of a bank balance, update the banks table
-		case bankBalance := <-db.bankChan:
-			_, err := db.conn.Exec(
-				db.ctx,
-				updateBankBalanceQ,
-				bankBalance.BankId,
-				bankBalance.Balance,
-			)
+		// In case of a backup, write it and send back the outcome
+		case backup := <-db.backups:
+			backup.Done <- writeBackup(backup)
+		case <-pruneTicker.C:
+			_, err := db.conn.Exec(db.ctx, pruneAppliedQ, dedupWindow.Milliseconds())
 			if err != nil {
-				db.logger.Printf("Error updating database: %s", err)
-			}
-		// In case of a account balance, update the accounts table
-		case accBalance := <-db.accChan:
-			_, err := db.conn.Exec(
-				db.ctx,
-				updateAccBalanceQ,
-				accBalance.BankId,
-				accBalance.Account,
-				accBalance.Balance,
-			)
-			if err != nil {
-				db.logger.Printf("Error updating database: %s", err)
+				db.logger.Printf("Error pruning applied updates: %s", err)
 			}
 		}
 	}
 }
 
+// writeBackup writes the balances of a backup and its applied ids
+// in a single transaction.
+func writeBackup(backup *utils.Backup) error {
+
+	tx, err := db.conn.Begin(db.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(db.ctx) // No-op after commit
+
+	// Update the banks table
+	for _, bankBalance := range backup.Banks {
+		_, err = tx.Exec(
+			db.ctx,
+			updateBankBalanceQ,
+			bankBalance.BankId,
+			bankBalance.Currency,
+			bankBalance.Balance,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update the accounts table
+	for _, accBalance := range backup.Accounts {
+		_, err = tx.Exec(
+			db.ctx,
+			updateAccBalanceQ,
+			accBalance.BankId,
+			accBalance.Account,
+			accBalance.Currency,
+			accBalance.Balance,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(backup.Applied) > 0 {
+		if _, err = tx.Exec(db.ctx, insertAppliedQ, backup.Applied, backup.Unsettled); err != nil {
+			return err
+		}
+	}
+	if len(backup.Settled) > 0 {
+		if _, err = tx.Exec(db.ctx, settleAppliedQ, backup.Settled); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(db.ctx)
+}
+
+// retrieveApplied returns when each update id in the dedup window was
+// applied, and the ids not settled yet in the order they were applied.
+func retrieveApplied() (*utils.Applied, error) {
+	var (
+		id        string
+		appliedAt time.Time
+		unsettled bool
+	)
+
+	rows, err := db.conn.Query(db.ctx, appliedRetrieveQ, dedupWindow.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := &utils.Applied{Ids: make(map[string]time.Time)}
+	for rows.Next() {
+		if err = rows.Scan(&id, &appliedAt, &unsettled); err != nil {
+			return nil, err
+		}
+		applied.Ids[id] = appliedAt
+		if unsettled {
+			applied.Unsettled = append(applied.Unsettled, id)
+		}
+	}
+
+	return applied, rows.Err()
+}
+
+// GetRates returns the rates converting each currency to the settlement currency.
+func GetRates(ctx context.Context) (map[string]*big.Rat, error) {
+	var currency, raw string
+
+	rows, err := db.conn.Query(ctx, ratesRetrieveQ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]*big.Rat)
+	for rows.Next() {
+		if err = rows.Scan(&currency, &raw); err != nil {
+			return nil, err
+		}
+		rates[currency], err = utils.ParseRate(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rates, rows.Err()
+}
+
 func Close() (err error) {
-	db.quit <- true
+	close(db.quit) // Stops both updateDatabase and refreshBanks
 	db.conn.Close()
 	return
 }
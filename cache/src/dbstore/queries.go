@@ -2,29 +2,77 @@ package dbstore
 
 const (
 	bankNumQ      = "SELECT COUNT(*) FROM banks;"
-	bankRetrieveQ = "SELECT id, name, balance FROM banks;"
-	accRetrieveQ  = `
+	bankListQ     = "SELECT id, name FROM banks;"
+	bankRetrieveQ = `
+		SELECT 	banks.id,
+				banks.name,
+				COALESCE(bank_positions.currency, ''),
+				COALESCE(bank_positions.balance, 0)
+		FROM banks
+		LEFT JOIN bank_positions ON
+		bank_positions.bank_id = banks.id;
+	`
+	accRetrieveQ = `
 		SELECT 	banks.name, 
 				accounts.account, 
-				accounts.balance
+				COALESCE(account_positions.currency, ''),
+				COALESCE(account_positions.balance, 0),
+				accounts.status
 		FROM banks
 		JOIN accounts ON 
-		accounts.bank_id = banks.id;
+		accounts.bank_id = banks.id
+		LEFT JOIN account_positions ON
+		account_positions.bank_id = accounts.bank_id
+		AND account_positions.account = accounts.account;
 	`
-	updateBankBalanceQ = "UPDATE banks SET balance = $2 WHERE id = $1;"
-	updateAccBalanceQ  = `
-		INSERT INTO accounts (
+	ratesRetrieveQ     = "SELECT currency, rate::text FROM fx_rates;"
+	updateBankBalanceQ = `
+		INSERT INTO bank_positions (
 			bank_id,
-			account,
+			currency,
 			balance
 		) VALUES (
 			$1,
 			$2,
 			$3
+		)
+		ON CONFLICT (bank_id, currency)
+		DO UPDATE SET balance = $3;
+	`
+	updateAccBalanceQ = `
+		INSERT INTO account_positions (
+			bank_id,
+			account,
+			currency,
+			balance
+		) VALUES (
+			$1,
+			$2,
+			$3,
+			$4
 		) 
-		ON CONFLICT (account, bank_id)
-		DO UPDATE SET balance = $3
-		WHERE accounts.bank_id = $1
-		AND accounts.account = $2;
+		ON CONFLICT (bank_id, account, currency)
+		DO UPDATE SET balance = $4;
+	`
+	// Ids are written by every backup holding the updates that applied them
+	insertAppliedQ = `
+		INSERT INTO applied_updates (update_id, unsettled)
+		SELECT id, id = ANY($2::text[]) FROM unnest($1::text[]) WITH ORDINALITY AS applied(id, n)
+		ORDER BY n
+		ON CONFLICT (update_id) DO NOTHING;
+	`
+	settleAppliedQ = `
+		UPDATE applied_updates SET unsettled = FALSE
+		WHERE update_id = ANY($1::text[]);
+	`
+	// Unsettled ids are kept past the dedup window, until settled
+	appliedRetrieveQ = `
+		SELECT update_id, applied_at, unsettled FROM applied_updates
+		WHERE applied_at > NOW() - $1 * INTERVAL '1 millisecond' OR unsettled
+		ORDER BY seq;
+	`
+	pruneAppliedQ = `
+		DELETE FROM applied_updates
+		WHERE applied_at <= NOW() - $1 * INTERVAL '1 millisecond' AND NOT unsettled;
 	`
 )
@@ -56,21 +56,23 @@ func main() {
 
 	// Initialize cache and DB connection
 	bankNumChan := make(chan uint16)
-	bankBalancesChannel := make(chan *utils.BankBalance)
-	accountBalancesChannel := make(chan *utils.Balance) // For the cache to send data to the db.
+	backupChannel := make(chan *utils.Backup) // For the cache to send data to the db.
 
 	bankRetreivalChannel := make(chan *utils.BankBalance)
 	accountRetreivalChannel := make(chan *utils.Balance) // To retreive balances from db.
+	appliedRetreivalChannel := make(chan *utils.Applied)
+	newBankChannel := make(chan *utils.BankBalance) // To add banks registered at runtime.
 
 	// The dbstore must be initialized concurrently to correctly update the cache with retreived db balances.
 	go func() {
 		err = dbstore.New(
 			ctx,
 			bankNumChan,
-			bankBalancesChannel,
-			accountBalancesChannel,
+			backupChannel,
 			bankRetreivalChannel,
 			accountRetreivalChannel,
+			appliedRetreivalChannel,
+			newBankChannel,
 		)
 		if err != nil {
 			logger.Fatalf("Could not init DB connection: %s", err)
@@ -81,14 +83,27 @@ func main() {
 	memstore.New(
 		ctx,
 		bankNumChan,
-		bankBalancesChannel,
-		accountBalancesChannel,
+		backupChannel,
 		bankRetreivalChannel,
 		accountRetreivalChannel,
+		appliedRetreivalChannel,
+		newBankChannel,
 	)
 
+	// Load the API keys of the services allowed to update balances, and of operators
+	keys, err := utils.LoadKeyring(os.Getenv("KEYSPATH"))
+	if err != nil {
+		logger.Fatalf("Could not load API keys: %s", err)
+	}
+
+	// Load the certificates for mutual TLS, if configured
+	tlsFiles, err := utils.TLSFromEnv()
+	if err != nil {
+		logger.Fatalf("Could not load TLS certificates: %s", err)
+	}
+
 	// Initialize service
-	s, err := service.New(u, ctx)
+	s, err := service.New(u, ctx, keys, tlsFiles, os.Getenv("GRPCPORT"))
 	if err != nil {
 		logger.Fatalf("Failed to initialize service: %s", err)
 	}
@@ -2,6 +2,7 @@ package memstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +15,9 @@ import (
 
 const (
 	backupInterval = time.Duration(1) * time.Second
+	backupTimeout  = 5 * time.Second // How long updates wait for their backup
+	pruneInterval  = time.Hour
+	dedupWindow    = 72 * time.Hour // How long applied ids are remembered
 )
 
 var (
@@ -21,15 +25,21 @@ var (
 	counter uint64
 )
 
+// ErrNotBackedUp is returned for updates that were applied, but whose backup
+// failed or is late. Retrying them is safe, since they're only applied once,
+// and succeeds once a backup holding them commits.
+var ErrNotBackedUp = errors.New("update not backed up yet")
+
 // Positive values are owed by Polka to the bank,
 // Negative values are owed by the bank to Polka.
 type cache struct {
 	Ctx            context.Context
-	List           *circularLinkedList
 	Snap           *utils.Snapshot // Snap is an option, it's nil if no snapshot has been taken
 	Chans          *channels
 	Logger         *log.Logger
 	Balances       *balancesRW
+	Applied        *appliedIds
+	Backups        *backups
 	BackupInterval time.Duration
 }
 
@@ -37,8 +47,8 @@ type cache struct {
 type channels struct {
 	Quit     chan bool
 	Done     chan bool
-	AccChan  chan<- *utils.Balance
-	BankChan chan<- *utils.BankBalance
+	Backups  chan<- *utils.Backup
+	NewBanks <-chan *utils.BankBalance
 }
 
 type balancesRW struct {
@@ -46,10 +56,52 @@ type balancesRW struct {
 	Banks map[string]*bank
 }
 
+// appliedIds records when each update id was applied, so that
+// updates delivered more than once only change balances once.
+// Unsettled holds the ids of payment and batch updates applied
+// since the last settlement, in order.
+type appliedIds struct {
+	sync.Mutex
+	Mp        map[string]time.Time
+	Unsettled []string
+}
+
+// backups holds the changes made since the last backup: the banks whose
+// balances changed, the ids applied, those of them to settle, and the ids
+// settled. Updates are only acknowledged once the backup holding their
+// changes commits.
+type backups struct {
+	sync.Mutex
+	Banks     map[*bank]bool
+	Applied   []string
+	Unsettled []string
+	Settled   []string
+	Result    *backupResult // Result of the next backup
+	Wake      chan struct{}
+}
+
+// backupResult is the outcome of a backup, set before Done is closed.
+type backupResult struct {
+	Done chan struct{}
+	Err  error
+}
+
 // bank stores data relevant to each bank, including accounts.
 // bank is only used in the main cache, not in any snapshot.
 type bank struct {
-	Id      uint16
+	Id        uint16
+	Positions *positions
+	Statuses  *statuses
+}
+
+// positions maps currency codes to a bank's position in each currency.
+type positions struct {
+	sync.RWMutex
+	Mp map[string]*position
+}
+
+// position holds a bank's balance and its accounts' balances in a single currency.
+type position struct {
 	Balance *int64
 	Accs    *accounts
 }
@@ -57,28 +109,38 @@ type bank struct {
 // accounts maps account ids to pointers to balances.
 type accounts struct {
 	sync.RWMutex
-	Mp map[uint32]*int32
+	Mp map[uint32]*int64
+}
+
+// delta is a change to a balance, applied atomically and only if it doesn't overflow.
+type delta struct {
+	balance *int64
+	amount  utils.Money
+}
+
+// statuses maps account ids to their status, as long as it's known.
+type statuses struct {
+	sync.RWMutex
+	Mp map[uint32]string
 }
 
 // New initializes the cache struct.
 func New(
 	ctx context.Context,
 	bankNumChan <-chan uint16,
-	banksChan chan<- *utils.BankBalance, // Channel to send bank balances
-	accountsChan chan<- *utils.Balance, // Channel to send account balances
+	backupChan chan<- *utils.Backup, // Channel to send backups
 	bankRetChan <-chan *utils.BankBalance, // Channel to retrieve bank balances
 	accRetChan <-chan *utils.Balance, // Channel to retrieve account balances
+	appliedRetChan <-chan *utils.Applied, // Channel to retrieve applied ids
+	newBankChan <-chan *utils.BankBalance, // Channel to receive banks registered at runtime
 ) {
 
 	logger := log.New(os.Stderr, "[cache] ", log.LstdFlags|log.Lshortfile)
 
-	// Initialize circular linked list.
-	list := newCircularLinkedList()
-
 	// Initialize channels struct.
 	chans := &channels{
-		BankChan: banksChan,
-		AccChan:  accountsChan,
+		Backups:  backupChan,
+		NewBanks: newBankChan,
 		Quit:     make(chan bool),
 		Done:     make(chan bool),
 	}
@@ -92,11 +154,11 @@ func New(
 	// Assign Cache singleton
 	c = cache{
 		Ctx:            ctx,
-		List:           list,
 		Snap:           nil, // Snapshot is nil because it wasn't taken
 		Chans:          chans,
 		Logger:         logger,
 		Balances:       balances,
+		Backups:        newBackups(),
 		BackupInterval: backupInterval,
 	}
 
@@ -109,20 +171,13 @@ func New(
 		bankBalance := incomingBalance.Balance
 
 		// If the bank is not in the cache map, allocate it.
-		if _, exists := c.Balances.Banks[incomingBalance.Name]; !exists {
-			c.Balances.Banks[bankName] = &bank{
-				Id:      bankId,
-				Balance: new(int64),
-				Accs: &accounts{
-					Mp: make(map[uint32]*int32),
-				},
-			}
+		addBank(bankId, bankName)
+		if incomingBalance.Currency == "" {
+			continue // The bank has no positions yet
 		}
-		cacheBank := c.Balances.Banks[bankName]
+		pos := positionOf(c.Balances.Banks[bankName], incomingBalance.Currency)
 		// Update value
-		atomic.StoreInt64(cacheBank.Balance, bankBalance)
-		// Update circular linked list and bank id map
-		c.List.add(bankName)
+		atomic.StoreInt64(pos.Balance, int64(bankBalance))
 	}
 
 	// Atomically update account balances retieved from database
@@ -132,13 +187,24 @@ func New(
 		accountNum := incomingBalance.Account
 		accountBalance := incomingBalance.Balance
 
-		// Update account in cache
-		accs := c.Balances.Banks[bankName].Accs
-		updateAccount(accs, accountNum, accountBalance)
+		// Update account in cache, allocating accounts without
+		// positions in the settlement currency
+		bnk := c.Balances.Banks[bankName]
+		setAccountStatus(bnk.Statuses, accountNum, incomingBalance.Status)
+		if incomingBalance.Currency == "" {
+			incomingBalance.Currency = utils.SettlementCurrency
+		}
+		accountPtr := accountOf(positionOf(bnk, incomingBalance.Currency).Accs, accountNum)
+		atomic.StoreInt64(accountPtr, int64(accountBalance))
 	}
 
 	c.Balances.Unlock()
 
+	// Remember the ids applied before a restart, so that they aren't applied
+	// again, and those not settled yet, so that the next snapshot holds them
+	applied := <-appliedRetChan
+	c.Applied = &appliedIds{Mp: applied.Ids, Unsettled: applied.Unsettled}
+
 	// Update periodically DB records at regular intervals
 	go manageDatabaseBackups()
 }
@@ -152,30 +218,210 @@ func Close() (err error) {
 }
 
 // UpdateBalances changes bank and account balances given an incoming payment.
+// Updates whose id was already applied are ignored, and updates that would
+// overflow any balance are rejected with utils.ErrOverflow. It returns once
+// the update is backed up.
 func UpdateBalances(current *utils.SRBalance) error {
+	result, err := updateBalances(current)
+	if err != nil {
+		return err
+	}
+	return result.wait()
+}
 
-	// Update counter and retrieve bank ids
-	atomic.AddUint64(&counter, 1)
+// updateBalances applies an incoming payment, returning the result of its backup.
+func updateBalances(current *utils.SRBalance) (*backupResult, error) {
 
 	// Lock and unlock balances
 	c.Balances.RLock()
 	defer c.Balances.RUnlock()
 
 	// Get bank structs
-	senBank := c.Balances.Banks[current.Sender.Name]
-	recBank := c.Balances.Banks[current.Receiver.Name]
+	senBank, exists := c.Balances.Banks[current.Sender.Name]
+	if !exists {
+		return nil, fmt.Errorf("unknown bank: %q", current.Sender.Name)
+	}
+	recBank, exists := c.Balances.Banks[current.Receiver.Name]
+	if !exists {
+		return nil, fmt.Errorf("unknown bank: %q", current.Receiver.Name)
+	}
 
-	// Update sender bank's balance
-	atomic.AddInt64(senBank.Balance, -int64(current.Amount)) // Amount is subtracted from sender...
+	debit, err := current.Amount.Neg()
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Id != "" && !c.Applied.add(current.Id) {
+		c.Logger.Printf("Ignored update %s, already applied", current.Id)
+		return c.Backups.changed(""), nil
+	}
 
-	// Update receiving bank's balance
-	atomic.AddInt64(recBank.Balance, int64(current.Amount)) // ... and added to receiver.
+	// Get positions in the payment's currency
+	senPos := positionOf(senBank, current.Currency)
+	recPos := positionOf(recBank, current.Currency)
+
+	// Amount is subtracted from sender and added to receiver, both banks and accounts
+	err = applyDeltas([]delta{
+		{senPos.Balance, debit},
+		{recPos.Balance, current.Amount},
+		{accountOf(senPos.Accs, current.Sender.Account), debit},
+		{accountOf(recPos.Accs, current.Receiver.Account), current.Amount},
+	})
+	if err != nil {
+		c.Applied.remove(current.Id)
+		return nil, err
+	}
+	if current.Id != "" {
+		c.Applied.track(current.Id)
+		c.Backups.track(current.Id)
+	}
 
-	// Update sender and receiver account balances
-	updateAccount(senBank.Accs, current.Sender.Account, -current.Amount)
-	updateAccount(recBank.Accs, current.Receiver.Account, current.Amount)
+	// Update counter
+	atomic.AddUint64(&counter, 1)
 
-	return nil
+	return c.Backups.changed(current.Id, senBank, recBank), nil
+}
+
+// UpdateBatchBalances applies the net balance changes of a batch of payments.
+// Batches whose id was already applied are ignored, and batches that would
+// overflow any balance are rejected with utils.ErrOverflow. It returns once
+// the batch is backed up.
+func UpdateBatchBalances(batch *utils.BatchBalance) error {
+	result, err := updateBatchBalances(batch)
+	if err != nil {
+		return err
+	}
+	return result.wait()
+}
+
+// updateBatchBalances applies a batch, returning the result of its backup.
+func updateBatchBalances(batch *utils.BatchBalance) (*backupResult, error) {
+
+	// Lock and unlock balances
+	c.Balances.RLock()
+	defer c.Balances.RUnlock()
+
+	// Check all banks before changing any balance
+	banks := make([]*bank, 0, len(batch.Deltas))
+	for _, delta := range batch.Deltas {
+		bnk, exists := c.Balances.Banks[delta.Name]
+		if !exists {
+			return nil, fmt.Errorf("unknown bank: %q", delta.Name)
+		}
+		banks = append(banks, bnk)
+	}
+
+	if !c.Applied.add(batch.Id) {
+		c.Logger.Printf("Ignored batch %s, already applied", batch.Id)
+		return c.Backups.changed(""), nil
+	}
+
+	deltas := make([]delta, 0, 2*len(batch.Deltas))
+	for i, accDelta := range batch.Deltas {
+		pos := positionOf(banks[i], accDelta.Currency)
+		deltas = append(
+			deltas,
+			delta{pos.Balance, accDelta.Amount},
+			delta{accountOf(pos.Accs, accDelta.Account), accDelta.Amount},
+		)
+	}
+	if err := applyDeltas(deltas); err != nil {
+		c.Applied.remove(batch.Id)
+		return nil, err
+	}
+	c.Applied.track(batch.Id)
+	c.Backups.track(batch.Id)
+
+	return c.Backups.changed(batch.Id, banks...), nil
+}
+
+// UpdateAccountStatus records a change of an account's status.
+// Changes whose id was already applied are ignored. It returns once
+// the change is backed up.
+func UpdateAccountStatus(update *utils.AccountStatus) error {
+	result, err := updateAccountStatus(update)
+	if err != nil {
+		return err
+	}
+	return result.wait()
+}
+
+// updateAccountStatus applies a change of status, returning the result of its backup.
+func updateAccountStatus(update *utils.AccountStatus) (*backupResult, error) {
+
+	// Lock and unlock balances
+	c.Balances.RLock()
+	defer c.Balances.RUnlock()
+
+	bnk, exists := c.Balances.Banks[update.Name]
+	if !exists {
+		return nil, fmt.Errorf("unknown bank: %q", update.Name)
+	}
+
+	if !c.Applied.add(update.Id) {
+		c.Logger.Printf("Ignored account update %s, already applied", update.Id)
+		return c.Backups.changed(""), nil
+	}
+
+	// Opening an account allocates it in the settlement currency
+	accountOf(positionOf(bnk, utils.SettlementCurrency).Accs, update.Account)
+	setAccountStatus(bnk.Statuses, update.Account, update.Status)
+
+	return c.Backups.changed(update.Id, bnk), nil
+}
+
+// GetAccountBalances returns the balances in each currency and the status
+// of the given accounts of a bank, or of all its accounts if none are given.
+func GetAccountBalances(bankName string, accountNums ...uint32) ([]*utils.Balance, error) {
+
+	// Lock and unlock balances
+	c.Balances.RLock()
+	defer c.Balances.RUnlock()
+
+	bnk, exists := c.Balances.Banks[bankName]
+	if !exists {
+		return nil, fmt.Errorf("unknown bank: %q", bankName)
+	}
+
+	// Read lock the positions and statuses
+	bnk.Positions.RLock()
+	defer bnk.Positions.RUnlock()
+	bnk.Statuses.RLock()
+	defer bnk.Statuses.RUnlock()
+
+	wanted := make(map[uint32]bool, len(accountNums))
+	for _, accountNum := range accountNums {
+		wanted[accountNum] = true
+	}
+
+	balances := make([]*utils.Balance, 0, len(accountNums))
+	found := make(map[uint32]bool, len(accountNums))
+	for currency, pos := range bnk.Positions.Mp {
+		pos.Accs.RLock()
+		for accountNum, balancePtr := range pos.Accs.Mp {
+			if len(wanted) > 0 && !wanted[accountNum] {
+				continue
+			}
+			found[accountNum] = true
+			balances = append(balances, &utils.Balance{
+				BankId:   bnk.Id,
+				BankName: bankName,
+				Account:  accountNum,
+				Currency: currency,
+				Balance:  utils.Money(atomic.LoadInt64(balancePtr)),
+				Status:   bnk.Statuses.Mp[accountNum],
+			})
+		}
+		pos.Accs.RUnlock()
+	}
+
+	for _, accountNum := range accountNums {
+		if !found[accountNum] {
+			return nil, fmt.Errorf("unknown account %d at %q", accountNum, bankName)
+		}
+	}
+
+	return balances, nil
 }
 
 // PrintDues prints to the console how much Polka owes to
@@ -191,8 +437,12 @@ func PrintBalances(andAccounts bool) {
 
 	// Print bank balances
 	for name, bnk := range c.Balances.Banks {
-		// NB bnk.Balance is a pointer to an int
-		fmt.Printf("\t%s: %d\n", name, *bnk.Balance)
+		bnk.Positions.RLock()
+		for currency, pos := range bnk.Positions.Mp {
+			// NB pos.Balance is a pointer to an int
+			fmt.Printf("\t%s: %d %s\n", name, atomic.LoadInt64(pos.Balance), currency)
+		}
+		bnk.Positions.RUnlock()
 	}
 	fmt.Println("}")
 
@@ -203,102 +453,163 @@ func PrintBalances(andAccounts bool) {
 		for name, bnk := range c.Balances.Banks {
 			fmt.Printf("\t%s: {\n", name)
 
-			// Read lock the accounts
-			bnk.Accs.RLock()
-
-			for account, amount := range bnk.Accs.Mp {
-				fmt.Printf("\t\t%d: %d\n", account, *amount)
+			// Read lock the positions and their accounts
+			bnk.Positions.RLock()
+			for currency, pos := range bnk.Positions.Mp {
+				pos.Accs.RLock()
+				for account, amount := range pos.Accs.Mp {
+					fmt.Printf("\t\t%d: %d %s\n", account, atomic.LoadInt64(amount), currency)
+				}
+				pos.Accs.RUnlock()
 			}
-			fmt.Println("\t}")
+			bnk.Positions.RUnlock()
 
-			// Read unlock them
-			bnk.Accs.RUnlock()
+			fmt.Println("\t}")
 		}
 		fmt.Println("}")
 	}
 }
 
 // manageDatabaseBackups backs up cache data in the database.
-// Every so often (e.g. one second) it backs up the bank dues.
-// In between every other bank dues update, it updates only one
-// bank's accounts balances for performance reasons.
+// A backup is made as soon as an update is waiting for one, and
+// every so often (e.g. one second) for changes made by settlements.
+// Each backup writes the balances of the banks that changed since
+// the last one, with the ids applied since then.
 func manageDatabaseBackups() {
-	var (
-		bankTicker    *time.Ticker
-		accountTicker *time.Ticker
-	)
-
-	bankTicker = time.NewTicker(c.BackupInterval)
-	time.Sleep(c.BackupInterval / 2)
-	accountTicker = time.NewTicker(c.BackupInterval * 2)
+	backupTicker := time.NewTicker(c.BackupInterval)
+	pruneTicker := time.NewTicker(pruneInterval)
 
 	for {
 		select {
 		case <-c.Chans.Quit:
-			backupDatabaseBankBalances()
-			for i := uint16(0); i < c.List.Length; i++ {
-				backupDatabaseAccountBalance()
-			}
+			backupDatabase()
 			c.Chans.Done <- true
 			return
-		case <-bankTicker.C:
-			backupDatabaseBankBalances()
-		case <-accountTicker.C:
-			backupDatabaseAccountBalance()
+		case <-c.Backups.Wake:
+			backupDatabase()
+		case <-backupTicker.C:
+			backupDatabase()
+		case <-pruneTicker.C:
+			c.Applied.prune(time.Now().Add(-dedupWindow))
+		case bnk := <-c.Chans.NewBanks:
+			c.Balances.Lock()
+			addBank(bnk.BankId, bnk.Name)
+			c.Balances.Unlock()
 		}
 	}
 }
 
-// backupDatabaseBankBalances sends bank due data to
-// the database connection through the bankChan channel.
-func backupDatabaseBankBalances() {
+// backupDatabase sends the changes made since the last backup to the database
+// through the backups channel, and tells the updates waiting on it the outcome.
+// The changes of a failed backup are made again by the next one.
+func backupDatabase() {
 
-	// Lock and unlock balances
-	c.Balances.RLock()
-	defer c.Balances.RUnlock()
+	// Lock so that the balances hold exactly the updates with the applied ids
+	c.Balances.Lock()
+	banks, backup, result := c.Backups.take()
+	for bnk := range banks {
+		bnk.Positions.RLock()
+		for currency, pos := range bnk.Positions.Mp {
+			backup.Banks = append(backup.Banks, &utils.BankBalance{
+				BankId:   bnk.Id,
+				Currency: currency,
+				Balance:  utils.Money(atomic.LoadInt64(pos.Balance)),
+			})
+
+			pos.Accs.RLock()
+			for account, balance := range pos.Accs.Mp {
+				backup.Accounts = append(backup.Accounts, &utils.Balance{
+					BankId:   bnk.Id,
+					Account:  account,
+					Currency: currency,
+					Balance:  utils.Money(atomic.LoadInt64(balance)),
+				})
+			}
+			pos.Accs.RUnlock()
+		}
+		bnk.Positions.RUnlock()
+	}
+	c.Balances.Unlock()
 
-	for _, bnk := range c.Balances.Banks {
-		c.Chans.BankChan <- &utils.BankBalance{
-			BankId:  bnk.Id,
-			Balance: *bnk.Balance,
+	var err error
+	if len(banks) > 0 || len(backup.Applied) > 0 || len(backup.Settled) > 0 {
+		c.Chans.Backups <- backup
+		if err = <-backup.Done; err != nil {
+			c.Logger.Printf("Error backing up balances: %s", err)
+			c.Backups.restore(banks, backup)
 		}
 	}
+
+	result.Err = err
+	close(result.Done)
 }
 
-// backupDatabaseAccountBalances is a function with state, like a generator in Python.
-// The goal is to update the account records of one bank at a time, in a specific order,
-// so as to update each bank's accounts at regular intervals.
-// The function sends account balance data to the database
-// connection through the accChan channel.
-func backupDatabaseAccountBalance() {
+// addBank allocates a bank unless it's already in the cache.
+// A known bank id with a new name means that the bank was renamed.
+// Balances must be write locked.
+func addBank(id uint16, name string) {
+	if _, exists := c.Balances.Banks[name]; exists {
+		return
+	}
 
-	// Lock and unlock balances
-	c.Balances.RLock()
-	defer c.Balances.RUnlock()
+	// Move a renamed bank under its new name
+	for oldName, bnk := range c.Balances.Banks {
+		if bnk.Id == id {
+			c.Logger.Printf("Bank %q was renamed to %q", oldName, name)
+			c.Balances.Banks[name] = bnk
+			delete(c.Balances.Banks, oldName)
+			return
+		}
+	}
+
+	c.Logger.Printf("Allocating bank %q", name)
+	c.Balances.Banks[name] = &bank{
+		Id:        id,
+		Positions: &positions{Mp: make(map[string]*position)},
+		Statuses:  &statuses{Mp: make(map[uint32]string)},
+	}
+}
+
+// positionOf returns a bank's position in a currency, allocating it
+// in a thread-safe way if needed. Updates without a currency predate
+// multi-currency payments, and are in the settlement currency.
+func positionOf(bnk *bank, currency string) *position {
+	if currency == "" {
+		currency = utils.SettlementCurrency
+	}
+
+	bnk.Positions.RLock()
+	pos, exists := bnk.Positions.Mp[currency]
+	bnk.Positions.RUnlock()
+	if exists {
+		return pos
+	}
 
-	// Get next bank
-	c.List.next()
-	name := c.List.getCurrent()
-	bankId := c.Balances.Banks[name].Id
-	bankAccounts := c.Balances.Banks[name].Accs
-
-	// Read lock the bank accounts
-	bankAccounts.RLock()
-	defer bankAccounts.RUnlock()
-
-	// Back up each account
-	for account, balance := range bankAccounts.Mp {
-		c.Chans.AccChan <- &utils.Balance{
-			BankId:  bankId,
-			Account: account,
-			Balance: *balance,
+	bnk.Positions.Lock()
+	defer bnk.Positions.Unlock()
+
+	// Check again, since another thread might have allocated it in between
+	if pos, exists = bnk.Positions.Mp[currency]; !exists {
+		pos = &position{
+			Balance: new(int64),
+			Accs:    &accounts{Mp: make(map[uint32]*int64)},
 		}
+		bnk.Positions.Mp[currency] = pos
 	}
+	return pos
+}
+
+// setAccountStatus records an account's status in a thread-safe way.
+func setAccountStatus(sts *statuses, accNum uint32, status string) {
+	sts.Lock()
+	defer sts.Unlock()
+
+	sts.Mp[accNum] = status
 }
 
-// addAccount adds a key to an accounts map in a
-// thread-safe way.
-func updateAccount(accs *accounts, accNum uint32, balance int32) {
+// accountOf returns a pointer to an account's balance, adding
+// the account to the accounts map in a thread-safe way if needed.
+func accountOf(accs *accounts, accNum uint32) *int64 {
 	// Read lock and defer unlock
 	accs.RLock()
 	defer accs.RUnlock()
@@ -307,11 +618,200 @@ func updateAccount(accs *accounts, accNum uint32, balance int32) {
 	if _, exists := accs.Mp[accNum]; !exists {
 		accs.RUnlock() // Release read lock to avoid deadlock
 		accs.Lock()    // Lock to make key change
-		accs.Mp[accNum] = new(int32)
+		if _, exists = accs.Mp[accNum]; !exists {
+			accs.Mp[accNum] = new(int64)
+		}
 		accs.Unlock()
 		accs.RLock() // Read lock again to read val
 	}
-	accountPtr := accs.Mp[accNum]
-	// Update value
-	atomic.AddInt32(accountPtr, balance)
+	return accs.Mp[accNum]
+}
+
+// applyDeltas applies all deltas, or none of them if any would overflow.
+// Balances may change concurrently, so each delta is applied with a
+// compare-and-swap, and the ones already applied are undone on failure.
+func applyDeltas(deltas []delta) error {
+	for i, d := range deltas {
+		if err := addMoney(d.balance, d.amount); err != nil {
+			for _, applied := range deltas[:i] {
+				atomic.AddInt64(applied.balance, -int64(applied.amount))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// addMoney atomically adds an amount to a balance, unless the sum would overflow.
+func addMoney(balance *int64, amount utils.Money) error {
+	for {
+		old := atomic.LoadInt64(balance)
+		sum, err := utils.Money(old).Add(amount)
+		if err != nil {
+			return err
+		}
+		if atomic.CompareAndSwapInt64(balance, old, int64(sum)) {
+			return nil
+		}
+	}
+}
+
+// add records an id as applied, returning false if it already was.
+func (ai *appliedIds) add(id string) bool {
+	ai.Lock()
+	defer ai.Unlock()
+
+	if _, exists := ai.Mp[id]; exists {
+		return false
+	}
+	ai.Mp[id] = time.Now()
+	return true
+}
+
+// remove forgets an id whose update was rejected, so that it isn't taken as applied.
+func (ai *appliedIds) remove(id string) {
+	ai.Lock()
+	defer ai.Unlock()
+
+	delete(ai.Mp, id)
+}
+
+// track records the id of an applied payment or batch update until it's settled.
+func (ai *appliedIds) track(id string) {
+	ai.Lock()
+	defer ai.Unlock()
+
+	ai.Unsettled = append(ai.Unsettled, id)
+}
+
+// unsettled returns a copy of the ids of the updates applied since the last settlement.
+func (ai *appliedIds) unsettled() []string {
+	ai.Lock()
+	defer ai.Unlock()
+
+	return append([]string(nil), ai.Unsettled...)
+}
+
+// settle forgets the first n unsettled ids, once their updates are
+// settled, and returns them.
+func (ai *appliedIds) settle(n int) []string {
+	ai.Lock()
+	defer ai.Unlock()
+
+	if n > len(ai.Unsettled) {
+		n = len(ai.Unsettled)
+	}
+	settled := ai.Unsettled[:n]
+	ai.Unsettled = append([]string(nil), ai.Unsettled[n:]...)
+	return settled
+}
+
+// prune forgets ids applied before the cutoff.
+func (ai *appliedIds) prune(cutoff time.Time) {
+	ai.Lock()
+	defer ai.Unlock()
+
+	for id, appliedAt := range ai.Mp {
+		if appliedAt.Before(cutoff) {
+			delete(ai.Mp, id)
+		}
+	}
+}
+
+// newBackups returns the changes of the first backup, which are none.
+func newBackups() *backups {
+	return &backups{
+		Banks:  make(map[*bank]bool),
+		Result: &backupResult{Done: make(chan struct{})},
+		Wake:   make(chan struct{}, 1),
+	}
+}
+
+// changed records the id an update applied and the banks it changed, and
+// returns the result of the backup holding them. Updates that changed
+// nothing still wait for the next backup, since the update that applied
+// their id first may not be backed up yet. Balances must be read locked.
+func (b *backups) changed(id string, banks ...*bank) *backupResult {
+	b.Lock()
+	defer b.Unlock()
+
+	if id != "" {
+		b.Applied = append(b.Applied, id)
+	}
+	for _, bnk := range banks {
+		b.Banks[bnk] = true
+	}
+
+	select {
+	case b.Wake <- struct{}{}:
+	default: // A backup is already due
+	}
+	return b.Result
+}
+
+// track records the id of an applied payment or batch update, which is
+// backed up as unsettled. Balances must be read locked.
+func (b *backups) track(id string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.Unsettled = append(b.Unsettled, id)
+}
+
+// settled records the ids of settled updates, which are backed up as such
+// with the balances they were settled from.
+func (b *backups) settled(ids []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.Settled = append(b.Settled, ids...)
+}
+
+// take returns the banks changed since the last backup, the backup of the
+// ids, and the result they wait on, starting over for the next backup.
+// Balances must be write locked, so that no update is half way through.
+func (b *backups) take() (map[*bank]bool, *utils.Backup, *backupResult) {
+	b.Lock()
+	defer b.Unlock()
+
+	banks, result := b.Banks, b.Result
+	backup := &utils.Backup{
+		Applied:   b.Applied,
+		Unsettled: b.Unsettled,
+		Settled:   b.Settled,
+		Done:      make(chan error, 1),
+	}
+	b.Banks = make(map[*bank]bool)
+	b.Applied, b.Unsettled, b.Settled = nil, nil, nil
+	b.Result = &backupResult{Done: make(chan struct{})}
+	return banks, backup, result
+}
+
+// restore records again the changes of a failed backup.
+func (b *backups) restore(banks map[*bank]bool, backup *utils.Backup) {
+	b.Lock()
+	defer b.Unlock()
+
+	for bnk := range banks {
+		b.Banks[bnk] = true
+	}
+	b.Applied = append(backup.Applied, b.Applied...)
+	b.Unsettled = append(backup.Unsettled, b.Unsettled...)
+	b.Settled = append(backup.Settled, b.Settled...)
+}
+
+// wait returns once the backup commits, or fails with ErrNotBackedUp.
+func (r *backupResult) wait() error {
+	timer := time.NewTimer(backupTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-r.Done:
+		if r.Err != nil {
+			return fmt.Errorf("%w: %s", ErrNotBackedUp, r.Err)
+		}
+		return nil
+	case <-timer.C:
+		return ErrNotBackedUp
+	}
 }
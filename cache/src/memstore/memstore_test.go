@@ -0,0 +1,192 @@
+package memstore
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// testBanks are the banks of the test database, by id.
+var testBanks = map[uint16]string{1: "JP Morgan Chase", 2: "Wells Fargo", 3: "Citigroup"}
+
+type testAccountKey struct {
+	bank     uint16
+	account  uint32
+	currency string
+}
+
+// testDatabase keeps backups as the dbstore writes them to applied_updates
+// and the balance tables, so that the cache can be restarted from it.
+type testDatabase struct {
+	banks     map[uint16]map[string]utils.Money
+	accounts  map[testAccountKey]utils.Money
+	applied   map[string]time.Time
+	unsettled map[string]bool
+	order     []string // Applied ids, in the order they were inserted
+}
+
+func newTestDatabase() *testDatabase {
+	db := &testDatabase{
+		banks:     make(map[uint16]map[string]utils.Money),
+		accounts:  make(map[testAccountKey]utils.Money),
+		applied:   make(map[string]time.Time),
+		unsettled: make(map[string]bool),
+	}
+	for id := range testBanks {
+		db.banks[id] = make(map[string]utils.Money)
+	}
+	return db
+}
+
+// write stores a backup, as writeBackup does in a single transaction.
+func (db *testDatabase) write(backup *utils.Backup) {
+	for _, bnk := range backup.Banks {
+		db.banks[bnk.BankId][bnk.Currency] = bnk.Balance
+	}
+	for _, acc := range backup.Accounts {
+		db.accounts[testAccountKey{acc.BankId, acc.Account, acc.Currency}] = acc.Balance
+	}
+
+	unsettled := make(map[string]bool, len(backup.Unsettled))
+	for _, id := range backup.Unsettled {
+		unsettled[id] = true
+	}
+	for _, id := range backup.Applied {
+		if _, exists := db.applied[id]; exists {
+			continue // ON CONFLICT DO NOTHING
+		}
+		db.applied[id] = time.Now()
+		db.unsettled[id] = unsettled[id]
+		db.order = append(db.order, id)
+	}
+	for _, id := range backup.Settled {
+		db.unsettled[id] = false
+	}
+}
+
+// start initializes the cache from the database, as after a restart, and
+// writes its backups until it's closed.
+func (db *testDatabase) start(t *testing.T) {
+	t.Helper()
+
+	bankNumChan := make(chan uint16, 1)
+	backupChan := make(chan *utils.Backup)
+	bankRetChan := make(chan *utils.BankBalance, 16)
+	accRetChan := make(chan *utils.Balance, 16)
+	appliedRetChan := make(chan *utils.Applied, 1)
+
+	bankNumChan <- uint16(len(testBanks))
+	for id, positions := range db.banks {
+		bankRetChan <- &utils.BankBalance{BankId: id, Name: testBanks[id]}
+		for currency, balance := range positions {
+			bankRetChan <- &utils.BankBalance{BankId: id, Name: testBanks[id], Currency: currency, Balance: balance}
+		}
+	}
+	close(bankRetChan)
+	for key, balance := range db.accounts {
+		accRetChan <- &utils.Balance{
+			BankName: testBanks[key.bank],
+			Account:  key.account,
+			Currency: key.currency,
+			Balance:  balance,
+			Status:   utils.AccountOpen,
+		}
+	}
+	close(accRetChan)
+
+	applied := &utils.Applied{Ids: make(map[string]time.Time)}
+	for _, id := range db.order {
+		applied.Ids[id] = db.applied[id]
+		if db.unsettled[id] {
+			applied.Unsettled = append(applied.Unsettled, id)
+		}
+	}
+	appliedRetChan <- applied
+
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case backup := <-backupChan:
+				db.write(backup)
+				backup.Done <- nil
+			case <-quit:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(quit) })
+
+	New(context.Background(), bankNumChan, backupChan, bankRetChan, accRetChan, appliedRetChan, nil)
+}
+
+func testPayment(id string, amount utils.Money) *utils.SRBalance {
+	return utils.NewSRBalance(&utils.Payment{
+		Id:       id,
+		Sender:   utils.BankInfo{Name: testBanks[1], Account: 12},
+		Receiver: utils.BankInfo{Name: testBanks[2], Account: 47},
+		Amount:   amount,
+		Currency: utils.SettlementCurrency,
+	})
+}
+
+func testSnapshot(t *testing.T) *utils.Snapshot {
+	t.Helper()
+
+	snap, err := GetSnapshot(map[string]*big.Rat{utils.SettlementCurrency: big.NewRat(1, 1)})
+	if err != nil {
+		t.Fatalf("GetSnapshot() = %v", err)
+	}
+	return snap
+}
+
+// TestRestartKeepsUnsettledUpdates restarts the cache between posting
+// payments and settling them, which must still settle every payment once.
+func TestRestartKeepsUnsettledUpdates(t *testing.T) {
+	db := newTestDatabase()
+
+	db.start(t)
+	for _, id := range []string{"p1", "p2"} {
+		if err := UpdateBalances(testPayment(id, 500)); err != nil {
+			t.Fatalf("UpdateBalances(%s) = %v", id, err)
+		}
+	}
+	Close()
+
+	// The payments posted before the restart are in the next snapshot
+	db.start(t)
+	if err := UpdateBalances(testPayment("p1", 500)); err != nil {
+		t.Fatalf("UpdateBalances(p1) again = %v", err)
+	}
+	if err := UpdateBalances(testPayment("p3", 200)); err != nil {
+		t.Fatalf("UpdateBalances(p3) = %v", err)
+	}
+	snap := testSnapshot(t)
+	if got := snap.Updates; len(got) != 3 || got[0] != "p1" || got[1] != "p2" || got[2] != "p3" {
+		t.Fatalf("snapshot updates = %v, want [p1 p2 p3]", got)
+	}
+	if got := snap.Settlement[testBanks[2]]; got != 1200 {
+		t.Errorf("settlement of %s = %d, want 1200", testBanks[2], got)
+	}
+	if err := SettleSnapshot(); err != nil {
+		t.Fatalf("SettleSnapshot() = %v", err)
+	}
+	Close()
+
+	// Settled payments aren't settled again after another restart
+	db.start(t)
+	if err := UpdateBalances(testPayment("p4", 100)); err != nil {
+		t.Fatalf("UpdateBalances(p4) = %v", err)
+	}
+	snap = testSnapshot(t)
+	if got := snap.Updates; len(got) != 1 || got[0] != "p4" {
+		t.Errorf("snapshot updates = %v, want [p4]", got)
+	}
+	if got := snap.Settlement[testBanks[2]]; got != 100 {
+		t.Errorf("settlement of %s = %d, want 100", testBanks[2], got)
+	}
+	Close()
+}
@@ -2,110 +2,207 @@ package memstore
 
 import (
 	"errors"
+	"fmt"
+	"math/big"
 	"sync/atomic"
 	"time"
 
 	"github.com/sekerez/polka/utils"
 )
 
-// SettleSnapshot subtracts all balances by the balances stored in the snapshot.
-// It is called when clearing payments.
+var errIncoherent = errors.New("incoherent snapshot")
+
+// SettleSnapshot subtracts all balances by the balances stored in the snapshot,
+// separately in each currency. It is called when clearing payments.
+// If any subtraction would overflow, no balance is changed.
 func SettleSnapshot() error {
 	// If there's no snapshot, return an error
-	if c.Snap.Banks == nil {
+	if c.Snap == nil || c.Snap.Currencies == nil {
 		return errors.New("no snapshot taken - must request a snapshot before settling payments")
 	}
 
-	// Read because there's no synchronization required.
-	c.Balances.RLock()
-	defer c.Balances.RUnlock()
+	// Lock to check all balances before changing any
+	c.Balances.Lock()
+	defer c.Balances.Unlock()
 
-	for name, bnk := range c.Balances.Banks {
-		snapBnk := c.Snap.Banks[name]
-
-		// change balance and reset snapshot
-		atomic.AddInt64(bnk.Balance, -snapBnk.Balance)
-		snapBnk.Balance = 0
-
-		// change all accounts
-		bnk.Accs.RLock()
-		for accNum, accPtr := range bnk.Accs.Mp {
-			// change balance and reset snapshot
-			atomic.AddInt32(accPtr, -snapBnk.Accounts[accNum])
-			snapBnk.Accounts[accNum] = 0
+	// settle subtracts the snapshot from a balance, and resets the snapshot,
+	// or only checks that it can be done unless apply is set
+	settle := func(balance *int64, snapshot *utils.Money, apply bool) error {
+		value, err := utils.Money(*balance).Sub(*snapshot)
+		if err != nil || !apply {
+			return err
 		}
-		bnk.Accs.RUnlock()
+		atomic.StoreInt64(balance, int64(value))
+		*snapshot = 0
+		return nil
 	}
 
+	// walk settles every balance in the snapshot
+	walk := func(apply bool) error {
+		for currency, snapCur := range c.Snap.Currencies {
+			for name, snapBnk := range snapCur.Banks {
+				bnk, exists := c.Balances.Banks[name]
+				if !exists {
+					// The bank was renamed after the snapshot
+					continue
+				}
+				pos := positionOf(bnk, currency)
+
+				// change balance
+				if err := settle(pos.Balance, &snapBnk.Balance, apply); err != nil {
+					return err
+				}
+
+				// change all accounts
+				for accNum, accPtr := range pos.Accs.Mp {
+					snapBalance, exists := snapBnk.Accounts[accNum]
+					if !exists {
+						continue
+					}
+					if err := settle(accPtr, &snapBalance, apply); err != nil {
+						return err
+					}
+					snapBnk.Accounts[accNum] = snapBalance
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(false); err != nil {
+		return err
+	}
+	if err := walk(true); err != nil {
+		return err
+	}
+
+	// Back up the settled balances with the next backup
+	for _, bnk := range c.Balances.Banks {
+		c.Backups.changed("", bnk)
+	}
+
+	// The updates in the snapshot are settled, and aren't settled again,
+	// even after a restart
+	c.Backups.settled(c.Applied.settle(len(c.Snap.Updates)))
+	c.Snap.Updates = nil
 	return nil
 }
 
-// GetSnapshot returns a snapshot of all balances in a given instant.
-// It returns a pointer to the snapshot.
-func GetSnapshot() (*utils.Snapshot, error) {
-	var sum int32
+// GetSnapshot returns a snapshot of all balances in a given instant,
+// with each bank's positions converted to the settlement currency at
+// the given rates. It returns a pointer to the snapshot.
+func GetSnapshot(rates map[string]*big.Rat) (*utils.Snapshot, error) {
+	var sum utils.Money
 	var err error
 	var snapbnk *utils.SnapBank // Stores the current snapbank
 
-	// Initialize banks
+	// Initialize currencies
 	snap := &utils.Snapshot{
-		Banks: make(map[string]*utils.SnapBank),
+		Id:                 utils.NewId(),
+		Currencies:         make(map[string]*utils.SnapCurrency),
+		SettlementCurrency: utils.SettlementCurrency,
+		Settlement:         make(map[string]utils.Money),
 	}
 
 	c.Balances.Lock() // Lock to keep out other reader threads
 
-	// Loop through banks, adding them one by one
+	// No update is applied while locked, so the snapshot covers exactly these
+	snap.Updates = c.Applied.unsettled()
+
+	// Loop through banks and their positions, adding them one by one
 	for name, bnk := range c.Balances.Banks {
-		// Make the snapbank with the balance
-		snapbnk = &utils.SnapBank{
-			Balance:  *bnk.Balance,
-			Accounts: make(map[uint32]int32),
-		}
+		bnk.Positions.RLock()
+		for currency, pos := range bnk.Positions.Mp {
+			// Make the snapbank with the balance
+			snapbnk = &utils.SnapBank{
+				Balance:  utils.Money(*pos.Balance),
+				Accounts: make(map[uint32]utils.Money),
+			}
 
-		// Add all accounts
-		bnk.Accs.RLock()
-		if len(bnk.Accs.Mp) == 0 {
-			bnk.Accs.RUnlock()
-			continue
-		}
-		for accNum, accBalance := range bnk.Accs.Mp {
-			snapbnk.Accounts[accNum] = *accBalance
-		}
-		bnk.Accs.RUnlock()
+			// Add all accounts
+			pos.Accs.RLock()
+			if len(pos.Accs.Mp) == 0 {
+				pos.Accs.RUnlock()
+				continue
+			}
+			for accNum, accBalance := range pos.Accs.Mp {
+				snapbnk.Accounts[accNum] = utils.Money(*accBalance)
+			}
+			pos.Accs.RUnlock()
 
-		// Add snapbank to snapshot
-		snap.Banks[name] = snapbnk
+			// Add snapbank to snapshot
+			if _, exists := snap.Currencies[currency]; !exists {
+				snap.Currencies[currency] = &utils.SnapCurrency{
+					Banks: make(map[string]*utils.SnapBank),
+				}
+			}
+			snap.Currencies[currency].Banks[name] = snapbnk
+		}
+		bnk.Positions.RUnlock()
 	}
 
 	// Unlock, letting reader threads back in
 	c.Balances.Unlock()
 
-	// Set error and total sum variables
-	err = errors.New("incoherent snapshot")
-	totalSum := int64(0)
+	for currency, snapCur := range snap.Currencies {
+		totalSum := utils.Money(0)
 
-	// Check that each bank balance corresponds to the sum of its accounts
-	for name, bnk := range snap.Banks {
-		sum = 0
-		for _, accountBalance := range bnk.Accounts {
-			sum += accountBalance
+		// Check that each bank balance corresponds to the sum of its accounts
+		for name, bnk := range snapCur.Banks {
+			sum = 0
+			for _, accountBalance := range bnk.Accounts {
+				if sum, err = sum.Add(accountBalance); err != nil {
+					break
+				}
+			}
+
+			// Make the check, if so print error
+			if err != nil || bnk.Balance != sum {
+				c.Logger.Printf("Error: account balances not synched with bank balance for %s in %s", name, currency)
+				c.Snap = nil
+				return nil, errIncoherent
+			}
+			if totalSum, err = totalSum.Add(bnk.Balance); err != nil {
+				c.Snap = nil
+				return nil, err
+			}
+		}
+
+		// check that all bank balances in the currency sum to zero
+		if totalSum != 0 {
+			c.Logger.Printf("Error: bank balances in %s don't add to 0, but to %d", currency, totalSum)
+			c.Snap = nil
+			return nil, errIncoherent
 		}
 
-		// Make the check, if so print error
-		if bnk.Balance != int64(sum) {
-			c.Logger.Printf("Error: account balances not synched with bank balance for %s", name)
-			c.Snap.Banks = nil
-			return nil, err
+		// Convert each bank's net position to the settlement currency
+		rate, exists := rates[currency]
+		if !exists {
+			c.Snap = nil
+			return nil, fmt.Errorf("no exchange rate for %s", currency)
+		}
+		snapCur.Rate = rate.FloatString(8)
+		for name, bnk := range snapCur.Banks {
+			converted, err := utils.Convert(bnk.Balance, rate)
+			if err == nil {
+				snap.Settlement[name], err = snap.Settlement[name].Add(converted)
+			}
+			if err != nil {
+				c.Snap = nil
+				return nil, err
+			}
 		}
-		totalSum += bnk.Balance
 	}
 
-	// check that all bank balances sum to zero
-	if totalSum != 0 {
-		c.Logger.Printf("Error: bank balances don't add to 0, but to ")
-		c.Snap.Banks = nil
+	// Rounding each conversion may leave a few units unaccounted for
+	name, residual, err := balanceSettlement(snap.Settlement)
+	if err != nil {
+		c.Snap = nil
 		return nil, err
 	}
+	if residual != 0 {
+		c.Logger.Printf("Settlement was off by %d %s due to rounding, taken from %s", residual, snap.SettlementCurrency, name)
+	}
 
 	// Update time of snapshot and readiness
 	snap.Print()
@@ -117,7 +214,45 @@ func GetSnapshot() (*utils.Snapshot, error) {
 	return snap, nil
 }
 
+// balanceSettlement makes the settlement net to zero, taking the residual
+// left by rounding conversions from the bank with the largest position, or
+// the first by name among those as large. It returns the bank and the
+// residual, or ErrOverflow if the positions don't add up.
+func balanceSettlement(settlement map[string]utils.Money) (string, utils.Money, error) {
+	var (
+		residual utils.Money
+		largest  string
+		err      error
+	)
+
+	for name, balance := range settlement {
+		if residual, err = residual.Add(balance); err != nil {
+			return "", 0, err
+		}
+		size, largestSize := magnitude(balance), magnitude(settlement[largest])
+		if largest == "" || size > largestSize || (size == largestSize && name < largest) {
+			largest = name
+		}
+	}
+	if residual == 0 {
+		return "", 0, nil
+	}
+
+	if settlement[largest], err = settlement[largest].Sub(residual); err != nil {
+		return "", 0, err
+	}
+	return largest, residual, nil
+}
+
+// magnitude returns the absolute value of an amount, which always fits in a uint64.
+func magnitude(m utils.Money) uint64 {
+	if m < 0 {
+		return uint64(-(m + 1)) + 1
+	}
+	return uint64(m)
+}
+
 // Cancels the last snapshot.
 func CancelSnapshot() {
-	c.Snap.Banks = nil
+	c.Snap = nil
 }
@@ -0,0 +1,111 @@
+package memstore
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// TestSnapshotBalancesConversions settles positions in euros and Canadian
+// dollars between three banks, whose rounded conversions are off by two cents.
+func TestSnapshotBalancesConversions(t *testing.T) {
+	jpm, wf, citi := testBanks[1], testBanks[2], testBanks[3]
+
+	db := newTestDatabase()
+	db.start(t)
+	defer Close()
+
+	payments := []struct {
+		id       string
+		from, to string
+		amount   utils.Money
+		currency string
+	}{
+		{"eur1", citi, jpm, 3, "EUR"},
+		{"eur2", citi, wf, 3, "EUR"},
+		{"cad1", jpm, wf, 5, "CAD"},
+		{"cad2", citi, wf, 5, "CAD"},
+	}
+	for _, p := range payments {
+		err := UpdateBalances(utils.NewSRBalance(&utils.Payment{
+			Id:       p.id,
+			Sender:   utils.BankInfo{Name: p.from, Account: 1},
+			Receiver: utils.BankInfo{Name: p.to, Account: 2},
+			Amount:   p.amount,
+			Currency: p.currency,
+		}))
+		if err != nil {
+			t.Fatalf("UpdateBalances(%s) = %v", p.id, err)
+		}
+	}
+
+	snap, err := GetSnapshot(map[string]*big.Rat{
+		"EUR": big.NewRat(10857, 10000),
+		"CAD": big.NewRat(7331, 10000),
+	})
+	if err != nil {
+		t.Fatalf("GetSnapshot() = %v", err)
+	}
+
+	// Rounded, JPM gets 3 - 4, WF 3 + 7 and Citigroup -7 - 4, which add up to
+	// -2, taken from Citigroup as the largest position
+	want := map[string]utils.Money{jpm: -1, wf: 10, citi: -9}
+	total := utils.Money(0)
+	for name, balance := range snap.Settlement {
+		if balance != want[name] {
+			t.Errorf("settlement of %s = %d, want %d", name, balance, want[name])
+		}
+		total += balance
+	}
+	if len(snap.Settlement) != len(want) || total != 0 {
+		t.Errorf("settlement = %v, want %v netting to 0", snap.Settlement, want)
+	}
+}
+
+func TestBalanceSettlement(t *testing.T) {
+	tests := []struct {
+		settlement map[string]utils.Money
+		want       map[string]utils.Money
+		bank       string
+		residual   utils.Money
+	}{
+		{
+			map[string]utils.Money{"a": 5, "b": -5},
+			map[string]utils.Money{"a": 5, "b": -5},
+			"", 0,
+		},
+		{
+			map[string]utils.Money{"a": 4, "b": -7, "c": 2},
+			map[string]utils.Money{"a": 4, "b": -6, "c": 2},
+			"b", -1,
+		},
+		// Ties go to the first bank by name
+		{
+			map[string]utils.Money{"b": 6, "a": -6, "c": 1},
+			map[string]utils.Money{"b": 6, "a": -7, "c": 1},
+			"a", 1,
+		},
+	}
+	for _, tt := range tests {
+		bank, residual, err := balanceSettlement(tt.settlement)
+		if err != nil || bank != tt.bank || residual != tt.residual {
+			t.Errorf("balanceSettlement() = %q, %d, %v, want %q, %d", bank, residual, err, tt.bank, tt.residual)
+		}
+		for name, balance := range tt.want {
+			if tt.settlement[name] != balance {
+				t.Errorf("settlement of %s = %d, want %d", name, tt.settlement[name], balance)
+			}
+		}
+	}
+}
+
+func TestBalanceSettlementOverflows(t *testing.T) {
+	settlement := map[string]utils.Money{"a": math.MaxInt64, "b": 1}
+
+	if _, _, err := balanceSettlement(settlement); !errors.Is(err, utils.ErrOverflow) {
+		t.Errorf("balanceSettlement() = %v, want ErrOverflow", err)
+	}
+}
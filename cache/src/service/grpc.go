@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sekerez/polka/cache/src/dbstore"
+	"github.com/sekerez/polka/cache/src/memstore"
+	"github.com/sekerez/polka/polkapb"
+	"github.com/sekerez/polka/utils"
+)
+
+// balancesServer serves balances over gRPC, with the same roles as over HTTP.
+type balancesServer struct {
+	polkapb.UnimplementedBalancesServer
+}
+
+// Update applies the balance update of a payment.
+func (bs *balancesServer) Update(ctx context.Context, req *polkapb.BalanceUpdate) (*polkapb.UpdateResponse, error) {
+	if err := requireRole(ctx, utils.RoleService); err != nil {
+		return nil, err
+	}
+
+	err := enqueueBalance(ctx, polkapb.ToSRBalance(req), memstore.UpdateBalances)
+	if err != nil {
+		return nil, status.Error(updateErrorCode(err), err.Error())
+	}
+	return &polkapb.UpdateResponse{}, nil
+}
+
+// UpdateBatch applies the net balance update of a batch of payments.
+func (bs *balancesServer) UpdateBatch(ctx context.Context, req *polkapb.BatchUpdate) (*polkapb.UpdateResponse, error) {
+	if err := requireRole(ctx, utils.RoleService); err != nil {
+		return nil, err
+	}
+
+	err := memstore.UpdateBatchBalances(polkapb.ToBatchBalance(req))
+	if err != nil {
+		return nil, status.Error(updateErrorCode(err), err.Error())
+	}
+	return &polkapb.UpdateResponse{}, nil
+}
+
+// GetBalances returns the balance and status of a bank's accounts,
+// or only of the one in the request.
+func (bs *balancesServer) GetBalances(ctx context.Context, req *polkapb.BalancesRequest) (*polkapb.BalancesResponse, error) {
+	var accountNums []uint32
+
+	if err := requireRole(ctx, utils.RoleService); err != nil {
+		return nil, err
+	}
+	if req.GetBank() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing bank")
+	}
+	if req.Account != nil {
+		accountNums = append(accountNums, req.GetAccount())
+	}
+
+	balances, err := memstore.GetAccountBalances(req.GetBank(), accountNums...)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	resp := &polkapb.BalancesResponse{Balances: make([]*polkapb.Balance, len(balances))}
+	for i, bal := range balances {
+		resp.Balances[i] = polkapb.FromBalance(bal)
+	}
+	return resp, nil
+}
+
+// TakeSnapshot takes a snapshot of all positions with the current exchange rates.
+func (bs *balancesServer) TakeSnapshot(ctx context.Context, _ *polkapb.SnapshotRequest) (*polkapb.Snapshot, error) {
+	if err := requireRole(ctx, utils.RoleOperator); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Got Snapshot rpc!")
+	rates, err := dbstore.GetRates(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	snap, err := enqueueSnapRequest(ctx, func() (*utils.Snapshot, error) {
+		return memstore.GetSnapshot(rates)
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if snap == nil {
+		return nil, status.Error(codes.Internal, "no snapshot taken")
+	}
+	return polkapb.FromSnapshot(snap), nil
+}
+
+// SettleSnapshot clears the positions of the last snapshot.
+func (bs *balancesServer) SettleSnapshot(ctx context.Context, _ *polkapb.SettleRequest) (*polkapb.SettleResponse, error) {
+	if err := requireRole(ctx, utils.RoleOperator); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Got Settle rpc!")
+	if err := memstore.SettleSnapshot(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &polkapb.SettleResponse{}, nil
+}
+
+// requireRole returns an error unless the caller has one of the given roles.
+func requireRole(ctx context.Context, roles ...string) error {
+	if !utils.CallerOf(ctx).HasRole(roles...) {
+		return status.Error(codes.PermissionDenied, utils.ErrForbidden.Error())
+	}
+	return nil
+}
+
+// updateErrorCode is the gRPC counterpart of updateErrorStatus.
+func updateErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, utils.ErrOverflow):
+		return codes.FailedPrecondition
+	case errors.Is(err, memstore.ErrNotBackedUp):
+		return codes.Unavailable
+	}
+	return codes.InvalidArgument
+}
@@ -3,10 +3,13 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/sekerez/polka/cache/src/dbstore"
 	"github.com/sekerez/polka/cache/src/memstore"
 	"github.com/sekerez/polka/utils"
 )
@@ -32,7 +35,8 @@ func balancesHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		err := json.NewDecoder(r.Body).Decode(&currentBalance)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
 		}
 
 		err = enqueueBalance(
@@ -41,17 +45,101 @@ func balancesHandler(w http.ResponseWriter, r *http.Request) {
 			memstore.UpdateBalances,
 		)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, updateErrorStatus(err), err)
+			return
 		}
 	case http.MethodGet:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	case http.MethodPut:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	case http.MethodDelete:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		getBalances(w, r)
+	default:
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// getBalances writes the balance and status of a bank's accounts,
+// or only of the one given by the account parameter.
+func getBalances(w http.ResponseWriter, r *http.Request) {
+	var accountNums []uint32
+
+	bankName := r.FormValue("bank")
+	if bankName == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, errors.New("missing bank"))
+		return
+	}
+
+	if raw := r.FormValue("account"); raw != "" {
+		accountNum, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, errors.New("invalid account"))
+			return
+		}
+		accountNums = append(accountNums, uint32(accountNum))
+	}
+
+	balances, err := memstore.GetAccountBalances(bankName, accountNums...)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balances)
+}
+
+// accountStatusHandler applies a change of an account's status.
+func accountStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var update utils.AccountStatus
+
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+
+	err = memstore.UpdateAccountStatus(&update)
+	if err != nil {
+		utils.WriteError(w, r, updateErrorStatus(err), err)
 	}
 }
 
+// batchBalancesHandler applies the net balance changes of a batch of payments.
+func batchBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	var batch utils.BatchBalance
+
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+
+	err = memstore.UpdateBatchBalances(&batch)
+	if err != nil {
+		utils.WriteError(w, r, updateErrorStatus(err), err)
+	}
+}
+
+// updateErrorStatus tells apart updates that can never be applied,
+// because they would overflow a balance, from those that may be retried,
+// and updates that were applied but not yet backed up.
+func updateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrOverflow):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, memstore.ErrNotBackedUp):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
 func clearingHandler(w http.ResponseWriter, r *http.Request) {
 
 	var (
@@ -71,10 +159,19 @@ func clearingHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		log.Printf("Got Snapshot get request!")
-		// The only thing you need to do is take the snapshot and send it back
-		balances, err := enqueueSnapRequest(ctx, memstore.GetSnapshot)
+		// Take the snapshot with the current exchange rates and send it back
+		rates, err := dbstore.GetRates(ctx)
+		if err != nil {
+			log.Printf("Error reading exchange rates: %s", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		balances, err := enqueueSnapRequest(ctx, func() (*utils.Snapshot, error) {
+			return memstore.GetSnapshot(rates)
+		})
 		if balances == nil || err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("Error taking snapshot: %v", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
 			return
 		}
 
@@ -86,13 +183,11 @@ func clearingHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Got Snapshot post request!")
 		err := memstore.SettleSnapshot()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, http.StatusBadRequest, err)
 		}
 		return
-	case http.MethodPut:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	case http.MethodDelete:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 	}
 }
 
@@ -2,16 +2,24 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/sekerez/polka/polkapb"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
 	balancePath  = "/balance"
+	batchPath    = "/balance/batch"
+	accountPath  = "/balance/account"
 	clearingPath = "/settle"
 )
 
@@ -22,22 +30,32 @@ type Service struct {
 	server   *http.Server
 	mux      *http.ServeMux
 	ctx      context.Context
+
+	grpcServer   *grpc.Server // Nil unless served over gRPC
+	grpcListener net.Listener
 }
 
-// New returns an uninitialized http service.
-func New(u *url.URL, ctx context.Context) (*Service, error) {
+// New returns an uninitialized http service. Balances are only updated by
+// Polka's services, and only operators can take snapshots and settle them.
+// With TLS files, connections are only accepted from the receivers and the
+// settler over mutual TLS. Unless grpcPort is empty, balances are also
+// served over gRPC on that port.
+func New(u *url.URL, ctx context.Context, keys *utils.Keyring, tlsFiles *utils.TLSFiles, grpcPort string) (*Service, error) {
 
 	logger := log.New(os.Stderr, "[service] ", log.LstdFlags)
 	port := fmt.Sprintf(":%s", u.Port())
 
 	// Set up multiplexor
 	mux := http.NewServeMux()
-	mux.HandleFunc(balancePath, balancesHandler)
-	mux.HandleFunc(clearingPath, clearingHandler)
+	mux.HandleFunc(balancePath, utils.RequireRole(balancesHandler, utils.RoleService))
+	mux.HandleFunc(batchPath, utils.RequireRole(batchBalancesHandler, utils.RoleService))
+	mux.HandleFunc(accountPath, utils.RequireRole(accountStatusHandler, utils.RoleService))
+	mux.HandleFunc(clearingPath, utils.RequireRole(clearingHandler, utils.RoleOperator))
+	mux.HandleFunc("/", utils.NotFound)
 
 	// Set up server
 	server := &http.Server{
-		Handler: mux,
+		Handler: utils.RequestIds(keys.Middleware(mux)),
 		Addr:    port,
 	}
 
@@ -50,14 +68,34 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	if tlsFiles != nil {
+		listener = tls.NewListener(listener, tlsFiles.ServerConfig(utils.PeerReceiver, utils.PeerSettler))
+	}
+
+	// Set up gRPC server, next to the http one
+	var (
+		grpcServer   *grpc.Server
+		grpcListener net.Listener
+	)
+	if grpcPort != "" {
+		grpcListener, err = net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		grpcServer = polkapb.NewServer(keys, tlsFiles, utils.PeerReceiver, utils.PeerSettler)
+		polkapb.RegisterBalancesServer(grpcServer, &balancesServer{})
+	}
 
 	// Successfully initialize service
 	s := &Service{
-		ctx:      ctx,
-		mux:      mux,
-		server:   server,
-		listener: listener,
-		logger:   logger,
+		grpcServer:   grpcServer,
+		grpcListener: grpcListener,
+		ctx:          ctx,
+		mux:          mux,
+		server:       server,
+		listener:     listener,
+		logger:       logger,
 	}
 
 	return s, nil
@@ -65,10 +103,20 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 
 // Start sets up a server and listener for incoming requests.
 func (s *Service) Serve(errChan chan<- error) {
+	if s.grpcServer != nil {
+		go func() {
+			if err := s.grpcServer.Serve(s.grpcListener); err != nil {
+				s.logger.Printf("Error serving gRPC: %s", err)
+			}
+		}()
+	}
 	errChan <- s.server.Serve(s.listener)
 }
 
 func (s *Service) Close() (err error) {
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
 	s.listener.Close()
 	err = s.server.Shutdown(s.ctx)
 	return
@@ -0,0 +1,292 @@
+package client
+
+/*
+The client package calls the HTTP endpoints of Polka's services: those of the
+receivers, directly or through the balancer, and those of the cache and the
+settler. Requests are signed with an API key, and those that are safe to send
+again are retried while the service is unavailable. The endpoints are described
+by the OpenAPI documents in the api directory.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 100 * time.Millisecond
+
+	jsonType          = "application/json"
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+
+	// maxErrorBytes is how much of an error response's body is read.
+	maxErrorBytes = 64 << 10
+)
+
+// Config sets up a client. The zero value sends unsigned requests, without TLS.
+type Config struct {
+	Key      *utils.APIKey   // Signs every request, if set
+	TLSFiles *utils.TLSFiles // Reaches the service over mutual TLS, if set
+	Peer     string          // Role the service's certificate must have, with TLS files
+	Timeout  time.Duration   // Of each attempt, 5 seconds unless set
+	Retries  int             // Attempts after the first, 2 unless set, none if negative
+	Backoff  time.Duration   // Wait before the first retry, doubled after each, 100ms unless set
+}
+
+// Client calls the endpoints of a Polka service. It's safe for concurrent use.
+type Client struct {
+	base    string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Error is a response with an error status, with the code, details and
+// request id Polka's services answer errors with. Responses that don't come
+// from a service, such as those of a proxy, only have a status and message.
+type Error struct {
+	Status    int
+	Code      string
+	Message   string
+	Details   map[string]string
+	RequestId string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// StatusOf returns the status of the response an error was returned for,
+// or zero if there was no response.
+func StatusOf(err error) int {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return 0
+}
+
+// CodeOf returns the code of the error a service answered with, such as
+// utils.CodeNotFound or account_frozen, or an empty string if it didn't.
+func CodeOf(err error) string {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// New returns a client of the service at baseUrl, such as http://localhost:8080.
+func New(baseUrl string, conf Config) (*Client, error) {
+
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url: %q", baseUrl)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 100
+	if conf.TLSFiles != nil {
+		var peers []string
+		if conf.Peer != "" {
+			peers = append(peers, conf.Peer)
+		}
+		transport.TLSClientConfig = conf.TLSFiles.ClientConfig(peers...)
+	}
+	var rt http.RoundTripper = transport
+	if conf.Key != nil {
+		rt = &utils.SigningTransport{Base: transport, Key: conf.Key}
+	}
+
+	c := &Client{
+		base:    strings.TrimSuffix(u.String(), "/"),
+		http:    &http.Client{Timeout: conf.Timeout, Transport: rt},
+		retries: conf.Retries,
+		backoff: conf.Backoff,
+	}
+	if c.http.Timeout == 0 {
+		c.http.Timeout = defaultTimeout
+	}
+	switch {
+	case c.retries == 0:
+		c.retries = defaultRetries
+	case c.retries < 0:
+		c.retries = 0
+	}
+	if c.backoff == 0 {
+		c.backoff = defaultBackoff
+	}
+
+	return c, nil
+}
+
+// request is a call to an endpoint.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{} // Sent as json, unless nil or raw
+	raw    []byte      // Sent as is, with the content type
+	ctype  string
+	key    string // Idempotency key, which makes a POST safe to retry
+}
+
+// do sends a request, retrying it while the service is unavailable if it's safe
+// to, and decodes the json body of a successful response into out, unless it's
+// nil. A *[]byte gets the body as is. Error statuses are returned as an *Error.
+func (c *Client) do(ctx context.Context, r *request, out interface{}) (*http.Response, error) {
+	var (
+		body []byte
+		err  error
+	)
+
+	// Encode the body once, so that it can be sent again
+	ctype := r.ctype
+	switch {
+	case r.raw != nil:
+		body = r.raw
+	case r.body != nil:
+		if body, err = json.Marshal(r.body); err != nil {
+			return nil, err
+		}
+		ctype = jsonType
+	}
+
+	target := c.base + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+	retry := r.method != http.MethodPost || r.key != ""
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, r, target, ctype, body)
+		if !retry || attempt == c.retries || !shouldRetry(resp, err) || ctx.Err() != nil {
+			if err != nil {
+				return nil, err
+			}
+			return resp, decode(resp, out)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// send makes a single attempt at a request.
+func (c *Client) send(ctx context.Context, r *request, target, ctype string, body []byte) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, r.method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	if r.key != "" {
+		req.Header.Set(idempotencyHeader, r.key)
+	}
+
+	return c.http.Do(req)
+}
+
+// shouldRetry tells whether an attempt failed because the service was unavailable.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// decode reads the body of a response into out, or the error it holds.
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return errorOf(resp)
+	}
+	switch out := out.(type) {
+	case nil:
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	case *[]byte:
+		var err error
+		*out, err = io.ReadAll(resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// errorOf returns the error of a response with an error status. Errors of
+// Polka's services are a json utils.Error, while others are taken as plain text.
+func errorOf(resp *http.Response) *Error {
+	var model utils.Error
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+	e := &Error{
+		Status:    resp.StatusCode,
+		Message:   string(bytes.TrimSpace(body)),
+		RequestId: resp.Header.Get(utils.RequestIdHeader),
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), jsonType) && json.Unmarshal(body, &model) == nil {
+		e.Code, e.Message, e.Details = model.Code, model.Message, model.Details
+		if model.RequestId != "" {
+			e.RequestId = model.RequestId
+		}
+	}
+	return e
+}
+
+// setInt adds an integer parameter to a query, if it's set.
+func setInt(query url.Values, name string, val *int) {
+	if val != nil {
+		query.Set(name, fmt.Sprint(*val))
+	}
+}
+
+// setString adds a parameter to a query, unless it's empty.
+func setString(query url.Values, name, val string) {
+	if val != "" {
+		query.Set(name, val)
+	}
+}
+
+// setLimit adds a limit to a query, unless it's the default one.
+func setLimit(query url.Values, limit int) {
+	if limit != 0 {
+		query.Set("limit", fmt.Sprint(limit))
+	}
+}
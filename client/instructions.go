@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ListFilter filters a list of a bank's scheduled payments or mandates.
+// Only the bank is required.
+type ListFilter struct {
+	Bank    string
+	Account *int
+	Status  string
+	Limit   int
+}
+
+// RequestFilter filters a list of payment requests. Either bank is required.
+type RequestFilter struct {
+	PayerBank    string
+	PayerAccount *int
+	PayeeBank    string
+	PayeeAccount *int
+	Status       string
+	Limit        int
+}
+
+func (lf *ListFilter) query() url.Values {
+	query := url.Values{}
+	setString(query, "bank", lf.Bank)
+	setInt(query, "account", lf.Account)
+	setString(query, "status", lf.Status)
+	setLimit(query, lf.Limit)
+	return query
+}
+
+// ListScheduled returns the scheduled payments sent by a bank, soonest first.
+func (c *Client) ListScheduled(ctx context.Context, filter *ListFilter) ([]utils.ScheduledPayment, error) {
+	var scheduled []utils.ScheduledPayment
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/scheduled", query: filter.query()}, &scheduled)
+	return scheduled, err
+}
+
+// SchedulePayment schedules a payment to be sent at its execution time.
+func (c *Client) SchedulePayment(ctx context.Context, sched *utils.ScheduledPayment) (*utils.ScheduledPayment, error) {
+	return c.scheduledCall(ctx, http.MethodPost, "/scheduled", sched)
+}
+
+// GetScheduled returns a scheduled payment.
+func (c *Client) GetScheduled(ctx context.Context, id string) (*utils.ScheduledPayment, error) {
+	return c.scheduledCall(ctx, http.MethodGet, "/scheduled/"+id, nil)
+}
+
+// CancelScheduled cancels a scheduled payment that wasn't executed yet.
+func (c *Client) CancelScheduled(ctx context.Context, id string) (*utils.ScheduledPayment, error) {
+	return c.scheduledCall(ctx, http.MethodPost, "/scheduled/"+id+"/cancel", nil)
+}
+
+func (c *Client) scheduledCall(ctx context.Context, method, path string, body *utils.ScheduledPayment) (*utils.ScheduledPayment, error) {
+	var sched utils.ScheduledPayment
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &sched); err != nil {
+		return nil, err
+	}
+	return &sched, nil
+}
+
+// ListMandates returns the mandates of a bank's payments.
+func (c *Client) ListMandates(ctx context.Context, filter *ListFilter) ([]utils.Mandate, error) {
+	var mandates []utils.Mandate
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/mandates", query: filter.query()}, &mandates)
+	return mandates, err
+}
+
+// CreateMandate sets up a recurring payment.
+func (c *Client) CreateMandate(ctx context.Context, m *utils.Mandate) (*utils.Mandate, error) {
+	return c.mandateCall(ctx, http.MethodPost, "/mandates", m)
+}
+
+// GetMandate returns a mandate.
+func (c *Client) GetMandate(ctx context.Context, id string) (*utils.Mandate, error) {
+	return c.mandateCall(ctx, http.MethodGet, "/mandates/"+id, nil)
+}
+
+// PauseMandate stops a mandate from executing until resumed.
+func (c *Client) PauseMandate(ctx context.Context, id string) (*utils.Mandate, error) {
+	return c.mandateCall(ctx, http.MethodPost, "/mandates/"+id+"/pause", nil)
+}
+
+// ResumeMandate lets a paused mandate execute again.
+func (c *Client) ResumeMandate(ctx context.Context, id string) (*utils.Mandate, error) {
+	return c.mandateCall(ctx, http.MethodPost, "/mandates/"+id+"/resume", nil)
+}
+
+// CancelMandate ends a mandate for good.
+func (c *Client) CancelMandate(ctx context.Context, id string) (*utils.Mandate, error) {
+	return c.mandateCall(ctx, http.MethodPost, "/mandates/"+id+"/cancel", nil)
+}
+
+// MandateExecutions returns the executions of a mandate, latest first.
+// A limit of zero is the receiver's default one.
+func (c *Client) MandateExecutions(ctx context.Context, id string, limit int) ([]utils.MandateExecution, error) {
+	var executions []utils.MandateExecution
+
+	query := url.Values{}
+	setLimit(query, limit)
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/mandates/" + id + "/executions", query: query}, &executions)
+	return executions, err
+}
+
+func (c *Client) mandateCall(ctx context.Context, method, path string, body *utils.Mandate) (*utils.Mandate, error) {
+	var m utils.Mandate
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListRequests returns the payment requests matching the filter.
+func (c *Client) ListRequests(ctx context.Context, filter *RequestFilter) ([]utils.PaymentRequest, error) {
+	var requests []utils.PaymentRequest
+
+	query := url.Values{}
+	setString(query, "payer_bank", filter.PayerBank)
+	setInt(query, "payer_account", filter.PayerAccount)
+	setString(query, "payee_bank", filter.PayeeBank)
+	setInt(query, "payee_account", filter.PayeeAccount)
+	setString(query, "status", filter.Status)
+	setLimit(query, filter.Limit)
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/requests", query: query}, &requests)
+	return requests, err
+}
+
+// RequestPayment asks the payer for a payment to the payee.
+func (c *Client) RequestPayment(ctx context.Context, r *utils.PaymentRequest) (*utils.PaymentRequest, error) {
+	return c.requestCall(ctx, http.MethodPost, "/requests", r)
+}
+
+// GetRequest returns a payment request.
+func (c *Client) GetRequest(ctx context.Context, id string) (*utils.PaymentRequest, error) {
+	return c.requestCall(ctx, http.MethodGet, "/requests/"+id, nil)
+}
+
+// DeclineRequest declines a payment request for its payer.
+func (c *Client) DeclineRequest(ctx context.Context, id string) (*utils.PaymentRequest, error) {
+	return c.requestCall(ctx, http.MethodPost, "/requests/"+id+"/decline", nil)
+}
+
+// AcceptRequest accepts a payment request for its payer, and returns the payment made.
+func (c *Client) AcceptRequest(ctx context.Context, id string) (*utils.Payment, error) {
+	var paymnt utils.Payment
+
+	if _, err := c.do(ctx, &request{method: http.MethodPost, path: "/requests/" + id + "/accept"}, &paymnt); err != nil {
+		return nil, err
+	}
+	return &paymnt, nil
+}
+
+func (c *Client) requestCall(ctx context.Context, method, path string, body *utils.PaymentRequest) (*utils.PaymentRequest, error) {
+	var r utils.PaymentRequest
+
+	req := &request{method: method, path: path}
+	if body != nil {
+		req.body = body
+	}
+	if _, err := c.do(ctx, req, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListWebhooks returns the webhooks of a bank.
+func (c *Client) ListWebhooks(ctx context.Context, bank string) ([]utils.Webhook, error) {
+	var hooks []utils.Webhook
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/webhooks", query: url.Values{"bank": {bank}}}, &hooks)
+	return hooks, err
+}
+
+// RegisterWebhook registers a url a bank's events are sent to. The secret
+// signing them is only returned now.
+func (c *Client) RegisterWebhook(ctx context.Context, bank, webhookUrl string) (*utils.Webhook, error) {
+	return c.webhookCall(ctx, http.MethodPost, "/webhooks", &utils.Webhook{Bank: bank, Url: webhookUrl})
+}
+
+// GetWebhook returns a webhook.
+func (c *Client) GetWebhook(ctx context.Context, id int) (*utils.Webhook, error) {
+	return c.webhookCall(ctx, http.MethodGet, fmt.Sprintf("/webhooks/%d", id), nil)
+}
+
+// EnableWebhook resumes sending events to a webhook.
+func (c *Client) EnableWebhook(ctx context.Context, id int) (*utils.Webhook, error) {
+	return c.webhookCall(ctx, http.MethodPost, fmt.Sprintf("/webhooks/%d/enable", id), nil)
+}
+
+// DisableWebhook stops sending events to a webhook.
+func (c *Client) DisableWebhook(ctx context.Context, id int) (*utils.Webhook, error) {
+	return c.webhookCall(ctx, http.MethodPost, fmt.Sprintf("/webhooks/%d/disable", id), nil)
+}
+
+// DeadLetters returns the events a webhook failed to receive, latest first.
+// A limit of zero is the receiver's default one.
+func (c *Client) DeadLetters(ctx context.Context, id, limit int) ([]utils.DeadLetter, error) {
+	var letters []utils.DeadLetter
+
+	query := url.Values{}
+	setLimit(query, limit)
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: fmt.Sprintf("/webhooks/%d/dead", id), query: query}, &letters)
+	return letters, err
+}
+
+func (c *Client) webhookCall(ctx context.Context, method, path string, body *utils.Webhook) (*utils.Webhook, error) {
+	var hook utils.Webhook
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &hook); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
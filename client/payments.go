@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// PaymentFilter filters a search of payments. Unset fields match any payment.
+type PaymentFilter struct {
+	SenderBank      string
+	SenderAccount   *int
+	ReceiverBank    string
+	ReceiverAccount *int
+	Currency        string
+	Status          string
+	Mandate         string
+	MinAmount       *utils.Money
+	MaxAmount       *utils.Money
+	From            time.Time
+	To              time.Time
+	Cursor          string // Next cursor of the previous page
+	Limit           int    // Of the page, the receiver's default unless set
+}
+
+// Hello checks that the service is up.
+func (c *Client) Hello(ctx context.Context) (string, error) {
+	var body []byte
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/hello"}, &body)
+	return string(body), err
+}
+
+// SubmitPayment originates a payment, which is updated to the one stored. With an
+// idempotency key, the payment is retried while the receiver is unavailable, and
+// replayed tells whether the key was used before, in which case the payment is
+// updated to the original.
+func (c *Client) SubmitPayment(ctx context.Context, paymnt *utils.Payment, key string) (replayed bool, err error) {
+
+	resp, err := c.do(ctx, &request{method: http.MethodPost, path: "/payment", body: paymnt, key: key}, paymnt)
+	if err != nil {
+		return false, err
+	}
+	return resp.Header.Get(replayedHeader) == "true", nil
+}
+
+// GetPayment returns a payment.
+func (c *Client) GetPayment(ctx context.Context, id string) (*utils.Payment, error) {
+	var paymnt utils.Payment
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/payment/" + id}, &paymnt)
+	if err != nil {
+		return nil, err
+	}
+	return &paymnt, nil
+}
+
+// PaymentEvents returns the statuses a payment moved to, and when, in order.
+func (c *Client) PaymentEvents(ctx context.Context, id string) ([]utils.PaymentEvent, error) {
+	var events []utils.PaymentEvent
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/payment/" + id + "/events"}, &events)
+	return events, err
+}
+
+// ReversePayment returns the payment compensating the one with the given id,
+// sent by its receiving bank.
+func (c *Client) ReversePayment(ctx context.Context, id string) (*utils.Payment, error) {
+	return c.paymentAction(ctx, id, "reverse")
+}
+
+// ApprovePayment validates a payment held for review. Only operators can.
+func (c *Client) ApprovePayment(ctx context.Context, id string) (*utils.Payment, error) {
+	return c.paymentAction(ctx, id, "approve")
+}
+
+// DeclinePayment rejects a payment held for review. Only operators can.
+func (c *Client) DeclinePayment(ctx context.Context, id string) (*utils.Payment, error) {
+	return c.paymentAction(ctx, id, "decline")
+}
+
+func (c *Client) paymentAction(ctx context.Context, id, action string) (*utils.Payment, error) {
+	var paymnt utils.Payment
+
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/payment/" + id + "/" + action}, &paymnt)
+	if err != nil {
+		return nil, err
+	}
+	return &paymnt, nil
+}
+
+// SearchPayments returns a page of the payments matching the filter, oldest first.
+func (c *Client) SearchPayments(ctx context.Context, filter *PaymentFilter) (*utils.PaymentPage, error) {
+	var page utils.PaymentPage
+
+	query := url.Values{}
+	setString(query, "sender_bank", filter.SenderBank)
+	setInt(query, "sender_account", filter.SenderAccount)
+	setString(query, "receiver_bank", filter.ReceiverBank)
+	setInt(query, "receiver_account", filter.ReceiverAccount)
+	setString(query, "currency", filter.Currency)
+	setString(query, "status", filter.Status)
+	setString(query, "mandate", filter.Mandate)
+	if filter.MinAmount != nil {
+		query.Set("min_amount", strconv.FormatInt(int64(*filter.MinAmount), 10))
+	}
+	if filter.MaxAmount != nil {
+		query.Set("max_amount", strconv.FormatInt(int64(*filter.MaxAmount), 10))
+	}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	setString(query, "cursor", filter.Cursor)
+	setLimit(query, filter.Limit)
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/payments", query: query}, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// SubmitBatch originates up to a thousand payments at once. Each is stored or
+// rejected on its own, as told by its result.
+func (c *Client) SubmitBatch(ctx context.Context, payments []*utils.Payment) (*utils.BatchResult, error) {
+	var result utils.BatchResult
+
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/payments/batch", body: payments}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SubmitPacs008 sends an ISO 20022 pacs.008 message, and returns the pacs.002
+// status report answering it.
+func (c *Client) SubmitPacs008(ctx context.Context, msg []byte) ([]byte, error) {
+	var report []byte
+
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/iso20022/pacs.008", raw: msg, ctype: "application/xml"}, &report)
+	return report, err
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ListBanks returns the bank registry.
+func (c *Client) ListBanks(ctx context.Context) ([]utils.Bank, error) {
+	var banks []utils.Bank
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/banks"}, &banks)
+	return banks, err
+}
+
+// CreateBank adds a bank to the registry. Only operators can.
+func (c *Client) CreateBank(ctx context.Context, bnk *utils.Bank) (*utils.Bank, error) {
+	return c.bankCall(ctx, http.MethodPost, "/banks", bnk)
+}
+
+// UpdateBank changes the name, BIC, routing number or settlement account of a bank.
+// Only operators can.
+func (c *Client) UpdateBank(ctx context.Context, id uint16, bnk *utils.Bank) (*utils.Bank, error) {
+	return c.bankCall(ctx, http.MethodPut, fmt.Sprintf("/banks/%d", id), bnk)
+}
+
+// SuspendBank stops a bank from sending and receiving payments. Only operators can.
+func (c *Client) SuspendBank(ctx context.Context, id uint16) (*utils.Bank, error) {
+	return c.bankCall(ctx, http.MethodPost, fmt.Sprintf("/banks/%d/suspend", id), nil)
+}
+
+// ActivateBank lets a suspended bank send and receive payments again. Only operators can.
+func (c *Client) ActivateBank(ctx context.Context, id uint16) (*utils.Bank, error) {
+	return c.bankCall(ctx, http.MethodPost, fmt.Sprintf("/banks/%d/activate", id), nil)
+}
+
+func (c *Client) bankCall(ctx context.Context, method, path string, body *utils.Bank) (*utils.Bank, error) {
+	var bnk utils.Bank
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &bnk); err != nil {
+		return nil, err
+	}
+	return &bnk, nil
+}
+
+// OpenAccount opens an account at a bank.
+func (c *Client) OpenAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return c.accountCall(ctx, http.MethodPost, "/accounts", &utils.Account{Bank: bank, Account: account})
+}
+
+// GetAccount returns an account, given the name of its bank.
+func (c *Client) GetAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return c.accountCall(ctx, http.MethodGet, accountPath(bank, account, ""), nil)
+}
+
+// FreezeAccount stops an account from sending and receiving payments.
+func (c *Client) FreezeAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return c.accountCall(ctx, http.MethodPost, accountPath(bank, account, "freeze"), nil)
+}
+
+// UnfreezeAccount reopens a frozen account.
+func (c *Client) UnfreezeAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return c.accountCall(ctx, http.MethodPost, accountPath(bank, account, "unfreeze"), nil)
+}
+
+// CloseAccount closes an account for good.
+func (c *Client) CloseAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return c.accountCall(ctx, http.MethodPost, accountPath(bank, account, "close"), nil)
+}
+
+func (c *Client) accountCall(ctx context.Context, method, path string, body *utils.Account) (*utils.Account, error) {
+	var acc utils.Account
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// EnrollAlias enrolls an email or phone number for an account. The alias is
+// pending until verified with the code it's returned with, which the account's
+// bank sends to the alias.
+func (c *Client) EnrollAlias(ctx context.Context, alias, bank string, account int) (*utils.Alias, error) {
+	return c.aliasCall(ctx, http.MethodPost, "/aliases", &utils.Alias{Alias: alias, Bank: bank, Account: account})
+}
+
+// GetAlias returns an alias of one of the caller's accounts.
+func (c *Client) GetAlias(ctx context.Context, alias string) (*utils.Alias, error) {
+	return c.aliasCall(ctx, http.MethodGet, aliasPath(alias, ""), nil)
+}
+
+// MoveAlias moves an alias to another account of the same bank.
+func (c *Client) MoveAlias(ctx context.Context, alias string, account int) (*utils.Alias, error) {
+	return c.aliasCall(ctx, http.MethodPut, aliasPath(alias, ""), &utils.Alias{Account: account})
+}
+
+// VerifyAlias verifies a pending alias with the code it was enrolled with.
+func (c *Client) VerifyAlias(ctx context.Context, alias, code string) (*utils.Alias, error) {
+	var verified utils.Alias
+
+	r := &request{method: http.MethodPost, path: aliasPath(alias, "verify"), body: &struct{ Code string }{code}}
+	if _, err := c.do(ctx, r, &verified); err != nil {
+		return nil, err
+	}
+	return &verified, nil
+}
+
+// UnenrollAlias removes an alias, which can then be enrolled for any account.
+func (c *Client) UnenrollAlias(ctx context.Context, alias string) (*utils.Alias, error) {
+	return c.aliasCall(ctx, http.MethodPost, aliasPath(alias, "unenroll"), nil)
+}
+
+func (c *Client) aliasCall(ctx context.Context, method, path string, body *utils.Alias) (*utils.Alias, error) {
+	var alias utils.Alias
+
+	r := &request{method: method, path: path}
+	if body != nil {
+		r.body = body
+	}
+	if _, err := c.do(ctx, r, &alias); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
+
+// accountPath returns the path of an account, escaping its bank's name.
+func accountPath(bank string, account int, action string) string {
+	p := fmt.Sprintf("/accounts/%s/%d", url.PathEscape(bank), account)
+	if action != "" {
+		p += "/" + action
+	}
+	return p
+}
+
+// aliasPath returns the path of an alias, escaped since it may be an email.
+func aliasPath(alias, action string) string {
+	p := "/aliases/" + url.PathEscape(alias)
+	if action != "" {
+		p += "/" + action
+	}
+	return p
+}
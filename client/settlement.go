@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// UpdateBalance applies the balance update of a payment to the cache. Only
+// Polka's services can.
+func (c *Client) UpdateBalance(ctx context.Context, update *utils.SRBalance) error {
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/balance", body: update}, nil)
+	return err
+}
+
+// UpdateBatchBalance applies the net balance update of a batch of payments
+// to the cache. Only Polka's services can.
+func (c *Client) UpdateBatchBalance(ctx context.Context, batch *utils.BatchBalance) error {
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/balance/batch", body: batch}, nil)
+	return err
+}
+
+// UpdateAccountStatus applies a change of an account's status to the cache.
+// Only Polka's services can.
+func (c *Client) UpdateAccountStatus(ctx context.Context, update *utils.AccountStatus) error {
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/balance/account", body: update}, nil)
+	return err
+}
+
+// GetBalances returns the balance and status of a bank's accounts from the
+// cache, or only of the given one if it's set. Only Polka's services can.
+func (c *Client) GetBalances(ctx context.Context, bank string, account *uint32) ([]*utils.Balance, error) {
+	var balances []*utils.Balance
+
+	query := url.Values{"bank": {bank}}
+	if account != nil {
+		query.Set("account", strconv.FormatUint(uint64(*account), 10))
+	}
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/balance", query: query}, &balances)
+	return balances, err
+}
+
+// TakeSnapshot takes a snapshot of all positions, from the settler, which
+// stores it, or straight from the cache. Only operators can.
+func (c *Client) TakeSnapshot(ctx context.Context) (*utils.Snapshot, error) {
+	var snap utils.Snapshot
+
+	if _, err := c.do(ctx, &request{method: http.MethodGet, path: "/settle"}, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Settle clears the positions of the last snapshot. Only operators can.
+func (c *Client) Settle(ctx context.Context) error {
+	_, err := c.do(ctx, &request{method: http.MethodPost, path: "/settle", body: struct{}{}}, nil)
+	return err
+}
+
+// ACH returns the NACHA file settling a snapshot stored by the settler. Only
+// operators can.
+func (c *Client) ACH(ctx context.Context, snapshotId string) ([]byte, error) {
+	var file []byte
+
+	_, err := c.do(ctx, &request{method: http.MethodGet, path: "/ach/" + snapshotId}, &file)
+	return file, err
+}
@@ -1,63 +1,366 @@
 /*
 Initially, the database is supposed to hold two tables:
 
- - banks: tracks the (10) largest US consumer banks, records their name and an id referenced in the next table;
+ - banks: the bank registry, initially holding the (10) largest US consumer banks, records their name and an id referenced in the next table;
  - transactions: tracks all processed transactions, records all information recorded in the Transaction struct.
 
 */
 
--- Creat banks table with built-in names
+-- Creat banks table with built-in names. Banks are then managed through the receiver's /banks endpoint.
 CREATE TABLE banks (
     id SERIAL,
     name VARCHAR(128),
-    balance BIGINT,
+    status VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended')),
+    bic VARCHAR(11),
+    routing CHAR(9),
+    settlement_account VARCHAR(17),
     PRIMARY KEY (id),
-    UNIQUE (name)
+    UNIQUE (name),
+    UNIQUE (bic)
 );
 
 INSERT INTO banks (
     name,
-    balance
+    bic,
+    routing,
+    settlement_account
 )
 VALUES
-('JP Morgan Chase', 0),
-('Bank of America', 0),
-('Wells Fargo', 0),
-('Citigroup', 0),
-('U.S. Bancorp', 0),
-('Truist Financial', 0),
-('PNC Financial Services Group', 0),
-('TD Group US', 0),
-('Bank of New York Mellon', 0),
-('Capital One Financial', 0);
-
--- Create accounts table with index
+('JP Morgan Chase', 'CHASUS33', '021000021', '4400000001'),
+('Bank of America', 'BOFAUS3N', '026009593', '4400000002'),
+('Wells Fargo', 'WFBIUS6S', '121000248', '4400000003'),
+('Citigroup', 'CITIUS33', '021000089', '4400000004'),
+('U.S. Bancorp', 'USBKUS44', '091000022', '4400000005'),
+('Truist Financial', 'BRBTUS33', '061000104', '4400000006'),
+('PNC Financial Services Group', 'PNCCUS33', '043000096', '4400000007'),
+('TD Group US', 'NRTHUS33', '031101266', '4400000008'),
+('Bank of New York Mellon', 'IRVTUS3N', '021000018', '4400000009'),
+('Capital One Financial', 'HIBKUS44', '056073502', '4400000010');
+
+-- Create FX rates table. Payments can only be made in the listed currencies,
+-- and rates convert a unit of each currency to the settlement currency, USD.
+CREATE TABLE fx_rates (
+    currency CHAR(3),
+    rate NUMERIC(18, 8) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (currency)
+);
+
+INSERT INTO fx_rates (
+    currency,
+    rate
+)
+VALUES
+('USD', 1),
+('EUR', 1.08),
+('CAD', 0.73);
+
+-- Create bank positions table, backed up by the cache with each bank's balance in each currency
+CREATE TABLE bank_positions (
+    bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    currency CHAR(3) NOT NULL REFERENCES fx_rates(currency),
+    balance BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bank_id, currency)
+);
+
+-- Create accounts table with index. Accounts are opened, frozen and closed
+-- through the receiver's /accounts endpoint, and only open accounts can pay or be paid.
 CREATE TABLE accounts (
     id SERIAL,
     account INT,
-    balance INT,
     bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'frozen', 'closed')),
+    opened_at TIMESTAMP NOT NULL DEFAULT NOW(),
     UNIQUE (account, bank_id)
 );
 
 CREATE UNIQUE INDEX idu ON accounts(account, bank_id);
 
+-- Open the first 100 accounts of each bank, used by the load generator
+INSERT INTO accounts (
+    account,
+    bank_id
+)
+SELECT number, banks.id
+FROM banks, generate_series(0, 99) AS number;
+
+-- Create account positions table, backed up by the cache with each account's balance in each currency
+CREATE TABLE account_positions (
+    bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    account INT NOT NULL,
+    currency CHAR(3) NOT NULL REFERENCES fx_rates(currency),
+    balance BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bank_id, account, currency)
+);
+
+-- Create applied updates table, holding the ids of the updates the cache applied,
+-- written with the balances they changed so that redelivered updates aren't applied twice,
+-- and whether their payments are still to settle
+CREATE TABLE applied_updates (
+    update_id TEXT NOT NULL,
+    seq BIGSERIAL NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    unsettled BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (update_id)
+);
+
+CREATE INDEX applied_updates_time_idx ON applied_updates(applied_at);
+
 -- Create transactions table
 CREATE TABLE transactions (
     id SERIAL,
+    payment_id UUID NOT NULL,
     sending_account INT,
     receiving_account INT,
-    dollar_amount INT NOT NULL,
+    dollar_amount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD' REFERENCES fx_rates(currency),
     time TIMESTAMP,
+    idempotency_key VARCHAR(255),
+    request_hash CHAR(64),
+    reverses UUID,
+    batch_id UUID,
+    -- Set on payments executing a mandate
+    mandate_id UUID,
+    -- Payments held for review stay received until they're validated or rejected,
+    -- validated ones then advance as they reach the cache and get settled
+    status VARCHAR(10) NOT NULL DEFAULT 'validated'
+        CHECK (status IN ('received', 'validated', 'posted', 'included', 'settled', 'reversed', 'rejected')),
+    review_reason TEXT,
+    -- Set once the payment is included in a snapshot
+    snapshot_id UUID,
     PRIMARY KEY (id),
     sending_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
     receiving_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
-    UNIQUE (
-        sending_bank_id,
-        receiving_bank_id,
-        sending_account,
-        receiving_account,
-        dollar_amount,
-        time
-    )
-);
\ No newline at end of file
+    UNIQUE (payment_id),
+    -- Idempotency keys are chosen by each sending bank
+    UNIQUE (sending_bank_id, idempotency_key),
+    -- A payment can only be reversed once
+    UNIQUE (reverses),
+    FOREIGN KEY (reverses) REFERENCES transactions(payment_id)
+);
+
+-- Create outbox table, holding cache updates written in the same
+-- database transaction as the payments causing them. The update id
+-- is the id of the payment, or of the batch of payments.
+CREATE TABLE outbox (
+    id BIGSERIAL,
+    update_id UUID NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP,
+    -- Set when the cache refuses the update for good, so that it isn't retried
+    rejected_at TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE (update_id)
+);
+
+CREATE INDEX outbox_pending_idx ON outbox(next_attempt_at) WHERE delivered_at IS NULL AND rejected_at IS NULL;
+
+-- Payments without an idempotency key can't repeat their accounts, amount
+-- and time, while keyed ones are told apart by their keys
+CREATE UNIQUE INDEX transactions_natural_idx ON transactions(
+    sending_bank_id,
+    receiving_bank_id,
+    sending_account,
+    receiving_account,
+    dollar_amount,
+    currency,
+    time
+) WHERE idempotency_key IS NULL;
+
+-- Create indexes for payment searches, which page through (time, id)
+CREATE INDEX transactions_time_idx ON transactions(time, id);
+CREATE INDEX transactions_sender_idx ON transactions(sending_bank_id, sending_account, time, id);
+CREATE INDEX transactions_receiver_idx ON transactions(receiving_bank_id, receiving_account, time, id);
+
+-- Create index for the queue of payments held for review
+CREATE INDEX transactions_pending_idx ON transactions(time, id) WHERE status = 'received';
+CREATE INDEX transactions_snapshot_idx ON transactions(snapshot_id) WHERE snapshot_id IS NOT NULL;
+
+-- Create payment events table, recording each status a payment moved to and when
+CREATE TABLE payment_events (
+    id BIGSERIAL,
+    payment_id UUID NOT NULL REFERENCES transactions(payment_id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL,
+    at TIMESTAMP NOT NULL DEFAULT NOW(),
+    detail TEXT,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX payment_events_payment_idx ON payment_events(payment_id, id);
+
+-- Create scheduled payments table, holding payments submitted ahead of their
+-- execution time. Once executed, a scheduled payment links to its payment,
+-- which is stored with an idempotency key derived from the schedule id.
+CREATE TABLE scheduled_payments (
+    id SERIAL,
+    schedule_id UUID NOT NULL,
+    sending_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    receiving_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    sending_account INT NOT NULL,
+    receiving_account INT NOT NULL,
+    dollar_amount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL REFERENCES fx_rates(currency),
+    execute_at TIMESTAMP NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'scheduled'
+        CHECK (status IN ('scheduled', 'executed', 'cancelled', 'failed')),
+    payment_id UUID REFERENCES transactions(payment_id),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    -- Set while a receiver executes the payment, which others skip until then
+    claimed_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id),
+    UNIQUE (schedule_id)
+);
+
+CREATE INDEX scheduled_due_idx ON scheduled_payments(execute_at) WHERE status = 'scheduled';
+CREATE INDEX scheduled_sender_idx ON scheduled_payments(sending_bank_id, sending_account, execute_at);
+
+-- Create mandates table, holding standing orders that pay the same amount
+-- on a schedule: weekly, monthly or a cron expression.
+CREATE TABLE mandates (
+    id SERIAL,
+    mandate_id UUID NOT NULL,
+    sending_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    receiving_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    sending_account INT NOT NULL,
+    receiving_account INT NOT NULL,
+    dollar_amount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL REFERENCES fx_rates(currency),
+    schedule VARCHAR(128) NOT NULL,
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP,
+    max_count INT NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'paused', 'ended', 'cancelled')),
+    count INT NOT NULL DEFAULT 0,
+    -- NULL once the mandate ended
+    next_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id),
+    UNIQUE (mandate_id)
+);
+
+CREATE INDEX mandates_due_idx ON mandates(next_at) WHERE status = 'active';
+CREATE INDEX mandates_sender_idx ON mandates(sending_bank_id, sending_account, id);
+
+-- Create mandate executions table, with a row for each time a mandate was due.
+-- Failed executions are retried with a backoff, up to a maximum number of attempts.
+CREATE TABLE mandate_executions (
+    id BIGSERIAL,
+    mandate_id UUID NOT NULL REFERENCES mandates(mandate_id) ON DELETE CASCADE,
+    due_at TIMESTAMP NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'executed', 'failed')),
+    payment_id UUID REFERENCES transactions(payment_id),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL,
+    -- Set while a receiver executes the payment, which others skip until then
+    claimed_until TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE (mandate_id, due_at)
+);
+
+CREATE INDEX mandate_executions_pending_idx ON mandate_executions(next_attempt_at) WHERE status = 'pending';
+
+-- Create payment requests table. Requests are made by the account to be paid,
+-- and once accepted by the paying account they link to the resulting payment.
+CREATE TABLE payment_requests (
+    id SERIAL,
+    request_id UUID NOT NULL,
+    payee_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    payee_account INT NOT NULL,
+    payer_bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    payer_account INT NOT NULL,
+    dollar_amount BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL REFERENCES fx_rates(currency),
+    memo TEXT NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'requested'
+        CHECK (status IN ('requested', 'accepted', 'declined', 'expired')),
+    payment_id UUID REFERENCES transactions(payment_id),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (request_id)
+);
+
+CREATE INDEX payment_requests_payer_idx ON payment_requests(payer_bank_id, payer_account, id);
+CREATE INDEX payment_requests_payee_idx ON payment_requests(payee_bank_id, payee_account, id);
+CREATE INDEX payment_requests_expiry_idx ON payment_requests(expires_at) WHERE status = 'requested';
+
+-- Create webhooks table, holding the endpoints banks are notified of events at.
+-- Each webhook has its own secret, signing the events sent to it.
+CREATE TABLE webhooks (
+    id SERIAL,
+    bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret CHAR(64) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX webhooks_bank_idx ON webhooks(bank_id) WHERE active;
+
+-- Create webhook deliveries table, holding each event until it's delivered to a
+-- webhook. Events are written in the same database transaction as their cause.
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL,
+    webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE delivered_at IS NULL;
+
+-- Create webhook dead letters table, where deliveries land once they run out of attempts
+CREATE TABLE webhook_dead_letters (
+    id BIGSERIAL,
+    webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    failed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX webhook_dead_letters_webhook_idx ON webhook_dead_letters(webhook_id, id);
+
+-- Create aliases table, mapping the emails and phone numbers of account owners
+-- to their account. Each alias belongs to a single account, and can only be paid
+-- once its owner confirmed the verification code sent to it.
+CREATE TABLE aliases (
+    id SERIAL,
+    alias VARCHAR(254) NOT NULL,
+    kind VARCHAR(5) NOT NULL CHECK (kind IN ('email', 'phone')),
+    bank_id INT NOT NULL REFERENCES banks(id) ON DELETE CASCADE,
+    account INT NOT NULL,
+    status VARCHAR(8) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'verified')),
+    code_hash CHAR(64),
+    code_expires_at TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    verified_at TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE (alias),
+    FOREIGN KEY (account, bank_id) REFERENCES accounts(account, bank_id) ON DELETE CASCADE
+);
+
+CREATE INDEX aliases_account_idx ON aliases(bank_id, account);
@@ -4,10 +4,12 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/joho/godotenv"
 
 	"github.com/sekerez/polka/generator/src/spammer"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
@@ -38,12 +40,31 @@ func main() {
 		log.Fatalf("Environmental variables failed to load: %s\n", err)
 	}
 
-	mainDest := os.Getenv("MAINURL")
-	helloDest := os.Getenv("HELLOURL")
-	settleDest := os.Getenv("SETTLERURL")
+	keys, err := utils.LoadKeyring(os.Getenv("KEYSPATH"))
+	if err != nil {
+		log.Fatalf("Could not load API keys: %s", err)
+	}
+
+	// Load the certificates for mutual TLS, if configured
+	tlsFiles, err := utils.TLSFromEnv()
+	if err != nil {
+		log.Fatalf("Could not load TLS certificates: %s", err)
+	}
+
+	// Snapshots and settlements are signed with the operator's key, which is
+	// only loaded for them
+	if *getSnapshotPtr || *settleBalancesPtr {
+		key, exists := keys.Get(os.Getenv("OPERATORKEY"))
+		if !exists {
+			log.Fatalf("Unknown operator key: %q", os.Getenv("OPERATORKEY"))
+		}
+		if err = spammer.ConnectSettler(os.Getenv("SETTLERURL"), key, tlsFiles); err != nil {
+			log.Fatalf("Could not set up the settler's client: %s", err)
+		}
+	}
 
 	if *getSnapshotPtr {
-		_, err := spammer.GetSnapshot(settleDest)
+		_, err := spammer.GetSnapshot()
 		if err != nil {
 			log.Fatalf("Error requesting snapshot: %s", err.Error())
 		}
@@ -51,7 +72,7 @@ func main() {
 	}
 
 	if *settleBalancesPtr {
-		err := spammer.SettleBalances(settleDest)
+		err := spammer.SettleBalances()
 		if err != nil {
 			log.Printf("Error requesting snapshot: %s", err.Error())
 			return
@@ -60,12 +81,29 @@ func main() {
 		return
 	}
 
+	// Sign each payment with the key of its sending bank
+	var bankKeys []*utils.APIKey
+	for _, id := range strings.Split(os.Getenv("APIKEYS"), ",") {
+		key, exists := keys.Get(strings.TrimSpace(id))
+		if !exists {
+			log.Fatalf("Unknown API key: %q", id)
+		}
+		bankKeys = append(bankKeys, key)
+	}
+	if err = spammer.Connect(os.Getenv("BALANCERURL"), bankKeys, tlsFiles); err != nil {
+		log.Fatalf("Could not set up clients: %s", err)
+	}
+
 	// Say hello if asked!
 	if *helloPtr {
-		spammer.SayHello(helloDest)
+		spammer.SayHello()
+	}
+
+	if err := spammer.LoadBanks(); err != nil {
+		log.Fatalf("Error loading banks: %s", err.Error())
 	}
 
 	log.Printf("Sending %d transactions with %d workers", *transactionsPtr, *workerPtr)
-	badReqs := spammer.PaymentSpammer(mainDest, *workerPtr, *transactionsPtr, *measurePtr)
+	badReqs := spammer.PaymentSpammer(*workerPtr, *transactionsPtr, *measurePtr)
 	log.Printf("Of all requests, %d were successful and %d failed.", *transactionsPtr-uint(badReqs), badReqs)
 }
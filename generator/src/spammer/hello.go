@@ -2,26 +2,19 @@ package spammer
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"net/http"
+	"strings"
 )
 
-func SayHello(dest string) {
-
-	resp, err := http.Get(dest)
+func SayHello() {
+	hello, err := balancer.Hello(context.Background())
 	if err != nil {
 		panic(err)
 	}
-	defer resp.Body.Close()
-
-	fmt.Println("Response status:", resp.Status)
 
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := bufio.NewScanner(strings.NewReader(hello))
 	for i := 0; scanner.Scan() && i < 5; i++ {
 		fmt.Println(scanner.Text())
 	}
-
-	if err := scanner.Err(); err != nil {
-		panic(err)
-	}
 }
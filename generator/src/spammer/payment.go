@@ -1,17 +1,17 @@
 package spammer
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"math/rand"
-	"net/http"
 	"os"
 	"strconv"
 	"sync/atomic"
 	"time"
 
+	"github.com/sekerez/polka/client"
 	"github.com/sekerez/polka/utils"
 )
 
@@ -19,33 +19,96 @@ const (
 	lo = 0
 	hi = 100000
 
-	timeout     = 3 * time.Second
-	contentType = "application/json"
+	timeout = 3 * time.Second
 )
 
 var (
 	badResponses *uint32
-	c            *http.Client
-
-	// list of the 10 largest US banks
-	banks = []string{
-		"JP Morgan Chase",
-		"Bank of America",
-		"Wells Fargo",
-		"Citigroup",
-		"U.S. Bancorp",
-		"Truist Financial",
-		"PNC Financial Services Group",
-		"TD Group US",
-		"Bank of New York Mellon",
-		"Capital One Financial",
-	}
+
+	// balancer lists banks and says hello, and settler takes snapshots and
+	// settles them, set up by Connect and ConnectSettler
+	balancer *client.Client
+	settler  *client.Client
+
+	// senders submit the payments of each bank, with its own key
+	senders map[string]*client.Client
+
+	// names of the active banks in the registry, and of those among them
+	// the generator has keys of, set by LoadBanks
+	banks       []string
+	senderBanks []string
 )
 
-// PaymentSpammer sends transactionNumber POST requests concurrently,
-// to the specified dest. The goal is to send requests as
+// Connect sets up a client of the balancer for each bank key, which signs the
+// payments sent by its bank. With TLS files, the balancer is reached over
+// mutual TLS.
+func Connect(balancerUrl string, keys []*utils.APIKey, tlsFiles *utils.TLSFiles) error {
+	if len(keys) == 0 {
+		return errors.New("payments need at least one bank key")
+	}
+
+	senders = make(map[string]*client.Client, len(keys))
+	for _, key := range keys {
+		if key.Role != utils.RoleBank {
+			return fmt.Errorf("key %q doesn't have the bank role", key.Id)
+		}
+		c, err := client.New(balancerUrl, client.Config{Key: key, TLSFiles: tlsFiles, Peer: utils.PeerBalancer})
+		if err != nil {
+			return err
+		}
+		senders[key.Bank] = c
+		balancer = c
+	}
+
+	return nil
+}
+
+// ConnectSettler sets up the client of the settler, signing requests with the
+// operator's key.
+func ConnectSettler(settlerUrl string, key *utils.APIKey, tlsFiles *utils.TLSFiles) (err error) {
+	if key.Role != utils.RoleOperator {
+		return fmt.Errorf("key %q doesn't have the operator role", key.Id)
+	}
+	settler, err = client.New(settlerUrl, client.Config{Key: key, TLSFiles: tlsFiles, Peer: utils.PeerSettler})
+	return err
+}
+
+// LoadBanks fetches the bank registry from the balancer, keeping the
+// active banks as receivers of generated payments, and those the generator
+// has keys of as senders.
+func LoadBanks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	registry, err := balancer.ListBanks(ctx)
+	if err != nil {
+		return err
+	}
+
+	banks, senderBanks = banks[:0], senderBanks[:0]
+	for _, bnk := range registry {
+		if bnk.Status != utils.BankActive {
+			continue
+		}
+		banks = append(banks, bnk.Name)
+		if _, exists := senders[bnk.Name]; exists {
+			senderBanks = append(senderBanks, bnk.Name)
+		}
+	}
+	if len(banks) < 2 {
+		return errors.New("payments need at least two active banks")
+	}
+	if len(senderBanks) == 0 {
+		return errors.New("none of the bank keys belong to an active bank")
+	}
+
+	return nil
+}
+
+// PaymentSpammer sends transactionNumber POST requests concurrently
+// to the balancer. The goal is to send requests as
 // close to simultaneous as possible. Returns number of bad requests.
-func PaymentSpammer(dest string, maxGoroutines, transactionNumber uint, measure bool) uint32 {
+func PaymentSpammer(maxGoroutines, transactionNumber uint, measure bool) uint32 {
 
 	badResponses = new(uint32)
 
@@ -55,15 +118,10 @@ func PaymentSpammer(dest string, maxGoroutines, transactionNumber uint, measure
 	// Reset randomness
 	rand.Seed(int64(time.Now().Second()))
 
-	// Initialize custom client
-	c = &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
 	// Initialize limited number of workers
 	log.Printf("initializing workers")
 	for i := uint(0); i < maxGoroutines; i++ {
-		go Worker(lo, hi, dest, workChan, doneChanNew, measure)
+		go Worker(lo, hi, workChan, doneChanNew, measure)
 	}
 
 	// Assign work to workers
@@ -82,7 +140,7 @@ func PaymentSpammer(dest string, maxGoroutines, transactionNumber uint, measure
 	return *badResponses
 }
 
-func Worker(lo, hi int, dest string, work <-chan interface{}, done <-chan interface{}, measure bool) {
+func Worker(lo, hi int, work <-chan interface{}, done <-chan interface{}, measure bool) {
 	for {
 		select {
 		case <-done:
@@ -91,13 +149,13 @@ func Worker(lo, hi int, dest string, work <-chan interface{}, done <-chan interf
 			payload := generateTransaction(lo, hi)
 
 			if !measure {
-				sendTransaction(dest, payload)
+				sendTransaction(payload)
 				continue
 			}
 
 			// Post request
 			start := time.Now()
-			sendTransaction(dest, payload)
+			sendTransaction(payload)
 			elapsedNum := int64(time.Since(start) / time.Nanosecond)
 			elapsedBytes := []byte(strconv.FormatInt(elapsedNum, 10) + "\n")
 
@@ -118,64 +176,52 @@ func Worker(lo, hi int, dest string, work <-chan interface{}, done <-chan interf
 	}
 }
 
-// sendTransaction sends a POST request with a json-encoded
-// payment to the load balancer.
-func sendTransaction(dest string, payload *bytes.Buffer) {
-	resp, err := c.Post(dest, contentType, payload)
-	if err != nil {
-		log.Printf("Error sending request: %s", err.Error())
-		return
-	}
-	defer resp.Body.Close()
-	// In case of failure, print
-	if resp.StatusCode > 299 {
+// sendTransaction submits a payment to the load balancer, signed by its
+// sending bank, with an idempotency key so that it's safely retried.
+func sendTransaction(paymnt *utils.Payment) {
+	var apiErr *client.Error
+
+	_, err := senders[paymnt.Sender.Name].SubmitPayment(context.Background(), paymnt, utils.NewId())
+	switch {
+	case errors.As(err, &apiErr):
+		// In case of failure, print
 		atomic.AddUint32(badResponses, 1)
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalf("Can't read body: %s", err.Error())
-		}
-		log.Printf("Received response with bad status code: %s", string(bodyBytes))
+		log.Printf("Received response with bad status code: %s", apiErr)
+	case err != nil:
+		log.Printf("Error sending request: %s", err.Error())
 	}
 }
 
-// Returns a buffer fit for the POST request encoding
-// a transaction struct.
-func generateTransaction(lo, hi int) *bytes.Buffer {
+// Returns a random payment from a bank the generator has a key of to
+// another active bank.
+func generateTransaction(lo, hi int) *utils.Payment {
 
 	// calculate basic transaction attributes
 	sum := rand.Intn(hi-lo) + lo
 	time := time.Now()
 	sendAcc := rand.Intn(100)
 	receiverAcc := rand.Intn(100)
-	senderIndex := rand.Intn(len(banks))
-	receiverIndex := rand.Intn(len(banks))
+	sender := senderBanks[rand.Intn(len(senderBanks))]
+	receiver := banks[rand.Intn(len(banks))]
 
 	// Make sure that the sending bank and the receiving bank are different
-	if receiverIndex == senderIndex {
-		if receiverIndex < len(banks)-1 {
-			receiverIndex++
-		} else {
-			receiverIndex = 0
-		}
-		// receiverIndex = rand.Intn(len(banks))
+	for receiver == sender {
+		receiver = banks[rand.Intn(len(banks))]
 	}
 
 	// create transaction and assign pointer
 	result := &utils.Payment{
 		Sender: utils.BankInfo{
-			Name:    banks[senderIndex],
+			Name:    sender,
 			Account: sendAcc,
 		},
 		Receiver: utils.BankInfo{
-			Name:    banks[receiverIndex],
+			Name:    receiver,
 			Account: receiverAcc,
 		},
-		Amount: sum,
+		Amount: utils.Money(sum),
 		Time:   time,
 	}
 
-	// format into payload for request
-	payloadBuffer := new(bytes.Buffer)
-	json.NewEncoder(payloadBuffer).Encode(result)
-	return payloadBuffer
+	return result
 }
@@ -0,0 +1,171 @@
+package polkapb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// Calls carry the signature headers of HTTP requests as metadata, whose keys are lowercase.
+var (
+	keyMetadata       = strings.ToLower(utils.KeyHeader)
+	timestampMetadata = strings.ToLower(utils.TimestampHeader)
+	signatureMetadata = strings.ToLower(utils.RequestSignatureHeader)
+)
+
+// marshalOptions marshal request messages the same way on both ends of a
+// call, so that the server hashes the message the client signed.
+var marshalOptions = proto.MarshalOptions{Deterministic: true}
+
+// errStreamNeedsTLS is returned for streaming calls over plaintext, whose
+// messages aren't covered by the signature and rely on the connection.
+var errStreamNeedsTLS = errors.New("streaming calls are only served over mutual TLS")
+
+// NewServer returns a gRPC server only serving calls signed with the keys of the
+// keyring. With TLS files, connections are only accepted from the given peers
+// over mutual TLS. Without them, streaming calls are refused.
+func NewServer(keys *utils.Keyring, tlsFiles *utils.TLSFiles, peers ...string) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			message, err := marshalMessage(req)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			ctx, err = authenticate(ctx, keys, info.FullMethod, message)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if !isMutualTLS(ss.Context()) {
+				return status.Error(codes.Unauthenticated, errStreamNeedsTLS.Error())
+			}
+			ctx, err := authenticate(ss.Context(), keys, info.FullMethod, nil)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &callerStream{ServerStream: ss, ctx: ctx})
+		}),
+	}
+	if tlsFiles != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsFiles.ServerConfig(peers...))))
+	}
+	return grpc.NewServer(opts...)
+}
+
+// Dial connects to a gRPC server, signing every call with key. With TLS files,
+// the server is reached over mutual TLS, and must be the given peer.
+func Dial(address string, key *utils.APIKey, tlsFiles *utils.TLSFiles, peer string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if tlsFiles != nil {
+		creds = credentials.NewTLS(tlsFiles.ClientConfig(peer))
+	}
+	return grpc.Dial(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			message, err := marshalMessage(req)
+			if err != nil {
+				return err
+			}
+			return invoker(sign(ctx, key, method, message), method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(sign(ctx, key, method, nil), desc, cc, method, opts...)
+		}),
+	)
+}
+
+// RejectionError returns the error a call fails with because of a rejection,
+// which carries the rejection as a detail.
+func RejectionError(rj *Rejection) error {
+	st := status.New(codes.Code(rj.Status), rj.Message)
+	if detailed, err := st.WithDetails(rj); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// RejectionOf returns the rejection a call failed with. Errors without one
+// are rejections with their status code and message, but no code.
+func RejectionOf(err error) *Rejection {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if rj, ok := detail.(*Rejection); ok {
+			return rj
+		}
+	}
+	return &Rejection{Status: uint32(st.Code()), Message: st.Message()}
+}
+
+// sign returns a copy of ctx whose outgoing metadata carries the signature
+// headers of a call to the method with the marshalled request message.
+func sign(ctx context.Context, key *utils.APIKey, method string, message []byte) context.Context {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return metadata.AppendToOutgoingContext(ctx,
+		keyMetadata, key.Id,
+		timestampMetadata, timestamp,
+		signatureMetadata, utils.SignRPC(key, method, timestamp, message),
+	)
+}
+
+// authenticate returns a copy of ctx carrying the key a call was signed with.
+func authenticate(ctx context.Context, keys *utils.Keyring, method string, message []byte) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	key, err := keys.AuthenticateRPC(method, first(keyMetadata), first(timestampMetadata), first(signatureMetadata), message, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return utils.WithCaller(ctx, key), nil
+}
+
+// marshalMessage returns the bytes a request message is signed with.
+func marshalMessage(req interface{}) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("can't sign a %T", req)
+	}
+	return marshalOptions.Marshal(msg)
+}
+
+// isMutualTLS tells whether a call came over a connection whose client
+// presented a verified certificate.
+func isMutualTLS(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
+
+// callerStream is a server stream whose context carries the key its call was signed with.
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (cs *callerStream) Context() context.Context {
+	return cs.ctx
+}
@@ -0,0 +1,259 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: balances.proto
+
+package polkapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BalancesClient is the client API for Balances service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BalancesClient interface {
+	// Update applies the balance update of a payment, like POST /balance.
+	Update(ctx context.Context, in *BalanceUpdate, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// UpdateBatch applies the net balance update of a batch, like POST /balance/batch.
+	UpdateBatch(ctx context.Context, in *BatchUpdate, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// GetBalances returns the balances of a bank's accounts, like GET /balance.
+	GetBalances(ctx context.Context, in *BalancesRequest, opts ...grpc.CallOption) (*BalancesResponse, error)
+	// TakeSnapshot takes a snapshot of all positions, like GET /settle.
+	TakeSnapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error)
+	// SettleSnapshot clears the positions of the last snapshot, like POST /settle.
+	SettleSnapshot(ctx context.Context, in *SettleRequest, opts ...grpc.CallOption) (*SettleResponse, error)
+}
+
+type balancesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBalancesClient(cc grpc.ClientConnInterface) BalancesClient {
+	return &balancesClient{cc}
+}
+
+func (c *balancesClient) Update(ctx context.Context, in *BalanceUpdate, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Balances/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balancesClient) UpdateBatch(ctx context.Context, in *BatchUpdate, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Balances/UpdateBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balancesClient) GetBalances(ctx context.Context, in *BalancesRequest, opts ...grpc.CallOption) (*BalancesResponse, error) {
+	out := new(BalancesResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Balances/GetBalances", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balancesClient) TakeSnapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error) {
+	out := new(Snapshot)
+	err := c.cc.Invoke(ctx, "/polka.v1.Balances/TakeSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balancesClient) SettleSnapshot(ctx context.Context, in *SettleRequest, opts ...grpc.CallOption) (*SettleResponse, error) {
+	out := new(SettleResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Balances/SettleSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BalancesServer is the server API for Balances service.
+// All implementations must embed UnimplementedBalancesServer
+// for forward compatibility
+type BalancesServer interface {
+	// Update applies the balance update of a payment, like POST /balance.
+	Update(context.Context, *BalanceUpdate) (*UpdateResponse, error)
+	// UpdateBatch applies the net balance update of a batch, like POST /balance/batch.
+	UpdateBatch(context.Context, *BatchUpdate) (*UpdateResponse, error)
+	// GetBalances returns the balances of a bank's accounts, like GET /balance.
+	GetBalances(context.Context, *BalancesRequest) (*BalancesResponse, error)
+	// TakeSnapshot takes a snapshot of all positions, like GET /settle.
+	TakeSnapshot(context.Context, *SnapshotRequest) (*Snapshot, error)
+	// SettleSnapshot clears the positions of the last snapshot, like POST /settle.
+	SettleSnapshot(context.Context, *SettleRequest) (*SettleResponse, error)
+	mustEmbedUnimplementedBalancesServer()
+}
+
+// UnimplementedBalancesServer must be embedded to have forward compatible implementations.
+type UnimplementedBalancesServer struct {
+}
+
+func (UnimplementedBalancesServer) Update(context.Context, *BalanceUpdate) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedBalancesServer) UpdateBatch(context.Context, *BatchUpdate) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateBatch not implemented")
+}
+func (UnimplementedBalancesServer) GetBalances(context.Context, *BalancesRequest) (*BalancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalances not implemented")
+}
+func (UnimplementedBalancesServer) TakeSnapshot(context.Context, *SnapshotRequest) (*Snapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TakeSnapshot not implemented")
+}
+func (UnimplementedBalancesServer) SettleSnapshot(context.Context, *SettleRequest) (*SettleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SettleSnapshot not implemented")
+}
+func (UnimplementedBalancesServer) mustEmbedUnimplementedBalancesServer() {}
+
+// UnsafeBalancesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BalancesServer will
+// result in compilation errors.
+type UnsafeBalancesServer interface {
+	mustEmbedUnimplementedBalancesServer()
+}
+
+func RegisterBalancesServer(s grpc.ServiceRegistrar, srv BalancesServer) {
+	s.RegisterService(&Balances_ServiceDesc, srv)
+}
+
+func _Balances_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BalanceUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalancesServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Balances/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalancesServer).Update(ctx, req.(*BalanceUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Balances_UpdateBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchUpdate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalancesServer).UpdateBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Balances/UpdateBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalancesServer).UpdateBatch(ctx, req.(*BatchUpdate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Balances_GetBalances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BalancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalancesServer).GetBalances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Balances/GetBalances",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalancesServer).GetBalances(ctx, req.(*BalancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Balances_TakeSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalancesServer).TakeSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Balances/TakeSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalancesServer).TakeSnapshot(ctx, req.(*SnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Balances_SettleSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SettleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalancesServer).SettleSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Balances/SettleSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalancesServer).SettleSnapshot(ctx, req.(*SettleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Balances_ServiceDesc is the grpc.ServiceDesc for Balances service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Balances_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "polka.v1.Balances",
+	HandlerType: (*BalancesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Update",
+			Handler:    _Balances_Update_Handler,
+		},
+		{
+			MethodName: "UpdateBatch",
+			Handler:    _Balances_UpdateBatch_Handler,
+		},
+		{
+			MethodName: "GetBalances",
+			Handler:    _Balances_GetBalances_Handler,
+		},
+		{
+			MethodName: "TakeSnapshot",
+			Handler:    _Balances_TakeSnapshot_Handler,
+		},
+		{
+			MethodName: "SettleSnapshot",
+			Handler:    _Balances_SettleSnapshot_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "balances.proto",
+}
@@ -0,0 +1,192 @@
+package polkapb
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// FromPayment returns the message of a payment.
+func FromPayment(paymnt *utils.Payment) *Payment {
+	return &Payment{
+		Id:       paymnt.Id,
+		Sender:   fromBankInfo(&paymnt.Sender),
+		Receiver: fromBankInfo(&paymnt.Receiver),
+		Amount:   int64(paymnt.Amount),
+		Currency: paymnt.Currency,
+		Time:     fromTime(paymnt.Time),
+		Reverses: paymnt.Reverses,
+		Mandate:  paymnt.Mandate,
+		Status:   paymnt.Status,
+		Reason:   paymnt.Reason,
+	}
+}
+
+// ToPayment returns the payment of a message.
+func ToPayment(msg *Payment) *utils.Payment {
+	return &utils.Payment{
+		Id:       msg.GetId(),
+		Sender:   toBankInfo(msg.GetSender()),
+		Receiver: toBankInfo(msg.GetReceiver()),
+		Amount:   utils.Money(msg.GetAmount()),
+		Currency: msg.GetCurrency(),
+		Time:     toTime(msg.GetTime()),
+		Reverses: msg.GetReverses(),
+		Mandate:  msg.GetMandate(),
+		Status:   msg.GetStatus(),
+		Reason:   msg.GetReason(),
+	}
+}
+
+// FromSRBalance returns the message of a payment's balance update.
+func FromSRBalance(update *utils.SRBalance) *BalanceUpdate {
+	return &BalanceUpdate{
+		Id:       update.Id,
+		Sender:   &AccountRef{Name: update.Sender.Name, Account: update.Sender.Account},
+		Receiver: &AccountRef{Name: update.Receiver.Name, Account: update.Receiver.Account},
+		Amount:   int64(update.Amount),
+		Currency: update.Currency,
+	}
+}
+
+// ToSRBalance returns the balance update of a message.
+func ToSRBalance(msg *BalanceUpdate) *utils.SRBalance {
+	return utils.NewSRBalance(&utils.Payment{
+		Id:       msg.GetId(),
+		Sender:   utils.BankInfo{Name: msg.GetSender().GetName(), Account: int(msg.GetSender().GetAccount())},
+		Receiver: utils.BankInfo{Name: msg.GetReceiver().GetName(), Account: int(msg.GetReceiver().GetAccount())},
+		Amount:   utils.Money(msg.GetAmount()),
+		Currency: msg.GetCurrency(),
+	})
+}
+
+// FromBatchBalance returns the message of a batch's balance update.
+func FromBatchBalance(batch *utils.BatchBalance) *BatchUpdate {
+	msg := &BatchUpdate{Id: batch.Id, Deltas: make([]*AccountDelta, len(batch.Deltas))}
+	for i, delta := range batch.Deltas {
+		msg.Deltas[i] = &AccountDelta{
+			Name:     delta.Name,
+			Account:  delta.Account,
+			Currency: delta.Currency,
+			Amount:   int64(delta.Amount),
+		}
+	}
+	return msg
+}
+
+// ToBatchBalance returns the batch balance update of a message.
+func ToBatchBalance(msg *BatchUpdate) *utils.BatchBalance {
+	batch := &utils.BatchBalance{Id: msg.GetId(), Deltas: make([]*utils.AccountDelta, len(msg.GetDeltas()))}
+	for i, delta := range msg.GetDeltas() {
+		batch.Deltas[i] = &utils.AccountDelta{
+			Name:     delta.GetName(),
+			Account:  delta.GetAccount(),
+			Currency: delta.GetCurrency(),
+			Amount:   utils.Money(delta.GetAmount()),
+		}
+	}
+	return batch
+}
+
+// FromBalance returns the message of an account's balance.
+func FromBalance(bal *utils.Balance) *Balance {
+	return &Balance{
+		BankId:   uint32(bal.BankId),
+		BankName: bal.BankName,
+		Account:  bal.Account,
+		Currency: bal.Currency,
+		Balance:  int64(bal.Balance),
+		Status:   bal.Status,
+	}
+}
+
+// ToBalance returns the account balance of a message.
+func ToBalance(msg *Balance) *utils.Balance {
+	return &utils.Balance{
+		BankId:   uint16(msg.GetBankId()),
+		BankName: msg.GetBankName(),
+		Account:  msg.GetAccount(),
+		Currency: msg.GetCurrency(),
+		Balance:  utils.Money(msg.GetBalance()),
+		Status:   msg.GetStatus(),
+	}
+}
+
+// FromSnapshot returns the message of a snapshot.
+func FromSnapshot(snap *utils.Snapshot) *Snapshot {
+	msg := &Snapshot{
+		Id:                 snap.Id,
+		Updates:            snap.Updates,
+		Currencies:         make(map[string]*SnapCurrency, len(snap.Currencies)),
+		SettlementCurrency: snap.SettlementCurrency,
+		Settlement:         make(map[string]int64, len(snap.Settlement)),
+		Timestamp:          fromTime(snap.Timestamp),
+	}
+	for currency, snapCur := range snap.Currencies {
+		banks := make(map[string]*SnapBank, len(snapCur.Banks))
+		for name, snapBank := range snapCur.Banks {
+			accounts := make(map[uint32]int64, len(snapBank.Accounts))
+			for accNum, balance := range snapBank.Accounts {
+				accounts[accNum] = int64(balance)
+			}
+			banks[name] = &SnapBank{Balance: int64(snapBank.Balance), Accounts: accounts}
+		}
+		msg.Currencies[currency] = &SnapCurrency{Rate: snapCur.Rate, Banks: banks}
+	}
+	for name, position := range snap.Settlement {
+		msg.Settlement[name] = int64(position)
+	}
+	return msg
+}
+
+// ToSnapshot returns the snapshot of a message.
+func ToSnapshot(msg *Snapshot) *utils.Snapshot {
+	snap := &utils.Snapshot{
+		Id:                 msg.GetId(),
+		Updates:            msg.GetUpdates(),
+		Currencies:         make(map[string]*utils.SnapCurrency, len(msg.GetCurrencies())),
+		SettlementCurrency: msg.GetSettlementCurrency(),
+		Settlement:         make(map[string]utils.Money, len(msg.GetSettlement())),
+		Timestamp:          toTime(msg.GetTimestamp()),
+	}
+	for currency, snapCur := range msg.GetCurrencies() {
+		banks := make(map[string]*utils.SnapBank, len(snapCur.GetBanks()))
+		for name, snapBank := range snapCur.GetBanks() {
+			accounts := make(map[uint32]utils.Money, len(snapBank.GetAccounts()))
+			for accNum, balance := range snapBank.GetAccounts() {
+				accounts[accNum] = utils.Money(balance)
+			}
+			banks[name] = &utils.SnapBank{Balance: utils.Money(snapBank.GetBalance()), Accounts: accounts}
+		}
+		snap.Currencies[currency] = &utils.SnapCurrency{Rate: snapCur.GetRate(), Banks: banks}
+	}
+	for name, position := range msg.GetSettlement() {
+		snap.Settlement[name] = utils.Money(position)
+	}
+	return snap
+}
+
+func fromBankInfo(info *utils.BankInfo) *BankInfo {
+	return &BankInfo{Name: info.Name, Account: int64(info.Account), Alias: info.Alias}
+}
+
+func toBankInfo(msg *BankInfo) utils.BankInfo {
+	return utils.BankInfo{Name: msg.GetName(), Account: int(msg.GetAccount()), Alias: msg.GetAlias()}
+}
+
+// fromTime leaves out zero times, which aren't set.
+func fromTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func toTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
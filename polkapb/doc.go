@@ -0,0 +1,14 @@
+package polkapb
+
+/*
+The polkapb package holds the gRPC services of the receivers and the cache,
+served next to their HTTP endpoints, and the conversions between their messages
+and Polka's types. Calls are signed with API keys, like HTTP requests,
+with the request message in place of the body. Streaming calls are only served
+over mutual TLS.
+
+The messages and services are defined in the .proto files, from which the rest
+of the generated code is regenerated with go generate.
+*/
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative payments.proto balances.proto
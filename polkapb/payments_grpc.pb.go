@@ -0,0 +1,296 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: payments.proto
+
+package polkapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PaymentsClient is the client API for Payments service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PaymentsClient interface {
+	// Submit stores a payment, like POST /payment.
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error)
+	// SubmitStream stores every payment sent on the stream, each on its own,
+	// and answers with the outcome of each once the stream is closed.
+	SubmitStream(ctx context.Context, opts ...grpc.CallOption) (Payments_SubmitStreamClient, error)
+	// Get returns a payment, like GET /payment/{id}.
+	Get(ctx context.Context, in *GetPaymentRequest, opts ...grpc.CallOption) (*Payment, error)
+	// Search returns a page of payments, like GET /payments.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// Reverse reverses a payment, like POST /payment/{id}/reverse.
+	Reverse(ctx context.Context, in *ReverseRequest, opts ...grpc.CallOption) (*Payment, error)
+}
+
+type paymentsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPaymentsClient(cc grpc.ClientConnInterface) PaymentsClient {
+	return &paymentsClient{cc}
+}
+
+func (c *paymentsClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	out := new(SubmitResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Payments/Submit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) SubmitStream(ctx context.Context, opts ...grpc.CallOption) (Payments_SubmitStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Payments_ServiceDesc.Streams[0], "/polka.v1.Payments/SubmitStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &paymentsSubmitStreamClient{stream}
+	return x, nil
+}
+
+type Payments_SubmitStreamClient interface {
+	Send(*SubmitRequest) error
+	CloseAndRecv() (*SubmitSummary, error)
+	grpc.ClientStream
+}
+
+type paymentsSubmitStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *paymentsSubmitStreamClient) Send(m *SubmitRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *paymentsSubmitStreamClient) CloseAndRecv() (*SubmitSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(SubmitSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *paymentsClient) Get(ctx context.Context, in *GetPaymentRequest, opts ...grpc.CallOption) (*Payment, error) {
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, "/polka.v1.Payments/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/polka.v1.Payments/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paymentsClient) Reverse(ctx context.Context, in *ReverseRequest, opts ...grpc.CallOption) (*Payment, error) {
+	out := new(Payment)
+	err := c.cc.Invoke(ctx, "/polka.v1.Payments/Reverse", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PaymentsServer is the server API for Payments service.
+// All implementations must embed UnimplementedPaymentsServer
+// for forward compatibility
+type PaymentsServer interface {
+	// Submit stores a payment, like POST /payment.
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	// SubmitStream stores every payment sent on the stream, each on its own,
+	// and answers with the outcome of each once the stream is closed.
+	SubmitStream(Payments_SubmitStreamServer) error
+	// Get returns a payment, like GET /payment/{id}.
+	Get(context.Context, *GetPaymentRequest) (*Payment, error)
+	// Search returns a page of payments, like GET /payments.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// Reverse reverses a payment, like POST /payment/{id}/reverse.
+	Reverse(context.Context, *ReverseRequest) (*Payment, error)
+	mustEmbedUnimplementedPaymentsServer()
+}
+
+// UnimplementedPaymentsServer must be embedded to have forward compatible implementations.
+type UnimplementedPaymentsServer struct {
+}
+
+func (UnimplementedPaymentsServer) Submit(context.Context, *SubmitRequest) (*SubmitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedPaymentsServer) SubmitStream(Payments_SubmitStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubmitStream not implemented")
+}
+func (UnimplementedPaymentsServer) Get(context.Context, *GetPaymentRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedPaymentsServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedPaymentsServer) Reverse(context.Context, *ReverseRequest) (*Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reverse not implemented")
+}
+func (UnimplementedPaymentsServer) mustEmbedUnimplementedPaymentsServer() {}
+
+// UnsafePaymentsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PaymentsServer will
+// result in compilation errors.
+type UnsafePaymentsServer interface {
+	mustEmbedUnimplementedPaymentsServer()
+}
+
+func RegisterPaymentsServer(s grpc.ServiceRegistrar, srv PaymentsServer) {
+	s.RegisterService(&Payments_ServiceDesc, srv)
+}
+
+func _Payments_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Payments/Submit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_SubmitStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PaymentsServer).SubmitStream(&paymentsSubmitStreamServer{stream})
+}
+
+type Payments_SubmitStreamServer interface {
+	SendAndClose(*SubmitSummary) error
+	Recv() (*SubmitRequest, error)
+	grpc.ServerStream
+}
+
+type paymentsSubmitStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *paymentsSubmitStreamServer) SendAndClose(m *SubmitSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *paymentsSubmitStreamServer) Recv() (*SubmitRequest, error) {
+	m := new(SubmitRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Payments_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Payments/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).Get(ctx, req.(*GetPaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Payments/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Payments_Reverse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReverseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentsServer).Reverse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/polka.v1.Payments/Reverse",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentsServer).Reverse(ctx, req.(*ReverseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Payments_ServiceDesc is the grpc.ServiceDesc for Payments service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Payments_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "polka.v1.Payments",
+	HandlerType: (*PaymentsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _Payments_Submit_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Payments_Get_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _Payments_Search_Handler,
+		},
+		{
+			MethodName: "Reverse",
+			Handler:    _Payments_Reverse_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubmitStream",
+			Handler:       _Payments_SubmitStream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "payments.proto",
+}
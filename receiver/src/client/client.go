@@ -2,11 +2,24 @@ package client
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	contentType = "transaction/json"
+	batchPath   = "/batch"
+	accountPath = "/account"
 )
 
-const contentType = "transaction/json"
+// ErrRejected is returned when the cache refuses an update for good,
+// for instance because it would overflow a balance.
+var ErrRejected = errors.New("update rejected by the cache")
 
 type client struct {
 	Client  *http.Client
@@ -16,15 +29,18 @@ type client struct {
 
 var c *client
 
-func New(destUrl string, connTimeout, reqTimeout time.Duration) (err error) {
+// New sets up the client sending updates to the cache, signed with key.
+// With TLS files, the cache is reached over mutual TLS.
+func New(destUrl string, connTimeout, reqTimeout time.Duration, key *utils.APIKey, tlsFiles *utils.TLSFiles) (err error) {
 	transport := &http.Transport{
 		MaxIdleConns:    100,
 		IdleConnTimeout: connTimeout,
+		TLSClientConfig: tlsFiles.ClientConfig(utils.PeerCache),
 	}
 
 	httpClient := &http.Client{
 		Timeout:   reqTimeout,
-		Transport: transport,
+		Transport: &utils.SigningTransport{Base: transport, Key: key},
 	}
 
 	c = &client{
@@ -36,9 +52,39 @@ func New(destUrl string, connTimeout, reqTimeout time.Duration) (err error) {
 	return
 }
 
+// SendTransactionUpdate posts a balance update to the cache.
+// A response with an error status is returned as an error.
 func SendTransactionUpdate(payload *bytes.Buffer) error {
+	return post(c.destUrl, payload)
+}
 
-	_, err := c.Client.Post(c.destUrl, c.content, payload)
-	// defer resp.Body.Close()
-	return err
+// SendBatchUpdate posts the net balance update of a batch of payments to the cache.
+// A response with an error status is returned as an error.
+func SendBatchUpdate(payload *bytes.Buffer) error {
+	return post(c.destUrl+batchPath, payload)
+}
+
+// SendAccountUpdate posts a change of an account's status to the cache.
+// A response with an error status is returned as an error.
+func SendAccountUpdate(payload *bytes.Buffer) error {
+	return post(c.destUrl+accountPath, payload)
+}
+
+func post(url string, payload *bytes.Buffer) error {
+
+	resp, err := c.Client.Post(url, c.content, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: %s", ErrRejected, bytes.TrimSpace(body))
+	}
+	if resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cache responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
 }
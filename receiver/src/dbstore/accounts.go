@@ -0,0 +1,206 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// Codes of the errors explaining why an account can't pay or be paid.
+const (
+	CodeAccountUnknown = "account_unknown"
+	CodeAccountFrozen  = "account_frozen"
+	CodeAccountClosed  = "account_closed"
+)
+
+var (
+	// ErrAccountNotFound is returned when no account has the requested bank and number.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrAccountExists is returned when opening an account that was already opened.
+	ErrAccountExists = errors.New("account already exists")
+	// ErrInvalidTransition is returned when an account can't move to the requested status.
+	ErrInvalidTransition = errors.New("invalid account status change")
+)
+
+// transitions lists the statuses each status can change to.
+var transitions = map[string][]string{
+	utils.AccountOpen:   {utils.AccountFrozen, utils.AccountClosed},
+	utils.AccountFrozen: {utils.AccountOpen, utils.AccountClosed},
+}
+
+// AccountError explains why an account can't take part in a payment.
+type AccountError struct {
+	Code    string
+	Bank    string
+	Account int
+}
+
+func (e *AccountError) Error() string {
+	switch e.Code {
+	case CodeAccountFrozen:
+		return fmt.Sprintf("account %d at %s is frozen", e.Account, e.Bank)
+	case CodeAccountClosed:
+		return fmt.Sprintf("account %d at %s is closed", e.Account, e.Bank)
+	default:
+		return fmt.Sprintf("account %d at %s does not exist", e.Account, e.Bank)
+	}
+}
+
+// accountKey identifies an account by bank name and number.
+type accountKey struct {
+	bank    string
+	account int
+}
+
+// GetAccountByName returns the account with the given bank name and number.
+func GetAccountByName(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	return scanAccount(db.conn.QueryRow(ctx, getAccountByNameQ, bank, account))
+}
+
+// OpenAccount opens a new account at the named bank,
+// and tells the cache about it through the outbox.
+func OpenAccount(ctx context.Context, bank string, account int) (*utils.Account, error) {
+	var pgErr *pgconn.PgError
+
+	acc := &utils.Account{Bank: bank}
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	err = tx.QueryRow(ctx, openAccountQ, bank, account).Scan(
+		&acc.BankId,
+		&acc.Account,
+		&acc.Status,
+		&acc.OpenedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, ErrBankNotFound
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = insertAccountOutboxEntry(ctx, tx, acc); err != nil {
+		return nil, err
+	}
+
+	return acc, tx.Commit(ctx)
+}
+
+// SetAccountStatus freezes, reopens or closes an account,
+// and tells the cache about it through the outbox.
+func SetAccountStatus(ctx context.Context, bankId uint16, account int, status string) (*utils.Account, error) {
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	acc, err := scanAccount(tx.QueryRow(ctx, lockAccountQ, bankId, account))
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, next := range transitions[acc.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s account can't become %s", ErrInvalidTransition, acc.Status, status)
+	}
+
+	_, err = tx.Exec(ctx, setAccountStatusQ, bankId, account, status)
+	if err != nil {
+		return nil, err
+	}
+	acc.Status = status
+
+	// Closed accounts can't be paid anymore, so their aliases are released
+	if status == utils.AccountClosed {
+		if _, err = tx.Exec(ctx, deleteAccountAliasesQ, bankId, account); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = insertAccountOutboxEntry(ctx, tx, acc); err != nil {
+		return nil, err
+	}
+
+	return acc, tx.Commit(ctx)
+}
+
+// scanAccount scans an account selected by getAccountQ.
+func scanAccount(row pgx.Row) (*utils.Account, error) {
+	acc := &utils.Account{}
+	err := row.Scan(&acc.BankId, &acc.Bank, &acc.Account, &acc.Status, &acc.OpenedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// lockAccounts returns the status of every account involved in the payments,
+// locking them until the transaction ends so that they can't change meanwhile.
+// Unknown accounts are left out.
+func lockAccounts(ctx context.Context, tx pgx.Tx, payments []*utils.Payment) (map[accountKey]string, error) {
+	var (
+		name    string
+		account int
+		status  string
+	)
+
+	names := make([]string, 0, 2*len(payments))
+	accounts := make([]int32, 0, 2*len(payments))
+	for _, paymnt := range payments {
+		names = append(names, paymnt.Sender.Name, paymnt.Receiver.Name)
+		accounts = append(accounts, int32(paymnt.Sender.Account), int32(paymnt.Receiver.Account))
+	}
+
+	rows, err := tx.Query(ctx, lockAccountStatusesQ, names, accounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[accountKey]string, len(names))
+	for rows.Next() {
+		if err = rows.Scan(&name, &account, &status); err != nil {
+			return nil, err
+		}
+		statuses[accountKey{name, account}] = status
+	}
+
+	return statuses, rows.Err()
+}
+
+// checkAccounts returns an *AccountError unless both of the payment's accounts are open.
+func checkAccounts(statuses map[accountKey]string, paymnt *utils.Payment) error {
+	for _, info := range []utils.BankInfo{paymnt.Sender, paymnt.Receiver} {
+		status, exists := statuses[accountKey{info.Name, info.Account}]
+
+		switch {
+		case !exists:
+			return &AccountError{Code: CodeAccountUnknown, Bank: info.Name, Account: info.Account}
+		case status == utils.AccountFrozen:
+			return &AccountError{Code: CodeAccountFrozen, Bank: info.Name, Account: info.Account}
+		case status == utils.AccountClosed:
+			return &AccountError{Code: CodeAccountClosed, Bank: info.Name, Account: info.Account}
+		}
+	}
+	return nil
+}
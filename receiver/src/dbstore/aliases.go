@@ -0,0 +1,194 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// CodeAliasUnknown is the code of the error returned when paying an alias that isn't verified.
+const CodeAliasUnknown = "alias_unknown"
+
+var (
+	// ErrAliasNotFound is returned when no account has the requested alias.
+	ErrAliasNotFound = errors.New("alias not found")
+	// ErrAliasTaken is returned when enrolling an alias that belongs to another account.
+	ErrAliasTaken = errors.New("alias already belongs to an account")
+	// ErrBadAliasCode is returned when an alias's verification code doesn't match, or can't be used anymore.
+	ErrBadAliasCode = errors.New("invalid verification code")
+)
+
+// EnrollAlias enrolls a pending alias for an open account, to be verified with
+// the code whose hash is given. The code can be tried maxAttempts times.
+func EnrollAlias(ctx context.Context, alias *utils.Alias, codeHash string, maxAttempts int) error {
+
+	if err := checkAliasAccount(ctx, alias.Bank, alias.Account); err != nil {
+		return err
+	}
+
+	err := db.conn.QueryRow(
+		ctx,
+		enrollAliasQ,
+		alias.Alias,
+		alias.Kind,
+		alias.Bank,
+		alias.Account,
+		codeHash,
+		utils.AliasCodeTTL.Milliseconds(),
+		maxAttempts,
+	).Scan(&alias.Status, &alias.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return ErrAliasTaken
+	}
+	alias.VerifiedAt = nil
+	return err
+}
+
+// GetAlias returns an alias, whether it's verified or not.
+func GetAlias(ctx context.Context, alias string) (*utils.Alias, error) {
+	return scanAlias(db.conn.QueryRow(ctx, getAliasQ, alias))
+}
+
+// ResolveAlias returns the account a verified alias pays to.
+func ResolveAlias(ctx context.Context, alias string) (*utils.BankInfo, error) {
+	found, err := GetAlias(ctx, alias)
+	if err == nil && found.Status != utils.AliasVerified {
+		err = ErrAliasNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &utils.BankInfo{Name: found.Bank, Account: found.Account}, nil
+}
+
+// VerifyAlias verifies a pending alias if the hash of the code matches the
+// one it was enrolled with. Each failed attempt counts towards maxAttempts.
+func VerifyAlias(ctx context.Context, alias, codeHash string, maxAttempts int) (*utils.Alias, error) {
+	var (
+		status   string
+		hash     *string
+		expired  *bool
+		attempts int
+	)
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	err = tx.QueryRow(ctx, lockAliasCodeQ, alias).Scan(&status, &hash, &expired, &attempts)
+	if err == pgx.ErrNoRows {
+		return nil, ErrAliasNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status == utils.AliasVerified:
+		return nil, fmt.Errorf("%w: alias is already verified", ErrBadAliasCode)
+	case hash == nil || (expired != nil && *expired) || attempts >= maxAttempts:
+		return nil, fmt.Errorf("%w: code can't be used anymore, enroll the alias again", ErrBadAliasCode)
+	case *hash != codeHash:
+		if _, err = tx.Exec(ctx, failAliasCodeQ, alias); err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %d attempts left", ErrBadAliasCode, maxAttempts-attempts-1)
+	}
+
+	if _, err = tx.Exec(ctx, verifyAliasQ, alias); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return GetAlias(ctx, alias)
+}
+
+// MoveAlias moves an alias to another open account of the same bank, keeping its status.
+func MoveAlias(ctx context.Context, alias string, account int) (*utils.Alias, error) {
+
+	found, err := GetAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAliasAccount(ctx, found.Bank, account); err != nil {
+		return nil, err
+	}
+
+	tag, err := db.conn.Exec(ctx, moveAliasQ, alias, account)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAliasNotFound
+	}
+	return GetAlias(ctx, alias)
+}
+
+// UnenrollAlias removes an alias, so that it can be enrolled for another account.
+func UnenrollAlias(ctx context.Context, alias string) (*utils.Alias, error) {
+
+	found, err := GetAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := db.conn.Exec(ctx, deleteAliasQ, alias)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAliasNotFound
+	}
+	return found, nil
+}
+
+// checkAliasAccount returns an *AccountError unless the account is open.
+func checkAliasAccount(ctx context.Context, bank string, account int) error {
+
+	acc, err := GetAccountByName(ctx, bank, account)
+	if err == ErrAccountNotFound {
+		return &AccountError{Code: CodeAccountUnknown, Bank: bank, Account: account}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch acc.Status {
+	case utils.AccountFrozen:
+		return &AccountError{Code: CodeAccountFrozen, Bank: bank, Account: account}
+	case utils.AccountClosed:
+		return &AccountError{Code: CodeAccountClosed, Bank: bank, Account: account}
+	}
+	return nil
+}
+
+// scanAlias scans an alias selected by getAliasQ.
+func scanAlias(row pgx.Row) (*utils.Alias, error) {
+	alias := &utils.Alias{}
+	err := row.Scan(
+		&alias.Alias,
+		&alias.Kind,
+		&alias.Bank,
+		&alias.Account,
+		&alias.Status,
+		&alias.CreatedAt,
+		&alias.VerifiedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, ErrAliasNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
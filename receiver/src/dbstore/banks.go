@@ -0,0 +1,76 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const uniqueViolation = "23505"
+
+var (
+	// ErrBankNotFound is returned when no bank has the requested id.
+	ErrBankNotFound = errors.New("bank not found")
+	// ErrBankExists is returned when a bank name or BIC is already taken.
+	ErrBankExists = errors.New("a bank with that name or BIC already exists")
+)
+
+// ListBanks returns all registered banks, ordered by id.
+func ListBanks(ctx context.Context) ([]*utils.Bank, error) {
+
+	rows, err := db.conn.Query(ctx, listBanksQ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	banks := make([]*utils.Bank, 0)
+	for rows.Next() {
+		bnk := &utils.Bank{}
+		if err = rows.Scan(&bnk.Id, &bnk.Name, &bnk.Status, &bnk.BIC, &bnk.Routing, &bnk.SettlementAccount); err != nil {
+			return nil, err
+		}
+		banks = append(banks, bnk)
+	}
+
+	return banks, rows.Err()
+}
+
+// CreateBank registers a new, active bank. Its BIC, routing number and settlement account may be empty.
+func CreateBank(ctx context.Context, bnk *utils.Bank) (*utils.Bank, error) {
+	return scanBank(db.conn.QueryRow(ctx, createBankQ, bnk.Name, bnk.BIC, bnk.Routing, bnk.SettlementAccount))
+}
+
+// UpdateBank changes the name of a bank, and its BIC, routing number and
+// settlement account unless they're empty.
+func UpdateBank(ctx context.Context, id uint16, bnk *utils.Bank) (*utils.Bank, error) {
+	return scanBank(db.conn.QueryRow(ctx, updateBankQ, id, bnk.Name, bnk.BIC, bnk.Routing, bnk.SettlementAccount))
+}
+
+// SetBankStatus suspends or reactivates a bank.
+func SetBankStatus(ctx context.Context, id uint16, status string) (*utils.Bank, error) {
+	return scanBank(db.conn.QueryRow(ctx, setBankStatusQ, id, status))
+}
+
+// scanBank scans a bank returned by a query, translating database errors.
+func scanBank(row pgx.Row) (*utils.Bank, error) {
+	var pgErr *pgconn.PgError
+
+	bnk := &utils.Bank{}
+	err := row.Scan(&bnk.Id, &bnk.Name, &bnk.Status, &bnk.BIC, &bnk.Routing, &bnk.SettlementAccount)
+	if err == pgx.ErrNoRows {
+		return nil, ErrBankNotFound
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrBankExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return bnk, nil
+}
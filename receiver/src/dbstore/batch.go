@@ -0,0 +1,111 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ErrDuplicate is returned for a payment in a batch that was already stored.
+var ErrDuplicate = errors.New("duplicate payment")
+
+// InsertBatch stores a batch of payments with a single round trip, together
+// with one cache update netting all of them. It returns an error for each
+// payment, which is an *AccountError if an account isn't open, ErrDuplicate
+// if it was already stored and nil if it was inserted. Payments held for
+// review are left out of the cache update.
+// Keys, if given, are the payments' idempotency keys, in order; payments
+// without one are duplicates if their accounts, amount and time repeat.
+// Payments must have their ids assigned and their banks must exist.
+func InsertBatch(ctx context.Context, batchId string, payments []*utils.Payment, keys []string) ([]error, error) {
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Leave out payments involving accounts that aren't open
+	statuses, err := lockAccounts(ctx, tx, payments)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(payments))
+	queued := make([]int, 0, len(payments))
+	for i, paymnt := range payments {
+		if errs[i] = checkAccounts(statuses, paymnt); errs[i] == nil {
+			queued = append(queued, i)
+		}
+	}
+	if len(queued) == 0 {
+		return errs, nil
+	}
+
+	// Queue all inserts
+	batch := &pgx.Batch{}
+	for _, i := range queued {
+		paymnt := payments[i]
+		key := ""
+		if keys != nil {
+			key = keys[i]
+		}
+		batch.Queue(
+			insertBatchPaymentQ,
+			paymnt.Id,
+			paymnt.Sender.Name,
+			paymnt.Receiver.Name,
+			paymnt.Sender.Account,
+			paymnt.Receiver.Account,
+			paymnt.Amount,
+			paymnt.Time.UTC(),
+			paymnt.Hash(),
+			batchId,
+			paymnt.Status,
+			nullIfEmpty(paymnt.Reason),
+			paymnt.Currency,
+			nullIfEmpty(key),
+		)
+	}
+
+	// Read each result, skipping duplicates
+	results := tx.SendBatch(ctx, batch)
+	stored := make([]*utils.Payment, 0, len(queued))
+	inserted := make([]*utils.Payment, 0, len(queued))
+	for _, i := range queued {
+		paymnt := payments[i]
+		tag, err := results.Exec()
+		if err != nil {
+			results.Close()
+			return nil, err
+		}
+		if tag.RowsAffected() == 0 {
+			errs[i] = ErrDuplicate
+			continue
+		}
+		stored = append(stored, paymnt)
+		if paymnt.Status == utils.PaymentValidated {
+			inserted = append(inserted, paymnt)
+		}
+	}
+	if err = results.Close(); err != nil {
+		return nil, err
+	}
+
+	if len(stored) > 0 {
+		if err = recordInserted(ctx, tx, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(inserted) > 0 {
+		err = insertBatchOutboxEntry(ctx, tx, batchId, inserted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, tx.Commit(ctx)
+}
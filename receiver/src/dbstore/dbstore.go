@@ -2,14 +2,18 @@ package dbstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
 
+	"github.com/sekerez/polka/receiver/src/iso20022"
 	"github.com/sekerez/polka/utils"
 )
 
@@ -20,6 +24,21 @@ const (
 // Create singleton DB
 var db *DB
 
+var (
+	// ErrNotFound is returned when no payment has the requested id.
+	ErrNotFound = errors.New("payment not found")
+	// ErrKeyReused is returned when an idempotency key is sent again with a different payment.
+	ErrKeyReused = errors.New("idempotency key was already used for a different payment")
+	// ErrReservedKey is returned for idempotency keys that only the receiver assigns.
+	ErrReservedKey = fmt.Errorf("idempotency keys can't start with %q", reservedKeyPrefixes)
+	// ErrAlreadyReversed is returned when reversing a payment for the second time.
+	ErrAlreadyReversed = errors.New("payment was already reversed")
+	// ErrIsReversal is returned when reversing a reversal.
+	ErrIsReversal = errors.New("a reversal can't be reversed")
+	// ErrNotApplied is returned when reversing a payment that is held for review or rejected.
+	ErrNotApplied = errors.New("only validated payments can be reversed")
+)
+
 type DB struct {
 	ctx    context.Context
 	conn   *pgxpool.Pool
@@ -62,66 +81,278 @@ func New(ctx context.Context) error {
 	return nil
 }
 
-func GetPayment(ctx context.Context, paymnt *utils.Payment) error {
+// GetPayment scans the payment with the given id into paymnt.
+func GetPayment(ctx context.Context, id string, paymnt *utils.Payment) error {
+	return scanPayment(db.conn.QueryRow(ctx, getPaymentQ, id), paymnt)
+}
+
+// ReversePayment records a payment compensating the one with the given id,
+// flowing the same amount in the opposite direction, and returns it.
+// Reversals can't be reversed, and a payment can only be reversed once.
+func ReversePayment(ctx context.Context, id, reversalId string) (*utils.Payment, error) {
 	var (
-		senBank string
-		recBank string
-		senAcc  int
-		recAcc  int
-		amount  int
-		time    time.Time
-		err     error
+		original utils.Payment
+		reversed bool
 	)
 
-	err = db.conn.QueryRow(
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Lock the original payment so that concurrent reversals queue up
+	err = scanPayment(tx.QueryRow(ctx, lockPaymentQ, id), &original)
+	if err != nil {
+		return nil, err
+	}
+	if original.Reverses != "" {
+		return nil, ErrIsReversal
+	}
+	err = tx.QueryRow(ctx, isReversedQ, id).Scan(&reversed)
+	if err != nil {
+		return nil, err
+	}
+	if reversed {
+		return nil, ErrAlreadyReversed
+	}
+	if !utils.IsApplied(original.Status) {
+		return nil, ErrNotApplied
+	}
+
+	reversal := &utils.Payment{
+		Id:       reversalId,
+		Sender:   original.Receiver,
+		Receiver: original.Sender,
+		Amount:   original.Amount,
+		Currency: original.Currency,
+		Time:     time.Now().UTC(),
+		Reverses: original.Id,
+		Status:   utils.PaymentValidated,
+	}
+
+	// Money can't flow back to or from accounts that are no longer open
+	statuses, err := lockAccounts(ctx, tx, []*utils.Payment{reversal})
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAccounts(statuses, reversal); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
 		ctx,
-		getLatestPaymentQ,
-	).Scan(
-		&senBank,
-		&recBank,
-		&senAcc,
-		&recAcc,
-		&amount,
-		&time,
+		insertPaymentQ,
+		reversal.Id,
+		reversal.Sender.Name,
+		reversal.Receiver.Name,
+		reversal.Sender.Account,
+		reversal.Receiver.Account,
+		reversal.Amount,
+		reversal.Time,
+		nil,
+		reversal.Hash(),
+		reversal.Reverses,
+		reversal.Status,
+		nil,
+		reversal.Currency,
+		nil,
 	)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err = recordInserted(ctx, tx, []*utils.Payment{reversal}); err != nil {
+		return nil, err
+	}
+	_, err = advancePayments(ctx, tx, []string{original.Id}, utils.PaymentReversed, "reversed by "+reversal.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = insertOutboxEntry(ctx, tx, reversal)
+	if err != nil {
+		return nil, err
 	}
 
-	paymnt.Sender.Name = senBank
-	paymnt.Receiver.Name = recBank
-	paymnt.Sender.Account = senAcc
-	paymnt.Receiver.Account = recAcc
-	paymnt.Amount = amount
-	paymnt.Time = time
+	return reversal, tx.Commit(ctx)
+}
+
+// scanPayment scans a row selected by getPaymentQ into paymnt.
+func scanPayment(row pgx.Row, paymnt *utils.Payment) error {
+	err := row.Scan(
+		&paymnt.Id,
+		&paymnt.Sender.Name,
+		&paymnt.Receiver.Name,
+		&paymnt.Sender.Account,
+		&paymnt.Receiver.Account,
+		&paymnt.Amount,
+		&paymnt.Currency,
+		&paymnt.Time,
+		&paymnt.Reverses,
+		&paymnt.Mandate,
+		&paymnt.Status,
+		&paymnt.Reason,
+	)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
 
 	return err
 }
 
-func InsertPayment(ctx context.Context, paymnt *utils.Payment) error {
-	_, err := db.conn.Exec(
-		ctx,
-		insertPaymentQ,
-		paymnt.Sender.Name,
-		paymnt.Receiver.Name,
-		paymnt.Sender.Account,
-		paymnt.Receiver.Account,
-		paymnt.Amount,
-		paymnt.Time,
+// InsertPayment stores the payment together with its idempotency key, if any.
+// New payments between accounts that aren't open fail with an *AccountError.
+// Only validated payments are sent to the cache, received ones wait for ReviewPayment.
+// It returns true if the key was already stored with the same payment,
+// in which case nothing is inserted, the request is a replay and the
+// payment's id and status are set to the ones originally assigned.
+func InsertPayment(ctx context.Context, paymnt *utils.Payment, key string) (bool, error) {
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	replayed, err := insertPayment(ctx, tx, paymnt, key)
+	if err != nil || replayed {
+		return replayed, err
+	}
+
+	return false, tx.Commit(ctx)
+}
+
+// reservedKeyPrefixes start the idempotency keys that only the receiver assigns.
+var reservedKeyPrefixes = []string{scheduleKeyPrefix, mandateKeyPrefix, iso20022.KeyPrefix}
+
+// CheckKey returns ErrReservedKey for idempotency keys sent by clients that
+// could take the place of those of scheduled payments, mandates or pacs.008
+// transactions.
+func CheckKey(key string) error {
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return ErrReservedKey
+		}
+	}
+	return nil
+}
+
+// FindIdempotentPayment tells whether the sending bank already stored the key
+// with the same payment, in which case the payment's id, status and reason are set to the
+// ones originally assigned. It fails with ErrKeyReused if the key was stored
+// with a different payment. Looking the key up before screening the payment
+// keeps replays from being screened, and rejected, again.
+func FindIdempotentPayment(ctx context.Context, paymnt *utils.Payment, key string) (bool, error) {
+	var storedId, storedHash, storedStatus, storedReason string
+
+	if key == "" {
+		return false, nil
+	}
+
+	err := db.conn.QueryRow(ctx, getIdempotentPaymentQ, paymnt.Sender.Name, key).Scan(
+		&storedId,
+		&storedHash,
+		&storedStatus,
+		&storedReason,
 	)
-	return err
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if storedHash != paymnt.Hash() {
+		return false, ErrKeyReused
+	}
+	paymnt.Id = storedId
+	paymnt.Status = storedStatus
+	paymnt.Reason = storedReason
+
+	return true, nil
 }
 
-func DeletePayment(ctx context.Context, paymnt *utils.Payment) error {
-	_, err := db.conn.Exec(
+// insertPayment does the work of InsertPayment as part of the given transaction.
+func insertPayment(ctx context.Context, tx pgx.Tx, paymnt *utils.Payment, key string) (bool, error) {
+	var (
+		idemKey      interface{} // NULL unless a key was sent
+		storedId     string
+		storedHash   string
+		storedStatus string
+		storedReason string
+	)
+
+	hash := paymnt.Hash()
+	if key != "" {
+		idemKey = key
+	}
+
+	tag, err := tx.Exec(
 		ctx,
-		deletePaymentQ,
+		insertPaymentQ,
+		paymnt.Id,
 		paymnt.Sender.Name,
 		paymnt.Receiver.Name,
 		paymnt.Sender.Account,
 		paymnt.Receiver.Account,
 		paymnt.Amount,
-		paymnt.Time,
+		paymnt.Time.UTC(),
+		idemKey,
+		hash,
+		nil,
+		paymnt.Status,
+		nullIfEmpty(paymnt.Reason),
+		paymnt.Currency,
+		nullIfEmpty(paymnt.Mandate),
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	// The payment is new, check its accounts and queue its cache update in the same transaction
+	if tag.RowsAffected() == 1 {
+		statuses, err := lockAccounts(ctx, tx, []*utils.Payment{paymnt})
+		if err != nil {
+			return false, err
+		}
+		if err = checkAccounts(statuses, paymnt); err != nil {
+			return false, err
+		}
+		if err = recordInserted(ctx, tx, []*utils.Payment{paymnt}); err != nil {
+			return false, err
+		}
+
+		if paymnt.Status == utils.PaymentValidated {
+			err = insertOutboxEntry(ctx, tx, paymnt)
+			if err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	// The key is taken, check that it was taken by the same payment
+	err = tx.QueryRow(ctx, getIdempotentPaymentQ, paymnt.Sender.Name, key).Scan(
+		&storedId,
+		&storedHash,
+		&storedStatus,
+		&storedReason,
+	)
+	if err != nil {
+		return false, err
+	}
+	if storedHash != hash {
+		return false, ErrKeyReused
+	}
+	paymnt.Id = storedId
+	paymnt.Status = storedStatus
+	paymnt.Reason = storedReason
+
+	return true, nil
+}
+
+// nullIfEmpty returns nil for empty strings, which are stored as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
@@ -0,0 +1,95 @@
+package dbstore
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// recordInserted records the statuses newly inserted payments start with,
+// which is received, followed by validated unless they're held for review.
+func recordInserted(ctx context.Context, tx pgx.Tx, payments []*utils.Payment) error {
+	ids := make([]string, 0, 2*len(payments))
+	statuses := make([]string, 0, 2*len(payments))
+	details := make([]string, 0, 2*len(payments))
+
+	for _, paymnt := range payments {
+		ids = append(ids, paymnt.Id)
+		statuses = append(statuses, utils.PaymentReceived)
+		details = append(details, paymnt.Reason)
+
+		if paymnt.Status == utils.PaymentValidated {
+			ids = append(ids, paymnt.Id)
+			statuses = append(statuses, utils.PaymentValidated)
+			details = append(details, "")
+		}
+	}
+
+	_, err := tx.Exec(ctx, insertPaymentEventsQ, ids, statuses, details)
+	return err
+}
+
+// advancePayments moves the payments with the given ids, or in the batches with
+// the given ids, to a status, recording the transition with an optional detail.
+// Payments that can't move to the status from their current one are left as they are.
+// It returns the ids of the payments moved.
+func advancePayments(ctx context.Context, tx pgx.Tx, ids []string, status, detail string) ([]string, error) {
+
+	rows, err := tx.Query(
+		ctx,
+		advancePaymentsQ,
+		ids,
+		status,
+		utils.StatusesBefore(status),
+		nullIfEmpty(detail),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	moved := make([]string, 0, len(ids))
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		moved = append(moved, id)
+	}
+
+	return moved, rows.Err()
+}
+
+// postPayments moves the payments of a cache update the cache applied to posted,
+// and queues the events telling their banks, as part of the same transaction.
+func postPayments(ctx context.Context, tx pgx.Tx, updateId string) error {
+	ids, err := advancePayments(ctx, tx, []string{updateId}, utils.PaymentPosted, "")
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return enqueuePaymentEvents(ctx, tx, utils.EventPaymentPosted, ids)
+}
+
+// ListPaymentEvents returns the statuses a payment moved to, in order.
+func ListPaymentEvents(ctx context.Context, id string) ([]utils.PaymentEvent, error) {
+
+	rows, err := db.conn.Query(ctx, listPaymentEventsQ, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]utils.PaymentEvent, 0)
+	for rows.Next() {
+		var event utils.PaymentEvent
+		err = rows.Scan(&event.PaymentId, &event.Status, &event.Time, &event.Detail)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
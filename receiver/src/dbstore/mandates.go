@@ -0,0 +1,366 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+var (
+	// ErrMandateNotFound is returned when no mandate has the requested id.
+	ErrMandateNotFound = errors.New("mandate not found")
+	// ErrMandateTransition is returned when a mandate can't move to the requested status.
+	ErrMandateTransition = errors.New("invalid mandate status change")
+)
+
+// mandateTransitions lists the statuses each status can change to.
+var mandateTransitions = map[string][]string{
+	utils.MandateActive: {utils.MandatePaused, utils.MandateCancelled},
+	utils.MandatePaused: {utils.MandateActive, utils.MandateCancelled},
+}
+
+// mandateKeyPrefix starts the idempotency keys of mandate executions.
+const mandateKeyPrefix = "mandate:"
+
+// MandateKey returns the idempotency key of the payment executing a mandate
+// at the given due time, so that each execution is only ever stored once.
+func MandateKey(id string, dueAt time.Time) string {
+	return fmt.Sprintf("%s%s:%d", mandateKeyPrefix, id, dueAt.Unix())
+}
+
+// CreateMandate stores a mandate, which is first due at its NextAt time.
+func CreateMandate(ctx context.Context, m *utils.Mandate) error {
+	_, err := db.conn.Exec(
+		ctx,
+		insertMandateQ,
+		m.Id,
+		m.Sender.Name,
+		m.Receiver.Name,
+		m.Sender.Account,
+		m.Receiver.Account,
+		m.Amount,
+		m.Currency,
+		m.Schedule,
+		m.StartAt.UTC(),
+		nullIfZero(m.EndAt),
+		m.MaxCount,
+		nullIfZero(m.NextAt),
+	)
+	return err
+}
+
+// GetMandate returns the mandate with the given id.
+func GetMandate(ctx context.Context, id string) (*utils.Mandate, error) {
+	m := &utils.Mandate{}
+	if err := scanMandate(db.conn.QueryRow(ctx, getMandateQ, id), m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ListMandates returns up to limit mandates of the named bank in order of creation,
+// only those of the account and with the status if they're given.
+func ListMandates(ctx context.Context, bank string, account *int, status string, limit int) ([]utils.Mandate, error) {
+
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := db.conn.Query(ctx, listMandatesQ, bank, account, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mandates := make([]utils.Mandate, 0, limit)
+	for rows.Next() {
+		var m utils.Mandate
+		if err = scanMandate(rows, &m); err != nil {
+			return nil, err
+		}
+		mandates = append(mandates, m)
+	}
+
+	return mandates, rows.Err()
+}
+
+// ListExecutions returns up to limit executions of a mandate, the latest first.
+func ListExecutions(ctx context.Context, id string, limit int) ([]utils.MandateExecution, error) {
+
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := db.conn.Query(ctx, listExecutionsQ, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := make([]utils.MandateExecution, 0, limit)
+	for rows.Next() {
+		var exec utils.MandateExecution
+		err = rows.Scan(
+			&exec.MandateId,
+			&exec.DueAt,
+			&exec.Status,
+			&exec.PaymentId,
+			&exec.Attempts,
+			&exec.Reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, exec)
+	}
+
+	return executions, rows.Err()
+}
+
+// SetMandateStatus pauses, resumes or cancels a mandate, and returns it.
+// Resumed mandates skip the executions that were due while they were paused,
+// and cancelled ones fail the executions still waiting to be retried.
+func SetMandateStatus(ctx context.Context, id, status string, now time.Time) (*utils.Mandate, error) {
+	m := &utils.Mandate{}
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Lock the mandate so that it isn't scheduled while it changes
+	if err = scanMandate(tx.QueryRow(ctx, lockMandateQ, id), m); err != nil {
+		return nil, err
+	}
+	allowed := false
+	for _, next := range mandateTransitions[m.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s mandate can't become %s", ErrMandateTransition, m.Status, status)
+	}
+
+	m.Status = status
+	switch status {
+	case utils.MandateActive:
+		if m.NextAt.Before(now) {
+			m.NextAt = m.Next(now, m.Count)
+		}
+		if m.NextAt.IsZero() {
+			m.Status = utils.MandateEnded
+		}
+	case utils.MandateCancelled:
+		m.NextAt = time.Time{}
+		_, err = tx.Exec(ctx, failPendingExecutionsQ, id, "mandate was cancelled")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, updateMandateQ, id, m.Status, m.Count, nullIfZero(m.NextAt))
+	if err != nil {
+		return nil, err
+	}
+
+	return m, tx.Commit(ctx)
+}
+
+// ScheduleMandates locks up to limit active mandates that are due by now, and
+// records an execution for each time they were due. Mandates that reached their
+// end date or maximum count end. Mandates locked by other receivers are skipped.
+// It returns the number of mandates handled.
+func ScheduleMandates(ctx context.Context, now time.Time, limit int) (int, error) {
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Read all mandates before updating them, the connection is busy until then
+	rows, err := tx.Query(ctx, selectDueMandatesQ, now.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	due := make([]*utils.Mandate, 0, limit)
+	for rows.Next() {
+		m := &utils.Mandate{}
+		if err = scanMandate(rows, m); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, m := range due {
+		// Catch up with every time the mandate was due
+		for !m.NextAt.IsZero() && !m.NextAt.After(now) {
+			_, err = tx.Exec(ctx, insertExecutionQ, m.Id, m.NextAt, now.UTC())
+			if err != nil {
+				return 0, err
+			}
+			m.Count++
+			m.NextAt = m.Next(m.NextAt, m.Count)
+		}
+		if m.NextAt.IsZero() {
+			m.Status = utils.MandateEnded
+		}
+
+		_, err = tx.Exec(ctx, updateMandateQ, m.Id, m.Status, m.Count, nullIfZero(m.NextAt))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(due), tx.Commit(ctx)
+}
+
+// ExecuteMandates claims up to limit executions of mandates that are due by
+// now, and passes each one to execute, which returns the id of the stored payment.
+// Claimed executions are skipped by other receivers for the claim duration, and
+// no locks are held while they're executed.
+// Failed executions are attempted again after the delay returned by retryIn,
+// until they fail maxAttempts times. Executions of paused or cancelled mandates
+// wait, and executions already stored by an attempt that was cut short are
+// marked as executed without executing them again.
+// It returns the number of executions handled.
+func ExecuteMandates(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+	maxAttempts int,
+	claim time.Duration,
+	retryIn func(attempts int) time.Duration,
+	execute func(*utils.Mandate, *utils.MandateExecution) (string, error),
+) (int, error) {
+	var (
+		paymentId string
+		hash      string
+		status    string
+		reason    string
+	)
+
+	mandates, executions, err := claimExecutions(ctx, now, limit, claim)
+	if err != nil {
+		return 0, err
+	}
+
+	// Execute payments and record each outcome on its own
+	for i, exec := range executions {
+		// A previous attempt may have stored the payment before being cut short
+		err = db.conn.QueryRow(ctx, getIdempotentPaymentQ, mandates[i].Sender.Name, MandateKey(exec.MandateId, exec.DueAt)).Scan(
+			&paymentId,
+			&hash,
+			&status,
+			&reason,
+		)
+		switch {
+		case err == nil:
+			_, err = db.conn.Exec(ctx, markExecutionDoneQ, exec.MandateId, exec.DueAt, paymentId)
+		case err == pgx.ErrNoRows:
+			paymentId, err = execute(mandates[i], exec)
+			switch {
+			case err == nil:
+				_, err = db.conn.Exec(ctx, markExecutionDoneQ, exec.MandateId, exec.DueAt, paymentId)
+			case exec.Attempts+1 >= maxAttempts:
+				_, err = db.conn.Exec(ctx, markExecutionFailedQ, exec.MandateId, exec.DueAt, err.Error())
+			default:
+				_, err = db.conn.Exec(
+					ctx,
+					markExecutionRetryQ,
+					exec.MandateId,
+					exec.DueAt,
+					err.Error(),
+					now.UTC().Add(retryIn(exec.Attempts+1)),
+				)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(executions), nil
+}
+
+// claimExecutions claims up to limit executions of mandates that are due by now
+// for the given duration, in order. It returns them together with their mandates.
+func claimExecutions(ctx context.Context, now time.Time, limit int, claim time.Duration) ([]*utils.Mandate, []*utils.MandateExecution, error) {
+
+	rows, err := db.conn.Query(ctx, claimExecutionsQ, now.UTC(), limit, claim.Milliseconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	mandates := make([]*utils.Mandate, 0, limit)
+	executions := make([]*utils.MandateExecution, 0, limit)
+	for rows.Next() {
+		m := &utils.Mandate{}
+		exec := &utils.MandateExecution{Status: utils.ExecutionPending}
+		if err = scanMandate(rows, m, &exec.DueAt, &exec.Attempts); err != nil {
+			return nil, nil, err
+		}
+		exec.MandateId = m.Id
+		mandates = append(mandates, m)
+		executions = append(executions, exec)
+	}
+
+	return mandates, executions, rows.Err()
+}
+
+// scanMandate scans a row selected with mandateFieldsQ into m,
+// and any columns following them into dest.
+func scanMandate(row pgx.Row, m *utils.Mandate, dest ...interface{}) error {
+	var endAt, nextAt *time.Time
+
+	err := row.Scan(append([]interface{}{
+		&m.Id,
+		&m.Sender.Name,
+		&m.Receiver.Name,
+		&m.Sender.Account,
+		&m.Receiver.Account,
+		&m.Amount,
+		&m.Currency,
+		&m.Schedule,
+		&m.StartAt,
+		&endAt,
+		&m.MaxCount,
+		&m.Status,
+		&m.Count,
+		&nextAt,
+	}, dest...)...)
+	if err == pgx.ErrNoRows {
+		return ErrMandateNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if endAt != nil {
+		m.EndAt = *endAt
+	}
+	if nextAt != nil {
+		m.NextAt = *nextAt
+	}
+	return nil
+}
+
+// nullIfZero returns nil for zero times, which are stored as NULL.
+func nullIfZero(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
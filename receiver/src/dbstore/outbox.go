@@ -0,0 +1,174 @@
+package dbstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// Kinds of outbox entries, each delivered to its own cache endpoint.
+const (
+	OutboxPayment = "payment" // Payload is a utils.SRBalance
+	OutboxBatch   = "batch"   // Payload is a utils.BatchBalance
+	OutboxAccount = "account" // Payload is a utils.AccountStatus
+)
+
+// ErrUndeliverable is returned by deliver functions for entries that must not be retried.
+var ErrUndeliverable = errors.New("undeliverable outbox entry")
+
+// OutboxEntry is a cache update waiting to be delivered.
+type OutboxEntry struct {
+	Id       int64
+	UpdateId string
+	Kind     string
+	Payload  []byte
+	Attempts int
+}
+
+// insertOutboxEntry queues the cache update caused by a payment
+// as part of the transaction inserting the payment.
+func insertOutboxEntry(ctx context.Context, tx pgx.Tx, paymnt *utils.Payment) error {
+	payload, err := json.Marshal(utils.NewSRBalance(paymnt))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertOutboxQ, paymnt.Id, OutboxPayment, payload)
+	return err
+}
+
+// insertBatchOutboxEntry queues the net cache update caused by a batch
+// of payments as part of the transaction inserting them.
+func insertBatchOutboxEntry(ctx context.Context, tx pgx.Tx, batchId string, payments []*utils.Payment) error {
+	batch, err := utils.NewBatchBalance(batchId, payments)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertOutboxQ, batchId, OutboxBatch, payload)
+	return err
+}
+
+// insertAccountOutboxEntry queues a change of an account's status
+// as part of the transaction making the change.
+func insertAccountOutboxEntry(ctx context.Context, tx pgx.Tx, acc *utils.Account) error {
+	update := &utils.AccountStatus{
+		Id:      utils.NewId(),
+		Name:    acc.Bank,
+		Account: uint32(acc.Account),
+		Status:  acc.Status,
+	}
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertOutboxQ, update.Id, OutboxAccount, payload)
+	return err
+}
+
+// DeliverOutbox claims up to limit due outbox entries and passes each one to deliver.
+// Claimed entries are skipped by other receivers for the claim duration, and no
+// locks are held while they're delivered, so a receiver stopping mid-delivery
+// only delays its entries until the claim runs out.
+// Delivered entries are marked as such, while failed ones are attempted again
+// after the delay returned by retryIn, unless deliver returns ErrUndeliverable.
+// The payments of delivered entries are posted, which their banks' webhooks are
+// told of, while those of rejected entries are rejected.
+// It returns the number of entries handled.
+func DeliverOutbox(
+	ctx context.Context,
+	limit int,
+	claim time.Duration,
+	deliver func(*OutboxEntry) error,
+	retryIn func(attempts int) time.Duration,
+) (int, error) {
+
+	entries, err := claimOutbox(ctx, limit, claim)
+	if err != nil {
+		return 0, err
+	}
+
+	// Deliver entries and record each outcome in its own transaction
+	for _, entry := range entries {
+		if err = recordDelivery(ctx, entry, deliver(entry), retryIn); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+// claimOutbox claims up to limit due outbox entries for the given duration, in order.
+func claimOutbox(ctx context.Context, limit int, claim time.Duration) ([]*OutboxEntry, error) {
+
+	rows, err := db.conn.Query(ctx, claimOutboxQ, limit, claim.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*OutboxEntry, 0, limit)
+	for rows.Next() {
+		entry := &OutboxEntry{}
+		err = rows.Scan(&entry.Id, &entry.UpdateId, &entry.Kind, &entry.Payload, &entry.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Updated rows are returned in no particular order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Id < entries[j].Id })
+	return entries, nil
+}
+
+// recordDelivery marks a claimed entry with the outcome of its delivery,
+// posting or rejecting its payments in the same transaction.
+func recordDelivery(ctx context.Context, entry *OutboxEntry, deliveryErr error, retryIn func(attempts int) time.Duration) error {
+
+	if deliveryErr != nil && !errors.Is(deliveryErr, ErrUndeliverable) {
+		delay := retryIn(entry.Attempts + 1)
+		_, err := db.conn.Exec(ctx, markFailedQ, entry.Id, deliveryErr.Error(), delay.Milliseconds())
+		return err
+	}
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	if deliveryErr == nil {
+		tag, err := tx.Exec(ctx, markDeliveredQ, entry.Id)
+		if err == nil && tag.RowsAffected() > 0 && entry.Kind != OutboxAccount {
+			err = postPayments(ctx, tx, entry.UpdateId)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		tag, err := tx.Exec(ctx, markRejectedQ, entry.Id, deliveryErr.Error())
+		if err == nil && tag.RowsAffected() > 0 && entry.Kind != OutboxAccount {
+			_, err = advancePayments(ctx, tx, []string{entry.UpdateId}, utils.PaymentRejected, deliveryErr.Error())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
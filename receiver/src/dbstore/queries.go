@@ -1,59 +1,708 @@
 package dbstore
 
 const (
-	getTransByTransQ = `
-	SELECT 
-		sending_bank_id AND
-		receiving_bank_id AND
-		sending_account AND
-		receiving_account AND
-		dollar_amount AND
-		time 
-	FROM transactions WHERE 
-	sending_bank_id=(SELECT id FROM banks WHERE name=$1) AND
-	receiving_bank_id=(SELECT id FROM banks WHERE name=$2) AND
-	sending_account=$3 AND
-	receiving_account=$4 AND
-	dollar_amount=$5 AND
-	time=$6;
-	`
-	getLatestPaymentQ = `
+	getPaymentQ = `
 	SELECT
+		payment_id,
 		sender.name,
 		receiver.name,
 		sending_account,
 		receiving_account,
 		dollar_amount,
-		time
+		currency,
+		time,
+		COALESCE(reverses::text, ''),
+		COALESCE(mandate_id::text, ''),
+		status,
+		COALESCE(review_reason, '')
+	FROM transactions
+	JOIN banks sender ON sender.id=transactions.sending_bank_id
+	JOIN banks receiver ON receiver.id=transactions.receiving_bank_id
+	WHERE payment_id=$1
+	`
+	lockPaymentQ = getPaymentQ + "FOR UPDATE OF transactions;"
+	isReversedQ  = `
+	SELECT EXISTS (SELECT 1 FROM transactions WHERE reverses=$1);
+	`
+	// searchPaymentsQ is completed by SearchPayments with filters, ordering and a limit
+	searchPaymentsQ = `
+	SELECT
+		transactions.id,
+		payment_id,
+		sender.name,
+		receiver.name,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		time,
+		COALESCE(reverses::text, ''),
+		COALESCE(mandate_id::text, ''),
+		status,
+		COALESCE(review_reason, '')
 	FROM transactions
 	JOIN banks sender ON sender.id=transactions.sending_bank_id
 	JOIN banks receiver ON receiver.id=transactions.receiving_bank_id
-	ORDER BY time;
 	`
 	insertPaymentQ = `
 	INSERT INTO transactions (
+		payment_id,
 		sending_bank_id,
 		receiving_bank_id,
 		sending_account,
 		receiving_account,
 		dollar_amount,
-		time
+		time,
+		idempotency_key,
+		request_hash,
+		reverses,
+		status,
+		review_reason,
+		currency,
+		mandate_id
 	) VALUES (
-		(SELECT id FROM banks WHERE name=$1),
+		$1,
 		(SELECT id FROM banks WHERE name=$2),
-		$3,
+		(SELECT id FROM banks WHERE name=$3),
+		$4,
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12,
+		$13,
+		$14
+	)
+	ON CONFLICT (sending_bank_id, idempotency_key) DO NOTHING;
+	`
+	getIdempotentPaymentQ = `
+	SELECT payment_id, request_hash, status, COALESCE(review_reason, '')
+	FROM transactions
+	WHERE sending_bank_id=(SELECT id FROM banks WHERE name=$1) AND idempotency_key=$2;
+	`
+	insertBatchPaymentQ = `
+	INSERT INTO transactions (
+		payment_id,
+		sending_bank_id,
+		receiving_bank_id,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		time,
+		request_hash,
+		batch_id,
+		status,
+		review_reason,
+		currency,
+		idempotency_key
+	) VALUES (
+		$1,
+		(SELECT id FROM banks WHERE name=$2),
+		(SELECT id FROM banks WHERE name=$3),
+		$4,
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12,
+		$13
+	)
+	ON CONFLICT DO NOTHING;
+	`
+	hasPaidQ = `
+	SELECT EXISTS (
+		SELECT 1 FROM transactions
+		WHERE sending_bank_id=(SELECT id FROM banks WHERE name=$1)
+		AND sending_account=$2
+		AND receiving_bank_id=(SELECT id FROM banks WHERE name=$3)
+		AND receiving_account=$4
+		AND status IN ('validated', 'posted', 'included', 'settled')
+	);
+	`
+	// scheduledColumnsQ is completed by the queries selecting scheduled payments
+	scheduledColumnsQ = `
+	SELECT
+		schedule_id,
+		sender.name,
+		receiver.name,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		execute_at,
+		status,
+		COALESCE(payment_id::text, ''),
+		COALESCE(last_error, ''),
+		attempts
+	FROM scheduled_payments
+	JOIN banks sender ON sender.id=scheduled_payments.sending_bank_id
+	JOIN banks receiver ON receiver.id=scheduled_payments.receiving_bank_id
+	`
+	getScheduledQ  = scheduledColumnsQ + "WHERE schedule_id=$1"
+	lockScheduledQ = getScheduledQ + " FOR UPDATE OF scheduled_payments;"
+	// listScheduledQ takes a bank name, an optional account and status, and a limit
+	listScheduledQ = scheduledColumnsQ + `
+	WHERE sender.name=$1
+	AND ($2::int IS NULL OR sending_account=$2)
+	AND ($3::text='' OR status=$3)
+	ORDER BY execute_at, scheduled_payments.id
+	LIMIT $4;
+	`
+	// claimScheduledQ holds off other receivers from up to $2 payments due by
+	// $1 for $3 milliseconds, in which they're executed without holding locks.
+	claimScheduledQ = `
+	WITH claimed AS (
+		UPDATE scheduled_payments SET claimed_until = NOW() + $3 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM scheduled_payments
+			WHERE status='scheduled' AND execute_at<=$1
+			AND (claimed_until IS NULL OR claimed_until<=NOW())
+			ORDER BY execute_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	)` + scheduledColumnsQ + `
+	WHERE scheduled_payments.id IN (SELECT id FROM claimed)
+	ORDER BY execute_at, scheduled_payments.id;
+	`
+	insertScheduledQ = `
+	INSERT INTO scheduled_payments (
+		schedule_id,
+		sending_bank_id,
+		receiving_bank_id,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		execute_at
+	) VALUES (
+		$1,
+		(SELECT id FROM banks WHERE name=$2),
+		(SELECT id FROM banks WHERE name=$3),
+		$4,
+		$5,
+		$6,
+		$7,
+		$8
+	);
+	`
+	// Payments being executed can't be cancelled
+	cancelScheduledQ = `
+	UPDATE scheduled_payments SET status='cancelled'
+	WHERE schedule_id=$1 AND (claimed_until IS NULL OR claimed_until<=NOW());
+	`
+	// Stored payments are always recorded, while failures are only recorded
+	// for payments no other receiver executed after their claim ran out.
+	markExecutedQ = `
+	UPDATE scheduled_payments SET
+		attempts = attempts + 1,
+		status = 'executed',
+		payment_id = $2,
+		claimed_until = NULL
+	WHERE schedule_id=$1;
+	`
+	markScheduleFailedQ = `
+	UPDATE scheduled_payments SET
+		attempts = attempts + 1,
+		status = 'failed',
+		last_error = $2,
+		claimed_until = NULL
+	WHERE schedule_id=$1 AND status='scheduled';
+	`
+	markScheduleRetryQ = `
+	UPDATE scheduled_payments SET attempts = attempts + 1, last_error = $2, claimed_until = NULL
+	WHERE schedule_id=$1 AND status='scheduled';
+	`
+	// mandateFieldsQ and mandateJoinsQ make up the queries selecting mandates
+	mandateFieldsQ = `
+		mandates.mandate_id,
+		sender.name,
+		receiver.name,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		schedule,
+		start_at,
+		end_at,
+		max_count,
+		mandates.status,
+		count,
+		next_at
+	`
+	mandateJoinsQ = `
+	JOIN banks sender ON sender.id=mandates.sending_bank_id
+	JOIN banks receiver ON receiver.id=mandates.receiving_bank_id
+	`
+	getMandateQ  = "SELECT" + mandateFieldsQ + "FROM mandates" + mandateJoinsQ + "WHERE mandates.mandate_id=$1"
+	lockMandateQ = getMandateQ + " FOR UPDATE OF mandates;"
+	// listMandatesQ takes a bank name, an optional account and status, and a limit
+	listMandatesQ = "SELECT" + mandateFieldsQ + "FROM mandates" + mandateJoinsQ + `
+	WHERE sender.name=$1
+	AND ($2::int IS NULL OR sending_account=$2)
+	AND ($3::text='' OR mandates.status=$3)
+	ORDER BY mandates.id
+	LIMIT $4;
+	`
+	selectDueMandatesQ = "SELECT" + mandateFieldsQ + "FROM mandates" + mandateJoinsQ + `
+	WHERE mandates.status='active' AND next_at<=$1
+	ORDER BY next_at, mandates.id
+	LIMIT $2
+	FOR UPDATE OF mandates SKIP LOCKED;
+	`
+	insertMandateQ = `
+	INSERT INTO mandates (
+		mandate_id,
+		sending_bank_id,
+		receiving_bank_id,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		schedule,
+		start_at,
+		end_at,
+		max_count,
+		next_at
+	) VALUES (
+		$1,
+		(SELECT id FROM banks WHERE name=$2),
+		(SELECT id FROM banks WHERE name=$3),
 		$4,
 		$5,
-		$6
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12
 	);
 	`
-	deletePaymentQ = `
-	DELETE FROM transactions WHERE 
-	sending_bank_id=(SELECT id FROM banks WHERE name=$1) AND
-	receiving_bank_id=(SELECT id FROM banks WHERE name=$2) AND
-	sending_account=$3 AND
-	receiving_account=$4 AND
-	dollar_amount=$5 AND
-	time=$6;
+	updateMandateQ = `
+	UPDATE mandates SET status=$2, count=$3, next_at=$4 WHERE mandate_id=$1;
+	`
+	insertExecutionQ = `
+	INSERT INTO mandate_executions (mandate_id, due_at, next_attempt_at) VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING;
+	`
+	listExecutionsQ = `
+	SELECT mandate_id, due_at, status, COALESCE(payment_id::text, ''), attempts, COALESCE(last_error, '')
+	FROM mandate_executions
+	WHERE mandate_id=$1
+	ORDER BY due_at DESC
+	LIMIT $2;
+	`
+	// selectDueExecutionsQ selects the mandate of each execution, followed by the execution
+	// claimExecutionsQ holds off other receivers from up to $2 executions due
+	// by $1 for $3 milliseconds, in which they're executed without holding locks.
+	claimExecutionsQ = `
+	WITH claimed AS (
+		UPDATE mandate_executions SET claimed_until = NOW() + $3 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT mandate_executions.id FROM mandate_executions
+			JOIN mandates ON mandates.mandate_id=mandate_executions.mandate_id
+			WHERE mandate_executions.status='pending'
+			AND next_attempt_at<=$1
+			AND (claimed_until IS NULL OR claimed_until<=NOW())
+			AND mandates.status IN ('active', 'ended')
+			ORDER BY next_attempt_at, mandate_executions.id
+			LIMIT $2
+			FOR UPDATE OF mandate_executions SKIP LOCKED
+		)
+		RETURNING id
+	)
+	SELECT` + mandateFieldsQ + `,
+		due_at,
+		attempts
+	FROM mandate_executions
+	JOIN mandates ON mandates.mandate_id=mandate_executions.mandate_id` + mandateJoinsQ + `
+	WHERE mandate_executions.id IN (SELECT id FROM claimed)
+	ORDER BY next_attempt_at, mandate_executions.id;
+	`
+	// Stored payments are always recorded, even for executions failed by
+	// cancelling their mandate while they ran, while failures are only recorded
+	// for executions that are still pending.
+	markExecutionDoneQ = `
+	UPDATE mandate_executions SET
+		attempts = attempts + 1,
+		status = 'executed',
+		payment_id = $3,
+		claimed_until = NULL
+	WHERE mandate_id=$1 AND due_at=$2;
+	`
+	markExecutionFailedQ = `
+	UPDATE mandate_executions SET
+		attempts = attempts + 1,
+		status = 'failed',
+		last_error = $3,
+		claimed_until = NULL
+	WHERE mandate_id=$1 AND due_at=$2 AND status='pending';
+	`
+	markExecutionRetryQ = `
+	UPDATE mandate_executions SET
+		attempts = attempts + 1,
+		last_error = $3,
+		next_attempt_at = $4,
+		claimed_until = NULL
+	WHERE mandate_id=$1 AND due_at=$2 AND status='pending';
+	`
+	failPendingExecutionsQ = `
+	UPDATE mandate_executions SET status='failed', last_error=$2
+	WHERE mandate_id=$1 AND status='pending';
+	`
+	// requestColumnsQ is completed by the queries selecting payment requests
+	requestColumnsQ = `
+	SELECT
+		request_id,
+		payee.name,
+		payee_account,
+		payer.name,
+		payer_account,
+		dollar_amount,
+		currency,
+		memo,
+		status,
+		COALESCE(payment_id::text, ''),
+		created_at,
+		expires_at
+	FROM payment_requests
+	JOIN banks payee ON payee.id=payment_requests.payee_bank_id
+	JOIN banks payer ON payer.id=payment_requests.payer_bank_id
+	`
+	getRequestQ  = requestColumnsQ + "WHERE request_id=$1"
+	lockRequestQ = getRequestQ + " FOR UPDATE OF payment_requests;"
+	// listRequestsQ takes the payer's and the payee's bank names and accounts,
+	// which are all optional, a status and a limit
+	listRequestsQ = requestColumnsQ + `
+	WHERE ($1::text='' OR payer.name=$1)
+	AND ($2::int IS NULL OR payer_account=$2)
+	AND ($3::text='' OR payee.name=$3)
+	AND ($4::int IS NULL OR payee_account=$4)
+	AND ($5::text='' OR status=$5)
+	ORDER BY payment_requests.id
+	LIMIT $6;
+	`
+	insertRequestQ = `
+	INSERT INTO payment_requests (
+		request_id,
+		payee_bank_id,
+		payee_account,
+		payer_bank_id,
+		payer_account,
+		dollar_amount,
+		currency,
+		memo,
+		created_at,
+		expires_at
+	) VALUES (
+		$1,
+		(SELECT id FROM banks WHERE name=$2),
+		$3,
+		(SELECT id FROM banks WHERE name=$4),
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10
+	);
+	`
+	// notifyRequestQ tells the receivers listening for requests about a new one
+	notifyRequestQ = `
+	SELECT pg_notify('payment_requests', $1);
+	`
+	listenRequestsQ = `
+	LISTEN payment_requests;
+	`
+	setRequestStatusQ = `
+	UPDATE payment_requests SET status=$2, payment_id=$3 WHERE request_id=$1;
+	`
+	expireRequestsQ = `
+	UPDATE payment_requests SET status='expired'
+	WHERE id IN (
+		SELECT id FROM payment_requests
+		WHERE status='requested' AND expires_at<=$1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	);
+	`
+	listRatesQ = `
+	SELECT currency, rate::text FROM fx_rates ORDER BY currency;
+	`
+	listBanksQ = `
+	SELECT id, name, status, COALESCE(bic, ''), COALESCE(routing, ''), COALESCE(settlement_account, '')
+	FROM banks ORDER BY id;
+	`
+	createBankQ = `
+	INSERT INTO banks (name, status, bic, routing, settlement_account)
+	VALUES ($1, 'active', NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''))
+	RETURNING id, name, status, COALESCE(bic, ''), COALESCE(routing, ''), COALESCE(settlement_account, '');
+	`
+	// updateBankQ keeps the bank's BIC and settlement account unless new ones are given
+	updateBankQ = `
+	UPDATE banks SET name=$2, bic=COALESCE(NULLIF($3, ''), bic),
+	routing=COALESCE(NULLIF($4, ''), routing), settlement_account=COALESCE(NULLIF($5, ''), settlement_account)
+	WHERE id=$1
+	RETURNING id, name, status, COALESCE(bic, ''), COALESCE(routing, ''), COALESCE(settlement_account, '');
+	`
+	setBankStatusQ = `
+	UPDATE banks SET status=$2 WHERE id=$1
+	RETURNING id, name, status, COALESCE(bic, ''), COALESCE(routing, ''), COALESCE(settlement_account, '');
+	`
+	getAccountQ = `
+	SELECT accounts.bank_id, banks.name, account, status, opened_at
+	FROM accounts
+	JOIN banks ON banks.id=accounts.bank_id
+	WHERE accounts.bank_id=$1 AND account=$2
+	`
+	lockAccountQ      = getAccountQ + "FOR UPDATE OF accounts;"
+	getAccountByNameQ = `
+	SELECT accounts.bank_id, banks.name, account, status, opened_at
+	FROM accounts
+	JOIN banks ON banks.id=accounts.bank_id
+	WHERE banks.name=$1 AND account=$2;
+	`
+	openAccountQ = `
+	INSERT INTO accounts (account, bank_id, status)
+	SELECT $2, id, 'open' FROM banks WHERE name=$1
+	RETURNING bank_id, account, status, opened_at;
+	`
+	setAccountStatusQ = `
+	UPDATE accounts SET status=$3 WHERE bank_id=$1 AND account=$2;
+	`
+	// lockAccountStatusesQ takes an array of bank names and one of accounts
+	lockAccountStatusesQ = `
+	SELECT banks.name, accounts.account, accounts.status
+	FROM accounts
+	JOIN banks ON banks.id=accounts.bank_id
+	JOIN unnest($1::text[], $2::int[]) AS wanted(name, account)
+	ON wanted.name=banks.name AND wanted.account=accounts.account
+	FOR SHARE OF accounts;
+	`
+	insertOutboxQ = `
+	INSERT INTO outbox (update_id, kind, payload) VALUES ($1, $2, $3);
+	`
+	// claimOutboxQ holds off other receivers from up to $1 due entries for
+	// $2 milliseconds, in which they're delivered without holding locks.
+	claimOutboxQ = `
+	UPDATE outbox SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+	WHERE id IN (
+		SELECT id FROM outbox
+		WHERE delivered_at IS NULL AND rejected_at IS NULL AND next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, update_id, kind, payload, attempts;
+	`
+	// Outcomes are only recorded for entries no other receiver has delivered
+	// or rejected after their claim ran out.
+	markDeliveredQ = `
+	UPDATE outbox SET attempts = attempts + 1, delivered_at = NOW()
+	WHERE id = $1 AND delivered_at IS NULL AND rejected_at IS NULL;
+	`
+	markRejectedQ = `
+	UPDATE outbox SET attempts = attempts + 1, last_error = $2, rejected_at = NOW()
+	WHERE id = $1 AND delivered_at IS NULL AND rejected_at IS NULL;
+	`
+	markFailedQ = `
+	UPDATE outbox SET
+		attempts = attempts + 1,
+		last_error = $2,
+		next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond'
+	WHERE id = $1 AND delivered_at IS NULL AND rejected_at IS NULL;
+	`
+	insertPaymentEventsQ = `
+	INSERT INTO payment_events (payment_id, status, detail)
+	SELECT id::uuid, status, NULLIF(detail, '')
+	FROM unnest($1::text[], $2::text[], $3::text[]) AS events(id, status, detail);
+	`
+	// advancePaymentsQ moves the payments with the given ids, or in the batches
+	// with the given ids, to a status from any of the given ones, recording it
+	advancePaymentsQ = `
+	WITH advanced AS (
+		UPDATE transactions SET status=$2
+		WHERE (payment_id = ANY($1::text[]::uuid[]) OR batch_id = ANY($1::text[]::uuid[]))
+		AND status = ANY($3::text[])
+		RETURNING payment_id
+	)
+	INSERT INTO payment_events (payment_id, status, detail)
+	SELECT payment_id, $2, $4 FROM advanced
+	RETURNING payment_id;
+	`
+	listPaymentEventsQ = `
+	SELECT payment_id, status, at, COALESCE(detail, '')
+	FROM payment_events
+	WHERE payment_id=$1
+	ORDER BY id;
+	`
+	createWebhookQ = `
+	INSERT INTO webhooks (bank_id, url, secret)
+	SELECT id, $2, $3 FROM banks WHERE name=$1
+	RETURNING id, active, created_at;
+	`
+	getWebhookQ = `
+	SELECT webhooks.id, banks.name, url, active, created_at
+	FROM webhooks
+	JOIN banks ON banks.id=webhooks.bank_id
+	WHERE webhooks.id=$1;
+	`
+	listWebhooksQ = `
+	SELECT webhooks.id, banks.name, url, active, created_at
+	FROM webhooks
+	JOIN banks ON banks.id=webhooks.bank_id
+	WHERE banks.name=$1
+	ORDER BY webhooks.id;
+	`
+	setWebhookActiveQ = `
+	UPDATE webhooks SET active=$2 WHERE id=$1;
+	`
+	listDeadLettersQ = `
+	SELECT id, webhook_id, event_id, event_type, payload, attempts, COALESCE(last_error, ''), failed_at
+	FROM webhook_dead_letters
+	WHERE webhook_id=$1
+	ORDER BY id DESC
+	LIMIT $2;
+	`
+	// selectPaymentWebhooksQ pairs each of the given payments
+	// with the active webhooks of its sending and receiving banks
+	selectPaymentWebhooksQ = `
+	SELECT
+		webhooks.id,
+		banks.name,
+		payment_id,
+		sender.name,
+		receiver.name,
+		sending_account,
+		receiving_account,
+		dollar_amount,
+		currency,
+		time,
+		COALESCE(reverses::text, ''),
+		COALESCE(mandate_id::text, ''),
+		status,
+		COALESCE(review_reason, '')
+	FROM transactions
+	JOIN banks sender ON sender.id=transactions.sending_bank_id
+	JOIN banks receiver ON receiver.id=transactions.receiving_bank_id
+	JOIN webhooks ON webhooks.active
+		AND webhooks.bank_id IN (transactions.sending_bank_id, transactions.receiving_bank_id)
+	JOIN banks ON banks.id=webhooks.bank_id
+	WHERE payment_id = ANY($1::text[]::uuid[])
+	ORDER BY transactions.id, webhooks.id;
+	`
+	insertWebhookDeliveriesQ = `
+	INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+	SELECT webhook_id, event_id::uuid, $4, payload
+	FROM unnest($1::int[], $2::text[], $3::text[]) AS deliveries(webhook_id, event_id, payload)
+	ON CONFLICT DO NOTHING;
+	`
+	// claimDeliveriesQ holds off other receivers from up to $1 due deliveries
+	// for $2 milliseconds, in which they're posted without holding locks.
+	claimDeliveriesQ = `
+	UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+	FROM webhooks
+	WHERE webhooks.id=webhook_deliveries.webhook_id AND webhook_deliveries.id IN (
+		SELECT webhook_deliveries.id FROM webhook_deliveries
+		JOIN webhooks ON webhooks.id=webhook_deliveries.webhook_id
+		WHERE delivered_at IS NULL AND next_attempt_at <= NOW() AND webhooks.active
+		ORDER BY webhook_deliveries.id
+		LIMIT $1
+		FOR UPDATE OF webhook_deliveries SKIP LOCKED
+	)
+	RETURNING
+		webhook_deliveries.id,
+		webhook_id,
+		url,
+		secret,
+		event_id,
+		event_type,
+		payload,
+		attempts;
+	`
+	// Outcomes are only recorded for deliveries no other receiver has made
+	// after their claim ran out.
+	markWebhookDeliveredQ = `
+	UPDATE webhook_deliveries SET attempts = attempts + 1, delivered_at = NOW()
+	WHERE id = $1 AND delivered_at IS NULL;
+	`
+	markWebhookFailedQ = `
+	UPDATE webhook_deliveries SET
+		attempts = attempts + 1,
+		last_error = $2,
+		next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond'
+	WHERE id = $1 AND delivered_at IS NULL;
+	`
+	// deadLetterQ moves a delivery that failed for the last time to the dead letters
+	deadLetterQ = `
+	WITH dead AS (
+		DELETE FROM webhook_deliveries WHERE id = $1 AND delivered_at IS NULL
+		RETURNING webhook_id, event_id, event_type, payload, attempts, created_at
+	)
+	INSERT INTO webhook_dead_letters (webhook_id, event_id, event_type, payload, attempts, last_error, created_at)
+	SELECT webhook_id, event_id, event_type, payload, attempts + 1, $2, created_at FROM dead;
+	`
+	// enrollAliasQ only replaces pending aliases, once they can't be verified anymore
+	// or when they're enrolled again by the same bank
+	enrollAliasQ = `
+	INSERT INTO aliases (alias, kind, bank_id, account, code_hash, code_expires_at)
+	SELECT $1, $2, banks.id, $4, $5, NOW() + $6 * INTERVAL '1 millisecond'
+	FROM banks WHERE name=$3
+	ON CONFLICT (alias) DO UPDATE SET
+		kind = EXCLUDED.kind,
+		bank_id = EXCLUDED.bank_id,
+		account = EXCLUDED.account,
+		status = 'pending',
+		code_hash = EXCLUDED.code_hash,
+		code_expires_at = EXCLUDED.code_expires_at,
+		attempts = 0,
+		created_at = NOW(),
+		verified_at = NULL
+	WHERE aliases.status = 'pending' AND (
+		aliases.bank_id = EXCLUDED.bank_id OR
+		aliases.code_expires_at < NOW() OR
+		aliases.attempts >= $7
+	)
+	RETURNING status, created_at;
+	`
+	getAliasQ = `
+	SELECT alias, kind, banks.name, account, status, aliases.created_at, verified_at
+	FROM aliases
+	JOIN banks ON banks.id=aliases.bank_id
+	WHERE alias=$1;
+	`
+	lockAliasCodeQ = `
+	SELECT status, code_hash, code_expires_at < NOW(), attempts
+	FROM aliases
+	WHERE alias=$1
+	FOR UPDATE;
+	`
+	failAliasCodeQ = `
+	UPDATE aliases SET attempts = attempts + 1 WHERE alias=$1;
+	`
+	verifyAliasQ = `
+	UPDATE aliases SET
+		status = 'verified',
+		code_hash = NULL,
+		code_expires_at = NULL,
+		verified_at = NOW()
+	WHERE alias=$1;
+	`
+	moveAliasQ = `
+	UPDATE aliases SET account=$2 WHERE alias=$1;
+	`
+	deleteAliasQ = `
+	DELETE FROM aliases WHERE alias=$1;
+	`
+	deleteAccountAliasesQ = `
+	DELETE FROM aliases WHERE bank_id=$1 AND account=$2;
 	`
 )
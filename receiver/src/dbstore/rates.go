@@ -0,0 +1,32 @@
+package dbstore
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ListRates returns the rates converting each supported currency to the settlement currency.
+func ListRates(ctx context.Context) (map[string]*big.Rat, error) {
+	var currency, raw string
+
+	rows, err := db.conn.Query(ctx, listRatesQ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]*big.Rat)
+	for rows.Next() {
+		if err = rows.Scan(&currency, &raw); err != nil {
+			return nil, err
+		}
+		rates[currency], err = utils.ParseRate(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rates, rows.Err()
+}
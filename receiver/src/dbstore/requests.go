@@ -0,0 +1,255 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+var (
+	// ErrRequestNotFound is returned when no payment request has the requested id.
+	ErrRequestNotFound = errors.New("payment request not found")
+	// ErrNotRequested is returned when answering a request that was already answered.
+	ErrNotRequested = errors.New("payment request was already answered")
+	// ErrRequestExpired is returned when answering a request after it expired.
+	ErrRequestExpired = errors.New("payment request expired")
+)
+
+// RequestFilter selects the payment requests returned by ListRequests.
+// Empty strings and nil pointers are ignored.
+type RequestFilter struct {
+	PayerBank    string
+	PayerAccount *int
+	PayeeBank    string
+	PayeeAccount *int
+	Status       string
+	Limit        int
+}
+
+// CreateRequest stores a payment request, and tells the receivers
+// listening for requests of the payer's bank about it.
+func CreateRequest(ctx context.Context, r *utils.PaymentRequest) error {
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	_, err = tx.Exec(
+		ctx,
+		insertRequestQ,
+		r.Id,
+		r.Payee.Name,
+		r.Payee.Account,
+		r.Payer.Name,
+		r.Payer.Account,
+		r.Amount,
+		r.Currency,
+		r.Memo,
+		r.CreatedAt.UTC(),
+		r.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Notifications are only sent once the transaction commits
+	_, err = tx.Exec(ctx, notifyRequestQ, fmt.Sprintf("%s|%s", r.Id, r.Payer.Name))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetRequest returns the payment request with the given id.
+func GetRequest(ctx context.Context, id string) (*utils.PaymentRequest, error) {
+	r := &utils.PaymentRequest{}
+	if err := scanRequest(db.conn.QueryRow(ctx, getRequestQ, id), r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// ListRequests returns the payment requests matching the filter, oldest first.
+func ListRequests(ctx context.Context, f *RequestFilter) ([]utils.PaymentRequest, error) {
+
+	limit := f.Limit
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := db.conn.Query(
+		ctx,
+		listRequestsQ,
+		f.PayerBank,
+		f.PayerAccount,
+		f.PayeeBank,
+		f.PayeeAccount,
+		f.Status,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]utils.PaymentRequest, 0, limit)
+	for rows.Next() {
+		var r utils.PaymentRequest
+		if err = scanRequest(rows, &r); err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+
+	return requests, rows.Err()
+}
+
+// AcceptRequest stores the payment paying a request, like InsertPayment does
+// without an idempotency key, and marks the request as accepted in the same
+// transaction, so that a request is never paid twice. It returns the request.
+func AcceptRequest(ctx context.Context, id string, paymnt *utils.Payment) (*utils.PaymentRequest, error) {
+	r := &utils.PaymentRequest{}
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	if err = lockOpenRequest(ctx, tx, id, paymnt.Time, r); err != nil {
+		return nil, err
+	}
+
+	if _, err = insertPayment(ctx, tx, paymnt, ""); err != nil {
+		return nil, err
+	}
+	r.Status, r.PaymentId = utils.RequestAccepted, paymnt.Id
+	if _, err = tx.Exec(ctx, setRequestStatusQ, id, r.Status, r.PaymentId); err != nil {
+		return nil, err
+	}
+
+	return r, tx.Commit(ctx)
+}
+
+// DeclineRequest marks a request as declined, and returns it.
+func DeclineRequest(ctx context.Context, id string, now time.Time) (*utils.PaymentRequest, error) {
+	r := &utils.PaymentRequest{}
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	if err = lockOpenRequest(ctx, tx, id, now, r); err != nil {
+		return nil, err
+	}
+
+	r.Status = utils.RequestDeclined
+	if _, err = tx.Exec(ctx, setRequestStatusQ, id, r.Status, nil); err != nil {
+		return nil, err
+	}
+
+	return r, tx.Commit(ctx)
+}
+
+// lockOpenRequest locks a request so that concurrent answers queue up,
+// and scans it into r. It fails unless the request still waits for an answer.
+func lockOpenRequest(ctx context.Context, tx pgx.Tx, id string, now time.Time, r *utils.PaymentRequest) error {
+
+	if err := scanRequest(tx.QueryRow(ctx, lockRequestQ, id), r); err != nil {
+		return err
+	}
+	if r.Status == utils.RequestExpired {
+		return ErrRequestExpired
+	}
+	if r.Status != utils.RequestRequested {
+		return ErrNotRequested
+	}
+
+	// Expire the request right away rather than waiting for ExpireRequests
+	if !now.Before(r.ExpiresAt) {
+		if _, err := tx.Exec(ctx, setRequestStatusQ, id, utils.RequestExpired, nil); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		return ErrRequestExpired
+	}
+
+	return nil
+}
+
+// ExpireRequests marks up to limit requests that weren't answered by now as expired.
+// It returns the number of requests expired.
+func ExpireRequests(ctx context.Context, now time.Time, limit int) (int, error) {
+	tag, err := db.conn.Exec(ctx, expireRequestsQ, now.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// ListenRequests calls notify with the id and the payer's bank of every
+// payment request created by any receiver, until the context is done
+// or the connection fails.
+func ListenRequests(ctx context.Context, notify func(id, payerBank string)) error {
+
+	conn, err := db.conn.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, listenRequestsQ); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(n.Payload, "|", 2)
+		if len(parts) != 2 {
+			db.logger.Printf("Ignoring malformed request notification: %q", n.Payload)
+			continue
+		}
+		notify(parts[0], parts[1])
+	}
+}
+
+// scanRequest scans a row selected by requestColumnsQ into r.
+func scanRequest(row pgx.Row, r *utils.PaymentRequest) error {
+	err := row.Scan(
+		&r.Id,
+		&r.Payee.Name,
+		&r.Payee.Account,
+		&r.Payer.Name,
+		&r.Payer.Account,
+		&r.Amount,
+		&r.Currency,
+		&r.Memo,
+		&r.Status,
+		&r.PaymentId,
+		&r.CreatedAt,
+		&r.ExpiresAt,
+	)
+	if err == pgx.ErrNoRows {
+		return ErrRequestNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,76 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ErrNotPending is returned when reviewing a payment that isn't held for review.
+var ErrNotPending = errors.New("payment is not pending review")
+
+// ReviewPayment approves or declines a payment held for review, and returns it.
+// Approved payments are validated and sent to the cache like new ones, as long
+// as their accounts are still open. Declined payments are rejected for good.
+func ReviewPayment(ctx context.Context, id string, approve bool) (*utils.Payment, error) {
+	var paymnt utils.Payment
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	// Lock the payment so that concurrent reviews queue up
+	err = scanPayment(tx.QueryRow(ctx, lockPaymentQ, id), &paymnt)
+	if err != nil {
+		return nil, err
+	}
+	if paymnt.Status != utils.PaymentReceived {
+		return nil, ErrNotPending
+	}
+
+	if !approve {
+		paymnt.Status = utils.PaymentRejected
+		_, err = advancePayments(ctx, tx, []string{id}, paymnt.Status, "declined on review")
+		if err != nil {
+			return nil, err
+		}
+		return &paymnt, tx.Commit(ctx)
+	}
+
+	statuses, err := lockAccounts(ctx, tx, []*utils.Payment{&paymnt})
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAccounts(statuses, &paymnt); err != nil {
+		return nil, err
+	}
+
+	paymnt.Status = utils.PaymentValidated
+	if _, err = advancePayments(ctx, tx, []string{id}, paymnt.Status, "approved on review"); err != nil {
+		return nil, err
+	}
+	if err = insertOutboxEntry(ctx, tx, &paymnt); err != nil {
+		return nil, err
+	}
+
+	return &paymnt, tx.Commit(ctx)
+}
+
+// HasPaid tells whether the sender ever made a payment that moved money to the receiver.
+func HasPaid(ctx context.Context, sender, receiver utils.BankInfo) (bool, error) {
+	var paid bool
+
+	err := db.conn.QueryRow(
+		ctx,
+		hasPaidQ,
+		sender.Name,
+		sender.Account,
+		receiver.Name,
+		receiver.Account,
+	).Scan(&paid)
+
+	return paid, err
+}
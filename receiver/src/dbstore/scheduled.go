@@ -0,0 +1,230 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+var (
+	// ErrScheduleNotFound is returned when no scheduled payment has the requested id.
+	ErrScheduleNotFound = errors.New("scheduled payment not found")
+	// ErrNotScheduled is returned when cancelling a payment that was already executed or cancelled.
+	ErrNotScheduled = errors.New("payment is no longer scheduled")
+	// ErrExecuting is returned when cancelling a payment that is being executed.
+	ErrExecuting = errors.New("payment is being executed")
+	// ErrNotExecutable is returned by execute functions for scheduled payments that must not be retried.
+	ErrNotExecutable = errors.New("scheduled payment can't be executed")
+)
+
+// scheduleKeyPrefix starts the idempotency keys of executed scheduled payments.
+const scheduleKeyPrefix = "scheduled:"
+
+// ScheduleKey returns the idempotency key of the payment a scheduled payment
+// is executed as, so that it's never stored twice.
+func ScheduleKey(id string) string {
+	return scheduleKeyPrefix + id
+}
+
+// SchedulePayment stores a payment to execute at a later time.
+func SchedulePayment(ctx context.Context, sched *utils.ScheduledPayment) error {
+	_, err := db.conn.Exec(
+		ctx,
+		insertScheduledQ,
+		sched.Id,
+		sched.Sender.Name,
+		sched.Receiver.Name,
+		sched.Sender.Account,
+		sched.Receiver.Account,
+		sched.Amount,
+		sched.Currency,
+		sched.ExecuteAt.UTC(),
+	)
+	return err
+}
+
+// GetScheduled returns the scheduled payment with the given id.
+func GetScheduled(ctx context.Context, id string) (*utils.ScheduledPayment, error) {
+	var attempts int
+
+	sched := &utils.ScheduledPayment{}
+	err := scanScheduled(db.conn.QueryRow(ctx, getScheduledQ, id), sched, &attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	return sched, nil
+}
+
+// ListScheduled returns up to limit payments scheduled by the named bank in order
+// of execution, only those of the account and with the status if they're given.
+func ListScheduled(ctx context.Context, bank string, account *int, status string, limit int) ([]utils.ScheduledPayment, error) {
+	var attempts int
+
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := db.conn.Query(ctx, listScheduledQ, bank, account, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scheduled := make([]utils.ScheduledPayment, 0, limit)
+	for rows.Next() {
+		var sched utils.ScheduledPayment
+		if err = scanScheduled(rows, &sched, &attempts); err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, sched)
+	}
+
+	return scheduled, rows.Err()
+}
+
+// CancelScheduled cancels a payment that wasn't executed yet, and returns it.
+// It fails with ErrExecuting while a receiver executes the payment.
+func CancelScheduled(ctx context.Context, id string) (*utils.ScheduledPayment, error) {
+	var attempts int
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	sched := &utils.ScheduledPayment{}
+	err = scanScheduled(tx.QueryRow(ctx, lockScheduledQ, id), sched, &attempts)
+	if err != nil {
+		return nil, err
+	}
+	if sched.Status != utils.ScheduleWaiting {
+		return nil, ErrNotScheduled
+	}
+
+	tag, err := tx.Exec(ctx, cancelScheduledQ, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrExecuting
+	}
+	sched.Status = utils.ScheduleCancelled
+
+	return sched, tx.Commit(ctx)
+}
+
+// ExecuteScheduled claims up to limit scheduled payments that are due by now
+// and passes each one to execute, which returns the id of the stored payment.
+// Claimed payments are skipped by other receivers for the claim duration, and
+// no locks are held while they're executed, so a receiver stopping mid-execution
+// only delays its payments until the claim runs out.
+// Payments that fail with ErrNotExecutable, or that fail maxAttempts times,
+// are marked as failed, while others are attempted again on the next call.
+// Payments already stored by an execution that was cut short are marked as
+// executed without executing them again. It returns the number of payments handled.
+func ExecuteScheduled(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+	maxAttempts int,
+	claim time.Duration,
+	execute func(*utils.ScheduledPayment) (string, error),
+) (int, error) {
+	var (
+		paymentId string
+		hash      string
+		status    string
+		reason    string
+	)
+
+	due, attempts, err := claimScheduled(ctx, now, limit, claim)
+	if err != nil {
+		return 0, err
+	}
+
+	// Execute payments and record each outcome on its own
+	for i, sched := range due {
+		// A previous execution may have stored the payment before being cut short
+		err = db.conn.QueryRow(ctx, getIdempotentPaymentQ, sched.Sender.Name, ScheduleKey(sched.Id)).Scan(
+			&paymentId,
+			&hash,
+			&status,
+			&reason,
+		)
+		switch {
+		case err == nil:
+			_, err = db.conn.Exec(ctx, markExecutedQ, sched.Id, paymentId)
+		case err == pgx.ErrNoRows:
+			paymentId, err = execute(sched)
+			switch {
+			case err == nil:
+				_, err = db.conn.Exec(ctx, markExecutedQ, sched.Id, paymentId)
+			case errors.Is(err, ErrNotExecutable) || attempts[i]+1 >= maxAttempts:
+				_, err = db.conn.Exec(ctx, markScheduleFailedQ, sched.Id, err.Error())
+			default:
+				_, err = db.conn.Exec(ctx, markScheduleRetryQ, sched.Id, err.Error())
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// claimScheduled claims up to limit scheduled payments that are due by now for
+// the given duration, in order of execution. It returns them together with the
+// number of times each one was attempted.
+func claimScheduled(ctx context.Context, now time.Time, limit int, claim time.Duration) ([]*utils.ScheduledPayment, []int, error) {
+
+	rows, err := db.conn.Query(ctx, claimScheduledQ, now.UTC(), limit, claim.Milliseconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	due := make([]*utils.ScheduledPayment, 0, limit)
+	attempts := make([]int, 0, limit)
+	for rows.Next() {
+		var n int
+		sched := &utils.ScheduledPayment{}
+		if err = scanScheduled(rows, sched, &n); err != nil {
+			return nil, nil, err
+		}
+		due = append(due, sched)
+		attempts = append(attempts, n)
+	}
+
+	return due, attempts, rows.Err()
+}
+
+// scanScheduled scans a row selected by scheduledColumnsQ into sched,
+// and the number of times it was attempted into attempts.
+func scanScheduled(row pgx.Row, sched *utils.ScheduledPayment, attempts *int) error {
+	err := row.Scan(
+		&sched.Id,
+		&sched.Sender.Name,
+		&sched.Receiver.Name,
+		&sched.Sender.Account,
+		&sched.Receiver.Account,
+		&sched.Amount,
+		&sched.Currency,
+		&sched.ExecuteAt,
+		&sched.Status,
+		&sched.PaymentId,
+		&sched.Reason,
+		attempts,
+	)
+	if err == pgx.ErrNoRows {
+		return ErrScheduleNotFound
+	}
+
+	return err
+}
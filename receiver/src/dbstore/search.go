@@ -0,0 +1,180 @@
+package dbstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+)
+
+// ErrBadCursor is returned when a search cursor was not issued by SearchPayments.
+var ErrBadCursor = errors.New("invalid cursor")
+
+// PaymentFilter selects the payments returned by SearchPayments.
+// Empty strings, nil pointers and zero times are ignored.
+type PaymentFilter struct {
+	Bank            string // Sending or receiving bank, set to the caller's
+	SenderBank      string
+	ReceiverBank    string
+	SenderAccount   *int
+	ReceiverAccount *int
+	MinAmount       *utils.Money
+	MaxAmount       *utils.Money
+	Currency        string
+	Status          string
+	Mandate         string
+	From            time.Time // Inclusive
+	To              time.Time // Exclusive
+	Cursor          string    // Returned by the previous page, empty for the first one
+	Limit           int
+}
+
+// SearchPayments returns a page of payments matching the filter, ordered by time.
+// The returned cursor fetches the next page, and is empty on the last one.
+func SearchPayments(ctx context.Context, f *PaymentFilter) ([]utils.Payment, string, error) {
+	var (
+		conds []string
+		args  []interface{}
+		rowId int64
+		last  int64
+	)
+
+	// add appends a condition, numbering its placeholders
+	add := func(cond string, vals ...interface{}) {
+		for _, val := range vals {
+			args = append(args, val)
+			cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		conds = append(conds, cond)
+	}
+
+	if f.Bank != "" {
+		add("(sender.name=? OR receiver.name=?)", f.Bank, f.Bank)
+	}
+	if f.SenderBank != "" {
+		add("sender.name=?", f.SenderBank)
+	}
+	if f.ReceiverBank != "" {
+		add("receiver.name=?", f.ReceiverBank)
+	}
+	if f.SenderAccount != nil {
+		add("sending_account=?", *f.SenderAccount)
+	}
+	if f.ReceiverAccount != nil {
+		add("receiving_account=?", *f.ReceiverAccount)
+	}
+	if f.MinAmount != nil {
+		add("dollar_amount>=?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		add("dollar_amount<=?", *f.MaxAmount)
+	}
+	if !f.From.IsZero() {
+		add("time>=?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("time<?", f.To.UTC())
+	}
+	if f.Currency != "" {
+		add("currency=?", f.Currency)
+	}
+	if f.Status != "" {
+		add("status=?", f.Status)
+	}
+	if f.Mandate != "" {
+		add("mandate_id=?", f.Mandate)
+	}
+	if f.Cursor != "" {
+		cursorTime, cursorId, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		add("(time, transactions.id)>(?, ?)", cursorTime, cursorId)
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	query := searchPaymentsQ
+	if len(conds) > 0 {
+		query += "WHERE " + strings.Join(conds, " AND ")
+	}
+	// Fetch one more row than needed to know whether there is a next page
+	query += fmt.Sprintf(" ORDER BY time, transactions.id LIMIT %d;", limit+1)
+
+	rows, err := db.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	payments := make([]utils.Payment, 0, limit)
+	for rows.Next() {
+		var paymnt utils.Payment
+		err = rows.Scan(
+			&rowId,
+			&paymnt.Id,
+			&paymnt.Sender.Name,
+			&paymnt.Receiver.Name,
+			&paymnt.Sender.Account,
+			&paymnt.Receiver.Account,
+			&paymnt.Amount,
+			&paymnt.Currency,
+			&paymnt.Time,
+			&paymnt.Reverses,
+			&paymnt.Mandate,
+			&paymnt.Status,
+			&paymnt.Reason,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(payments) == limit {
+			// The extra row only signals that there's more
+			return payments, encodeCursor(payments[limit-1].Time, last), rows.Err()
+		}
+		payments = append(payments, paymnt)
+		last = rowId
+	}
+
+	return payments, "", rows.Err()
+}
+
+// encodeCursor makes an opaque cursor out of the last row's sort key.
+func encodeCursor(t time.Time, id int64) string {
+	raw := fmt.Sprintf("%s|%d", t.UTC().Format(time.RFC3339Nano), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns the sort key stored in a cursor.
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrBadCursor
+	}
+	return t, id, nil
+}
@@ -0,0 +1,293 @@
+package dbstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// ErrWebhookNotFound is returned when no webhook has the requested id.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookDelivery is an event waiting to be delivered to a webhook.
+type WebhookDelivery struct {
+	Id        int64
+	WebhookId int
+	Url       string
+	Secret    string
+	EventId   string
+	Type      string
+	Payload   []byte
+	Attempts  int
+}
+
+// CreateWebhook registers a webhook for a bank, assigning its id and creation time.
+func CreateWebhook(ctx context.Context, hook *utils.Webhook) error {
+	err := db.conn.QueryRow(ctx, createWebhookQ, hook.Bank, hook.Url, hook.Secret).Scan(
+		&hook.Id,
+		&hook.Active,
+		&hook.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return ErrBankNotFound
+	}
+	return err
+}
+
+// GetWebhook returns the webhook with the given id, without its secret.
+func GetWebhook(ctx context.Context, id int) (*utils.Webhook, error) {
+	return scanWebhook(db.conn.QueryRow(ctx, getWebhookQ, id))
+}
+
+// ListWebhooks returns the webhooks of a bank, without their secrets, in order of registration.
+func ListWebhooks(ctx context.Context, bank string) ([]*utils.Webhook, error) {
+
+	rows, err := db.conn.Query(ctx, listWebhooksQ, bank)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]*utils.Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+// SetWebhookActive enables or disables a webhook. Events keep being queued
+// for disabled webhooks, and are delivered once they're enabled again.
+func SetWebhookActive(ctx context.Context, id int, active bool) (*utils.Webhook, error) {
+	tag, err := db.conn.Exec(ctx, setWebhookActiveQ, id, active)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return GetWebhook(ctx, id)
+}
+
+// ListDeadLetters returns the events that couldn't be delivered to a webhook, latest first.
+func ListDeadLetters(ctx context.Context, id, limit int) ([]utils.DeadLetter, error) {
+
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	rows, err := db.conn.Query(ctx, listDeadLettersQ, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := make([]utils.DeadLetter, 0, limit)
+	for rows.Next() {
+		var letter utils.DeadLetter
+		err = rows.Scan(
+			&letter.Id,
+			&letter.WebhookId,
+			&letter.EventId,
+			&letter.Type,
+			&letter.Payload,
+			&letter.Attempts,
+			&letter.LastError,
+			&letter.FailedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, rows.Err()
+}
+
+// scanWebhook scans a webhook selected by getWebhookQ.
+func scanWebhook(row pgx.Row) (*utils.Webhook, error) {
+	hook := &utils.Webhook{}
+
+	err := row.Scan(&hook.Id, &hook.Bank, &hook.Url, &hook.Active, &hook.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// enqueuePaymentEvents queues an event about each of the given payments
+// for the active webhooks of its sending and receiving banks, as part
+// of the transaction moving the payments.
+func enqueuePaymentEvents(ctx context.Context, tx pgx.Tx, eventType string, ids []string) error {
+	var (
+		hookIds  []int
+		eventIds []string
+		payloads []string
+	)
+
+	// Read all rows before inserting, the connection is busy until then
+	rows, err := tx.Query(ctx, selectPaymentWebhooksQ, ids)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	events := make(map[string]string) // Event ids by bank and payment
+	for rows.Next() {
+		var (
+			hookId int
+			bank   string
+			paymnt utils.Payment
+		)
+		err = rows.Scan(
+			&hookId,
+			&bank,
+			&paymnt.Id,
+			&paymnt.Sender.Name,
+			&paymnt.Receiver.Name,
+			&paymnt.Sender.Account,
+			&paymnt.Receiver.Account,
+			&paymnt.Amount,
+			&paymnt.Currency,
+			&paymnt.Time,
+			&paymnt.Reverses,
+			&paymnt.Mandate,
+			&paymnt.Status,
+			&paymnt.Reason,
+		)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		// Webhooks of the same bank get the same event
+		eventId, exists := events[bank+"/"+paymnt.Id]
+		if !exists {
+			eventId = utils.NewId()
+			events[bank+"/"+paymnt.Id] = eventId
+		}
+		payload, err := json.Marshal(&utils.WebhookEvent{
+			Id:      eventId,
+			Type:    eventType,
+			Bank:    bank,
+			Time:    now,
+			Payment: &paymnt,
+		})
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		hookIds = append(hookIds, hookId)
+		eventIds = append(eventIds, eventId)
+		payloads = append(payloads, string(payload))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(hookIds) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(ctx, insertWebhookDeliveriesQ, hookIds, eventIds, payloads, eventType)
+	return err
+}
+
+// DeliverWebhooks claims up to limit due webhook deliveries and passes each one
+// to deliver. Claimed deliveries are skipped by other receivers for the claim
+// duration, and no locks are held while they're posted. Failed deliveries are
+// attempted again after the delay returned by retryIn, and land in the dead
+// letters once they fail maxAttempts times. Deliveries to disabled webhooks
+// are skipped. It returns the number of deliveries handled.
+func DeliverWebhooks(
+	ctx context.Context,
+	limit int,
+	maxAttempts int,
+	claim time.Duration,
+	deliver func(*WebhookDelivery) error,
+	retryIn func(attempts int) time.Duration,
+) (int, error) {
+
+	deliveries, err := claimDeliveries(ctx, limit, claim)
+	if err != nil {
+		return 0, err
+	}
+
+	// Deliver events and record each outcome on its own
+	for _, d := range deliveries {
+		query, args := webhookOutcome(d, deliver(d), maxAttempts, retryIn)
+		if _, err = db.conn.Exec(ctx, query, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// claimDeliveries claims up to limit due webhook deliveries for the given duration, in order.
+func claimDeliveries(ctx context.Context, limit int, claim time.Duration) ([]*WebhookDelivery, error) {
+
+	rows, err := db.conn.Query(ctx, claimDeliveriesQ, limit, claim.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0, limit)
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		err = rows.Scan(
+			&d.Id,
+			&d.WebhookId,
+			&d.Url,
+			&d.Secret,
+			&d.EventId,
+			&d.Type,
+			&d.Payload,
+			&d.Attempts,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Updated rows are returned in no particular order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Id < deliveries[j].Id })
+	return deliveries, nil
+}
+
+// webhookOutcome returns the query recording the outcome of a delivery attempt,
+// and its arguments: delivered, failed until the next attempt, or dead.
+func webhookOutcome(
+	d *WebhookDelivery,
+	deliveryErr error,
+	maxAttempts int,
+	retryIn func(attempts int) time.Duration,
+) (string, []interface{}) {
+	switch {
+	case deliveryErr == nil:
+		return markWebhookDeliveredQ, []interface{}{d.Id}
+	case d.Attempts+1 >= maxAttempts:
+		return deadLetterQ, []interface{}{d.Id, deliveryErr.Error()}
+	default:
+		delay := retryIn(d.Attempts + 1)
+		return markWebhookFailedQ, []interface{}{d.Id, deliveryErr.Error(), delay.Milliseconds()}
+	}
+}
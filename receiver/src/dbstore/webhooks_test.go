@@ -0,0 +1,136 @@
+package dbstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	testSecret      = "whsec_test"
+	testMaxAttempts = 4
+)
+
+// testBackoff doubles from a second, so that each attempt has its own delay.
+func testBackoff(attempts int) time.Duration {
+	return time.Second << (attempts - 1)
+}
+
+// poster returns a deliver function posting signed events with the client,
+// as the webhooks dispatcher does.
+func poster(client *http.Client) func(*WebhookDelivery) error {
+	return func(d *WebhookDelivery) error {
+		req, err := http.NewRequest(http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set(utils.SignatureHeader, utils.SignWebhook(d.Secret, time.Now(), d.Payload))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+func testDelivery(url string) *WebhookDelivery {
+	return &WebhookDelivery{
+		Id:        7,
+		WebhookId: 3,
+		Url:       url,
+		Secret:    testSecret,
+		EventId:   utils.NewId(),
+		Type:      utils.EventPaymentPosted,
+		Payload:   []byte(`{"Type":"payment.posted"}`),
+	}
+}
+
+func TestWebhookOutcomeDelivered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		err := utils.VerifyWebhook(testSecret, req.Header.Get(utils.SignatureHeader), body, time.Minute, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	d := testDelivery(server.URL)
+	query, args := webhookOutcome(d, poster(server.Client())(d), testMaxAttempts, testBackoff)
+	if query != markWebhookDeliveredQ {
+		t.Fatalf("query = %q, want markWebhookDeliveredQ", query)
+	}
+	if len(args) != 1 || args[0] != d.Id {
+		t.Errorf("args = %v, want [%d]", args, d.Id)
+	}
+}
+
+func TestWebhookOutcomeRetriesServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := testDelivery(server.URL)
+	deliver := poster(server.Client())
+	for d.Attempts = 0; d.Attempts < testMaxAttempts-1; d.Attempts++ {
+		query, args := webhookOutcome(d, deliver(d), testMaxAttempts, testBackoff)
+		if query != markWebhookFailedQ {
+			t.Fatalf("attempt %d: query = %q, want markWebhookFailedQ", d.Attempts+1, query)
+		}
+		if want := testBackoff(d.Attempts + 1).Milliseconds(); args[2] != want {
+			t.Errorf("attempt %d: retried in %vms, want %dms", d.Attempts+1, args[2], want)
+		}
+	}
+}
+
+func TestWebhookOutcomeRetriesTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release) // Runs before Close, which waits for the handler
+
+	client := server.Client()
+	client.Timeout = 50 * time.Millisecond
+
+	d := testDelivery(server.URL)
+	query, args := webhookOutcome(d, poster(client)(d), testMaxAttempts, testBackoff)
+	if query != markWebhookFailedQ {
+		t.Fatalf("query = %q, want markWebhookFailedQ", query)
+	}
+	if want := testBackoff(1).Milliseconds(); args[2] != want {
+		t.Errorf("retried in %vms, want %dms", args[2], want)
+	}
+}
+
+func TestWebhookOutcomeDeadLetters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	d := testDelivery(server.URL)
+	d.Attempts = testMaxAttempts - 1 // The last attempt
+
+	deliveryErr := poster(server.Client())(d)
+	query, args := webhookOutcome(d, deliveryErr, testMaxAttempts, testBackoff)
+	if query != deadLetterQ {
+		t.Fatalf("query = %q, want deadLetterQ", query)
+	}
+	if len(args) != 2 || args[0] != d.Id || args[1] != deliveryErr.Error() {
+		t.Errorf("args = %v, want [%d %q]", args, d.Id, deliveryErr)
+	}
+}
@@ -0,0 +1,166 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	pacs002Namespace = namespacePrefix + "pacs.002.001.10"
+	maxInfoLength    = 105
+)
+
+// Statuses of transactions and groups in status reports.
+const (
+	StatusAccepted = "ACSP" // Validated, and sent on to settlement
+	StatusPending  = "PDNG" // Held for review
+	StatusRejected = "RJCT"
+	StatusPartial  = "PART" // Only for groups, some of whose transactions were rejected
+)
+
+// Reason codes of rejections, from the ISO 20022 external status reason code set.
+const (
+	ReasonIncorrectAccount     = "AC01"
+	ReasonClosedAccount        = "AC04"
+	ReasonBlockedAccount       = "AC06"
+	ReasonForbidden            = "AG01"
+	ReasonNotAllowedAmount     = "AM02"
+	ReasonNotAllowedCurrency   = "AM03"
+	ReasonControlSum           = "AM10"
+	ReasonInvalidAmount        = "AM12"
+	ReasonLimitExceeded        = "AM14"
+	ReasonNumberOfTransactions = "AM18"
+	ReasonDuplicate            = "DUPL"
+	ReasonInvalidFormat        = "FF01"
+	ReasonNarrative            = "NARR"
+	ReasonBankIdentifier       = "RC01"
+	ReasonRegulatory           = "RR04"
+)
+
+// StatusReport is a pacs.002 FIToFIPaymentStatusReport answering a credit transfer.
+type StatusReport struct {
+	XMLName      xml.Name            `xml:"Document"`
+	Namespace    string              `xml:"xmlns,attr"`
+	Header       ReportHeader        `xml:"FIToFIPmtStsRpt>GrpHdr"`
+	Group        GroupStatus         `xml:"FIToFIPmtStsRpt>OrgnlGrpInfAndSts"`
+	Transactions []TransactionStatus `xml:"FIToFIPmtStsRpt>TxInfAndSts"`
+}
+
+// ReportHeader identifies a status report.
+type ReportHeader struct {
+	MessageId string `xml:"MsgId"`
+	CreatedAt string `xml:"CreDtTm"`
+}
+
+// GroupStatus is the status of a credit transfer as a whole.
+type GroupStatus struct {
+	OriginalMessageId    string        `xml:"OrgnlMsgId"`
+	OriginalMessageName  string        `xml:"OrgnlMsgNmId"`
+	OriginalTransactions string        `xml:"OrgnlNbOfTxs,omitempty"`
+	Status               string        `xml:"GrpSts"`
+	Reason               *StatusReason `xml:"StsRsnInf,omitempty"`
+}
+
+// TransactionStatus is the status of a transaction of a credit transfer. The
+// clearing system reference is the id of the payment the transaction was stored as.
+type TransactionStatus struct {
+	OriginalInstructionId string        `xml:"OrgnlInstrId,omitempty"`
+	OriginalEndToEndId    string        `xml:"OrgnlEndToEndId,omitempty"`
+	OriginalTransactionId string        `xml:"OrgnlTxId,omitempty"`
+	OriginalUETR          string        `xml:"OrgnlUETR,omitempty"`
+	Status                string        `xml:"TxSts"`
+	Reason                *StatusReason `xml:"StsRsnInf,omitempty"`
+	ClearingReference     string        `xml:"ClrSysRef,omitempty"`
+}
+
+// StatusReason explains a rejection.
+type StatusReason struct {
+	Code string `xml:"Rsn>Cd"`
+	Info string `xml:"AddtlInf,omitempty"`
+}
+
+// NewStatusReport starts the report on a credit transfer, with an entry for each
+// of its transactions. Transactions are rejected until they're accepted.
+func NewStatusReport(msg *CreditTransfer, id string, at time.Time) *StatusReport {
+
+	report := &StatusReport{
+		Namespace: pacs002Namespace,
+		Header: ReportHeader{
+			MessageId: id,
+			CreatedAt: at.UTC().Format(time.RFC3339),
+		},
+		Group: GroupStatus{
+			OriginalMessageId:    msg.Header.MessageId,
+			OriginalMessageName:  msg.Name(),
+			OriginalTransactions: msg.Header.Transactions,
+		},
+		Transactions: make([]TransactionStatus, len(msg.Transactions)),
+	}
+
+	for i, tx := range msg.Transactions {
+		report.Transactions[i] = TransactionStatus{
+			OriginalInstructionId: tx.InstructionId,
+			OriginalEndToEndId:    tx.EndToEndId,
+			OriginalTransactionId: tx.TransactionId,
+			OriginalUETR:          tx.UETR,
+			Status:                StatusRejected,
+		}
+	}
+
+	return report
+}
+
+// Accept sets the status of the i-th transaction, stored as the given payment.
+func (report *StatusReport) Accept(i int, status, paymentId string) {
+	report.Transactions[i].Status = status
+	report.Transactions[i].Reason = nil
+	report.Transactions[i].ClearingReference = paymentId
+}
+
+// Reject rejects the i-th transaction.
+func (report *StatusReport) Reject(i int, rj *Rejection) {
+	report.Transactions[i].Status = StatusRejected
+	report.Transactions[i].Reason = newStatusReason(rj)
+	report.Transactions[i].ClearingReference = ""
+}
+
+// RejectGroup rejects the whole credit transfer, leaving out its transactions.
+func (report *StatusReport) RejectGroup(rj *Rejection) {
+	report.Group.Status = StatusRejected
+	report.Group.Reason = newStatusReason(rj)
+	report.Transactions = nil
+}
+
+// Marshal sets the group's status from its transactions' unless the group was
+// rejected, and returns the report as an XML document.
+func (report *StatusReport) Marshal() ([]byte, error) {
+
+	if report.Group.Status != StatusRejected || report.Group.Reason == nil {
+		report.Group.Status = ""
+		for _, tx := range report.Transactions {
+			switch report.Group.Status {
+			case "":
+				report.Group.Status = tx.Status
+			case tx.Status:
+			default:
+				report.Group.Status = StatusPartial
+			}
+		}
+	}
+
+	body, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// newStatusReason returns the reason of a rejection, with its information
+// truncated to the length allowed by the schema.
+func newStatusReason(rj *Rejection) *StatusReason {
+	info := rj.Info
+	if len(info) > maxInfoLength {
+		info = info[:maxInfoLength]
+	}
+	return &StatusReason{Code: rj.Reason, Info: info}
+}
@@ -0,0 +1,108 @@
+package iso20022
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// comments matches the comments describing fixtures, which reports don't have.
+var comments = regexp.MustCompile(`<!--.*-->\n`)
+
+// TestStatusReportMatchesFixture answers pacs008_batch.xml as the receiver does:
+// transactions that map onto payments are checked by the registry and the
+// database, whose rejections are given here, and the others are rejected.
+func TestStatusReportMatchesFixture(t *testing.T) {
+	msg := parseFixture(t, "pacs008_batch.xml")
+	report := NewStatusReport(msg, "5b0e5a0c-7d3e-4f51-9a4e-2f3c1d8e6b21", time.Date(2026, 10, 18, 14, 5, 13, 0, time.UTC))
+
+	accepted := map[int]string{
+		0: "0d4bd3a6-51c2-4d8e-9f0a-6c2b7e1f4a90",
+		1: "e7a1c5f2-3b84-4c6d-8e29-1f5a9d0b7c34",
+	}
+	checked := map[int]*Rejection{
+		4: {ReasonNotAllowedAmount, "payments over $1000 are not allowed"},
+		5: {ReasonNotAllowedCurrency, `unsupported currency: "GBP"`},
+		7: {ReasonIncorrectAccount, "account 100000 at Citigroup does not exist"},
+	}
+	for i, tx := range msg.Transactions {
+		_, err := tx.Payment(time.Now(), testBankOf)
+		if rj, ok := err.(*Rejection); ok {
+			report.Reject(i, rj)
+		} else if rj, rejected := checked[i]; rejected {
+			report.Reject(i, rj)
+		} else {
+			report.Accept(i, StatusAccepted, accepted[i])
+		}
+	}
+
+	got, err := report.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := comments.ReplaceAll(readFixture(t, "pacs002_batch.xml"), nil)
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		t.Errorf("report doesn't match pacs002_batch.xml:\n%s", got)
+	}
+}
+
+func TestStatusReportGroupStatus(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     string
+	}{
+		{[]string{StatusAccepted, StatusAccepted}, StatusAccepted},
+		{[]string{StatusPending, StatusPending}, StatusPending},
+		{[]string{StatusRejected, StatusRejected}, StatusRejected},
+		{[]string{StatusAccepted, StatusPending}, StatusPartial},
+		{[]string{StatusAccepted, StatusRejected}, StatusPartial},
+	}
+	for _, tt := range tests {
+		report := NewStatusReport(parseFixture(t, "pacs008_v02.xml"), "id", time.Now())
+		for i, status := range tt.statuses {
+			if status == StatusRejected {
+				report.Reject(i, &Rejection{ReasonNarrative, "rejected"})
+			} else {
+				report.Accept(i, status, "payment")
+			}
+		}
+
+		if _, err := report.Marshal(); err != nil {
+			t.Fatal(err)
+		}
+		if report.Group.Status != tt.want {
+			t.Errorf("statuses %v: group status = %s, want %s", tt.statuses, report.Group.Status, tt.want)
+		}
+	}
+}
+
+func TestStatusReportRejectsBadHeader(t *testing.T) {
+	msg := parseFixture(t, "pacs008_bad_header.xml")
+	report := NewStatusReport(msg, "id", time.Now())
+
+	rj, ok := msg.Check(testMaxTransactions).(*Rejection)
+	if !ok {
+		t.Fatal("Check() didn't reject the header")
+	}
+	report.RejectGroup(rj)
+	if _, err := report.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Group.Status != StatusRejected || report.Group.Reason == nil || report.Group.Reason.Code != ReasonNumberOfTransactions {
+		t.Errorf("group = %+v, want rejected with %s", report.Group, ReasonNumberOfTransactions)
+	}
+	if report.Group.OriginalMessageId != "IRVT-20261018-0003" || len(report.Transactions) != 0 {
+		t.Errorf("report = %+v, want the original message id and no transactions", report)
+	}
+}
+
+func TestStatusReasonTruncatesInfo(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 2*maxInfoLength))
+
+	reason := newStatusReason(&Rejection{ReasonNarrative, long})
+	if len(reason.Info) != maxInfoLength {
+		t.Errorf("info has %d characters, want %d", len(reason.Info), maxInfoLength)
+	}
+}
@@ -0,0 +1,269 @@
+package iso20022
+
+/*
+The iso20022 package maps ISO 20022 messages onto Polka's payment model. Banks
+send payments as pacs.008 FI to FI customer credit transfers, and get a pacs.002
+payment status report back, which accepts or rejects each of their transactions.
+
+Only the parts of the messages Polka needs are read. Banks are identified by the
+BIC of their agents, accounts by their proprietary identification, and amounts
+are in the currency's minor units, of which every supported currency has two.
+*/
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	namespacePrefix = "urn:iso:std:iso:20022:tech:xsd:"
+	pacs008Name     = "pacs.008."
+	minorDigits     = 2
+	minorScale      = 100 // Minor units in a unit
+)
+
+// KeyPrefix starts the idempotency keys of pacs.008 transactions.
+const KeyPrefix = "pacs.008:"
+
+// ErrNotPacs008 is returned when parsing a message that isn't a pacs.008 credit transfer.
+var ErrNotPacs008 = errors.New("message isn't a pacs.008 FIToFICustomerCreditTransfer")
+
+// Rejection explains why a message or transaction was rejected with an ISO 20022 status reason code.
+type Rejection struct {
+	Reason string
+	Info   string
+}
+
+func (rj *Rejection) Error() string {
+	return fmt.Sprintf("%s: %s", rj.Reason, rj.Info)
+}
+
+// CreditTransfer is a pacs.008 FIToFICustomerCreditTransfer message.
+type CreditTransfer struct {
+	XMLName      xml.Name
+	Header       GroupHeader   `xml:"FIToFICstmrCdtTrf>GrpHdr"`
+	Transactions []Transaction `xml:"FIToFICstmrCdtTrf>CdtTrfTxInf"`
+}
+
+// GroupHeader holds what a message's transactions have in common.
+type GroupHeader struct {
+	MessageId        string `xml:"MsgId"`
+	CreatedAt        string `xml:"CreDtTm"`
+	Transactions     string `xml:"NbOfTxs"`
+	ControlSum       string `xml:"CtrlSum"`
+	SettlementMethod string `xml:"SttlmInf>SttlmMtd"`
+}
+
+// Transaction is a single credit transfer of a message.
+type Transaction struct {
+	InstructionId   string  `xml:"PmtId>InstrId"`
+	EndToEndId      string  `xml:"PmtId>EndToEndId"`
+	TransactionId   string  `xml:"PmtId>TxId"`
+	UETR            string  `xml:"PmtId>UETR"`
+	Amount          Amount  `xml:"IntrBkSttlmAmt"`
+	DebtorName      string  `xml:"Dbtr>Nm"`
+	DebtorAccount   Account `xml:"DbtrAcct"`
+	DebtorAgent     Agent   `xml:"DbtrAgt"`
+	CreditorAgent   Agent   `xml:"CdtrAgt"`
+	CreditorName    string  `xml:"Cdtr>Nm"`
+	CreditorAccount Account `xml:"CdtrAcct"`
+}
+
+// Amount is a decimal amount in a currency.
+type Amount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// Account identifies an account either by IBAN or by a proprietary identification.
+type Account struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+// Agent is a financial institution, identified by its BIC. Versions before
+// pacs.008.001.03 call it BIC rather than BICFI.
+type Agent struct {
+	BICFI string `xml:"FinInstnId>BICFI"`
+	BIC   string `xml:"FinInstnId>BIC"`
+}
+
+// ParseCreditTransfer reads a pacs.008 message of any version.
+func ParseCreditTransfer(data []byte) (*CreditTransfer, error) {
+	var msg CreditTransfer
+
+	if err := xml.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("invalid pacs.008 message: %w", err)
+	}
+	if msg.XMLName.Local != "Document" || !strings.HasPrefix(msg.XMLName.Space, namespacePrefix+pacs008Name) {
+		return nil, ErrNotPacs008
+	}
+	if strings.TrimSpace(msg.Header.MessageId) == "" {
+		return nil, fmt.Errorf("%w: no MsgId", ErrNotPacs008)
+	}
+	return &msg, nil
+}
+
+// Name returns the message's name and version, as in pacs.008.001.08.
+func (msg *CreditTransfer) Name() string {
+	return strings.TrimPrefix(msg.XMLName.Space, namespacePrefix)
+}
+
+// Check returns a *Rejection if the group header doesn't match the transactions,
+// or if the message has more than max of them.
+func (msg *CreditTransfer) Check(max int) error {
+
+	count, err := strconv.Atoi(strings.TrimSpace(msg.Header.Transactions))
+	if err != nil || count != len(msg.Transactions) {
+		return &Rejection{ReasonNumberOfTransactions, fmt.Sprintf("NbOfTxs is %q, but the message has %d transactions", msg.Header.Transactions, len(msg.Transactions))}
+	}
+	if count == 0 || count > max {
+		return &Rejection{ReasonNumberOfTransactions, fmt.Sprintf("messages must have between 1 and %d transactions", max)}
+	}
+	if _, err = msg.CreationTime(); err != nil {
+		return &Rejection{ReasonInvalidFormat, err.Error()}
+	}
+
+	if sum := strings.TrimSpace(msg.Header.ControlSum); sum != "" {
+		want, err := parseAmount(sum)
+		if err != nil {
+			return &Rejection{ReasonControlSum, fmt.Sprintf("invalid CtrlSum %q", sum)}
+		}
+		amounts := make([]utils.Money, 0, len(msg.Transactions))
+		for i, tx := range msg.Transactions {
+			// The sum can't be trusted to match without every amount
+			amount, err := parseAmount(tx.Amount.Value)
+			if err != nil {
+				return &Rejection{ReasonControlSum, fmt.Sprintf("CtrlSum can't be checked, transaction %d has an invalid amount", i+1)}
+			}
+			amounts = append(amounts, amount)
+		}
+		if got, err := utils.Sum(amounts...); err != nil || got != want {
+			return &Rejection{ReasonControlSum, fmt.Sprintf("CtrlSum is %s, but the amounts don't add up to it", sum)}
+		}
+	}
+
+	return nil
+}
+
+// CreationTime returns the time the message was created at, which is
+// in UTC if it has no time zone.
+func (msg *CreditTransfer) CreationTime() (time.Time, error) {
+	raw := strings.TrimSpace(msg.Header.CreatedAt)
+	if at, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return at.UTC(), nil
+	}
+	at, err := time.Parse("2006-01-02T15:04:05.999999999", raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid CreDtTm %q", raw)
+	}
+	return at, nil
+}
+
+// Payment maps the transaction onto a payment made at the given time, finding
+// banks by BIC with bankOf. It returns a *Rejection if it can't.
+func (tx *Transaction) Payment(at time.Time, bankOf func(bic string) (string, error)) (*utils.Payment, error) {
+
+	amount, err := parseAmount(tx.Amount.Value)
+	if err != nil {
+		return nil, &Rejection{ReasonInvalidAmount, err.Error()}
+	}
+	sender, err := tx.DebtorAgent.bank(bankOf)
+	if err != nil {
+		return nil, &Rejection{ReasonBankIdentifier, "debtor agent: " + err.Error()}
+	}
+	receiver, err := tx.CreditorAgent.bank(bankOf)
+	if err != nil {
+		return nil, &Rejection{ReasonBankIdentifier, "creditor agent: " + err.Error()}
+	}
+	senderAccount, err := tx.DebtorAccount.number()
+	if err != nil {
+		return nil, &Rejection{ReasonIncorrectAccount, "debtor account: " + err.Error()}
+	}
+	receiverAccount, err := tx.CreditorAccount.number()
+	if err != nil {
+		return nil, &Rejection{ReasonIncorrectAccount, "creditor account: " + err.Error()}
+	}
+
+	return &utils.Payment{
+		Sender:   utils.BankInfo{Name: sender, Account: senderAccount},
+		Receiver: utils.BankInfo{Name: receiver, Account: receiverAccount},
+		Amount:   amount,
+		Currency: strings.ToUpper(strings.TrimSpace(tx.Amount.Currency)),
+		Time:     at,
+	}, nil
+}
+
+// Key returns the idempotency key of the transaction, sent in the message with
+// the given id: its UETR if it has one, and otherwise its EndToEndId and TxId
+// within the message. Transactions are told apart by their keys rather than
+// by their accounts and amounts, which repeat in payroll messages.
+func (tx *Transaction) Key(messageId string) string {
+	if uetr := strings.TrimSpace(tx.UETR); uetr != "" {
+		return KeyPrefix + "uetr:" + strings.ToLower(uetr)
+	}
+	ids := []string{messageId, tx.EndToEndId, tx.TransactionId}
+	for i := range ids {
+		ids[i] = strings.TrimSpace(ids[i])
+	}
+	return KeyPrefix + strings.Join(ids, "/")
+}
+
+// bank returns the name of the agent's bank.
+func (ag *Agent) bank(bankOf func(bic string) (string, error)) (string, error) {
+	bic := ag.BICFI
+	if bic == "" {
+		bic = ag.BIC
+	}
+	if bic == "" {
+		return "", errors.New("no BIC")
+	}
+	return bankOf(bic)
+}
+
+// number returns the account number given as the account's proprietary identification.
+func (acc *Account) number() (int, error) {
+	if acc.IBAN != "" {
+		return 0, errors.New("IBANs aren't supported, use Othr/Id with the account number")
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(acc.Other), 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid account number %q", acc.Other)
+	}
+	return int(n), nil
+}
+
+// parseAmount reads a positive decimal amount into minor units.
+func parseAmount(raw string) (utils.Money, error) {
+	raw = strings.TrimSpace(raw)
+
+	parts := strings.SplitN(raw, ".", 2)
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > minorDigits || parts[0] == "" {
+		return 0, fmt.Errorf("invalid amount %q", raw)
+	}
+	fraction += strings.Repeat("0", minorDigits-len(fraction))
+
+	units, err := strconv.ParseUint(parts[0], 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", raw)
+	}
+	minor, err := strconv.ParseUint(fraction, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", raw)
+	}
+	if units > (math.MaxInt64-minor)/minorScale {
+		return 0, fmt.Errorf("amount %q overflows", raw)
+	}
+	return utils.Money(units*minorScale + minor), nil
+}
@@ -0,0 +1,243 @@
+package iso20022
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const testMaxTransactions = 100
+
+// testBanks are the banks of the fixtures' BICs.
+var testBanks = map[string]string{
+	"BOFAUS3N":    "Bank of America",
+	"CITIUS33":    "Citigroup",
+	"PNCCUS33":    "PNC",
+	"CHASUS33XXX": "JP Morgan Chase",
+	"WFBIUS6S":    "Wells Fargo",
+	"USBKUS44":    "U.S. Bank",
+	"HIBKUS44":    "Hibernia Bank",
+}
+
+// testBankOf finds banks as the registry does.
+func testBankOf(bic string) (string, error) {
+	if name, exists := testBanks[bic]; exists {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown BIC: %q", bic)
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parseFixture(t *testing.T, name string) *CreditTransfer {
+	t.Helper()
+
+	msg, err := ParseCreditTransfer(readFixture(t, name))
+	if err != nil {
+		t.Fatalf("ParseCreditTransfer(%s) = %v", name, err)
+	}
+	return msg
+}
+
+func TestParseCreditTransfer(t *testing.T) {
+	tests := []struct {
+		file         string
+		name         string
+		messageId    string
+		transactions int
+	}{
+		{"pacs008_single.xml", "pacs.008.001.08", "CHAS-20261018-0001", 1},
+		{"pacs008_batch.xml", "pacs.008.001.08", "BOFA-20261018-0042", 8},
+		{"pacs008_v02.xml", "pacs.008.001.02", "USBK-20261018-0007", 2},
+		{"pacs008_payroll.xml", "pacs.008.001.08", "USBK-20261018-0008", 2},
+		{"pacs008_bad_header.xml", "pacs.008.001.08", "IRVT-20261018-0003", 2},
+	}
+	for _, tt := range tests {
+		msg := parseFixture(t, tt.file)
+		if msg.Name() != tt.name {
+			t.Errorf("%s: Name() = %q, want %q", tt.file, msg.Name(), tt.name)
+		}
+		if msg.Header.MessageId != tt.messageId {
+			t.Errorf("%s: MsgId = %q, want %q", tt.file, msg.Header.MessageId, tt.messageId)
+		}
+		if len(msg.Transactions) != tt.transactions {
+			t.Errorf("%s: %d transactions, want %d", tt.file, len(msg.Transactions), tt.transactions)
+		}
+	}
+}
+
+func TestParseCreditTransferRejectsOtherMessages(t *testing.T) {
+	_, err := ParseCreditTransfer(readFixture(t, "pacs002_batch.xml"))
+	if !errors.Is(err, ErrNotPacs008) {
+		t.Errorf("ParseCreditTransfer(pacs.002) = %v, want ErrNotPacs008", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, file := range []string{"pacs008_single.xml", "pacs008_batch.xml", "pacs008_v02.xml", "pacs008_payroll.xml"} {
+		if err := parseFixture(t, file).Check(testMaxTransactions); err != nil {
+			t.Errorf("%s: Check() = %v, want nil", file, err)
+		}
+	}
+}
+
+func TestCheckRejectsBadHeader(t *testing.T) {
+	var rj *Rejection
+
+	err := parseFixture(t, "pacs008_bad_header.xml").Check(testMaxTransactions)
+	if !errors.As(err, &rj) {
+		t.Fatalf("Check() = %v, want a *Rejection", err)
+	}
+	if rj.Reason != ReasonNumberOfTransactions {
+		t.Errorf("reason = %s, want %s", rj.Reason, ReasonNumberOfTransactions)
+	}
+}
+
+func TestCheckRejectsTooManyTransactions(t *testing.T) {
+	var rj *Rejection
+
+	err := parseFixture(t, "pacs008_batch.xml").Check(4)
+	if !errors.As(err, &rj) || rj.Reason != ReasonNumberOfTransactions {
+		t.Errorf("Check(4) = %v, want an %s rejection", err, ReasonNumberOfTransactions)
+	}
+}
+
+// TestCheckRejectsBadAmount checks a message whose CtrlSum only matches
+// without its invalid amount, which can't be accepted in part.
+func TestCheckRejectsBadAmount(t *testing.T) {
+	var rj *Rejection
+
+	err := parseFixture(t, "pacs008_bad_amount.xml").Check(testMaxTransactions)
+	if !errors.As(err, &rj) || rj.Reason != ReasonControlSum {
+		t.Errorf("Check() = %v, want an %s rejection", err, ReasonControlSum)
+	}
+}
+
+func TestCreationTime(t *testing.T) {
+	tests := []struct {
+		file string
+		want time.Time
+	}{
+		{"pacs008_single.xml", time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)},
+		{"pacs008_batch.xml", time.Date(2026, 10, 18, 14, 5, 12, 250e6, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseFixture(t, tt.file).CreationTime()
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: CreationTime() = %s, %v, want %s", tt.file, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransactionPayment(t *testing.T) {
+	at := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		file string
+		i    int
+		want utils.Payment
+	}{
+		{"pacs008_single.xml", 0, utils.Payment{
+			Sender:   utils.BankInfo{Name: "JP Morgan Chase", Account: 12},
+			Receiver: utils.BankInfo{Name: "Wells Fargo", Account: 47},
+			Amount:   12550,
+			Currency: "USD",
+		}},
+		// Agents of pacs.008.001.02 have a BIC rather than a BICFI
+		{"pacs008_v02.xml", 1, utils.Payment{
+			Sender:   utils.BankInfo{Name: "U.S. Bank", Account: 14},
+			Receiver: utils.BankInfo{Name: "Hibernia Bank", Account: 63},
+			Amount:   575,
+			Currency: "CAD",
+		}},
+	}
+	for _, tt := range tests {
+		msg := parseFixture(t, tt.file)
+		got, err := msg.Transactions[tt.i].Payment(at, testBankOf)
+		if err != nil {
+			t.Errorf("%s: Payment() = %v", tt.file, err)
+			continue
+		}
+		if got.Sender != tt.want.Sender || got.Receiver != tt.want.Receiver ||
+			got.Amount != tt.want.Amount || got.Currency != tt.want.Currency || !got.Time.Equal(at) {
+			t.Errorf("%s: Payment() = %+v, want %+v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionPaymentRejections(t *testing.T) {
+	msg := parseFixture(t, "pacs008_batch.xml")
+
+	tests := []struct {
+		i      int
+		reason string
+	}{
+		{2, ReasonBankIdentifier},
+		{3, ReasonIncorrectAccount},
+		{6, ReasonInvalidAmount},
+	}
+	for _, tt := range tests {
+		var rj *Rejection
+
+		_, err := msg.Transactions[tt.i].Payment(time.Now(), testBankOf)
+		if !errors.As(err, &rj) || rj.Reason != tt.reason {
+			t.Errorf("transaction %d: Payment() = %v, want an %s rejection", tt.i, err, tt.reason)
+		}
+	}
+}
+
+func TestTransactionKey(t *testing.T) {
+	tests := []struct {
+		file string
+		i    int
+		want string
+	}{
+		{"pacs008_single.xml", 0, "pacs.008:uetr:8a562c67-ca16-48ba-b074-65581be6f011"},
+		// Identical payroll lines are told apart by their EndToEndIds
+		{"pacs008_payroll.xml", 0, "pacs.008:USBK-20261018-0008/PAYROLL-0001/USBK-TX-0011"},
+		{"pacs008_payroll.xml", 1, "pacs.008:USBK-20261018-0008/PAYROLL-0002/USBK-TX-0012"},
+	}
+	for _, tt := range tests {
+		msg := parseFixture(t, tt.file)
+		if got := msg.Transactions[tt.i].Key(msg.Header.MessageId); got != tt.want {
+			t.Errorf("%s: transaction %d Key() = %q, want %q", tt.file, tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  utils.Money
+		valid bool
+	}{
+		{"125.50", 12550, true},
+		{"5.7", 570, true},
+		{"42", 4200, true},
+		{" 1.00 ", 100, true},
+		{"12.345", 0, false},
+		{".50", 0, false},
+		{"-1.00", 0, false},
+		{"1e3", 0, false},
+		{"92233720368547758.08", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.raw)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("parseAmount(%q) = %d, %v, want %d", tt.raw, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package limits
+
+/*
+The limits package caps how many payments, and how much money, a sending
+account and a sending bank may move within rolling windows. Counters are
+kept in memory: each window is split into a fixed number of buckets, so
+that old payments drop out of a window a bucket at a time.
+
+Payments reserve room under every limit before they're stored, and release
+it if they end up not being stored.
+*/
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/utils"
+)
+
+// Scopes of a limit.
+const (
+	ScopeAccount = "account"
+	ScopeBank    = "bank"
+)
+
+// Code is the error code of payments rejected for exceeding a limit.
+const Code = "limit_exceeded"
+
+const numBuckets = 60
+
+// Limit caps the number and the total amount of the payments sent
+// by each account or bank within a window. Amounts are in the
+// settlement currency. Zero means no cap.
+type Limit struct {
+	Scope     string
+	Window    time.Duration
+	MaxCount  int64
+	MaxAmount utils.Money
+}
+
+// windows maps the env variables' infixes to the windows they configure.
+var windows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"HOURLY", time.Hour},
+	{"DAILY", 24 * time.Hour},
+}
+
+// LimitError explains which limit a payment would exceed.
+type LimitError struct {
+	Limit   Limit
+	Key     string
+	Count   bool  // Whether the count rather than the amount is exceeded
+	Current int64 // Count or amount before the payment
+}
+
+func (e *LimitError) Error() string {
+	if e.Count {
+		return fmt.Sprintf("%s %s exceeded its limit of %d payments per %s",
+			e.Limit.Scope, e.Key, e.Limit.MaxCount, e.Limit.Window)
+	}
+	return fmt.Sprintf("%s %s exceeded its limit of %d sent per %s",
+		e.Limit.Scope, e.Key, e.Limit.MaxAmount, e.Limit.Window)
+}
+
+// bucket counts the payments sent within a slice of a window.
+type bucket struct {
+	idx    int64
+	count  int64
+	amount utils.Money
+}
+
+// window holds the buckets of a single limit for a single key.
+type window struct {
+	width   int64
+	buckets [numBuckets]bucket
+}
+
+// Reservation records what a payment added to the counters.
+type Reservation struct {
+	keys   [2]string
+	amount utils.Money
+	at     time.Time
+}
+
+type limiter struct {
+	sync.Mutex
+	logger   *log.Logger
+	limits   []Limit
+	counters map[string][]*window // Indexed by key, then by limit
+	quit     chan struct{}
+}
+
+var l *limiter // Limiter singleton
+
+// New reads the limits from the environment and prunes idle counters every interval.
+func New(ctx context.Context, interval time.Duration) error {
+
+	limits, err := fromEnv()
+	if err != nil {
+		return err
+	}
+
+	l = &limiter{
+		logger:   log.New(os.Stderr, "[limits] ", log.LstdFlags|log.Lshortfile),
+		limits:   limits,
+		counters: make(map[string][]*window),
+		quit:     make(chan struct{}),
+	}
+	for _, lim := range limits {
+		l.logger.Printf("Limiting %s to %d payments and %d sent per %s",
+			lim.Scope, lim.MaxCount, lim.MaxAmount, lim.Window)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.quit:
+				return
+			case <-ticker.C:
+				l.prune(time.Now())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops pruning counters.
+func Close() {
+	close(l.quit)
+}
+
+// Reserve counts the payment towards the limits of its sending account and bank,
+// or returns a *LimitError without counting it if it would exceed any of them.
+func Reserve(paymnt *utils.Payment) (*Reservation, error) {
+	amount, err := registry.Settled(paymnt)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Reservation{
+		keys: [2]string{
+			accountKey(paymnt.Sender.Name, paymnt.Sender.Account),
+			bankKey(paymnt.Sender.Name),
+		},
+		amount: amount,
+		at:     time.Now(),
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	// Check every limit before counting towards any
+	for i, lim := range l.limits {
+		key := res.keyOf(lim.Scope)
+		win := l.windowOf(key, i)
+		count, amount := win.sum(res.at)
+
+		if lim.MaxCount > 0 && count+1 > lim.MaxCount {
+			return nil, &LimitError{Limit: lim, Key: key, Count: true, Current: count}
+		}
+		total, err := amount.Add(res.amount)
+		if lim.MaxAmount > 0 && (err != nil || total > lim.MaxAmount) {
+			return nil, &LimitError{Limit: lim, Key: key, Current: int64(amount)}
+		}
+	}
+
+	for i, lim := range l.limits {
+		l.windowOf(res.keyOf(lim.Scope), i).add(res.at, 1, res.amount)
+	}
+
+	return res, nil
+}
+
+// Release takes back a reservation of a payment that wasn't stored.
+func Release(res *Reservation) {
+	if res == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	for i, lim := range l.limits {
+		l.windowOf(res.keyOf(lim.Scope), i).add(res.at, -1, -res.amount)
+	}
+}
+
+// keyOf returns the key a reservation counts towards for the given scope.
+func (res *Reservation) keyOf(scope string) string {
+	if scope == ScopeAccount {
+		return res.keys[0]
+	}
+	return res.keys[1]
+}
+
+// windowOf returns the window of the i-th limit for the key, creating it if needed.
+func (l *limiter) windowOf(key string, i int) *window {
+	wins, exists := l.counters[key]
+	if !exists {
+		wins = make([]*window, len(l.limits))
+		l.counters[key] = wins
+	}
+	if wins[i] == nil {
+		wins[i] = &window{width: int64(l.limits[i].Window) / numBuckets}
+	}
+	return wins[i]
+}
+
+// prune drops the counters of keys that sent nothing within any window.
+func (l *limiter) prune(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	for key, wins := range l.counters {
+		idle := true
+		for _, win := range wins {
+			if win == nil {
+				continue
+			}
+			if count, _ := win.sum(now); count != 0 {
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(l.counters, key)
+		}
+	}
+}
+
+// add counts payments towards the bucket holding the given time,
+// unless that bucket already dropped out of the window.
+func (win *window) add(at time.Time, count int64, amount utils.Money) {
+	idx := at.UnixNano() / win.width
+	b := &win.buckets[idx%numBuckets]
+
+	if b.idx != idx {
+		if b.idx > idx {
+			return
+		}
+		*b = bucket{idx: idx}
+	}
+	b.count += count
+	b.amount += amount
+}
+
+// sum returns the count and amount of the payments within the window ending now.
+// Amounts are capped by the limits, so they can't overflow.
+func (win *window) sum(now time.Time) (count int64, amount utils.Money) {
+	idx := now.UnixNano() / win.width
+	for _, b := range win.buckets {
+		if b.idx > idx-numBuckets && b.idx <= idx {
+			count += b.count
+			amount += b.amount
+		}
+	}
+	return count, amount
+}
+
+func accountKey(bank string, account int) string {
+	return fmt.Sprintf("%s/%d", bank, account)
+}
+
+func bankKey(bank string) string {
+	return bank
+}
+
+// fromEnv reads limits from variables such as LIMIT_ACCOUNT_HOURLY_COUNT
+// and LIMIT_BANK_DAILY_AMOUNT. Unset variables don't cap anything.
+func fromEnv() ([]Limit, error) {
+	var limits []Limit
+
+	for _, scope := range []string{ScopeAccount, ScopeBank} {
+		for _, w := range windows {
+			lim := Limit{Scope: scope, Window: w.window}
+			prefix := fmt.Sprintf("LIMIT_%s_%s_", strings.ToUpper(scope), w.name)
+
+			var err error
+			if lim.MaxCount, err = envInt(prefix + "COUNT"); err != nil {
+				return nil, err
+			}
+			maxAmount, err := envInt(prefix + "AMOUNT")
+			if err != nil {
+				return nil, err
+			}
+			lim.MaxAmount = utils.Money(maxAmount)
+			if lim.MaxCount > 0 || lim.MaxAmount > 0 {
+				limits = append(limits, lim)
+			}
+		}
+	}
+
+	return limits, nil
+}
+
+// envInt reads a non-negative integer variable, defaulting to zero.
+func envInt(name string) (int64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// testStart is at the start of a bucket of every window.
+var testStart = time.Unix(0, 0).Add(1000 * 24 * time.Hour)
+
+type testAdd struct {
+	at     time.Duration // Since testStart
+	count  int64
+	amount utils.Money
+}
+
+func TestWindowRollover(t *testing.T) {
+	tests := []struct {
+		name       string
+		adds       []testAdd
+		at         time.Duration
+		wantCount  int64
+		wantAmount utils.Money
+	}{
+		{
+			"same bucket",
+			[]testAdd{{0, 1, 100}, {30 * time.Second, 1, 250}},
+			59 * time.Second,
+			2, 350,
+		},
+		{
+			"spread over the window",
+			[]testAdd{{0, 1, 100}, {30 * time.Minute, 1, 250}, {59 * time.Minute, 1, 50}},
+			59*time.Minute + 59*time.Second,
+			3, 400,
+		},
+		// The first bucket drops out once a full window went by
+		{
+			"oldest bucket drops out",
+			[]testAdd{{0, 1, 100}, {30 * time.Minute, 1, 250}},
+			time.Hour,
+			1, 250,
+		},
+		{
+			"everything drops out",
+			[]testAdd{{0, 1, 100}, {30 * time.Minute, 1, 250}},
+			90 * time.Minute,
+			0, 0,
+		},
+		// A bucket is reset before it's reused by the next window
+		{
+			"reused bucket",
+			[]testAdd{{0, 1, 100}, {time.Hour + 10*time.Second, 1, 250}},
+			time.Hour + 20*time.Second,
+			1, 250,
+		},
+		// Releases of payments whose bucket was reused are dropped
+		{
+			"late release",
+			[]testAdd{{0, 1, 100}, {time.Hour, 1, 250}, {0, -1, -100}},
+			time.Hour,
+			1, 250,
+		},
+		{
+			"release",
+			[]testAdd{{0, 1, 100}, {time.Minute, 1, 250}, {0, -1, -100}},
+			2 * time.Minute,
+			1, 250,
+		},
+		// Buckets ahead of the end of the window aren't counted
+		{
+			"later bucket",
+			[]testAdd{{0, 1, 100}, {10 * time.Minute, 1, 250}},
+			5 * time.Minute,
+			1, 100,
+		},
+	}
+	for _, tt := range tests {
+		win := &window{width: int64(time.Hour) / numBuckets}
+		for _, add := range tt.adds {
+			win.add(testStart.Add(add.at), add.count, add.amount)
+		}
+
+		count, amount := win.sum(testStart.Add(tt.at))
+		if count != tt.wantCount || amount != tt.wantAmount {
+			t.Errorf("%s: sum() = %d, %d, want %d, %d", tt.name, count, amount, tt.wantCount, tt.wantAmount)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	lim := &limiter{
+		limits: []Limit{
+			{Scope: ScopeBank, Window: time.Hour},
+			{Scope: ScopeBank, Window: 24 * time.Hour},
+		},
+		counters: make(map[string][]*window),
+	}
+	lim.windowOf("idle", 0).add(testStart, 1, 100)
+	lim.windowOf("hourly", 0).add(testStart.Add(30*time.Minute), 1, 100)
+	lim.windowOf("daily", 1).add(testStart, 1, 100)
+
+	lim.prune(testStart.Add(70 * time.Minute))
+
+	for key, want := range map[string]bool{"idle": false, "hourly": true, "daily": true} {
+		if _, exists := lim.counters[key]; exists != want {
+			t.Errorf("counters of %s kept = %t, want %t", key, exists, want)
+		}
+	}
+}
@@ -15,13 +15,26 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/sekerez/polka/receiver/src/client"
 	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/receiver/src/rules"
+	"github.com/sekerez/polka/receiver/src/scheduler"
 	"github.com/sekerez/polka/receiver/src/service"
+	"github.com/sekerez/polka/receiver/src/webhooks"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
 	mainEnv          = "receiver.env"
 	cacheConnTimeout = 30 * time.Second
 	cacheReqTimeout  = 10 * time.Second
+	relayInterval    = time.Second
+	registryInterval = 5 * time.Second
+	limitsInterval   = 10 * time.Minute
+	scheduleInterval = time.Second
+	webhookInterval  = time.Second
+	webhookTimeout   = 10 * time.Second
 )
 
 func main() {
@@ -56,11 +69,29 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Load the API keys signing requests
+	keys, err := utils.LoadKeyring(os.Getenv("KEYSPATH"))
+	if err != nil {
+		logger.Fatalf("Could not load API keys: %s", err)
+	}
+	key, exists := keys.Get(os.Getenv("APIKEY"))
+	if !exists {
+		logger.Fatalf("Unknown API key: %q", os.Getenv("APIKEY"))
+	}
+
+	// Load the certificates for mutual TLS, if configured
+	tlsFiles, err := utils.TLSFromEnv()
+	if err != nil {
+		logger.Fatalf("Could not load TLS certificates: %s", err)
+	}
+
 	// Initialize client
 	err = client.New(
 		os.Getenv("CACHEADDRESS"),
 		cacheConnTimeout,
 		cacheReqTimeout,
+		key,
+		tlsFiles,
 	)
 	if err != nil {
 		logger.Fatalf("Could not start client: %s", err)
@@ -72,8 +103,35 @@ func main() {
 		logger.Fatalf("Could not init DB connection: %s", err)
 	}
 
+	// Initialize bank registry
+	err = registry.New(ctx, registryInterval)
+	if err != nil {
+		logger.Fatalf("Could not load bank registry: %s", err)
+	}
+
+	// Initialize velocity limits
+	err = limits.New(ctx, limitsInterval)
+	if err != nil {
+		logger.Fatalf("Could not load velocity limits: %s", err)
+	}
+
+	// Initialize fraud and risk rules
+	err = rules.New(os.Getenv("RULESPATH"))
+	if err != nil {
+		logger.Fatalf("Could not load rules: %s", err)
+	}
+
+	// Initialize dispatcher delivering webhook events, woken up by the relay
+	webhooks.New(ctx, webhookInterval, webhookTimeout)
+
+	// Initialize relay delivering cache updates
+	relay.New(ctx, relayInterval)
+
+	// Initialize scheduler executing due payments
+	scheduler.New(ctx, scheduleInterval)
+
 	// Initialize service
-	s, err := service.New(u, ctx)
+	s, err := service.New(u, ctx, keys, tlsFiles, os.Getenv("GRPCPORT"))
 	if err != nil {
 		logger.Fatalf("Failed to initialize service: %s", err)
 	}
@@ -117,4 +175,12 @@ func main() {
 		logger.Fatalf("Failed to close service: %s", err)
 	}
 	logger.Printf("Shut down api service.")
+
+	// Stop executing scheduled payments, then relay the last updates
+	scheduler.Close()
+	relay.Close()
+	webhooks.Close()
+	registry.Close()
+	limits.Close()
+	logger.Printf("Shut down scheduler, relay, webhooks, registry and limits.")
 }
@@ -0,0 +1,170 @@
+package registry
+
+/*
+The registry keeps an in-memory copy of the banks and fx_rates tables, so that
+payments can be validated without querying the database. Since banks may be
+changed through other receivers, the copy is refreshed at regular intervals.
+*/
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+type registry struct {
+	sync.RWMutex
+	ctx    context.Context
+	logger *log.Logger
+	banks  map[string]*utils.Bank
+	bics   map[string]*utils.Bank
+	rates  map[string]*big.Rat
+	quit   chan struct{}
+}
+
+var r *registry // Registry singleton
+
+// New loads all banks and rates and keeps them up to date every interval.
+func New(ctx context.Context, interval time.Duration) error {
+
+	r = &registry{
+		ctx:    ctx,
+		logger: log.New(os.Stderr, "[registry] ", log.LstdFlags|log.Lshortfile),
+		quit:   make(chan struct{}),
+	}
+
+	if err := Refresh(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				if err := Refresh(r.ctx); err != nil {
+					r.logger.Printf("Error refreshing banks: %s", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops refreshing the registry.
+func Close() {
+	close(r.quit)
+}
+
+// Refresh reloads all banks and rates from the database.
+func Refresh(ctx context.Context) error {
+
+	banks, err := dbstore.ListBanks(ctx)
+	if err != nil {
+		return err
+	}
+	rates, err := dbstore.ListRates(ctx)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*utils.Bank, len(banks))
+	byBIC := make(map[string]*utils.Bank, len(banks))
+	for _, bnk := range banks {
+		byName[bnk.Name] = bnk
+		if bnk.BIC != "" {
+			byBIC[bnk.BIC] = bnk
+		}
+	}
+
+	r.Lock()
+	r.banks = byName
+	r.bics = byBIC
+	r.rates = rates
+	r.Unlock()
+
+	return nil
+}
+
+// CheckBank returns an error unless the bank is registered and active.
+func CheckBank(name string) error {
+	r.RLock()
+	bnk, exists := r.banks[name]
+	r.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("unknown bank: %q", name)
+	}
+	if bnk.Status != utils.BankActive {
+		return fmt.Errorf("bank is %s: %q", bnk.Status, name)
+	}
+	return nil
+}
+
+// BankByBIC returns the name of the bank with the given BIC. A BIC with a
+// branch code also matches the bank registered with the BIC of its head office.
+func BankByBIC(bic string) (string, error) {
+	normalized, err := utils.NormalizeBIC(bic)
+	if err != nil {
+		return "", err
+	}
+
+	r.RLock()
+	bnk, exists := r.bics[normalized]
+	if !exists {
+		bnk, exists = r.bics[normalized[:8]]
+	}
+	r.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("unknown BIC: %q", bic)
+	}
+	return bnk.Name, nil
+}
+
+// CheckCurrency returns an error unless the currency has an exchange rate.
+func CheckCurrency(currency string) error {
+	r.RLock()
+	_, exists := r.rates[currency]
+	r.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("unsupported currency: %q", currency)
+	}
+	return nil
+}
+
+// CheckPayment returns an error unless both of the payment's banks
+// are active and its currency is supported.
+func CheckPayment(paymnt *utils.Payment) error {
+	if err := CheckCurrency(paymnt.Currency); err != nil {
+		return err
+	}
+	if err := CheckBank(paymnt.Sender.Name); err != nil {
+		return err
+	}
+	return CheckBank(paymnt.Receiver.Name)
+}
+
+// Settled returns the payment's amount converted to the settlement currency.
+func Settled(paymnt *utils.Payment) (utils.Money, error) {
+	r.RLock()
+	rate, exists := r.rates[paymnt.Currency]
+	r.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("unsupported currency: %q", paymnt.Currency)
+	}
+	return utils.Convert(paymnt.Amount, rate)
+}
@@ -0,0 +1,138 @@
+package relay
+
+/*
+The relay delivers the cache updates queued in the outbox table.
+Updates are written in the same database transaction as their payments,
+so every stored payment eventually reaches the cache. Since an update may be
+delivered more than once, the cache ignores ids it has already applied.
+Updates the cache rejects for good, such as those overflowing a balance,
+are set aside rather than retried.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/client"
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/webhooks"
+)
+
+const (
+	batchSize  = 100
+	claim      = 5 * time.Minute // How long other receivers skip the entries being delivered
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = time.Minute
+)
+
+type relay struct {
+	ctx      context.Context
+	logger   *log.Logger
+	interval time.Duration
+	wake     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+var r *relay // Relay singleton
+
+// New starts the relay, which looks for pending outbox entries
+// every interval, or as soon as Notify is called.
+func New(ctx context.Context, interval time.Duration) {
+
+	r = &relay{
+		ctx:      ctx,
+		logger:   log.New(os.Stderr, "[relay] ", log.LstdFlags|log.Lshortfile),
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go r.run()
+}
+
+// Notify wakes up the relay after a new entry was written to the outbox.
+// It never blocks.
+func Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default: // A wake up is already pending
+	}
+}
+
+// Close stops the relay once the current delivery round is over.
+func Close() {
+	close(r.quit)
+	<-r.done
+}
+
+func (r *relay) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			close(r.done)
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+
+		// Keep going while there might be more due entries
+		for {
+			n, err := dbstore.DeliverOutbox(r.ctx, batchSize, claim, deliver, backoff)
+			if err != nil {
+				r.logger.Printf("Error delivering outbox entries: %s", err)
+				break
+			}
+			if n > 0 {
+				// Posting payments queues events for their banks
+				webhooks.Notify()
+			}
+			if n < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliver sends an outbox entry to the cache.
+func deliver(entry *dbstore.OutboxEntry) error {
+	var err error
+
+	switch entry.Kind {
+	case dbstore.OutboxBatch:
+		err = client.SendBatchUpdate(bytes.NewBuffer(entry.Payload))
+	case dbstore.OutboxAccount:
+		err = client.SendAccountUpdate(bytes.NewBuffer(entry.Payload))
+	default:
+		err = client.SendTransactionUpdate(bytes.NewBuffer(entry.Payload))
+	}
+	if errors.Is(err, client.ErrRejected) {
+		r.logger.Printf("Cache rejected outbox entry %d: %s", entry.Id, err)
+		return fmt.Errorf("%w: %s", dbstore.ErrUndeliverable, err)
+	}
+	if err != nil {
+		r.logger.Printf("Failed delivering outbox entry %d (attempt %d): %s", entry.Id, entry.Attempts+1, err)
+	}
+	return err
+}
+
+// backoff returns an exponentially growing delay given the number of attempts.
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,173 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/utils"
+)
+
+// threshold holds or rejects payments over fixed amounts in the settlement currency.
+type threshold struct {
+	review utils.Money // Zero means no review threshold
+	deny   utils.Money // Zero means no deny threshold
+}
+
+func (r *threshold) Name() string { return "threshold" }
+
+func (r *threshold) Evaluate(ctx context.Context, paymnt *utils.Payment) (string, string, error) {
+	amount, err := registry.Settled(paymnt)
+	if err != nil {
+		return "", "", err
+	}
+	if r.deny > 0 && amount >= r.deny {
+		return Deny, fmt.Sprintf("amount %d reaches %d", amount, r.deny), nil
+	}
+	if r.review > 0 && amount >= r.review {
+		return Review, fmt.Sprintf("amount %d reaches %d", amount, r.review), nil
+	}
+	return Allow, "", nil
+}
+
+// newAccount screens large payments sent by recently opened accounts.
+type newAccount struct {
+	minAge    time.Duration
+	maxAmount utils.Money
+	verdict   string
+}
+
+func (r *newAccount) Name() string { return "new_account" }
+
+func (r *newAccount) Evaluate(ctx context.Context, paymnt *utils.Payment) (string, string, error) {
+	amount, err := registry.Settled(paymnt)
+	if err != nil {
+		return "", "", err
+	}
+	if amount <= r.maxAmount {
+		return Allow, "", nil
+	}
+
+	acc, err := dbstore.GetAccountByName(ctx, paymnt.Sender.Name, paymnt.Sender.Account)
+	if err == dbstore.ErrAccountNotFound {
+		// Left to the account checks when storing the payment
+		return Allow, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if age := time.Since(acc.OpenedAt); age < r.minAge {
+		return r.verdict, fmt.Sprintf(
+			"account opened %s ago sends more than %d",
+			age.Round(time.Minute),
+			r.maxAmount,
+		), nil
+	}
+	return Allow, "", nil
+}
+
+// counterparty screens large payments to accounts the sender never paid before.
+type counterparty struct {
+	minAmount utils.Money
+	verdict   string
+}
+
+func (r *counterparty) Name() string { return "counterparty" }
+
+func (r *counterparty) Evaluate(ctx context.Context, paymnt *utils.Payment) (string, string, error) {
+	amount, err := registry.Settled(paymnt)
+	if err != nil {
+		return "", "", err
+	}
+	if amount < r.minAmount {
+		return Allow, "", nil
+	}
+
+	paid, err := dbstore.HasPaid(ctx, paymnt.Sender, paymnt.Receiver)
+	if err != nil {
+		return "", "", err
+	}
+	if !paid {
+		return r.verdict, fmt.Sprintf(
+			"first payment of at least %d to account %d at %s",
+			r.minAmount,
+			paymnt.Receiver.Account,
+			paymnt.Receiver.Name,
+		), nil
+	}
+	return Allow, "", nil
+}
+
+// roundBurst screens accounts sending many round amounts in a short time,
+// counting the payments that were stored, as told by Record.
+type roundBurst struct {
+	sync.Mutex
+	multiple utils.Money
+	window   time.Duration
+	maxCount int
+	verdict  string
+	seen     map[string][]time.Time // Times of recent round payments by sender
+}
+
+func (r *roundBurst) Name() string { return "round_burst" }
+
+func (r *roundBurst) Evaluate(ctx context.Context, paymnt *utils.Payment) (string, string, error) {
+	if !r.isRound(paymnt) {
+		return Allow, "", nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// The payment counts as one more round payment
+	count := len(r.recent(burstKey(paymnt), time.Now())) + 1
+	if count > r.maxCount {
+		return r.verdict, fmt.Sprintf(
+			"%d payments of multiples of %d within %s",
+			count,
+			r.multiple,
+			r.window,
+		), nil
+	}
+	return Allow, "", nil
+}
+
+func (r *roundBurst) Record(paymnt *utils.Payment) {
+	if !r.isRound(paymnt) {
+		return
+	}
+
+	key := burstKey(paymnt)
+	now := time.Now()
+
+	r.Lock()
+	r.seen[key] = append(r.recent(key, now), now)
+	r.Unlock()
+}
+
+// isRound tells whether the payment's amount is a multiple the rule screens.
+func (r *roundBurst) isRound(paymnt *utils.Payment) bool {
+	return paymnt.Amount != 0 && paymnt.Amount%r.multiple == 0
+}
+
+// recent drops the sender's payments that fell out of the window, and returns
+// the others. It must be called with the lock held.
+func (r *roundBurst) recent(key string, now time.Time) []time.Time {
+	recent := r.seen[key][:0]
+	for _, t := range r.seen[key] {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	r.seen[key] = recent
+	return recent
+}
+
+// burstKey identifies the sending account of a payment.
+func burstKey(paymnt *utils.Payment) string {
+	return fmt.Sprintf("%s/%d", paymnt.Sender.Name, paymnt.Sender.Account)
+}
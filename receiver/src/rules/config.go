@@ -0,0 +1,118 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// Config describes the built-in rules. Rules left out of it are disabled.
+// Durations are strings such as "24h", and verdicts default to review.
+// Amounts are in the settlement currency, except for round bursts.
+type Config struct {
+	Threshold *struct {
+		Review utils.Money
+		Deny   utils.Money
+	}
+	NewAccount *struct {
+		MinAge    string
+		MaxAmount utils.Money
+		Verdict   string
+	}
+	Counterparty *struct {
+		MinAmount utils.Money
+		Verdict   string
+	}
+	RoundBurst *struct {
+		Multiple utils.Money
+		Window   string
+		MaxCount int
+		Verdict  string
+	}
+}
+
+// loadConfig reads a json config file.
+func loadConfig(path string) (*Config, error) {
+	var cfg Config
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid rules config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// rules builds the rules enabled by the config.
+func (cfg *Config) rules() ([]Rule, error) {
+	var rules []Rule
+
+	if c := cfg.Threshold; c != nil {
+		rules = append(rules, &threshold{review: c.Review, deny: c.Deny})
+	}
+
+	if c := cfg.NewAccount; c != nil {
+		minAge, err := time.ParseDuration(c.MinAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid new account age: %w", err)
+		}
+		verdict, err := parseVerdict(c.Verdict)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &newAccount{minAge: minAge, maxAmount: c.MaxAmount, verdict: verdict})
+	}
+
+	if c := cfg.Counterparty; c != nil {
+		verdict, err := parseVerdict(c.Verdict)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &counterparty{minAmount: c.MinAmount, verdict: verdict})
+	}
+
+	if c := cfg.RoundBurst; c != nil {
+		window, err := time.ParseDuration(c.Window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid round burst window: %w", err)
+		}
+		if c.Multiple <= 0 || c.MaxCount <= 0 {
+			return nil, fmt.Errorf("round burst multiple and count must be positive")
+		}
+		verdict, err := parseVerdict(c.Verdict)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &roundBurst{
+			multiple: c.Multiple,
+			window:   window,
+			maxCount: c.MaxCount,
+			verdict:  verdict,
+			seen:     make(map[string][]time.Time),
+		})
+	}
+
+	return rules, nil
+}
+
+// parseVerdict checks the verdict of a rule, defaulting to review.
+func parseVerdict(verdict string) (string, error) {
+	switch verdict {
+	case "":
+		return Review, nil
+	case Review, Deny:
+		return verdict, nil
+	default:
+		return "", fmt.Errorf("invalid verdict: %q", verdict)
+	}
+}
@@ -0,0 +1,147 @@
+package rules
+
+/*
+The rules package screens payments for fraud and risk before they're stored.
+Each rule looks at a payment and returns a verdict: allow lets the payment
+through, review holds it as pending until an operator approves or declines
+it, and deny rejects it outright. The strictest verdict of all rules wins.
+
+Built-in rules are set up from a json config, and other rules can be added
+by implementing the Rule interface and passing them to Register.
+*/
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// Verdicts of a rule, from the most to the least lenient.
+const (
+	Allow  = "allow"
+	Review = "review"
+	Deny   = "deny"
+)
+
+// Code is the error code of payments denied by a rule.
+const Code = "payment_denied"
+
+// severity orders verdicts, so that the strictest one wins.
+var severity = map[string]int{
+	Allow:  0,
+	Review: 1,
+	Deny:   2,
+}
+
+// Rule evaluates a payment, returning a verdict and, unless it allows
+// the payment, the reason for it.
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, paymnt *utils.Payment) (verdict string, reason string, err error)
+}
+
+// Recorder is implemented by rules that keep state about past payments, such
+// as how many a sender made recently. They only learn about payments once
+// they're stored, so that rejected payments and replays aren't counted.
+type Recorder interface {
+	Record(paymnt *utils.Payment)
+}
+
+// Decision is the outcome of evaluating a payment against all rules.
+type Decision struct {
+	Verdict string
+	Reasons []string // One for each rule that didn't allow the payment
+}
+
+// Reason joins the reasons of the decision.
+func (d *Decision) Reason() string {
+	return strings.Join(d.Reasons, "; ")
+}
+
+type engine struct {
+	sync.RWMutex
+	logger *log.Logger
+	rules  []Rule
+}
+
+var e *engine // Engine singleton
+
+// New sets up the built-in rules described by the config file at path.
+// An empty path sets up no rules, so that every payment is allowed.
+func New(path string) error {
+
+	e = &engine{
+		logger: log.New(os.Stderr, "[rules] ", log.LstdFlags|log.Lshortfile),
+	}
+	if path == "" {
+		return nil
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	rules, err := cfg.rules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		Register(rule)
+	}
+
+	return nil
+}
+
+// Register adds a rule to those every payment is evaluated against.
+func Register(rule Rule) {
+	e.Lock()
+	e.rules = append(e.rules, rule)
+	e.Unlock()
+
+	e.logger.Printf("Registered rule %s", rule.Name())
+}
+
+// Evaluate runs the payment through every rule and returns the strictest verdict.
+// Rules that fail to evaluate the payment hold it for review.
+func Evaluate(ctx context.Context, paymnt *utils.Payment) *Decision {
+	decision := &Decision{Verdict: Allow}
+
+	e.RLock()
+	defer e.RUnlock()
+
+	for _, rule := range e.rules {
+		verdict, reason, err := rule.Evaluate(ctx, paymnt)
+		if err != nil {
+			e.logger.Printf("Error evaluating rule %s: %s", rule.Name(), err)
+			verdict, reason = Review, "could not be evaluated"
+		}
+		if verdict == Allow {
+			continue
+		}
+
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("%s: %s", rule.Name(), reason))
+		if severity[verdict] > severity[decision.Verdict] {
+			decision.Verdict = verdict
+		}
+	}
+
+	return decision
+}
+
+// Record tells the rules that keep state about past payments that a new
+// payment was stored.
+func Record(paymnt *utils.Payment) {
+	e.RLock()
+	defer e.RUnlock()
+
+	for _, rule := range e.rules {
+		if rec, ok := rule.(Recorder); ok {
+			rec.Record(paymnt)
+		}
+	}
+}
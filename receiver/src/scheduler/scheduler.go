@@ -0,0 +1,199 @@
+package scheduler
+
+/*
+The scheduler executes payments that were submitted ahead of their execution time,
+as well as mandates, which pay the same amount on a schedule. Due payments go
+through the same checks, rules and limits as payments sent right away, and are
+stored together with their cache update like any other payment.
+
+Each scheduled payment, and each execution of a mandate, is stored with an
+idempotency key derived from its id, and is claimed while it's executed, so
+that it's only ever stored once, even by several receivers or across restarts.
+Failed executions of mandates are retried with an exponential backoff.
+
+The scheduler also expires the payment requests left unanswered in time.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/receiver/src/rules"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	batchSize          = 100
+	maxAttempts        = 10
+	maxMandateAttempts = 5
+	minRetry           = time.Minute
+	maxRetry           = 6 * time.Hour
+	claim              = 5 * time.Minute // How long other receivers skip the payments being executed
+)
+
+type scheduler struct {
+	ctx      context.Context
+	logger   *log.Logger
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+var s *scheduler // Scheduler singleton
+
+// New starts the scheduler, which looks for due payments every interval.
+func New(ctx context.Context, interval time.Duration) {
+
+	s = &scheduler{
+		ctx:      ctx,
+		logger:   log.New(os.Stderr, "[scheduler] ", log.LstdFlags|log.Lshortfile),
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+}
+
+// Close stops the scheduler once the current execution round is over.
+func Close() {
+	close(s.quit)
+	<-s.done
+}
+
+func (s *scheduler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			close(s.done)
+			return
+		case <-ticker.C:
+		}
+
+		s.drain("scheduled payments", func(now time.Time) (int, error) {
+			return dbstore.ExecuteScheduled(s.ctx, now, batchSize, maxAttempts, claim, executeScheduled)
+		})
+		s.drain("mandates", func(now time.Time) (int, error) {
+			return dbstore.ScheduleMandates(s.ctx, now, batchSize)
+		})
+		s.drain("mandate executions", func(now time.Time) (int, error) {
+			return dbstore.ExecuteMandates(s.ctx, now, batchSize, maxMandateAttempts, claim, retryIn, executeMandate)
+		})
+		s.drain("payment requests", func(now time.Time) (int, error) {
+			return dbstore.ExpireRequests(s.ctx, now, batchSize)
+		})
+	}
+}
+
+// drain keeps handling due work while there might be more of it.
+func (s *scheduler) drain(what string, handle func(now time.Time) (int, error)) {
+	for {
+		n, err := handle(time.Now())
+		if err != nil {
+			s.logger.Printf("Error handling %s: %s", what, err)
+			return
+		}
+		if n < batchSize {
+			return
+		}
+	}
+}
+
+// executeScheduled stores the payment a scheduled payment stands for, and returns its id.
+func executeScheduled(sched *utils.ScheduledPayment) (string, error) {
+	name := fmt.Sprintf("scheduled payment %s", sched.Id)
+	return store(sched.Payment(), dbstore.ScheduleKey(sched.Id), name)
+}
+
+// executeMandate stores the payment executing a mandate when it was due, and returns its id.
+func executeMandate(m *utils.Mandate, exec *utils.MandateExecution) (string, error) {
+	name := fmt.Sprintf("mandate %s due %s", m.Id, exec.DueAt.Format(time.RFC3339))
+	return store(m.Payment(exec.DueAt), dbstore.MandateKey(m.Id, exec.DueAt), name)
+}
+
+// store runs a payment through the checks, rules and limits, and stores it with
+// the given idempotency key. It returns the payment's id, or an error wrapping
+// dbstore.ErrNotExecutable if the payment can't be stored as it is.
+func store(paymnt *utils.Payment, key, name string) (string, error) {
+	var accErr *dbstore.AccountError
+
+	if err := registry.CheckPayment(paymnt); err != nil {
+		return "", notExecutable(name, err.Error())
+	}
+
+	// An execution that was already stored isn't screened again
+	replayed, err := dbstore.FindIdempotentPayment(s.ctx, paymnt, key)
+	if err != nil {
+		s.logger.Printf("Failed executing %s: %s", name, err)
+		return "", err
+	}
+	if replayed {
+		return paymnt.Id, nil
+	}
+
+	dec := rules.Evaluate(s.ctx, paymnt)
+	if dec.Verdict == rules.Deny {
+		return "", notExecutable(name, dec.Reason())
+	}
+	res, err := limits.Reserve(paymnt)
+	if err != nil {
+		return "", notExecutable(name, err.Error())
+	}
+
+	paymnt.Id = utils.NewId()
+	paymnt.Status = utils.PaymentValidated
+	if dec.Verdict == rules.Review {
+		paymnt.Status, paymnt.Reason = utils.PaymentReceived, dec.Reason()
+	}
+
+	replayed, err = dbstore.InsertPayment(s.ctx, paymnt, key)
+	if err != nil || replayed {
+		// Only newly stored payments count towards limits
+		limits.Release(res)
+	}
+	if errors.As(err, &accErr) {
+		return "", notExecutable(name, accErr.Error())
+	}
+	if err != nil {
+		s.logger.Printf("Failed executing %s: %s", name, err)
+		return "", err
+	}
+
+	if !replayed {
+		rules.Record(paymnt)
+	}
+	if !replayed && paymnt.Status == utils.PaymentValidated {
+		relay.Notify()
+	}
+	s.logger.Printf("Executed %s as payment %s", name, paymnt.Id)
+	return paymnt.Id, nil
+}
+
+// notExecutable logs why a payment can't be executed, and returns it as an error.
+func notExecutable(name, reason string) error {
+	s.logger.Printf("Can't execute %s: %s", name, reason)
+	return fmt.Errorf("%w: %s", dbstore.ErrNotExecutable, reason)
+}
+
+// retryIn returns an exponentially growing delay given the number of attempts.
+func retryIn(attempts int) time.Duration {
+	delay := minRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+	if delay > maxRetry {
+		delay = maxRetry
+	}
+	return delay
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/utils"
+)
+
+// accountActions maps the actions of /accounts/{bank}/{account}/{action}
+// to the status the account moves to.
+var accountActions = map[string]string{
+	"freeze":   utils.AccountFrozen,
+	"unfreeze": utils.AccountOpen,
+	"close":    utils.AccountClosed,
+}
+
+// handleAccounts opens accounts.
+func handleAccounts(w http.ResponseWriter, req *http.Request) {
+	var acc utils.Account
+
+	if req.Method != http.MethodPost {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	err := json.NewDecoder(req.Body).Decode(&acc)
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+	if acc.Account < 0 {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("account numbers can't be negative"))
+		return
+	}
+	if !authorizeBank(w, req, acc.Bank) {
+		return
+	}
+	if err = registry.CheckBank(acc.Bank); err != nil {
+		utils.WriteError(w, req, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	opened, err := dbstore.OpenAccount(req.Context(), acc.Bank, acc.Account)
+	if err == nil {
+		relay.Notify() // The cache learns about new accounts through the outbox
+	}
+	writeAccount(w, req, opened, err, http.StatusCreated)
+}
+
+// handleAccountById returns an account with GET /accounts/{bank}/{account},
+// and changes its status with POST /accounts/{bank}/{account}/{action}. The
+// bank is given by name, escaped, as when opening accounts.
+func handleAccountById(w http.ResponseWriter, req *http.Request) {
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.EscapedPath(), accountIdView), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		utils.NotFound(w, req)
+		return
+	}
+	bank, err := url.PathUnescape(parts[0])
+	if err != nil || strings.TrimSpace(bank) == "" {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("invalid bank name"))
+		return
+	}
+	account, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("invalid account number"))
+		return
+	}
+
+	// Without an action, return the account
+	if len(parts) == 2 {
+		if req.Method != http.MethodGet {
+			utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+			return
+		}
+		acc, err := dbstore.GetAccountByName(req.Context(), bank, account)
+		writeAccount(w, req, acc, err, http.StatusOK)
+		return
+	}
+
+	status, exists := accountActions[parts[2]]
+	if !exists {
+		utils.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	acc, err := dbstore.GetAccountByName(req.Context(), bank, account)
+	if err != nil {
+		writeAccount(w, req, nil, err, http.StatusOK)
+		return
+	}
+	if !authorizeBank(w, req, acc.Bank) {
+		return
+	}
+
+	acc, err = dbstore.SetAccountStatus(req.Context(), acc.BankId, account, status)
+	if err == nil {
+		relay.Notify() // The cache learns about status changes through the outbox
+	}
+	writeAccount(w, req, acc, err, http.StatusOK)
+}
+
+// writeAccount writes an account, or the error that occurred fetching or changing it.
+func writeAccount(w http.ResponseWriter, req *http.Request, acc *utils.Account, err error, status int) {
+
+	switch {
+	case err == nil:
+	case err == dbstore.ErrAccountNotFound || err == dbstore.ErrBankNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case err == dbstore.ErrAccountExists || errors.Is(err, dbstore.ErrInvalidTransition):
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error with accounts: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acc)
+}
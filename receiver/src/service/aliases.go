@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	verifyAction     = "verify"
+	unenrollAction   = "unenroll"
+	aliasMaxAttempts = 5
+)
+
+// aliasCode is the body of requests verifying an alias.
+type aliasCode struct {
+	Code string
+}
+
+// handleAliases enrolls an alias for an account with POST /aliases. The alias
+// is pending until verified, and the verification code, which the account's
+// bank sends to the alias, is only returned when the alias is enrolled.
+func handleAliases(w http.ResponseWriter, req *http.Request) {
+	var alias utils.Alias
+
+	if req.Method != http.MethodPost {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	err := json.NewDecoder(req.Body).Decode(&alias)
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+	alias.Alias, alias.Kind, err = utils.NormalizeAlias(alias.Alias)
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+	if !authorizeBank(w, req, alias.Bank) {
+		return
+	}
+
+	// The code is always generated by the receiver, and only its hash is stored
+	alias.Code = utils.NewAliasCode()
+
+	err = dbstore.EnrollAlias(req.Context(), &alias, utils.HashAliasCode(alias.Alias, alias.Code), aliasMaxAttempts)
+	writeAlias(w, req, &alias, err, http.StatusCreated)
+}
+
+// handleAliasById returns an alias with GET /aliases/{alias}, moves it to another
+// account of the same bank with PUT /aliases/{alias}, verifies it with
+// POST /aliases/{alias}/verify and unenrolls it with POST /aliases/{alias}/unenroll.
+func handleAliasById(w http.ResponseWriter, req *http.Request) {
+
+	raw, action := path.Split(strings.TrimPrefix(req.URL.Path, aliasIdView))
+	if raw == "" {
+		// There's no action, only an alias
+		raw, action = action, ""
+	}
+	alias, _, err := utils.NormalizeAlias(strings.TrimSuffix(raw, "/"))
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+
+	if action != "" && action != verifyAction && action != unenrollAction {
+		utils.NotFound(w, req)
+		return
+	}
+	if (action == "" && req.Method != http.MethodGet && req.Method != http.MethodPut) ||
+		(action != "" && req.Method != http.MethodPost) {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	// Aliases are only seen and changed by the bank they belong to
+	found, err := dbstore.GetAlias(req.Context(), alias)
+	if err != nil {
+		writeAlias(w, req, nil, err, http.StatusOK)
+		return
+	}
+	if !authorizeBank(w, req, found.Bank) {
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		writeAlias(w, req, found, nil, http.StatusOK)
+
+	case action == "":
+		var target utils.Alias
+		if err = json.NewDecoder(req.Body).Decode(&target); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		if target.Bank != "" && target.Bank != found.Bank {
+			utils.WriteError(w, req, http.StatusUnprocessableEntity, errors.New("aliases can only move between accounts of the same bank, unenroll it first"))
+			return
+		}
+		moved, err := dbstore.MoveAlias(req.Context(), alias, target.Account)
+		writeAlias(w, req, moved, err, http.StatusOK)
+
+	case action == verifyAction:
+		var code aliasCode
+		if err = json.NewDecoder(req.Body).Decode(&code); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		verified, err := dbstore.VerifyAlias(req.Context(), alias, utils.HashAliasCode(alias, code.Code), aliasMaxAttempts)
+		writeAlias(w, req, verified, err, http.StatusOK)
+
+	case action == unenrollAction:
+		removed, err := dbstore.UnenrollAlias(req.Context(), alias)
+		writeAlias(w, req, removed, err, http.StatusOK)
+	}
+}
+
+// resolveReceiver sets the receiver of a payment made to an alias to the account
+// the alias pays to, or returns a *paymentError telling why it can't. A receiver
+// given both ways must match the alias.
+func resolveReceiver(ctx context.Context, paymnt *utils.Payment) error {
+
+	if paymnt.Receiver.Alias == "" {
+		return nil
+	}
+	alias, _, err := utils.NormalizeAlias(paymnt.Receiver.Alias)
+	if err != nil {
+		return &paymentError{status: http.StatusBadRequest, err: err}
+	}
+
+	info, err := dbstore.ResolveAlias(ctx, alias)
+	if err == dbstore.ErrAliasNotFound {
+		return &paymentError{status: http.StatusUnprocessableEntity, code: dbstore.CodeAliasUnknown, err: fmt.Errorf("no account has verified alias %s", alias)}
+	}
+	if err != nil {
+		log.Printf("Error resolving alias: %s", err)
+		return err
+	}
+	if paymnt.Receiver.Name != "" && (paymnt.Receiver.Name != info.Name || paymnt.Receiver.Account != info.Account) {
+		return &paymentError{status: http.StatusUnprocessableEntity, err: fmt.Errorf("alias %s belongs to another account than the receiver", alias)}
+	}
+
+	paymnt.Receiver = utils.BankInfo{Name: info.Name, Account: info.Account, Alias: alias}
+	return nil
+}
+
+// writeAlias writes an alias, or the error that occurred fetching or changing it.
+func writeAlias(w http.ResponseWriter, req *http.Request, alias *utils.Alias, err error, status int) {
+	var accErr *dbstore.AccountError
+
+	switch {
+	case err == nil:
+	case err == dbstore.ErrAliasNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case err == dbstore.ErrAliasTaken:
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	case errors.Is(err, dbstore.ErrBadAliasCode):
+		utils.WriteError(w, req, http.StatusUnprocessableEntity, err)
+		return
+	case errors.As(err, &accErr):
+		writeAccountError(w, req, accErr)
+		return
+	default:
+		log.Printf("Error with aliases: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(alias)
+}
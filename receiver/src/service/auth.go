@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sekerez/polka/utils"
+)
+
+// canActFor tells whether the caller can act for a bank, such as originating
+// its payments, which only operators and the bank's own keys can.
+func canActFor(caller *utils.APIKey, bank string) bool {
+	return caller.HasRole(utils.RoleOperator) || (caller.HasRole(utils.RoleBank) && caller.Bank == bank)
+}
+
+// errCantRead is returned for payments of other banks.
+var errCantRead = &paymentError{status: http.StatusForbidden, err: fmt.Errorf("%w: can only read the payments of the caller's bank", utils.ErrForbidden)}
+
+// errCantActFor returns why the caller can't act for a bank.
+func errCantActFor(bank string) error {
+	return &paymentError{status: http.StatusForbidden, err: fmt.Errorf("%w: can't act for bank %q", utils.ErrForbidden, bank)}
+}
+
+// canRead tells whether the caller can read a payment, which banks can only
+// do for the payments they send or receive.
+func canRead(caller *utils.APIKey, paymnt *utils.Payment) bool {
+	return canActFor(caller, paymnt.Sender.Name) || canActFor(caller, paymnt.Receiver.Name)
+}
+
+// readScope returns the bank whose payments the caller can search, which is
+// empty for operators, who can search all of them.
+func readScope(caller *utils.APIKey) (string, error) {
+	switch {
+	case caller.HasRole(utils.RoleOperator):
+		return "", nil
+	case caller.HasRole(utils.RoleBank):
+		return caller.Bank, nil
+	}
+	return "", errCantRead
+}
+
+// authorizeBank tells whether the caller can act for a bank, and writes why not otherwise.
+func authorizeBank(w http.ResponseWriter, req *http.Request, bank string) bool {
+	if !canActFor(utils.CallerOf(req.Context()), bank) {
+		writePaymentError(w, req, errCantActFor(bank))
+		return false
+	}
+	return true
+}
+
+// authorizeOperator tells whether the caller is an operator, and writes why not otherwise.
+func authorizeOperator(w http.ResponseWriter, req *http.Request) bool {
+	if !utils.CallerOf(req.Context()).HasRole(utils.RoleOperator) {
+		utils.WriteError(w, req, http.StatusForbidden, fmt.Errorf("%w: operators only", utils.ErrForbidden))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	suspendAction  = "suspend"
+	activateAction = "activate"
+)
+
+// handleBanks lists registered banks and lets operators register new ones.
+func handleBanks(w http.ResponseWriter, req *http.Request) {
+	var bnk utils.Bank
+
+	switch req.Method {
+	case http.MethodGet:
+		banks, err := dbstore.ListBanks(req.Context())
+		if err != nil {
+			log.Printf("Error listing banks: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(banks)
+
+	case http.MethodPost:
+		if !authorizeOperator(w, req) {
+			return
+		}
+		err := json.NewDecoder(req.Body).Decode(&bnk)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		if err = normalizeBank(&bnk); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+
+		created, err := dbstore.CreateBank(req.Context(), &bnk)
+		writeBank(w, req, created, err, http.StatusCreated)
+
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// handleBankById renames a bank and sets its BIC, routing number and settlement
+// account with PUT /banks/{id}, and suspends or reactivates it with
+// POST /banks/{id}/suspend and POST /banks/{id}/activate.
+func handleBankById(w http.ResponseWriter, req *http.Request) {
+	var bnk utils.Bank
+
+	rest := strings.TrimPrefix(req.URL.Path, bankIdView)
+	rawId, action := path.Split(rest)
+	if rawId == "" {
+		rawId, action = action, ""
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(rawId, "/"), 10, 16)
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("invalid bank id"))
+		return
+	}
+	// Only operators administer banks
+	if !authorizeOperator(w, req) {
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodPut:
+		err = json.NewDecoder(req.Body).Decode(&bnk)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		if err = normalizeBank(&bnk); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+
+		updated, err := dbstore.UpdateBank(req.Context(), uint16(id), &bnk)
+		writeBank(w, req, updated, err, http.StatusOK)
+
+	case action == suspendAction && req.Method == http.MethodPost:
+		updated, err := dbstore.SetBankStatus(req.Context(), uint16(id), utils.BankSuspended)
+		writeBank(w, req, updated, err, http.StatusOK)
+
+	case action == activateAction && req.Method == http.MethodPost:
+		updated, err := dbstore.SetBankStatus(req.Context(), uint16(id), utils.BankActive)
+		writeBank(w, req, updated, err, http.StatusOK)
+
+	case action == "" || action == suspendAction || action == activateAction:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
+	}
+}
+
+// normalizeBank checks the fields of a bank being registered or changed, and puts
+// them in the form they're stored in. Only the name is required.
+func normalizeBank(bnk *utils.Bank) (err error) {
+
+	bnk.Name = strings.TrimSpace(bnk.Name)
+	if bnk.Name == "" {
+		return errors.New("bank name can't be empty")
+	}
+	if bnk.BIC != "" {
+		if bnk.BIC, err = utils.NormalizeBIC(bnk.BIC); err != nil {
+			return err
+		}
+	}
+	if bnk.Routing != "" {
+		if err = utils.CheckRouting(bnk.Routing); err != nil {
+			return err
+		}
+	}
+	if bnk.SettlementAccount != "" {
+		if bnk.SettlementAccount, err = utils.NormalizeSettlementAccount(bnk.SettlementAccount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBank writes the outcome of a change to the registry,
+// refreshing the local copy of the registry on success.
+func writeBank(w http.ResponseWriter, req *http.Request, bnk *utils.Bank, err error, status int) {
+
+	switch err {
+	case nil:
+	case dbstore.ErrBankNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case dbstore.ErrBankExists:
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error updating bank registry: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	if err = registry.Refresh(req.Context()); err != nil {
+		log.Printf("Error refreshing bank registry: %s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(bnk)
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/receiver/src/rules"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	maxBatchSize  = 1000
+	maxBatchBytes = 1 << 20
+)
+
+var errBatchTooLarge = fmt.Errorf("batches can't hold more than %d payments", maxBatchSize)
+
+// handleBatchPayments stores a batch of payments, sent either as a json array
+// or as newline-delimited json. Invalid and duplicate payments are reported
+// in the response, while the rest are stored and sent to the cache as a single update.
+func handleBatchPayments(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	payments, err := decodeBatch(w, req)
+	if err == errBatchTooLarge {
+		utils.WriteError(w, req, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error decoding batch: %s", err)
+		utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+	if len(payments) == 0 {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("empty batch"))
+		return
+	}
+
+	result, _, err := storeBatch(req, payments, nil)
+	if err != nil {
+		log.Printf("Error with database: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// storeBatch validates payments and stores the valid ones as a single batch.
+// It reports the outcome of each payment in order, together with the error
+// that rejected it, if any. Rules and limits rejections are reported by code.
+// Keys, if given, are the payments' idempotency keys, in order.
+func storeBatch(req *http.Request, payments []*utils.Payment, keys []string) (*utils.BatchResult, []error, error) {
+	var err error
+
+	result := &utils.BatchResult{
+		BatchId: utils.NewId(),
+		Results: make([]utils.BatchItemResult, len(payments)),
+	}
+	errs := make([]error, len(payments))
+
+	// Validate payments, keeping track of the valid ones' positions
+	// and of what they reserved under the velocity limits
+	valid := make([]*utils.Payment, 0, len(payments))
+	positions := make([]int, 0, len(payments))
+	var validKeys []string
+	reservations := make([]*limits.Reservation, 0, len(payments))
+	var limErr *limits.LimitError
+	for i, paymnt := range payments {
+		var res *limits.Reservation
+		result.Results[i].Index = i
+
+		paymnt.DefaultCurrency()
+		err = paymnt.IsValidPayment()
+		if err == nil {
+			err = registry.CheckPayment(paymnt)
+		}
+		if err == nil && !canActFor(utils.CallerOf(req.Context()), paymnt.Sender.Name) {
+			// Banks can only originate payments they send
+			err = fmt.Errorf("%w: can't act for bank %q", utils.ErrForbidden, paymnt.Sender.Name)
+		}
+		if err != nil {
+			errs[i] = err
+			result.Results[i].Error = err.Error()
+			continue
+		}
+
+		dec := rules.Evaluate(req.Context(), paymnt)
+		if dec.Verdict == rules.Deny {
+			result.Results[i].Code = rules.Code
+			result.Results[i].Error = dec.Reason()
+			continue
+		}
+
+		res, err = limits.Reserve(paymnt)
+		if errors.As(err, &limErr) {
+			result.Results[i].Code = limits.Code
+		}
+		if err != nil {
+			errs[i] = err
+			result.Results[i].Error = err.Error()
+			continue
+		}
+
+		paymnt.Id = utils.NewId()
+		paymnt.Reverses = ""
+		paymnt.Mandate = ""
+		paymnt.Status, paymnt.Reason = statusOf(dec)
+		valid = append(valid, paymnt)
+		positions = append(positions, i)
+		if keys != nil {
+			validKeys = append(validKeys, keys[i])
+		}
+		reservations = append(reservations, res)
+	}
+
+	// Insert valid payments
+	if len(valid) > 0 {
+		insertErrs, err := dbstore.InsertBatch(req.Context(), result.BatchId, valid, validKeys)
+		if err != nil {
+			for _, res := range reservations {
+				limits.Release(res)
+			}
+			return nil, nil, err
+		}
+
+		var accErr *dbstore.AccountError
+		inserted := uint64(0)
+		for j, err := range insertErrs {
+			if errors.As(err, &accErr) {
+				result.Results[positions[j]].Code = accErr.Code
+			}
+			if err != nil {
+				limits.Release(reservations[j])
+				errs[positions[j]] = err
+				result.Results[positions[j]].Error = err.Error()
+				continue
+			}
+			rules.Record(valid[j])
+			result.Results[positions[j]].Id = valid[j].Id
+			result.Results[positions[j]].Status = valid[j].Status
+			if valid[j].Status == utils.PaymentValidated {
+				inserted++
+			}
+		}
+
+		if inserted > 0 {
+			relay.Notify()
+			atomic.AddUint64(&counter, inserted)
+		}
+	}
+
+	return result, errs, nil
+}
+
+// decodeBatch reads the payments in the request body.
+func decodeBatch(w http.ResponseWriter, req *http.Request) ([]*utils.Payment, error) {
+	var payments []*utils.Payment
+
+	body := http.MaxBytesReader(w, req.Body, maxBatchBytes)
+	dec := json.NewDecoder(body)
+
+	// A json array
+	if !strings.Contains(req.Header.Get("Content-Type"), ndjsonType) {
+		err := dec.Decode(&payments)
+		if err != nil {
+			return nil, err
+		}
+		if len(payments) > maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		return payments, nil
+	}
+
+	// One payment per line
+	for {
+		paymnt := &utils.Payment{}
+		err := dec.Decode(paymnt)
+		if errors.Is(err, io.EOF) {
+			return payments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(payments) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		payments = append(payments, paymnt)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/sekerez/polka/polkapb"
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+// maxStreamPayments is how many payments a stream stores before it's closed,
+// which bounds the size of its summary.
+const maxStreamPayments = 10000
+
+// rpcCodes are the gRPC status codes of the HTTP statuses payments are rejected with.
+var rpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+}
+
+// paymentsServer serves payments over gRPC, with the same checks as over HTTP.
+type paymentsServer struct {
+	polkapb.UnimplementedPaymentsServer
+}
+
+// Submit stores a payment.
+func (ps *paymentsServer) Submit(ctx context.Context, req *polkapb.SubmitRequest) (*polkapb.SubmitResponse, error) {
+	resp, err := submitMessage(ctx, req)
+	if err != nil {
+		return nil, polkapb.RejectionError(rpcRejection(err))
+	}
+	return resp, nil
+}
+
+// SubmitStream stores the payments sent on the stream one by one, and answers
+// with the outcome of each once the client closes the stream, or once
+// maxStreamPayments were sent.
+func (ps *paymentsServer) SubmitStream(stream polkapb.Payments_SubmitStreamServer) error {
+	summary := &polkapb.SubmitSummary{}
+
+	for len(summary.Results) < maxStreamPayments {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &polkapb.SubmitResult{}
+		if result.Response, err = submitMessage(stream.Context(), req); err != nil {
+			result.Rejection = rpcRejection(err)
+			summary.Rejected++
+		} else {
+			summary.Stored++
+		}
+		summary.Results = append(summary.Results, result)
+	}
+
+	return stream.SendAndClose(summary)
+}
+
+// Get returns a payment.
+func (ps *paymentsServer) Get(ctx context.Context, req *polkapb.GetPaymentRequest) (*polkapb.Payment, error) {
+	var paymnt utils.Payment
+
+	if !utils.IsValidId(req.GetId()) {
+		return nil, polkapb.RejectionError(rpcRejection(invalidId(req.GetId())))
+	}
+	if err := getPayment(ctx, utils.CallerOf(ctx), req.GetId(), &paymnt); err != nil {
+		return nil, polkapb.RejectionError(rpcRejection(err))
+	}
+	return polkapb.FromPayment(&paymnt), nil
+}
+
+// Search returns a page of the payments matching the request.
+func (ps *paymentsServer) Search(ctx context.Context, req *polkapb.SearchRequest) (*polkapb.SearchResponse, error) {
+
+	filter := &dbstore.PaymentFilter{
+		SenderBank:   req.GetSenderBank(),
+		ReceiverBank: req.GetReceiverBank(),
+		Currency:     req.GetCurrency(),
+		Status:       req.GetStatus(),
+		Mandate:      req.GetMandate(),
+		Cursor:       req.GetCursor(),
+		Limit:        int(req.GetLimit()),
+	}
+	if req.SenderAccount != nil {
+		account := int(req.GetSenderAccount())
+		filter.SenderAccount = &account
+	}
+	if req.ReceiverAccount != nil {
+		account := int(req.GetReceiverAccount())
+		filter.ReceiverAccount = &account
+	}
+	if req.MinAmount != nil {
+		amount := utils.Money(req.GetMinAmount())
+		filter.MinAmount = &amount
+	}
+	if req.MaxAmount != nil {
+		amount := utils.Money(req.GetMaxAmount())
+		filter.MaxAmount = &amount
+	}
+	if req.From != nil {
+		filter.From = req.From.AsTime()
+	}
+	if req.To != nil {
+		filter.To = req.To.AsTime()
+	}
+
+	err := checkPaymentFilter(filter)
+	if err != nil {
+		return nil, polkapb.RejectionError(rpcRejection(&paymentError{status: http.StatusBadRequest, err: err}))
+	}
+	if filter.Bank, err = readScope(utils.CallerOf(ctx)); err != nil {
+		return nil, polkapb.RejectionError(rpcRejection(err))
+	}
+	payments, cursor, err := dbstore.SearchPayments(ctx, filter)
+	if err == dbstore.ErrBadCursor {
+		return nil, polkapb.RejectionError(rpcRejection(&paymentError{status: http.StatusBadRequest, err: err}))
+	}
+	if err != nil {
+		log.Printf("Error searching payments: %s", err)
+		return nil, polkapb.RejectionError(rpcRejection(err))
+	}
+
+	resp := &polkapb.SearchResponse{
+		Payments:   make([]*polkapb.Payment, len(payments)),
+		NextCursor: cursor,
+	}
+	for i := range payments {
+		resp.Payments[i] = polkapb.FromPayment(&payments[i])
+	}
+	return resp, nil
+}
+
+// Reverse reverses a payment.
+func (ps *paymentsServer) Reverse(ctx context.Context, req *polkapb.ReverseRequest) (*polkapb.Payment, error) {
+
+	if !utils.IsValidId(req.GetId()) {
+		return nil, polkapb.RejectionError(rpcRejection(invalidId(req.GetId())))
+	}
+	reversal, err := reversePayment(ctx, utils.CallerOf(ctx), req.GetId())
+	if err != nil {
+		return nil, polkapb.RejectionError(rpcRejection(err))
+	}
+	return polkapb.FromPayment(reversal), nil
+}
+
+// submitMessage stores the payment of a submit request.
+func submitMessage(ctx context.Context, req *polkapb.SubmitRequest) (*polkapb.SubmitResponse, error) {
+
+	if req.GetPayment() == nil {
+		return nil, &paymentError{status: http.StatusBadRequest, err: errors.New("payment required")}
+	}
+	paymnt := polkapb.ToPayment(req.GetPayment())
+
+	replayed, err := submitPayment(ctx, utils.CallerOf(ctx), paymnt, req.GetIdempotencyKey())
+	if err != nil {
+		return nil, err
+	}
+	return &polkapb.SubmitResponse{Payment: polkapb.FromPayment(paymnt), Replayed: replayed}, nil
+}
+
+// invalidId returns the error of a request for a payment with an invalid id.
+func invalidId(id string) error {
+	return &paymentError{status: http.StatusBadRequest, err: fmt.Errorf("invalid payment id: %q", id)}
+}
+
+// rpcRejection returns the rejection of a *paymentError, or of any other error as an internal one.
+func rpcRejection(err error) *polkapb.Rejection {
+	var pe *paymentError
+
+	if !errors.As(err, &pe) {
+		return &polkapb.Rejection{Status: uint32(codes.Internal), Message: err.Error()}
+	}
+	code, exists := rpcCodes[pe.status]
+	if !exists {
+		code = codes.Unknown
+	}
+	return &polkapb.Rejection{Status: uint32(code), Code: pe.code, Message: pe.Error()}
+}
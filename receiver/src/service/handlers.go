@@ -1,39 +1,34 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/sekerez/polka/receiver/src/client"
 	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/receiver/src/rules"
 	"github.com/sekerez/polka/utils"
 )
 
-var validBanks = []string{
-	"JP Morgan Chase",
-	"Bank of America",
-	"Wells Fargo",
-	"Citigroup",
-	"U.S. Bancorp",
-	"Truist Financial",
-	"PNC Financial Services Group",
-	"TD Group US",
-	"Bank of New York Mellon",
-	"Capital One Financial",
-}
-
-// bankBalance stores information processed by the cache.
-type bankBalance struct {
-	Sender   utils.BankInfo
-	Receiver utils.BankInfo
-	Amount   int
-}
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	reverseAction     = "reverse"
+	approveAction     = "approve"
+	declineAction     = "decline"
+	eventsAction      = "events"
+)
 
 var counter uint64
 
@@ -63,8 +58,8 @@ func handlePayment(w http.ResponseWriter, req *http.Request) {
 	}
 	defer cancel()
 
-	// Read body if post or delete request
-	if req.Method == http.MethodPost || req.Method == http.MethodDelete {
+	// Read body if post request
+	if req.Method == http.MethodPost {
 		// Read the body
 		// body, err := ioutil.ReadAll(req.Body)
 		// if err != nil {
@@ -76,12 +71,7 @@ func handlePayment(w http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			log.Printf("Error decoding json: %s", err.Error())
 			// log.Printf("Request body: %s", body)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if err = paymnt.IsValidPayment(); err != nil {
-			log.Printf("%s", err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
 			return
 		}
 	}
@@ -89,77 +79,370 @@ func handlePayment(w http.ResponseWriter, req *http.Request) {
 	// Multiplex according to method
 	switch req.Method {
 	case http.MethodPost:
-		// Concurrently send request over to cache
-		cacheErr := make(chan error)
-		go sendTransactionToCache(&paymnt, paymnt.Amount, cacheErr)
-
-		// innerStart := time.Now()
-		// Insert transaction data into db
-		err = dbstore.InsertPayment(ctx, &paymnt)
+		replayed, err := submitPayment(ctx, utils.CallerOf(req.Context()), &paymnt, req.Header.Get(idempotencyHeader))
 		if err != nil {
-			log.Printf("Error with database: %s", err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writePaymentError(w, req, err)
 			return
 		}
-		// innerEnd := time.Now()
-		// log.Printf("insert duration %s", innerEnd.Sub(innerStart))
 
-		// check error from cache
-		err = <-cacheErr
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Printf("Error from cache: %s", err)
+		// A replay gets the original response
+		if replayed {
+			w.Header().Set(replayedHeader, "true")
 		}
-		atomic.AddUint64(&counter, 1)
+		writePayment(w, &paymnt, createdStatus(&paymnt))
 
 	case http.MethodGet:
-		// Scan table row in current transaction struct
-		err = dbstore.GetPayment(ctx, &paymnt)
-		if err != nil {
-			log.Printf("Error: %s", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		fmt.Fprintf(w, "%+v", paymnt)
-
-		// Encode transaction data and send back to client
-	// end := time.Now()
-	// duration := end.Sub(start)
-	// log.Printf("Handling request took %s", duration)
-	case http.MethodPut:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("payment id required, use GET /payment/{id}"))
 	case http.MethodDelete:
+		// Payments are never deleted, they are reversed
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, errors.New("payments can't be deleted, use POST /payment/{id}/reverse"))
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
 
-		// Concurrently update cache
-		cacheErr := make(chan error)
-		// Send the negative of the amount
-		go sendTransactionToCache(&paymnt, -paymnt.Amount, cacheErr)
+// submitPayment stores a new payment originated by the caller, whether over HTTP
+// or gRPC, and tells whether the idempotency key was used before, in which case
+// the payment is set to the original. It returns a *paymentError for payments
+// that can't be stored.
+func submitPayment(ctx context.Context, caller *utils.APIKey, paymnt *utils.Payment, key string) (bool, error) {
+	var accErr *dbstore.AccountError
 
-		// Update database
-		err = dbstore.DeletePayment(ctx, &paymnt)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	// Banks can only originate payments they send
+	if !canActFor(caller, paymnt.Sender.Name) {
+		return false, errCantActFor(paymnt.Sender.Name)
+	}
+	// Payments can be made to an alias instead of a bank and account
+	if err := resolveReceiver(ctx, paymnt); err != nil {
+		return false, err
+	}
+
+	// Keys of scheduled payments and mandates are only assigned by the receiver
+	if err := dbstore.CheckKey(key); err != nil {
+		return false, &paymentError{status: http.StatusBadRequest, err: err}
+	}
+
+	// Replays get the stored payment without being screened again, since the
+	// rules and limits could reject what was already accepted
+	paymnt.DefaultCurrency()
+	replayed, err := dbstore.FindIdempotentPayment(ctx, paymnt, key)
+	if err == dbstore.ErrKeyReused {
+		return false, &paymentError{status: http.StatusUnprocessableEntity, err: err}
+	}
+	if err != nil {
+		log.Printf("Error with database: %s", err.Error())
+		return false, err
+	}
+	if replayed {
+		return true, nil
+	}
+
+	dec, res, err := screenPayment(ctx, paymnt)
+	if err != nil {
+		return false, err
+	}
+
+	// The id, status and any link to a reversed payment or a mandate are always assigned by the receiver
+	paymnt.Id = utils.NewId()
+	paymnt.Reverses = ""
+	paymnt.Mandate = ""
+	paymnt.Status, paymnt.Reason = statusOf(dec)
+
+	// Insert transaction data into db, together with the cache update
+	// that the relay delivers. Replays don't queue any update.
+	replayed, err = dbstore.InsertPayment(ctx, paymnt, key)
+	if err != nil || replayed {
+		// Only newly stored payments count towards limits
+		limits.Release(res)
+	}
+	if err == dbstore.ErrKeyReused {
+		log.Printf("Error with idempotency key: %s", err.Error())
+		return false, &paymentError{status: http.StatusUnprocessableEntity, err: err}
+	}
+	if errors.As(err, &accErr) {
+		return false, &paymentError{status: http.StatusUnprocessableEntity, code: accErr.Code, err: err}
+	}
+	if err != nil {
+		log.Printf("Error with database: %s", err.Error())
+		return false, err
+	}
+
+	if !replayed {
+		rules.Record(paymnt)
+	}
+	if !replayed && paymnt.Status == utils.PaymentValidated {
+		relay.Notify()
+		atomic.AddUint64(&counter, 1)
+	}
+
+	return replayed, nil
+}
+
+// screenPayment validates a new payment and runs it through the registry, the rules
+// and the limits. It returns the rules' decision and the payment's reservation,
+// or a *paymentError telling why the payment can't be stored.
+func screenPayment(ctx context.Context, paymnt *utils.Payment) (*rules.Decision, *limits.Reservation, error) {
+	var limErr *limits.LimitError
+
+	paymnt.DefaultCurrency()
+	if err := paymnt.IsValidPayment(); err != nil {
+		log.Printf("%s", err.Error())
+		return nil, nil, &paymentError{status: http.StatusBadRequest, err: err}
+	}
+	if err := registry.CheckPayment(paymnt); err != nil {
+		log.Printf("%s", err.Error())
+		return nil, nil, &paymentError{status: http.StatusUnprocessableEntity, err: err}
+	}
+	dec := rules.Evaluate(ctx, paymnt)
+	if dec.Verdict == rules.Deny {
+		return nil, nil, &paymentError{status: http.StatusUnprocessableEntity, code: rules.Code, err: errors.New(dec.Reason())}
+	}
+	res, err := limits.Reserve(paymnt)
+	if errors.As(err, &limErr) {
+		return nil, nil, &paymentError{status: http.StatusTooManyRequests, code: limits.Code, err: err}
+	}
+	if err != nil {
+		return nil, nil, &paymentError{status: http.StatusUnprocessableEntity, err: err}
+	}
+
+	return dec, res, nil
+}
+
+// statusOf returns the status and reason of a payment given the rules' decision.
+func statusOf(dec *rules.Decision) (string, string) {
+	if dec.Verdict == rules.Review {
+		return utils.PaymentReceived, dec.Reason()
+	}
+	return utils.PaymentValidated, ""
+}
+
+// createdStatus tells apart payments that were validated from those held for review.
+func createdStatus(paymnt *utils.Payment) int {
+	if paymnt.Status == utils.PaymentReceived {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+// handlePaymentById handles requests to /payment/{id}, /payment/{id}/events,
+// /payment/{id}/reverse and, for payments held for review, /payment/{id}/approve
+// and /payment/{id}/decline.
+func handlePaymentById(w http.ResponseWriter, req *http.Request) {
+	var paymnt utils.Payment
+
+	id := strings.TrimPrefix(req.URL.Path, paymentIdView)
+	id, action := path.Split(id)
+	if id == "" {
+		// There's no action, only an id
+		id, action = action, ""
+	} else {
+		id = strings.TrimSuffix(id, "/")
+	}
+
+	if !utils.IsValidId(id) {
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid payment id: %q", id))
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		if err := getPayment(req.Context(), utils.CallerOf(req.Context()), id, &paymnt); err != nil {
+			writePaymentError(w, req, err)
 			return
 		}
+		writePayment(w, &paymnt, http.StatusOK)
 
-		// check error from cache
-		err = <-cacheErr
-		if err != nil {
-			log.Printf("Error from cache: %s", err)
+	case action == eventsAction && req.Method == http.MethodGet:
+		handlePaymentEvents(w, req, id)
+
+	case action == reverseAction && req.Method == http.MethodPost:
+		handleReversal(w, req, id)
+
+	case (action == approveAction || action == declineAction) && req.Method == http.MethodPost:
+		if authorizeOperator(w, req) {
+			handleReview(w, req, id, action == approveAction)
 		}
 
+	case action == "" || action == eventsAction || action == reverseAction || action == approveAction || action == declineAction:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
 	}
 }
 
-func sendTransactionToCache(paymnt *utils.Payment, amount int, cacheErr chan<- error) {
-	payloadBuffer := new(bytes.Buffer)
-	currentBalance := &bankBalance{
-		Sender:   paymnt.Sender,
-		Receiver: paymnt.Receiver,
-		Amount:   amount,
+// getPayment reads the payment with the given id for the caller, or returns a
+// *paymentError if there's none or the caller can't read it.
+func getPayment(ctx context.Context, caller *utils.APIKey, id string, paymnt *utils.Payment) error {
+	err := dbstore.GetPayment(ctx, id, paymnt)
+	if err == dbstore.ErrNotFound {
+		return &paymentError{status: http.StatusNotFound, err: err}
+	}
+	if err != nil {
+		log.Printf("Error: %s", err)
+		return err
 	}
-	json.NewEncoder(payloadBuffer).Encode(currentBalance)
-	cacheErr <- client.SendTransactionUpdate(payloadBuffer)
+	if !canRead(caller, paymnt) {
+		return errCantRead
+	}
+	return nil
+}
+
+// handlePaymentEvents writes the statuses a payment moved to, and when, in order.
+func handlePaymentEvents(w http.ResponseWriter, req *http.Request, id string) {
+	var paymnt utils.Payment
+
+	if err := getPayment(req.Context(), utils.CallerOf(req.Context()), id, &paymnt); err != nil {
+		writePaymentError(w, req, err)
+		return
+	}
+
+	events, err := dbstore.ListPaymentEvents(req.Context(), id)
+	if err != nil {
+		log.Printf("Error listing events of payment %s: %s", id, err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// handleReversal records a payment compensating the one with the given id,
+// which is sent by the original's receiving bank.
+func handleReversal(w http.ResponseWriter, req *http.Request, id string) {
+
+	reversal, err := reversePayment(req.Context(), utils.CallerOf(req.Context()), id)
+	if err != nil {
+		writePaymentError(w, req, err)
+		return
+	}
+	writePayment(w, reversal, http.StatusCreated)
+}
+
+// reversePayment stores the reversal of the payment with the given id for the
+// caller, or returns a *paymentError telling why it can't be reversed.
+func reversePayment(ctx context.Context, caller *utils.APIKey, id string) (*utils.Payment, error) {
+	var (
+		accErr   *dbstore.AccountError
+		original utils.Payment
+	)
+
+	err := dbstore.GetPayment(ctx, id, &original)
+	switch {
+	case err == dbstore.ErrNotFound:
+		return nil, &paymentError{status: http.StatusNotFound, err: err}
+	case err != nil:
+		log.Printf("Error reading payment %s: %s", id, err)
+		return nil, err
+	case !canActFor(caller, original.Receiver.Name):
+		return nil, errCantActFor(original.Receiver.Name)
+	}
+
+	reversal, err := dbstore.ReversePayment(ctx, id, utils.NewId())
+	if errors.As(err, &accErr) {
+		return nil, &paymentError{status: http.StatusUnprocessableEntity, code: accErr.Code, err: err}
+	}
+	switch err {
+	case nil:
+	case dbstore.ErrNotFound:
+		return nil, &paymentError{status: http.StatusNotFound, err: err}
+	case dbstore.ErrAlreadyReversed, dbstore.ErrIsReversal, dbstore.ErrNotApplied:
+		return nil, &paymentError{status: http.StatusConflict, err: err}
+	default:
+		log.Printf("Error reversing payment %s: %s", id, err)
+		return nil, err
+	}
+
+	relay.Notify()
+	atomic.AddUint64(&counter, 1)
+
+	return reversal, nil
+}
+
+// handleReview approves or declines a payment held for review.
+func handleReview(w http.ResponseWriter, req *http.Request, id string, approve bool) {
+	var accErr *dbstore.AccountError
+
+	paymnt, err := dbstore.ReviewPayment(req.Context(), id, approve)
+	if errors.As(err, &accErr) {
+		writeAccountError(w, req, accErr)
+		return
+	}
+	switch err {
+	case nil:
+	case dbstore.ErrNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case dbstore.ErrNotPending:
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error reviewing payment %s: %s", id, err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	if approve {
+		relay.Notify()
+		atomic.AddUint64(&counter, 1)
+	}
+
+	writePayment(w, paymnt, http.StatusOK)
+}
+
+// paymentError is why a payment couldn't be made, read or changed, with the
+// HTTP status it's answered with and, for errors clients tell apart, a code.
+type paymentError struct {
+	status int
+	code   string
+	err    error
+}
+
+func (pe *paymentError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe *paymentError) Unwrap() error {
+	return pe.err
+}
+
+// writePaymentError writes a *paymentError, or any other error as an internal
+// one, whose message isn't answered since it may come from the database.
+func writePaymentError(w http.ResponseWriter, req *http.Request, err error) {
+	var pe *paymentError
+
+	if !errors.As(err, &pe) {
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+	code := pe.code
+	if code == "" {
+		code = utils.CodeOf(pe.status)
+	}
+	utils.WriteCodedError(w, req, pe.status, code, pe.Error(), detailsOf(pe.err))
+}
+
+// writeAccountError writes why an account can't take part in a payment.
+func writeAccountError(w http.ResponseWriter, req *http.Request, accErr *dbstore.AccountError) {
+	utils.WriteCodedError(w, req, http.StatusUnprocessableEntity, accErr.Code, accErr.Error(), detailsOf(accErr))
+}
+
+// detailsOf returns the bank and account of errors about an account, or nil for others.
+func detailsOf(err error) map[string]string {
+	var accErr *dbstore.AccountError
+
+	if !errors.As(err, &accErr) {
+		return nil
+	}
+	return map[string]string{"bank": accErr.Bank, "account": strconv.Itoa(accErr.Account)}
+}
+
+// writePayment encodes the payment as the json body of the response.
+func writePayment(w http.ResponseWriter, paymnt *utils.Payment, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(paymnt)
 }
 
 func PrintProcessedTransactions() {
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/utils"
+)
+
+const executionsAction = "executions"
+
+// mandateActions maps the actions of /mandates/{id}/{action}
+// to the status the mandate moves to.
+var mandateActions = map[string]string{
+	"pause":      utils.MandatePaused,
+	"resume":     utils.MandateActive,
+	cancelAction: utils.MandateCancelled,
+}
+
+// handleMandates sets up mandates with POST /mandates, and lists those
+// of a bank with GET /mandates?bank=...&account=...&status=...
+func handleMandates(w http.ResponseWriter, req *http.Request) {
+	var m utils.Mandate
+
+	switch req.Method {
+	case http.MethodGet:
+		listMandates(w, req)
+
+	case http.MethodPost:
+		err := json.NewDecoder(req.Body).Decode(&m)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+
+		if !authorizeBank(w, req, m.Sender.Name) {
+			return
+		}
+
+		// Mandates start right away unless they're given a start time
+		if m.StartAt.IsZero() {
+			m.StartAt = time.Now()
+		}
+		if m.StartAt.Before(time.Now().Add(-time.Minute)) {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("start time can't be in the past"))
+			return
+		}
+		if err = m.IsValidSchedule(); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+
+		// Mandates are checked like payments sent right away, and again on each execution
+		paymnt := m.Payment(m.StartAt)
+		paymnt.DefaultCurrency()
+		if err = paymnt.IsValidPayment(); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		if err = registry.CheckPayment(paymnt); err != nil {
+			utils.WriteError(w, req, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		// The id, status and progress are always assigned by the receiver
+		m.Id = utils.NewId()
+		m.Currency = paymnt.Currency
+		m.StartAt = m.StartAt.UTC().Truncate(time.Second)
+		m.Status = utils.MandateActive
+		m.Count = 0
+		if m.NextAt = m.First(); m.NextAt.IsZero() {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("mandate would never execute"))
+			return
+		}
+
+		if err = dbstore.CreateMandate(req.Context(), &m); err != nil {
+			log.Printf("Error creating mandate: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		writeMandate(w, req, &m, nil, http.StatusCreated)
+
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// listMandates writes the mandates of a bank, in order of creation.
+func listMandates(w http.ResponseWriter, req *http.Request) {
+	var (
+		account *int
+		limit   int
+		err     error
+	)
+
+	query := req.URL.Query()
+	bank := query.Get("bank")
+	if bank == "" {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("bank required"))
+		return
+	}
+	if raw := query.Get("account"); raw != "" {
+		val, err := strconv.Atoi(raw)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid account: %q", raw))
+			return
+		}
+		account = &val
+	}
+	status := query.Get("status")
+	switch status {
+	case "", utils.MandateActive, utils.MandatePaused, utils.MandateEnded, utils.MandateCancelled:
+	default:
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid status: %q", status))
+		return
+	}
+	if limit, err = parseLimit(query.Get("limit")); err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+
+	mandates, err := dbstore.ListMandates(req.Context(), bank, account, status, limit)
+	if err != nil {
+		log.Printf("Error listing mandates: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mandates)
+}
+
+// handleMandateById returns a mandate with GET /mandates/{id} and its executions
+// with GET /mandates/{id}/executions, and pauses, resumes or cancels it with
+// POST /mandates/{id}/pause, /mandates/{id}/resume and /mandates/{id}/cancel.
+func handleMandateById(w http.ResponseWriter, req *http.Request) {
+
+	id, action := path.Split(strings.TrimPrefix(req.URL.Path, mandateIdView))
+	if id == "" {
+		// There's no action, only an id
+		id, action = action, ""
+	} else {
+		id = strings.TrimSuffix(id, "/")
+	}
+
+	if !utils.IsValidId(id) {
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid mandate id: %q", id))
+		return
+	}
+
+	status, isChange := mandateActions[action]
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		m, err := dbstore.GetMandate(req.Context(), id)
+		writeMandate(w, req, m, err, http.StatusOK)
+
+	case action == executionsAction && req.Method == http.MethodGet:
+		limit, err := parseLimit(req.URL.Query().Get("limit"))
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		executions, err := dbstore.ListExecutions(req.Context(), id, limit)
+		if err != nil {
+			log.Printf("Error listing executions of mandate %s: %s", id, err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(executions)
+
+	case isChange && req.Method == http.MethodPost:
+		m, err := dbstore.GetMandate(req.Context(), id)
+		if err == nil && !authorizeBank(w, req, m.Sender.Name) {
+			return
+		}
+		m, err = dbstore.SetMandateStatus(req.Context(), id, status, time.Now())
+		writeMandate(w, req, m, err, http.StatusOK)
+
+	case action == "" || action == executionsAction || isChange:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
+	}
+}
+
+// parseLimit reads an optional page size, which is zero if it's not given.
+func parseLimit(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 || limit > dbstore.MaxSearchLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", dbstore.MaxSearchLimit)
+	}
+	return limit, nil
+}
+
+// writeMandate writes a mandate, or the error that occurred fetching or changing it.
+func writeMandate(w http.ResponseWriter, req *http.Request, m *utils.Mandate, err error, status int) {
+
+	switch {
+	case err == nil:
+	case err == dbstore.ErrMandateNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case errors.Is(err, dbstore.ErrMandateTransition):
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error with mandates: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/iso20022"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/rules"
+	"github.com/sekerez/polka/utils"
+)
+
+const xmlType = "application/xml"
+
+// handlePacs008 stores the transactions of a pacs.008 credit transfer, sent as
+// XML, as a batch of payments. It answers with a pacs.002 status report, which
+// accepts or rejects each transaction, or the whole message if its group
+// header doesn't match its transactions.
+func handlePacs008(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBatchBytes))
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+	msg, err := iso20022.ParseCreditTransfer(body)
+	if errors.Is(err, iso20022.ErrNotPacs008) {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		// Decoder errors describe the body's internals, and are only logged
+		log.Printf("Error parsing pacs.008 message: %s", err)
+		utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+		return
+	}
+
+	report := iso20022.NewStatusReport(msg, utils.NewId(), time.Now())
+
+	var rj *iso20022.Rejection
+	if err = msg.Check(maxBatchSize); errors.As(err, &rj) {
+		report.RejectGroup(rj)
+		writeStatusReport(w, req, report)
+		return
+	}
+	at, _ := msg.CreationTime() // Checked with the message
+
+	// Map the transactions onto payments, keeping track of their positions
+	// and of the keys they're deduplicated with
+	payments := make([]*utils.Payment, 0, len(msg.Transactions))
+	positions := make([]int, 0, len(msg.Transactions))
+	keys := make([]string, 0, len(msg.Transactions))
+	for i := range msg.Transactions {
+		paymnt, err := msg.Transactions[i].Payment(at, registry.BankByBIC)
+		if err == nil {
+			err = screenTransaction(paymnt)
+		}
+		if errors.As(err, &rj) {
+			report.Reject(i, rj)
+			continue
+		}
+		payments = append(payments, paymnt)
+		positions = append(positions, i)
+		keys = append(keys, msg.Transactions[i].Key(msg.Header.MessageId))
+	}
+
+	if len(payments) > 0 {
+		result, errs, err := storeBatch(req, payments, keys)
+		if err != nil {
+			log.Printf("Error with database: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+
+		for j, item := range result.Results {
+			switch {
+			case item.Error != "":
+				report.Reject(positions[j], rejectionOf(&item, errs[j]))
+			case item.Status == utils.PaymentReceived:
+				report.Accept(positions[j], iso20022.StatusPending, item.Id)
+			default:
+				report.Accept(positions[j], iso20022.StatusAccepted, item.Id)
+			}
+		}
+	}
+
+	writeStatusReport(w, req, report)
+}
+
+// screenTransaction returns a *iso20022.Rejection for payments whose currency
+// or amount aren't allowed, which can be told apart by reason code.
+func screenTransaction(paymnt *utils.Payment) error {
+	if err := registry.CheckCurrency(paymnt.Currency); err != nil {
+		return &iso20022.Rejection{Reason: iso20022.ReasonNotAllowedCurrency, Info: err.Error()}
+	}
+	if err := paymnt.IsValidPayment(); err != nil {
+		return &iso20022.Rejection{Reason: iso20022.ReasonNotAllowedAmount, Info: err.Error()}
+	}
+	return nil
+}
+
+// rejectionOf returns the rejection of a payment of a batch, given its result and error.
+func rejectionOf(item *utils.BatchItemResult, err error) *iso20022.Rejection {
+	reason := iso20022.ReasonNarrative
+
+	switch {
+	case item.Code == dbstore.CodeAccountUnknown:
+		reason = iso20022.ReasonIncorrectAccount
+	case item.Code == dbstore.CodeAccountClosed:
+		reason = iso20022.ReasonClosedAccount
+	case item.Code == dbstore.CodeAccountFrozen:
+		reason = iso20022.ReasonBlockedAccount
+	case item.Code == limits.Code:
+		reason = iso20022.ReasonLimitExceeded
+	case item.Code == rules.Code:
+		reason = iso20022.ReasonRegulatory
+	case errors.Is(err, dbstore.ErrDuplicate):
+		reason = iso20022.ReasonDuplicate
+	case errors.Is(err, utils.ErrForbidden):
+		reason = iso20022.ReasonForbidden
+	}
+
+	return &iso20022.Rejection{Reason: reason, Info: item.Error}
+}
+
+// writeStatusReport writes a pacs.002 status report as the response.
+func writeStatusReport(w http.ResponseWriter, req *http.Request, report *iso20022.StatusReport) {
+
+	body, err := report.Marshal()
+	if err != nil {
+		log.Printf("Error encoding status report: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", xmlType)
+	w.Write(body)
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/limits"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/receiver/src/relay"
+	"github.com/sekerez/polka/receiver/src/rules"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	acceptAction     = "accept"
+	requestLifetime  = 7 * 24 * time.Hour
+	keepAliveEvery   = 30 * time.Second
+	eventStreamType  = "text/event-stream"
+	requestEventName = "request"
+)
+
+// handleRequests creates payment requests with POST /requests, and lists them with
+// GET /requests?payer_bank=...&payer_account=...&payee_bank=...&payee_account=...&status=...
+func handleRequests(w http.ResponseWriter, req *http.Request) {
+	var r utils.PaymentRequest
+
+	switch req.Method {
+	case http.MethodGet:
+		filter, err := parseRequestFilter(req.URL.Query())
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		requests, err := dbstore.ListRequests(req.Context(), filter)
+		if err != nil {
+			log.Printf("Error listing payment requests: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+
+	case http.MethodPost:
+		err := json.NewDecoder(req.Body).Decode(&r)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+
+		// Banks can only ask for payments to themselves
+		if !authorizeBank(w, req, r.Payee.Name) {
+			return
+		}
+
+		now := time.Now().UTC()
+		if r.ExpiresAt.IsZero() {
+			r.ExpiresAt = now.Add(requestLifetime)
+		}
+		if !r.ExpiresAt.After(now) {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("expiry time must be in the future"))
+			return
+		}
+		if r.Amount <= 0 {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("requested amount must be positive"))
+			return
+		}
+
+		// Requests are checked like the payments they ask for, and again when accepted
+		paymnt := r.Payment(now)
+		paymnt.DefaultCurrency()
+		if err = paymnt.IsValidPayment(); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		if err = registry.CheckPayment(paymnt); err != nil {
+			utils.WriteError(w, req, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err = checkPayee(req, &r.Payee); err != nil {
+			writeRequest(w, req, nil, err, 0)
+			return
+		}
+
+		// The id and status are always assigned by the receiver
+		r.Id = utils.NewId()
+		r.Currency = paymnt.Currency
+		r.Status = utils.RequestRequested
+		r.PaymentId = ""
+		r.CreatedAt = now
+
+		if err = dbstore.CreateRequest(req.Context(), &r); err != nil {
+			log.Printf("Error creating payment request: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		writeRequest(w, req, &r, nil, http.StatusCreated)
+
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// checkPayee returns an *dbstore.AccountError unless the account asking to be paid is open.
+func checkPayee(req *http.Request, payee *utils.BankInfo) error {
+	acc, err := dbstore.GetAccountByName(req.Context(), payee.Name, payee.Account)
+	if err == dbstore.ErrAccountNotFound {
+		return &dbstore.AccountError{Code: dbstore.CodeAccountUnknown, Bank: payee.Name, Account: payee.Account}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch acc.Status {
+	case utils.AccountFrozen:
+		return &dbstore.AccountError{Code: dbstore.CodeAccountFrozen, Bank: payee.Name, Account: payee.Account}
+	case utils.AccountClosed:
+		return &dbstore.AccountError{Code: dbstore.CodeAccountClosed, Bank: payee.Name, Account: payee.Account}
+	}
+	return nil
+}
+
+// parseRequestFilter reads the filters of a list of payment requests from the query parameters.
+func parseRequestFilter(query url.Values) (*dbstore.RequestFilter, error) {
+	var err error
+
+	filter := &dbstore.RequestFilter{
+		PayerBank: query.Get("payer_bank"),
+		PayeeBank: query.Get("payee_bank"),
+		Status:    query.Get("status"),
+	}
+	if filter.PayerBank == "" && filter.PayeeBank == "" {
+		return nil, errors.New("payer_bank or payee_bank required")
+	}
+
+	switch filter.Status {
+	case "", utils.RequestRequested, utils.RequestAccepted, utils.RequestDeclined, utils.RequestExpired:
+	default:
+		return nil, fmt.Errorf("invalid status: %q", filter.Status)
+	}
+
+	// Parse the accounts
+	accounts := []struct {
+		name string
+		dest **int
+	}{
+		{"payer_account", &filter.PayerAccount},
+		{"payee_account", &filter.PayeeAccount},
+	}
+	for _, param := range accounts {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		val, err := strconv.Atoi(query.Get(param.name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", param.name, query.Get(param.name))
+		}
+		*param.dest = &val
+	}
+
+	if filter.Limit, err = parseLimit(query.Get("limit")); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// handleRequestEvents streams the payment requests waiting for an answer from
+// the payer's bank as server-sent events, with GET /requests/events?payer_bank=...
+// The requests already waiting are sent first, followed by new ones as they're made.
+func handleRequestEvents(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodGet {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseRequestFilter(req.URL.Query())
+	if err != nil || filter.PayerBank == "" {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("payer_bank required"))
+		return
+	}
+	filter.Status = utils.RequestRequested
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteError(w, req, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	// Subscribe before listing, so that no request falls in between
+	ids := watch.subscribe(filter.PayerBank)
+	defer watch.unsubscribe(filter.PayerBank, ids)
+
+	waiting, err := dbstore.ListRequests(req.Context(), filter)
+	if err != nil {
+		log.Printf("Error listing payment requests: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]bool, len(waiting))
+	for i := range waiting {
+		writeRequestEvent(w, &waiting[i])
+		sent[waiting[i].Id] = true
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAliveEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case id := <-ids:
+			if sent[id] {
+				continue
+			}
+			r, err := dbstore.GetRequest(req.Context(), id)
+			if err != nil {
+				log.Printf("Error fetching payment request %s: %s", id, err)
+				continue
+			}
+			if filter.PayerAccount != nil && r.Payer.Account != *filter.PayerAccount {
+				continue
+			}
+			writeRequestEvent(w, r)
+			sent[id] = true
+		}
+		flusher.Flush()
+	}
+}
+
+// writeRequestEvent writes a payment request as a server-sent event.
+func writeRequestEvent(w http.ResponseWriter, r *utils.PaymentRequest) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", r.Id, requestEventName, data)
+}
+
+// handleRequestById returns a payment request with GET /requests/{id}, and lets
+// the payer answer it with POST /requests/{id}/accept and /requests/{id}/decline.
+func handleRequestById(w http.ResponseWriter, req *http.Request) {
+
+	id, action := path.Split(strings.TrimPrefix(req.URL.Path, requestIdView))
+	if id == "" {
+		// There's no action, only an id
+		id, action = action, ""
+	} else {
+		id = strings.TrimSuffix(id, "/")
+	}
+
+	if !utils.IsValidId(id) {
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid payment request id: %q", id))
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		r, err := dbstore.GetRequest(req.Context(), id)
+		writeRequest(w, req, r, err, http.StatusOK)
+
+	case action == acceptAction && req.Method == http.MethodPost:
+		handleAccept(w, req, id)
+
+	case action == declineAction && req.Method == http.MethodPost:
+		r, err := dbstore.GetRequest(req.Context(), id)
+		if err == nil && !authorizeBank(w, req, r.Payer.Name) {
+			return
+		}
+		r, err = dbstore.DeclineRequest(req.Context(), id, time.Now())
+		writeRequest(w, req, r, err, http.StatusOK)
+
+	case action == "" || action == acceptAction || action == declineAction:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
+	}
+}
+
+// handleAccept pays a payment request. The payment goes through the same checks,
+// rules and limits as any other, and is written like the response to POST /payment.
+func handleAccept(w http.ResponseWriter, req *http.Request, id string) {
+
+	r, err := dbstore.GetRequest(req.Context(), id)
+	if err == nil && !authorizeBank(w, req, r.Payer.Name) {
+		return
+	}
+	if err == nil && r.Status != utils.RequestRequested {
+		err = dbstore.ErrNotRequested
+		if r.Status == utils.RequestExpired {
+			err = dbstore.ErrRequestExpired
+		}
+	}
+	if err != nil {
+		writeRequest(w, req, nil, err, 0)
+		return
+	}
+
+	paymnt := r.Payment(time.Now())
+	dec, res, err := screenPayment(req.Context(), paymnt)
+	if err != nil {
+		writePaymentError(w, req, err)
+		return
+	}
+	paymnt.Id = utils.NewId()
+	paymnt.Status, paymnt.Reason = statusOf(dec)
+
+	if _, err = dbstore.AcceptRequest(req.Context(), id, paymnt); err != nil {
+		limits.Release(res)
+		writeRequest(w, req, nil, err, 0)
+		return
+	}
+	rules.Record(paymnt)
+
+	if paymnt.Status == utils.PaymentValidated {
+		relay.Notify()
+		atomic.AddUint64(&counter, 1)
+	}
+
+	writePayment(w, paymnt, createdStatus(paymnt))
+}
+
+// writeRequest writes a payment request, or the error that occurred fetching or changing it.
+func writeRequest(w http.ResponseWriter, req *http.Request, r *utils.PaymentRequest, err error, status int) {
+	var accErr *dbstore.AccountError
+
+	switch {
+	case err == nil:
+	case errors.As(err, &accErr):
+		writeAccountError(w, req, accErr)
+		return
+	case err == dbstore.ErrRequestNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case err == dbstore.ErrNotRequested || err == dbstore.ErrRequestExpired:
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error with payment requests: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/receiver/src/registry"
+	"github.com/sekerez/polka/utils"
+)
+
+const cancelAction = "cancel"
+
+// handleScheduled schedules payments with POST /scheduled, and lists those
+// scheduled by a bank with GET /scheduled?bank=...&account=...&status=...
+func handleScheduled(w http.ResponseWriter, req *http.Request) {
+	var sched utils.ScheduledPayment
+
+	switch req.Method {
+	case http.MethodGet:
+		listScheduled(w, req)
+
+	case http.MethodPost:
+		err := json.NewDecoder(req.Body).Decode(&sched)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		if !authorizeBank(w, req, sched.Sender.Name) {
+			return
+		}
+		if !sched.ExecuteAt.After(time.Now()) {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("execution time must be in the future"))
+			return
+		}
+
+		// Scheduled payments are checked like payments sent right away, and again when executed
+		paymnt := sched.Payment()
+		paymnt.DefaultCurrency()
+		if err = paymnt.IsValidPayment(); err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		if err = registry.CheckPayment(paymnt); err != nil {
+			utils.WriteError(w, req, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		// The id and status are always assigned by the receiver
+		sched.Id = utils.NewId()
+		sched.Currency = paymnt.Currency
+		sched.Status = utils.ScheduleWaiting
+		sched.PaymentId, sched.Reason = "", ""
+
+		if err = dbstore.SchedulePayment(req.Context(), &sched); err != nil {
+			log.Printf("Error scheduling payment: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		writeScheduled(w, req, &sched, nil, http.StatusCreated)
+
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// listScheduled writes the payments scheduled by a bank, in order of execution.
+func listScheduled(w http.ResponseWriter, req *http.Request) {
+	var (
+		account *int
+		limit   int
+		err     error
+	)
+
+	query := req.URL.Query()
+	bank := query.Get("bank")
+	if bank == "" {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("bank required"))
+		return
+	}
+	if raw := query.Get("account"); raw != "" {
+		val, err := strconv.Atoi(raw)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid account: %q", raw))
+			return
+		}
+		account = &val
+	}
+	status := query.Get("status")
+	switch status {
+	case "", utils.ScheduleWaiting, utils.ScheduleExecuted, utils.ScheduleCancelled, utils.ScheduleFailed:
+	default:
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid status: %q", status))
+		return
+	}
+	if limit, err = parseLimit(query.Get("limit")); err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+
+	scheduled, err := dbstore.ListScheduled(req.Context(), bank, account, status, limit)
+	if err != nil {
+		log.Printf("Error listing scheduled payments: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// handleScheduledById returns a scheduled payment with GET /scheduled/{id},
+// and cancels it before its execution with POST /scheduled/{id}/cancel.
+func handleScheduledById(w http.ResponseWriter, req *http.Request) {
+
+	id, action := path.Split(strings.TrimPrefix(req.URL.Path, scheduledIdView))
+	if id == "" {
+		// There's no action, only an id
+		id, action = action, ""
+	} else {
+		id = strings.TrimSuffix(id, "/")
+	}
+
+	if !utils.IsValidId(id) {
+		utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid scheduled payment id: %q", id))
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		sched, err := dbstore.GetScheduled(req.Context(), id)
+		writeScheduled(w, req, sched, err, http.StatusOK)
+
+	case action == cancelAction && req.Method == http.MethodPost:
+		sched, err := dbstore.GetScheduled(req.Context(), id)
+		if err == nil && !authorizeBank(w, req, sched.Sender.Name) {
+			return
+		}
+		sched, err = dbstore.CancelScheduled(req.Context(), id)
+		writeScheduled(w, req, sched, err, http.StatusOK)
+
+	case action == "" || action == cancelAction:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
+	}
+}
+
+// writeScheduled writes a scheduled payment, or the error that occurred fetching or changing it.
+func writeScheduled(w http.ResponseWriter, req *http.Request, sched *utils.ScheduledPayment, err error, status int) {
+
+	switch err {
+	case nil:
+	case dbstore.ErrScheduleNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	case dbstore.ErrNotScheduled, dbstore.ErrExecuting:
+		utils.WriteError(w, req, http.StatusConflict, err)
+		return
+	default:
+		log.Printf("Error with scheduled payments: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(sched)
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	ndjsonType   = "application/x-ndjson"
+	cursorHeader = "Next-Cursor"
+)
+
+var errSearchLimit = fmt.Errorf("limit must be between 1 and %d", dbstore.MaxSearchLimit)
+
+// handleSearchPayments lists the payments matching the query parameters,
+// one page at a time. The page is written as a json object, or as
+// newline-delimited json if the client accepts it.
+func handleSearchPayments(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodGet {
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	filter, err := parsePaymentFilter(req.URL.Query())
+	if err != nil {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+	if filter.Bank, err = readScope(utils.CallerOf(req.Context())); err != nil {
+		writePaymentError(w, req, err)
+		return
+	}
+
+	payments, cursor, err := dbstore.SearchPayments(req.Context(), filter)
+	if err == dbstore.ErrBadCursor {
+		utils.WriteError(w, req, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching payments: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	// Stream one payment per line, passing the cursor in a header
+	if req.FormValue("format") == "ndjson" || strings.Contains(req.Header.Get("Accept"), ndjsonType) {
+		w.Header().Set("Content-Type", ndjsonType)
+		w.Header().Set(cursorHeader, cursor)
+		enc := json.NewEncoder(w)
+		for i := range payments {
+			enc.Encode(&payments[i])
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&utils.PaymentPage{
+		Payments:   payments,
+		NextCursor: cursor,
+	})
+}
+
+// parsePaymentFilter reads search filters from the query parameters.
+func parsePaymentFilter(query url.Values) (*dbstore.PaymentFilter, error) {
+	var err error
+
+	filter := &dbstore.PaymentFilter{
+		SenderBank:   query.Get("sender_bank"),
+		ReceiverBank: query.Get("receiver_bank"),
+		Cursor:       query.Get("cursor"),
+		Currency:     query.Get("currency"),
+		Status:       query.Get("status"),
+		Mandate:      query.Get("mandate"),
+	}
+
+	// Parse the integer parameters
+	ints := []struct {
+		name string
+		dest **int
+	}{
+		{"sender_account", &filter.SenderAccount},
+		{"receiver_account", &filter.ReceiverAccount},
+	}
+	for _, param := range ints {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		val, err := strconv.Atoi(query.Get(param.name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", param.name, query.Get(param.name))
+		}
+		*param.dest = &val
+	}
+
+	// Parse the amounts
+	amounts := []struct {
+		name string
+		dest **utils.Money
+	}{
+		{"min_amount", &filter.MinAmount},
+		{"max_amount", &filter.MaxAmount},
+	}
+	for _, param := range amounts {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		val, err := strconv.ParseInt(query.Get(param.name), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", param.name, query.Get(param.name))
+		}
+		amount := utils.Money(val)
+		*param.dest = &amount
+	}
+	if raw := query.Get("limit"); raw != "" {
+		filter.Limit, err = strconv.Atoi(raw)
+		if err != nil || filter.Limit == 0 {
+			return nil, errSearchLimit
+		}
+	}
+
+	// Parse the time window
+	if raw := query.Get("from"); raw != "" {
+		filter.From, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from time, must be RFC 3339: %q", raw)
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		filter.To, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to time, must be RFC 3339: %q", raw)
+		}
+	}
+
+	if err = checkPaymentFilter(filter); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// checkPaymentFilter returns an error for search filters with an invalid status,
+// mandate or limit. A limit of zero is the default one.
+func checkPaymentFilter(filter *dbstore.PaymentFilter) error {
+	if filter.Status != "" && !utils.IsPaymentStatus(filter.Status) {
+		return fmt.Errorf("invalid status: %q", filter.Status)
+	}
+	if filter.Mandate != "" && !utils.IsValidId(filter.Mandate) {
+		return fmt.Errorf("invalid mandate: %q", filter.Mandate)
+	}
+	if filter.Limit < 0 || filter.Limit > dbstore.MaxSearchLimit {
+		return errSearchLimit
+	}
+	return nil
+}
@@ -2,17 +2,42 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/sekerez/polka/polkapb"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
-	paymentView = "/payment"
-	helloView   = "/hello"
+	paymentView       = "/payment"
+	paymentIdView     = "/payment/"
+	paymentsView      = "/payments"
+	batchView         = "/payments/batch"
+	banksView         = "/banks"
+	bankIdView        = "/banks/"
+	accountsView      = "/accounts"
+	accountIdView     = "/accounts/"
+	scheduledView     = "/scheduled"
+	scheduledIdView   = "/scheduled/"
+	mandatesView      = "/mandates"
+	mandateIdView     = "/mandates/"
+	requestsView      = "/requests"
+	requestIdView     = "/requests/"
+	requestEventsView = "/requests/events"
+	webhooksView      = "/webhooks"
+	webhookIdView     = "/webhooks/"
+	aliasesView       = "/aliases"
+	aliasIdView       = "/aliases/"
+	pacs008View       = "/iso20022/pacs.008"
+	helloView         = "/hello"
 )
 
 // Service manages the main application functions.
@@ -22,14 +47,21 @@ type Service struct {
 	server   *http.Server
 	mux      *http.ServeMux
 	ctx      context.Context
+
+	grpcServer   *grpc.Server // Nil unless served over gRPC
+	grpcListener net.Listener
 }
 
 func (s *Service) Address() net.Addr {
 	return s.listener.Addr()
 }
 
-// New returns an uninitialized http service.
-func New(u *url.URL, ctx context.Context) (*Service, error) {
+// New returns an uninitialized http service, only serving
+// requests signed with the keys of the keyring. With TLS files,
+// connections are only accepted from the balancer over mutual TLS.
+// Unless grpcPort is empty, payments are also served over gRPC on
+// that port, to the balancer, banks and operators.
+func New(u *url.URL, ctx context.Context, keys *utils.Keyring, tlsFiles *utils.TLSFiles, grpcPort string) (*Service, error) {
 
 	logger := log.New(os.Stderr, "[service] ", log.LstdFlags|log.Lshortfile)
 
@@ -46,24 +78,67 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	if tlsFiles != nil {
+		listener = tls.NewListener(listener, tlsFiles.ServerConfig(utils.PeerBalancer))
+	}
 
 	// Set up multiplexor
 	mux := http.NewServeMux()
 	mux.HandleFunc(paymentView, handlePayment)
+	mux.HandleFunc(paymentIdView, handlePaymentById)
+	mux.HandleFunc(paymentsView, handleSearchPayments)
+	mux.HandleFunc(batchView, handleBatchPayments)
+	mux.HandleFunc(banksView, handleBanks)
+	mux.HandleFunc(bankIdView, handleBankById)
+	mux.HandleFunc(accountsView, handleAccounts)
+	mux.HandleFunc(accountIdView, handleAccountById)
+	mux.HandleFunc(scheduledView, handleScheduled)
+	mux.HandleFunc(scheduledIdView, handleScheduledById)
+	mux.HandleFunc(mandatesView, handleMandates)
+	mux.HandleFunc(mandateIdView, handleMandateById)
+	mux.HandleFunc(requestsView, handleRequests)
+	mux.HandleFunc(requestIdView, handleRequestById)
+	mux.HandleFunc(requestEventsView, handleRequestEvents)
+	mux.HandleFunc(webhooksView, handleWebhooks)
+	mux.HandleFunc(webhookIdView, handleWebhookById)
+	mux.HandleFunc(aliasesView, handleAliases)
+	mux.HandleFunc(aliasIdView, handleAliasById)
+	mux.HandleFunc(pacs008View, handlePacs008)
 	mux.HandleFunc(helloView, handleHello)
+	mux.HandleFunc("/", utils.NotFound)
+
+	// Pass on new payment requests to the clients subscribed to them
+	go watch.listen(ctx, logger)
 
 	// Set up server
 	server := &http.Server{
-		Handler: mux,
+		Handler: utils.RequestIds(keys.Middleware(mux, helloView)),
+	}
+
+	// Set up gRPC server, next to the http one
+	var (
+		grpcServer   *grpc.Server
+		grpcListener net.Listener
+	)
+	if grpcPort != "" {
+		grpcListener, err = net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		grpcServer = polkapb.NewServer(keys, tlsFiles, utils.PeerBalancer, utils.RoleBank, utils.RoleOperator)
+		polkapb.RegisterPaymentsServer(grpcServer, &paymentsServer{})
 	}
 
 	// Successfully initialize service
 	s := &Service{
-		ctx:      ctx,
-		mux:      mux,
-		logger:   logger,
-		server:   server,
-		listener: listener,
+		grpcServer:   grpcServer,
+		grpcListener: grpcListener,
+		ctx:          ctx,
+		mux:          mux,
+		logger:       logger,
+		server:       server,
+		listener:     listener,
 	}
 
 	return s, nil
@@ -71,12 +146,22 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 
 // Start sets up a server and listener for incoming requests.
 func (s *Service) Serve(errChan chan<- error) {
+	if s.grpcServer != nil {
+		go func() {
+			if err := s.grpcServer.Serve(s.grpcListener); err != nil {
+				s.logger.Printf("Error serving gRPC: %s", err)
+			}
+		}()
+	}
 	s.logger.Printf("Listening on port %s", s.listener.Addr().String())
 
 	errChan <- s.server.Serve(s.listener)
 }
 
 func (s *Service) Close() (err error) {
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
 	s.listener.Close()
 	err = s.server.Shutdown(s.ctx)
 	return
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+)
+
+const (
+	watchBuffer     = 64
+	watchRetryDelay = 5 * time.Second
+)
+
+// requestWatch passes the ids of new payment requests, made through any receiver,
+// to the clients subscribed to the requests of the payer's bank. Ids are dropped
+// for clients that fall too far behind, which can catch up by listing requests.
+type requestWatch struct {
+	sync.Mutex
+	subs map[string]map[chan string]bool // Indexed by the payer's bank
+}
+
+var watch = &requestWatch{subs: make(map[string]map[chan string]bool)}
+
+// listen passes on notifications of new requests until the context is done,
+// listening again whenever the connection fails.
+func (rw *requestWatch) listen(ctx context.Context, logger *log.Logger) {
+	for {
+		err := dbstore.ListenRequests(ctx, rw.notify)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryDelay):
+			logger.Printf("Stopped listening for payment requests, listening again: %s", err)
+		}
+	}
+}
+
+// subscribe returns a channel receiving the ids of new requests to the bank.
+func (rw *requestWatch) subscribe(bank string) chan string {
+	ids := make(chan string, watchBuffer)
+
+	rw.Lock()
+	defer rw.Unlock()
+
+	if rw.subs[bank] == nil {
+		rw.subs[bank] = make(map[chan string]bool)
+	}
+	rw.subs[bank][ids] = true
+	return ids
+}
+
+// unsubscribe stops sending ids to a channel returned by subscribe.
+func (rw *requestWatch) unsubscribe(bank string, ids chan string) {
+	rw.Lock()
+	defer rw.Unlock()
+
+	delete(rw.subs[bank], ids)
+	if len(rw.subs[bank]) == 0 {
+		delete(rw.subs, bank)
+	}
+}
+
+// notify sends the id of a new request to the subscribers of the payer's bank.
+// It never blocks.
+func (rw *requestWatch) notify(id, payerBank string) {
+	rw.Lock()
+	defer rw.Unlock()
+
+	for ids := range rw.subs[payerBank] {
+		select {
+		case ids <- id:
+		default: // The subscriber is too far behind
+		}
+	}
+}
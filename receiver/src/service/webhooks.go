@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+const deadAction = "dead"
+
+// webhookActions maps the actions of /webhooks/{id}/{action}
+// to whether the webhook is active afterwards.
+var webhookActions = map[string]bool{
+	"enable":  true,
+	"disable": false,
+}
+
+// handleWebhooks registers a webhook for a bank with POST /webhooks, and lists
+// those of a bank with GET /webhooks?bank=... The secret signing the events sent
+// to a webhook is only returned when it's registered.
+func handleWebhooks(w http.ResponseWriter, req *http.Request) {
+	var hook utils.Webhook
+
+	switch req.Method {
+	case http.MethodGet:
+		bank := req.URL.Query().Get("bank")
+		if bank == "" {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("bank required"))
+			return
+		}
+		hooks, err := dbstore.ListWebhooks(req.Context(), bank)
+		if err != nil {
+			log.Printf("Error listing webhooks: %s", err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		err := json.NewDecoder(req.Body).Decode(&hook)
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, utils.ErrInvalidBody)
+			return
+		}
+		if strings.TrimSpace(hook.Bank) == "" {
+			utils.WriteError(w, req, http.StatusBadRequest, errors.New("bank required"))
+			return
+		}
+		if !authorizeBank(w, req, hook.Bank) {
+			return
+		}
+		u, err := url.Parse(hook.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			utils.WriteError(w, req, http.StatusBadRequest, fmt.Errorf("invalid webhook url: %q", hook.Url))
+			return
+		}
+
+		// The secret is always generated by the receiver
+		hook.Secret = utils.NewWebhookSecret()
+
+		err = dbstore.CreateWebhook(req.Context(), &hook)
+		writeWebhook(w, req, &hook, err, http.StatusCreated)
+
+	default:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// handleWebhookById returns a webhook with GET /webhooks/{id} and the events that
+// couldn't be delivered to it with GET /webhooks/{id}/dead, and enables or disables
+// it with POST /webhooks/{id}/enable and /webhooks/{id}/disable.
+func handleWebhookById(w http.ResponseWriter, req *http.Request) {
+
+	rawId, action := path.Split(strings.TrimPrefix(req.URL.Path, webhookIdView))
+	if rawId == "" {
+		// There's no action, only an id
+		rawId, action = action, ""
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(rawId, "/"))
+	if err != nil || id <= 0 {
+		utils.WriteError(w, req, http.StatusBadRequest, errors.New("invalid webhook id"))
+		return
+	}
+
+	active, isChange := webhookActions[action]
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		hook, err := dbstore.GetWebhook(req.Context(), id)
+		writeWebhook(w, req, hook, err, http.StatusOK)
+
+	case action == deadAction && req.Method == http.MethodGet:
+		limit, err := parseLimit(req.URL.Query().Get("limit"))
+		if err != nil {
+			utils.WriteError(w, req, http.StatusBadRequest, err)
+			return
+		}
+		letters, err := dbstore.ListDeadLetters(req.Context(), id, limit)
+		if err != nil {
+			log.Printf("Error listing dead letters of webhook %d: %s", id, err)
+			utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+
+	case isChange && req.Method == http.MethodPost:
+		hook, err := dbstore.GetWebhook(req.Context(), id)
+		if err == nil && !authorizeBank(w, req, hook.Bank) {
+			return
+		}
+		hook, err = dbstore.SetWebhookActive(req.Context(), id, active)
+		writeWebhook(w, req, hook, err, http.StatusOK)
+
+	case action == "" || action == deadAction || isChange:
+		utils.WriteError(w, req, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+
+	default:
+		utils.NotFound(w, req)
+	}
+}
+
+// writeWebhook writes a webhook, or the error that occurred fetching or changing it.
+func writeWebhook(w http.ResponseWriter, req *http.Request, hook *utils.Webhook, err error, status int) {
+
+	switch err {
+	case nil:
+	case dbstore.ErrWebhookNotFound, dbstore.ErrBankNotFound:
+		utils.WriteError(w, req, http.StatusNotFound, err)
+		return
+	default:
+		log.Printf("Error with webhooks: %s", err)
+		utils.WriteError(w, req, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(hook)
+}
@@ -0,0 +1,150 @@
+package webhooks
+
+/*
+The webhooks dispatcher delivers the events queued for the webhooks of banks,
+such as payments being posted by the receivers and snapshots being settled by
+the settler. Events are written in the same database transaction as their
+cause, so every event is eventually delivered, and banks can tell repeated
+deliveries apart by the event's id.
+
+Each event is signed with the webhook's secret, and failed deliveries are
+retried with an exponential backoff until they land in the dead letters.
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	batchSize   = 50
+	maxAttempts = 12
+	minBackoff  = 5 * time.Second
+	maxBackoff  = time.Hour
+	claim       = 15 * time.Minute // How long other receivers skip the deliveries being posted
+	contentType = "application/json"
+	eventHeader = "Polka-Event"
+)
+
+type dispatcher struct {
+	ctx      context.Context
+	logger   *log.Logger
+	client   *http.Client
+	interval time.Duration
+	wake     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+var d *dispatcher // Dispatcher singleton
+
+// New starts the dispatcher, which looks for due deliveries every interval,
+// or as soon as Notify is called, giving each webhook up to timeout to answer.
+func New(ctx context.Context, interval, timeout time.Duration) {
+
+	d = &dispatcher{
+		ctx:      ctx,
+		logger:   log.New(os.Stderr, "[webhooks] ", log.LstdFlags|log.Lshortfile),
+		client:   &http.Client{Timeout: timeout},
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go d.run()
+}
+
+// Notify wakes up the dispatcher after new events were queued.
+// It never blocks.
+func Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default: // A wake up is already pending
+	}
+}
+
+// Close stops the dispatcher once the current delivery round is over.
+func Close() {
+	close(d.quit)
+	<-d.done
+}
+
+func (d *dispatcher) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.quit:
+			close(d.done)
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		// Keep going while there might be more due deliveries
+		for {
+			n, err := dbstore.DeliverWebhooks(d.ctx, batchSize, maxAttempts, claim, deliver, backoff)
+			if err != nil {
+				d.logger.Printf("Error delivering webhook events: %s", err)
+				break
+			}
+			if n < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliver posts a signed event to a webhook, which must answer with a 2xx status.
+func deliver(delivery *dbstore.WebhookDelivery) error {
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(eventHeader, delivery.Type)
+	req.Header.Set(utils.SignatureHeader, utils.SignWebhook(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		d.logger.Printf(
+			"Failed delivering event %s to webhook %d (attempt %d): %s",
+			delivery.EventId,
+			delivery.WebhookId,
+			delivery.Attempts+1,
+			err,
+		)
+	}
+	return err
+}
+
+// backoff returns an exponentially growing delay given the number of attempts.
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
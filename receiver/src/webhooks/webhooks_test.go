@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/receiver/src/dbstore"
+	"github.com/sekerez/polka/utils"
+)
+
+const testSecret = "whsec_test"
+
+// useDispatcher sets up the dispatcher singleton for deliver, without starting it.
+func useDispatcher(t *testing.T, timeout time.Duration) {
+	d = &dispatcher{
+		ctx:    context.Background(),
+		logger: log.New(io.Discard, "", 0),
+		client: &http.Client{Timeout: timeout},
+	}
+	t.Cleanup(func() { d = nil })
+}
+
+func testDelivery(url string) *dbstore.WebhookDelivery {
+	return &dbstore.WebhookDelivery{
+		Id:        1,
+		WebhookId: 2,
+		Url:       url,
+		Secret:    testSecret,
+		EventId:   utils.NewId(),
+		Type:      utils.EventPaymentPosted,
+		Payload:   []byte(`{"Type":"payment.posted"}`),
+	}
+}
+
+func TestDeliverSignsEvents(t *testing.T) {
+	useDispatcher(t, time.Second)
+
+	received := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		switch {
+		case req.Method != http.MethodPost:
+			t.Errorf("method = %s, want POST", req.Method)
+		case req.Header.Get("Content-Type") != contentType:
+			t.Errorf("content type = %q, want %q", req.Header.Get("Content-Type"), contentType)
+		case req.Header.Get(eventHeader) != utils.EventPaymentPosted:
+			t.Errorf("event = %q, want %q", req.Header.Get(eventHeader), utils.EventPaymentPosted)
+		}
+		received <- utils.VerifyWebhook(testSecret, req.Header.Get(utils.SignatureHeader), body, time.Minute, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := deliver(testDelivery(server.URL)); err != nil {
+		t.Fatalf("deliver() = %v, want nil", err)
+	}
+	if err := <-received; err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+}
+
+func TestDeliverRejectsTamperedSignatures(t *testing.T) {
+	useDispatcher(t, time.Second)
+
+	received := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		tampered := append(body, ' ')
+		received <- utils.VerifyWebhook(testSecret, req.Header.Get(utils.SignatureHeader), tampered, time.Minute, time.Now())
+	}))
+	defer server.Close()
+
+	if err := deliver(testDelivery(server.URL)); err != nil {
+		t.Fatalf("deliver() = %v, want nil", err)
+	}
+	if err := <-received; err == nil {
+		t.Error("signature verifies for another body")
+	}
+}
+
+func TestDeliverFailsOnServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		useDispatcher(t, time.Second)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		err := deliver(testDelivery(server.URL))
+		if err == nil || !strings.Contains(err.Error(), strconv.Itoa(status)) {
+			t.Errorf("deliver() with status %d = %v, want an error with the status", status, err)
+		}
+		server.Close()
+	}
+}
+
+func TestDeliverFailsOnTimeout(t *testing.T) {
+	useDispatcher(t, 50*time.Millisecond)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release) // Runs before Close, which waits for the handler
+
+	if err := deliver(testDelivery(server.URL)); err == nil {
+		t.Fatal("deliver() = nil, want a timeout")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, minBackoff},
+		{2, 2 * minBackoff},
+		{3, 4 * minBackoff},
+		{11, maxBackoff},
+		{maxAttempts, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -1,4 +1,9 @@
+if [ ! -f envs/keys.json ]; then
+    echo "Missing envs/keys.json: copy envs/keys.example.json and set its secrets" >&2
+    exit 1
+fi
 if [ ! -d "./generator/env" ]; then
     mkdir generator/env
     cp envs/generator.env generator/env/generator.env
+    cp envs/keys.json generator/env/keys.json
 fi
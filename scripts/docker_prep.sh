@@ -6,14 +6,22 @@ set -euo pipefail
 # Make list of services
 declare -a services=("receiver" "balancer" "cache" "generator" "settler")
 
+# The keys file holds live secrets, and isn't committed
+if [ ! -f envs/keys.json ]
+then
+    echo "Missing envs/keys.json: copy envs/keys.example.json and set its secrets" >&2
+    exit 1
+fi
+
 # Place env directories and files
 for service in ${services[@]}
 do
-    # The log and the .env files go in a different place for receiver
+    # The log, the .env and the keys files go in a different place for receiver
     if [ $service != "receiver" ]
     then
         mkdir $service/env
         cp envs/$service.env $service/env/
+        cp envs/keys.json $service/env/
     fi
 done
 
@@ -22,8 +30,9 @@ echo "Made env directories and log files"
 # Place postgres.env file in cache
 cp envs/postgres.env cache/env/
 
-# Place mongo.env file in settler
+# Place mongo.env and postgres.env files in settler
 cp envs/mongo.env settler/env/
+cp envs/postgres.env settler/env/
 
 # Build all binaries
 for service in ${services[@]}
@@ -5,20 +5,30 @@ set -euo pipefail
 
 # Define base port for receiver nodes
 BASEPORT=8083
+# Define base gRPC port for receiver nodes
+GRPCBASEPORT=9083
 # Define number of receiver nodes
 NODES=$(($1-1))
 # Make list of services
 declare -a services=("receiver" "balancer" "cache" "generator" "settler")
 
+# The keys file holds live secrets, and isn't committed
+if [ ! -f envs/keys.json ]
+then
+    echo "Missing envs/keys.json: copy envs/keys.example.json and set its secrets" >&2
+    exit 1
+fi
+
 # Place env directories and files
 for service in ${services[@]}
 do
-    # The log and the .env files go in a different place for receiver
+    # The log, the .env and the keys files go in a different place for receiver
     if [ $service != "receiver" ]
     then
         mkdir $service/env
         touch $service/log.txt
         cp envs/$service.env $service/env/
+        cp envs/keys.json $service/env/
     fi
 done
 
@@ -27,8 +37,9 @@ echo "Made env directories and log files"
 # Place postgres.env file in cache
 cp envs/postgres.env cache/env/
 
-# Place mongo.env file in settler
+# Place mongo.env and postgres.env files in settler
 cp envs/mongo.env settler/env/
+cp envs/postgres.env settler/env/
 
 # Build all binaries
 for service in ${services[@]}
@@ -59,6 +70,9 @@ do
     cp "receiver/bin/polkareceiver" "receiver/node$i/polkareceiver$i"
     cp "envs/receiver.env" "receiver/node$i/"
     cp "envs/postgres.env" "receiver/node$i/"
+    cp "envs/rules.json" "receiver/node$i/"
+    cp "envs/keys.json" "receiver/node$i/"
     sed -i -e "s/${BASEPORT}/${CURPORT}/g" "receiver/node$i/receiver.env"
+    sed -i -e "s/GRPCPORT=${GRPCBASEPORT}/GRPCPORT=$((GRPCBASEPORT+i))/g" "receiver/node$i/receiver.env"
     echo "Prepared node $i"
 done
\ No newline at end of file
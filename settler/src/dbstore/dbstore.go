@@ -2,9 +2,12 @@ package dbstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/mgo.v2/bson"
@@ -12,12 +15,17 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/sekerez/polka/utils"
 )
 
 const (
 	envPath = "env/mongo.env"
 )
 
+// ErrSnapshotNotFound is returned when no stored snapshot has the requested id.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
 // Create singleton DB
 var db *DB
 
@@ -96,3 +104,43 @@ func InsertSnapshot(ctx context.Context, snapshot []byte) error {
 
 	return nil
 }
+
+// storedSnapshot is the part of a stored snapshot settlements are made from.
+// Snapshots are stored as converted from JSON, so their numbers are doubles
+// and their timestamp a string.
+type storedSnapshot struct {
+	Id                 string             `bson:"Id"`
+	SettlementCurrency string             `bson:"SettlementCurrency"`
+	Settlement         map[string]float64 `bson:"Settlement"`
+	Timestamp          string             `bson:"Timestamp"`
+}
+
+// GetSnapshot returns the id, timestamp and settlement positions of a stored snapshot.
+func GetSnapshot(ctx context.Context, id string) (*utils.Snapshot, error) {
+	var stored storedSnapshot
+
+	err := db.snapshots.FindOne(ctx, bson.M{"Id": id}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, stored.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s has an invalid timestamp: %w", id, err)
+	}
+	snap := &utils.Snapshot{
+		Id:                 stored.Id,
+		SettlementCurrency: stored.SettlementCurrency,
+		Settlement:         make(map[string]utils.Money, len(stored.Settlement)),
+		Timestamp:          at,
+	}
+	for bank, position := range stored.Settlement {
+		// Positions are whole minor units, well within the precision of doubles
+		snap.Settlement[bank] = utils.Money(math.Round(position))
+	}
+
+	return snap, nil
+}
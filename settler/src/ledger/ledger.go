@@ -0,0 +1,197 @@
+package ledger
+
+/*
+The ledger advances the status of the payments stored by the receivers as the
+settler snapshots and settles the balances they moved. Payments are included
+once a snapshot covers them, and settled once that snapshot is settled, which
+the banks with webhooks are told of by the receivers.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/joho/godotenv"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	envPath = "env/postgres.env"
+)
+
+// Create singleton DB
+var db *DB
+
+type DB struct {
+	ctx    context.Context
+	conn   *pgxpool.Pool
+	logger *log.Logger
+}
+
+func New(ctx context.Context) error {
+
+	logger := log.New(os.Stderr, "[ledger] ", log.LstdFlags)
+
+	// Get environment variables and format url
+	if err := godotenv.Load(envPath); err != nil {
+		return err
+	}
+
+	// Write db url
+	uri := fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s",
+		os.Getenv("POSTGRESUSER"),
+		os.Getenv("POSTGRESPASS"),
+		os.Getenv("POSTGRESHOST"),
+		os.Getenv("POSTGRESPORT"),
+		os.Getenv("POSTGRESNAME"),
+	)
+
+	// Connect to database
+	conn, err := pgxpool.Connect(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	// Insert variables inside object
+	db = &DB{
+		ctx:    ctx,
+		conn:   conn,
+		logger: logger,
+	}
+
+	return nil
+}
+
+// Close closes the postgres connection pool.
+func Close() {
+	db.conn.Close()
+}
+
+// IncludeSnapshot marks the payments covered by a snapshot as included in it,
+// given the ids of the payment and batch updates the snapshot covers.
+// Payments that were reversed or rejected in the meantime are left as they are.
+// It returns the number of payments included.
+func IncludeSnapshot(ctx context.Context, snapshotId string, updates []string) (int64, error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	tag, err := db.conn.Exec(ctx, includePaymentsQ, snapshotId, updates)
+	if err != nil {
+		return 0, err
+	}
+	db.logger.Printf("Included %d payments in snapshot %s", tag.RowsAffected(), snapshotId)
+	return tag.RowsAffected(), nil
+}
+
+// SettleSnapshot marks the payments included in a snapshot as settled, and queues
+// an event telling each bank with a webhook its settled position, all at once.
+// It returns the number of payments settled.
+func SettleSnapshot(ctx context.Context, snap *utils.Snapshot) (int64, error) {
+	var (
+		hookIds  []int
+		eventIds []string
+		payloads []string
+	)
+
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // No-op after commit
+
+	tag, err := tx.Exec(ctx, settlePaymentsQ, snap.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read all webhooks before inserting, the connection is busy until then
+	rows, err := tx.Query(ctx, selectWebhooksQ)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	events := make(map[string]string) // Event ids by bank
+	for rows.Next() {
+		var (
+			hookId int
+			bank   string
+		)
+		if err = rows.Scan(&hookId, &bank); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		// Webhooks of the same bank get the same event
+		eventId, exists := events[bank]
+		if !exists {
+			eventId = utils.NewId()
+			events[bank] = eventId
+		}
+		payload, err := json.Marshal(&utils.WebhookEvent{
+			Id:   eventId,
+			Type: utils.EventSettlementCompleted,
+			Bank: bank,
+			Time: now,
+			Settlement: &utils.Settlement{
+				SnapshotId: snap.Id,
+				Position:   snap.Settlement[bank],
+				Currency:   snap.SettlementCurrency,
+			},
+		})
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		hookIds = append(hookIds, hookId)
+		eventIds = append(eventIds, eventId)
+		payloads = append(payloads, string(payload))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(hookIds) > 0 {
+		_, err = tx.Exec(ctx, insertWebhookDeliveriesQ, hookIds, eventIds, payloads, utils.EventSettlementCompleted)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	db.logger.Printf("Settled %d payments in snapshot %s", tag.RowsAffected(), snap.Id)
+	return tag.RowsAffected(), nil
+}
+
+// Banks returns the registered banks by name, with the accounts their
+// positions are settled in.
+func Banks(ctx context.Context) (map[string]*utils.Bank, error) {
+
+	rows, err := db.conn.Query(ctx, selectBanksQ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	banks := make(map[string]*utils.Bank)
+	for rows.Next() {
+		bnk := &utils.Bank{}
+		if err = rows.Scan(&bnk.Id, &bnk.Name, &bnk.Status, &bnk.BIC, &bnk.Routing, &bnk.SettlementAccount); err != nil {
+			return nil, err
+		}
+		banks[bnk.Name] = bnk
+	}
+
+	return banks, rows.Err()
+}
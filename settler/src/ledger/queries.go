@@ -0,0 +1,42 @@
+package ledger
+
+const (
+	// includePaymentsQ includes the payments with the given ids, or in the batches
+	// with the given ids, in a snapshot, as long as they moved money and still do
+	includePaymentsQ = `
+	WITH included AS (
+		UPDATE transactions SET status='included', snapshot_id=$1::uuid
+		WHERE (payment_id = ANY($2::text[]::uuid[]) OR batch_id = ANY($2::text[]::uuid[]))
+		AND status IN ('validated', 'posted')
+		RETURNING payment_id
+	)
+	INSERT INTO payment_events (payment_id, status, detail)
+	SELECT payment_id, 'included', 'snapshot ' || $1::uuid::text FROM included;
+	`
+	settlePaymentsQ = `
+	WITH settled AS (
+		UPDATE transactions SET status='settled'
+		WHERE snapshot_id=$1::uuid AND status='included'
+		RETURNING payment_id
+	)
+	INSERT INTO payment_events (payment_id, status, detail)
+	SELECT payment_id, 'settled', 'snapshot ' || $1::uuid::text FROM settled;
+	`
+	selectWebhooksQ = `
+	SELECT webhooks.id, banks.name
+	FROM webhooks
+	JOIN banks ON banks.id=webhooks.bank_id
+	WHERE webhooks.active
+	ORDER BY webhooks.id;
+	`
+	insertWebhookDeliveriesQ = `
+	INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+	SELECT webhook_id, event_id::uuid, $4, payload
+	FROM unnest($1::int[], $2::text[], $3::text[]) AS deliveries(webhook_id, event_id, payload)
+	ON CONFLICT DO NOTHING;
+	`
+	selectBanksQ = `
+	SELECT id, name, status, COALESCE(bic, ''), COALESCE(routing, ''), COALESCE(settlement_account, '')
+	FROM banks ORDER BY id;
+	`
+)
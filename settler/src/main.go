@@ -13,9 +13,12 @@ import (
 
 	"github.com/joho/godotenv"
 
-	"github.com/sekerez/polka/settler/src/client"
+	"github.com/sekerez/polka/client"
 	"github.com/sekerez/polka/settler/src/dbstore"
+	"github.com/sekerez/polka/settler/src/ledger"
+	"github.com/sekerez/polka/settler/src/nacha"
 	"github.com/sekerez/polka/settler/src/service"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
@@ -74,14 +77,47 @@ func main() {
 		logger.Fatalf("Could not initialize MongoDB database connection: %s", err.Error())
 	}
 
-	// Initialize client
-	err = client.New(os.Getenv("CACHEADDRESS"), cacheReqTimeout)
+	// Initialize payments ledger connection
+	err = ledger.New(ctx)
+	if err != nil {
+		logger.Fatalf("Could not initialize Postgres database connection: %s", err.Error())
+	}
+
+	// Load the API keys signing requests
+	keys, err := utils.LoadKeyring(os.Getenv("KEYSPATH"))
+	if err != nil {
+		logger.Fatalf("Could not load API keys: %s", err)
+	}
+	key, exists := keys.Get(os.Getenv("APIKEY"))
+	if !exists {
+		logger.Fatalf("Unknown API key: %q", os.Getenv("APIKEY"))
+	}
+
+	// Load the certificates for mutual TLS, if configured
+	tlsFiles, err := utils.TLSFromEnv()
+	if err != nil {
+		logger.Fatalf("Could not load TLS certificates: %s", err)
+	}
+
+	// Load who the ACH files settling snapshots are sent by, if configured
+	originator, err := nacha.OriginatorFromEnv()
+	if err != nil {
+		logger.Fatalf("Invalid ACH originator: %s", err)
+	}
+
+	// Initialize the client of the cache
+	cache, err := client.New(os.Getenv("CACHEADDRESS"), client.Config{
+		Key:      key,
+		TLSFiles: tlsFiles,
+		Peer:     utils.PeerCache,
+		Timeout:  cacheReqTimeout,
+	})
 	if err != nil {
 		logger.Fatalf("Could not start client: %s", err)
 	}
 
 	// Initialize service
-	s, err := service.New(u, ctx)
+	s, err := service.New(u, ctx, keys, tlsFiles, originator, cache)
 	if err != nil {
 		logger.Fatalf("Failed to initialize service: %s", err)
 	}
@@ -124,6 +160,7 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Failed to close database: %s", err)
 	}
+	ledger.Close()
 
 	logger.Printf("Shut down api service.")
 }
@@ -0,0 +1,287 @@
+package nacha
+
+/*
+The nacha package writes NACHA formatted ACH files, which instruct the banks'
+settlement accounts to be credited or debited through the ACH network.
+
+A file has a single batch of CCD (corporate credit or debit) entries, made by
+Polka as the originator through its own bank, the ODFI. Records are 94
+characters long, and grouped in blocks of ten, the last of which is padded with
+records of nines.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	recordLength   = 94
+	blockingFactor = 10
+	maxAmount      = 9999999999 // Amounts of entries have 10 digits
+	maxTotal       = 999999999999
+	hashModulus    = 10000000000 // Hashes keep their last 10 digits
+
+	serviceMixed   = "200"
+	serviceCredits = "220"
+	serviceDebits  = "225"
+	secCorporate   = "CCD"
+
+	checkingCredit = "22"
+	checkingDebit  = "27"
+)
+
+// Environment variables configuring the originator.
+const (
+	DestinationEnv     = "NACHA_DESTINATION"
+	DestinationNameEnv = "NACHA_DESTINATION_NAME"
+	ODFIEnv            = "NACHA_ODFI"
+	ODFINameEnv        = "NACHA_ODFI_NAME"
+	CompanyNameEnv     = "NACHA_COMPANY_NAME"
+	CompanyIdEnv       = "NACHA_COMPANY_ID"
+)
+
+var (
+	// ErrNoEntries is returned when writing a file without entries.
+	ErrNoEntries = errors.New("ACH files need at least one entry")
+	// ErrAmountTooLarge is returned for entries whose amount doesn't fit in its field.
+	ErrAmountTooLarge = errors.New("ACH entries are for at most $99,999,999.99")
+)
+
+// Originator is who files are sent by and to.
+type Originator struct {
+	Destination     string // Routing number of the ACH operator or bank receiving the files
+	DestinationName string
+	ODFI            string // Routing number of the bank sending the files for Polka
+	ODFIName        string
+	CompanyName     string
+	CompanyId       string // Identifies Polka to the ODFI, usually 1 followed by its EIN
+}
+
+// Batch is what a file instructs.
+type Batch struct {
+	Description string    // Shown to the receivers, as in SETTLEMENT
+	Reference   string    // Identifies the batch to Polka
+	Date        time.Time // Date the entries are for
+	Effective   time.Time // Date the entries should settle on
+	Entries     []Entry
+}
+
+// Entry credits or debits an account.
+type Entry struct {
+	Routing string
+	Account string
+	Amount  utils.Money // Positive amounts are credited to the account, negative ones debited
+	Id      string
+	Name    string
+}
+
+// OriginatorFromEnv returns the originator set by environment variables,
+// or nil if none of them are set.
+func OriginatorFromEnv() (*Originator, error) {
+	orig := &Originator{
+		Destination:     os.Getenv(DestinationEnv),
+		DestinationName: os.Getenv(DestinationNameEnv),
+		ODFI:            os.Getenv(ODFIEnv),
+		ODFIName:        os.Getenv(ODFINameEnv),
+		CompanyName:     os.Getenv(CompanyNameEnv),
+		CompanyId:       os.Getenv(CompanyIdEnv),
+	}
+
+	if *orig == (Originator{}) {
+		return nil, nil
+	}
+	if err := orig.check(); err != nil {
+		return nil, err
+	}
+	return orig, nil
+}
+
+// check returns an error unless the originator can send files.
+func (orig *Originator) check() error {
+	if err := utils.CheckRouting(orig.Destination); err != nil {
+		return fmt.Errorf("%s: %w", DestinationEnv, err)
+	}
+	if err := utils.CheckRouting(orig.ODFI); err != nil {
+		return fmt.Errorf("%s: %w", ODFIEnv, err)
+	}
+	if orig.CompanyName == "" || orig.CompanyId == "" || len(orig.CompanyId) > 10 {
+		return fmt.Errorf("%s and %s must be set, the latter to up to 10 characters", CompanyNameEnv, CompanyIdEnv)
+	}
+	return nil
+}
+
+// NextBankingDay returns the first weekday after t. Holidays aren't skipped.
+func NextBankingDay(t time.Time) time.Time {
+	t = t.AddDate(0, 0, 1)
+	for t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// Marshal returns the file instructing a batch, created at the given time.
+func (orig *Originator) Marshal(batch *Batch, at time.Time) ([]byte, error) {
+	var (
+		buf                   bytes.Buffer
+		hash                  int64
+		debits, credits       utils.Money
+		hasDebits, hasCredits bool
+	)
+
+	if len(batch.Entries) == 0 {
+		return nil, ErrNoEntries
+	}
+	if err := orig.check(); err != nil {
+		return nil, err
+	}
+
+	// Entries are traced by the ODFI and their position in the file
+	odfi := orig.ODFI[:8]
+	entries := make([]string, 0, len(batch.Entries))
+	for i, entry := range batch.Entries {
+		if err := utils.CheckRouting(entry.Routing); err != nil {
+			return nil, fmt.Errorf("entry for %s: %w", entry.Name, err)
+		}
+		account, err := utils.NormalizeSettlementAccount(entry.Account)
+		if err != nil {
+			return nil, fmt.Errorf("entry for %s: %w", entry.Name, err)
+		}
+		if entry.Amount == 0 {
+			return nil, fmt.Errorf("entry for %s has no amount", entry.Name)
+		}
+
+		code, amount := checkingCredit, entry.Amount
+		if amount < 0 {
+			code, amount = checkingDebit, -amount
+			hasDebits = true
+			debits += amount
+		} else {
+			hasCredits = true
+			credits += amount
+		}
+		if amount > maxAmount || debits > maxTotal || credits > maxTotal {
+			return nil, fmt.Errorf("entry for %s: %w", entry.Name, ErrAmountTooLarge)
+		}
+
+		// The hash adds up the receiving banks' routing numbers, without their check digits
+		rdfi, _ := strconv.ParseInt(entry.Routing[:8], 10, 64) // Checked with the routing number
+		hash = (hash + rdfi) % hashModulus
+
+		entries = append(entries, "6"+
+			code+
+			entry.Routing+
+			alpha(account, 17)+
+			numeric(int64(amount), 10)+
+			alpha(entry.Id, 15)+
+			alpha(entry.Name, 22)+
+			alpha("", 2)+
+			"0"+ // No addenda
+			odfi+numeric(int64(i+1), 7))
+	}
+
+	service := serviceMixed
+	switch {
+	case !hasDebits:
+		service = serviceCredits
+	case !hasCredits:
+		service = serviceDebits
+	}
+	companyId := alpha(orig.CompanyId, 10)
+
+	records := make([]string, 0, len(entries)+4)
+	records = append(records, "1"+
+		"01"+ // Priority code
+		" "+orig.Destination+
+		" "+orig.ODFI+
+		at.Format("0601021504")+
+		"A"+ // File id modifier, telling files created the same day apart
+		"094"+
+		numeric(blockingFactor, 2)+
+		"1"+ // Format code
+		alpha(orig.DestinationName, 23)+
+		alpha(orig.ODFIName, 23)+
+		alpha(batch.Reference, 8))
+
+	records = append(records, "5"+
+		service+
+		alpha(orig.CompanyName, 16)+
+		alpha(batch.Reference, 20)+
+		companyId+
+		secCorporate+
+		alpha(batch.Description, 10)+
+		batch.Date.Format("060102")+
+		batch.Effective.Format("060102")+
+		"   "+ // Settlement date, filled in by the ACH operator
+		"1"+ // Originator status code, for depository financial institutions
+		odfi+
+		numeric(1, 7))
+
+	records = append(records, entries...)
+
+	records = append(records, "8"+
+		service+
+		numeric(int64(len(entries)), 6)+
+		numeric(hash, 10)+
+		numeric(int64(debits), 12)+
+		numeric(int64(credits), 12)+
+		companyId+
+		alpha("", 19)+ // Message authentication code
+		alpha("", 6)+
+		odfi+
+		numeric(1, 7))
+
+	blocks := (len(records) + 1 + blockingFactor - 1) / blockingFactor
+	records = append(records, "9"+
+		numeric(1, 6)+
+		numeric(int64(blocks), 6)+
+		numeric(int64(len(entries)), 8)+
+		numeric(hash, 10)+
+		numeric(int64(debits), 12)+
+		numeric(int64(credits), 12)+
+		alpha("", 39))
+
+	// Fill the last block
+	for len(records)%blockingFactor != 0 {
+		records = append(records, strings.Repeat("9", recordLength))
+	}
+
+	for _, record := range records {
+		if len(record) != recordLength {
+			return nil, fmt.Errorf("record %q isn't %d characters long", record, recordLength)
+		}
+		buf.WriteString(record)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// alpha returns an alphanumeric field of the given length, uppercase, left
+// justified and padded with spaces. Characters ACH files can't have are blanked.
+func alpha(s string, length int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return ' '
+		}
+		return r
+	}, strings.ToUpper(s))
+
+	if len(s) > length {
+		return s[:length]
+	}
+	return s + strings.Repeat(" ", length-len(s))
+}
+
+// numeric returns a numeric field of the given length, right justified and
+// padded with zeros. Callers make sure that n fits.
+func numeric(n int64, length int) string {
+	return fmt.Sprintf("%0*d", length, n)
+}
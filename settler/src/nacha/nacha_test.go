@@ -0,0 +1,174 @@
+package nacha
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sekerez/polka/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testOriginator = &Originator{
+	Destination:     "091000019",
+	DestinationName: "Federal Reserve Bank",
+	ODFI:            "021000021",
+	ODFIName:        "JPMorgan Chase",
+	CompanyName:     "Polka",
+	CompanyId:       "1234567890",
+}
+
+var (
+	testCreated   = time.Date(2026, 10, 18, 14, 5, 0, 0, time.UTC)
+	testDate      = time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	testEffective = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+)
+
+func testBatch(entries []Entry) *Batch {
+	return &Batch{
+		Description: "SETTLEMENT",
+		Reference:   "8a562c67ca1648bab07465581be6f011",
+		Date:        testDate,
+		Effective:   testEffective,
+		Entries:     entries,
+	}
+}
+
+// testEntries returns n entries alternately crediting and debiting banks,
+// whose routing numbers add up to more than ten digits past 100 entries.
+func testEntries(n int) []Entry {
+	entries := make([]Entry, n)
+	for i := range entries {
+		amount := utils.Money(1000 + i)
+		if i%2 == 1 {
+			amount = -utils.Money(1000 + i - 1)
+		}
+		entries[i] = Entry{
+			Routing: "999999992",
+			Account: "SETTLE-" + strconv.Itoa(i+1),
+			Amount:  amount,
+			Id:      strconv.Itoa(i + 1),
+			Name:    "Bank " + strconv.Itoa(i+1),
+		}
+	}
+	return entries
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		file    string
+		entries []Entry
+	}{
+		// Seven records, padded to a block with three records of nines
+		{"nacha_mixed.ach", []Entry{
+			{"021000021", "0011-2233", 125050, "1", "JP Morgan Chase"},
+			{"121000248", "4455667788", -100000, "2", "Wells Fargo"},
+			{"021000089", "99001", -25050, "3", "Citigroup"},
+		}},
+		// Exactly one block, which isn't padded
+		{"nacha_full_block.ach", []Entry{
+			{"021000021", "0011-2233", 5000, "1", "JP Morgan Chase"},
+			{"121000248", "4455667788", 2500, "2", "Wells Fargo"},
+			{"021000089", "99001", -1500, "3", "Citigroup"},
+			{"026009593", "BOFA-1", -4000, "4", "Bank of America"},
+			{"111000614", "77", 1000, "5", "Hibernia Bank"},
+			{"999999992", "SETTLE-6", -3000, "6", "U.S. Bank"},
+		}},
+		// Twelve blocks, with an entry hash truncated to its last ten digits
+		{"nacha_hash.ach", testEntries(110)},
+	}
+	for _, tt := range tests {
+		batch := testBatch(tt.entries)
+		got, err := testOriginator.Marshal(batch, testCreated)
+		if err != nil {
+			t.Errorf("%s: Marshal() = %v", tt.file, err)
+			continue
+		}
+		checkFile(t, tt.file, got, batch)
+
+		path := filepath.Join("testdata", tt.file)
+		if *update {
+			if err = os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: Marshal() doesn't match the golden file, rerun with -update to see the differences", tt.file)
+		}
+	}
+}
+
+// checkFile checks the layout and control totals of a file regardless of its
+// golden copy.
+func checkFile(t *testing.T, name string, file []byte, batch *Batch) {
+	t.Helper()
+
+	records := strings.Split(strings.TrimSuffix(string(file), "\n"), "\n")
+	for i, record := range records {
+		if len(record) != recordLength {
+			t.Errorf("%s: record %d is %d characters long, want %d", name, i+1, len(record), recordLength)
+		}
+	}
+	if len(records)%blockingFactor != 0 {
+		t.Errorf("%s: %d records aren't whole blocks of %d", name, len(records), blockingFactor)
+	}
+
+	var hash, debits, credits int64
+	for _, entry := range batch.Entries {
+		rdfi, _ := strconv.ParseInt(entry.Routing[:8], 10, 64)
+		hash += rdfi
+		if entry.Amount < 0 {
+			debits -= int64(entry.Amount)
+		} else {
+			credits += int64(entry.Amount)
+		}
+	}
+	hash %= hashModulus
+	n := len(batch.Entries)
+
+	batchControl, fileControl := records[n+2], records[n+3]
+	wantBatch := numeric(int64(n), 6) + numeric(hash, 10) + numeric(debits, 12) + numeric(credits, 12)
+	if batchControl[0] != '8' || batchControl[4:44] != wantBatch {
+		t.Errorf("%s: batch control = %q, want counts and totals %q", name, batchControl, wantBatch)
+	}
+	blocks := int64(len(records) / blockingFactor)
+	wantFile := numeric(1, 6) + numeric(blocks, 6) + numeric(int64(n), 8) + numeric(hash, 10) + numeric(debits, 12) + numeric(credits, 12)
+	if fileControl[0] != '9' || fileControl[1:55] != wantFile {
+		t.Errorf("%s: file control = %q, want counts and totals %q", name, fileControl, wantFile)
+	}
+
+	for i, record := range records[n+4:] {
+		if record != strings.Repeat("9", recordLength) {
+			t.Errorf("%s: padding record %d = %q, want nines", name, i+1, record)
+		}
+	}
+}
+
+func TestMarshalRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{"no entries", nil},
+		{"invalid routing", []Entry{{"021000022", "1", 100, "1", "JP Morgan Chase"}}},
+		{"invalid account", []Entry{{"021000021", "", 100, "1", "JP Morgan Chase"}}},
+		{"no amount", []Entry{{"021000021", "1", 0, "1", "JP Morgan Chase"}}},
+		{"amount too large", []Entry{{"021000021", "1", maxAmount + 1, "1", "JP Morgan Chase"}}},
+	}
+	for _, tt := range tests {
+		if _, err := testOriginator.Marshal(testBatch(tt.entries), testCreated); err == nil {
+			t.Errorf("%s: Marshal() = nil, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sekerez/polka/settler/src/dbstore"
+	"github.com/sekerez/polka/settler/src/ledger"
+	"github.com/sekerez/polka/settler/src/nacha"
+	"github.com/sekerez/polka/utils"
+)
+
+const (
+	achCurrency    = "USD"
+	achDescription = "SETTLEMENT"
+)
+
+// handleACH returns the ACH file settling a stored snapshot with GET /ach/{snapshotId}.
+// The file has an entry for each bank with a position, crediting its settlement
+// account what Polka owes it or debiting what it owes Polka, effective the next
+// banking day.
+func handleACH(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+	if cm.originator == nil {
+		utils.WriteError(w, r, http.StatusNotImplemented, errors.New("ACH files aren't configured"))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, achPath)
+	if id == "" || strings.Contains(id, "/") {
+		utils.NotFound(w, r)
+		return
+	}
+
+	snap, err := dbstore.GetSnapshot(r.Context(), id)
+	if err == dbstore.ErrSnapshotNotFound {
+		utils.WriteError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		cm.logger.Printf("Error retrieving snapshot: %s", err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+	if snap.SettlementCurrency != achCurrency {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, fmt.Errorf("snapshot is settled in %s, ACH files are in %s", snap.SettlementCurrency, achCurrency))
+		return
+	}
+	if err = checkBalanced(snap.Settlement); err != nil {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	banks, err := ledger.Banks(r.Context())
+	if err != nil {
+		cm.logger.Printf("Error retrieving banks: %s", err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	// Order the entries by bank, so the same snapshot always gives the same entries
+	names := make([]string, 0, len(snap.Settlement))
+	for name, position := range snap.Settlement {
+		if position != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	entries := make([]nacha.Entry, 0, len(names))
+	for _, name := range names {
+		bnk, exists := banks[name]
+		if !exists || bnk.Routing == "" || bnk.SettlementAccount == "" {
+			utils.WriteCodedError(w, r, http.StatusUnprocessableEntity, utils.CodeUnprocessable, "bank has no routing number or settlement account", map[string]string{"bank": name})
+			return
+		}
+		entries = append(entries, nacha.Entry{
+			Routing: bnk.Routing,
+			Account: bnk.SettlementAccount,
+			Amount:  snap.Settlement[name],
+			Id:      strconv.Itoa(int(bnk.Id)),
+			Name:    name,
+		})
+	}
+
+	now := time.Now().UTC()
+	file, err := cm.originator.Marshal(&nacha.Batch{
+		Description: achDescription,
+		Reference:   strings.ReplaceAll(snap.Id, "-", ""),
+		Date:        snap.Timestamp,
+		Effective:   nacha.NextBankingDay(now),
+		Entries:     entries,
+	}, now)
+	if errors.Is(err, nacha.ErrNoEntries) || errors.Is(err, nacha.ErrAmountTooLarge) {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		cm.logger.Printf("Error writing ACH file: %s", err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "polka-"+snap.Id+".ach"))
+	w.Write(file)
+}
+
+// checkBalanced returns an error unless what banks are credited adds up to
+// what they're debited, so that files don't move money Polka doesn't hold.
+func checkBalanced(settlement map[string]utils.Money) error {
+	positions := make([]utils.Money, 0, len(settlement))
+	for _, position := range settlement {
+		positions = append(positions, position)
+	}
+	total, err := utils.Sum(positions...)
+	if err != nil {
+		return fmt.Errorf("snapshot's positions can't be added up: %w", err)
+	}
+	if total != 0 {
+		return fmt.Errorf("snapshot's credits and debits are off by %d", total)
+	}
+	return nil
+}
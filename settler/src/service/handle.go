@@ -1,7 +1,6 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,21 +10,32 @@ import (
 	"os"
 	"time"
 
-	"github.com/sekerez/polka/settler/src/client"
+	"github.com/sekerez/polka/client"
 	"github.com/sekerez/polka/settler/src/dbstore"
+	"github.com/sekerez/polka/settler/src/ledger"
+	"github.com/sekerez/polka/settler/src/nacha"
+	"github.com/sekerez/polka/utils"
 )
 
 type settlementsManager struct {
-	requested bool
-	logger    *log.Logger
+	requested  bool
+	snapshot   *utils.Snapshot   // Last snapshot, whose payments are settled next
+	originator *nacha.Originator // Sends the ACH files settling snapshots, if configured
+	cache      *client.Client
+	logger     *log.Logger
 }
 
 var cm settlementsManager
 
-func initManager() {
+// errCacheFailed is answered when the cache can't take or settle a snapshot.
+var errCacheFailed = errors.New("cache couldn't handle the request")
+
+func initManager(originator *nacha.Originator, cache *client.Client) {
 	cm = settlementsManager{
-		requested: false,
-		logger:    log.New(os.Stderr, "[handler] ", log.LstdFlags|log.Lshortfile),
+		requested:  false,
+		originator: originator,
+		cache:      cache,
+		logger:     log.New(os.Stderr, "[handler] ", log.LstdFlags|log.Lshortfile),
 	}
 }
 
@@ -46,57 +56,67 @@ func handle(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	switch r.Method {
-	case http.MethodPut:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	case http.MethodDelete:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-
 	case http.MethodGet:
 		log.Printf("Got a snapshot request!")
 
-		snapshot, err := client.RequestSnapshot()
+		snap, err := cm.cache.TakeSnapshot(ctx)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error retrieving balances: %s", err)
 			cm.logger.Printf("Error retrieving balances: %s", err)
+			utils.WriteError(w, r, http.StatusBadGateway, errCacheFailed)
+			return
+		}
+		snapshot, err := json.Marshal(snap)
+		if err != nil {
+			cm.logger.Printf("Error encoding snapshot: %s", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
 			return
 		}
 		err = dbstore.InsertSnapshot(ctx, snapshot)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error sending snapshot to MongoDB database: %s", err)
 			cm.logger.Printf("Error sending snapshot to MongoDB database: %s", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
 			return
 		}
-		_, err = w.Write(snapshot)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Error writing snapshot to response: %s", err)
-			cm.logger.Printf("Error writing snapshot to response: %s", err)
+
+		// Mark the payments covered by the snapshot as included in it
+		if _, err = ledger.IncludeSnapshot(ctx, snap.Id, snap.Updates); err != nil {
+			cm.logger.Printf("Error including payments in snapshot: %s", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
 			return
 		}
+		cm.snapshot = snap
+		w.Header().Set("Content-Type", "application/json")
 		cm.requested = true
+		if _, err = w.Write(snapshot); err != nil {
+			cm.logger.Printf("Error writing snapshot to response: %s", err)
+		}
 
 	case http.MethodPost:
 		// Make sure that the snapshot was requested
 		if !cm.requested {
-			err := errors.New("must request snapshot before requesting settlement").Error()
 			cm.logger.Printf("Client requested snapshot before requesting settlement")
-			http.Error(w, err, http.StatusBadRequest)
+			utils.WriteError(w, r, http.StatusBadRequest, errors.New("must request snapshot before requesting settlement"))
 			return
 		}
 
-		// payload buffer might include data in the future
-		payloadBuffer := new(bytes.Buffer)
-		json.NewEncoder(payloadBuffer).Encode(struct{}{})
-
 		// request settlement
-		err := client.Settle(payloadBuffer)
+		err := cm.cache.Settle(ctx)
 		if err != nil {
-			log.Fatalf("Error sending clearing request to cache: %s", err)
+			cm.logger.Printf("Error sending clearing request to cache: %s", err)
+			utils.WriteError(w, r, http.StatusBadGateway, errCacheFailed)
+			return
 		}
 		log.Printf("Successfully cleared balances.")
+
+		// Mark the payments included in the snapshot as settled, and tell the banks
+		if _, err = ledger.SettleSnapshot(ctx, cm.snapshot); err != nil {
+			cm.logger.Printf("Error marking payments as settled: %s", err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrInternal)
+			return
+		}
 		fmt.Fprintf(w, "Successfully cleared balances.")
+	default:
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 	}
 
 }
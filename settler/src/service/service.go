@@ -2,16 +2,22 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+
+	"github.com/sekerez/polka/client"
+	"github.com/sekerez/polka/settler/src/nacha"
+	"github.com/sekerez/polka/utils"
 )
 
 const (
-	path = "/settle"
+	path    = "/settle"
+	achPath = "/ach/"
 )
 
 // Service manages the main application functions.
@@ -23,8 +29,11 @@ type Service struct {
 	ctx      context.Context
 }
 
-// New returns an uninitialized http service.
-func New(u *url.URL, ctx context.Context) (*Service, error) {
+// New returns an uninitialized http service, where only operators can take snapshots, settle them
+// and download the ACH files settling them, which are sent by originator if it isn't nil.
+// Snapshots are taken and settled by the cache. With TLS files, connections are only
+// accepted from operators over mutual TLS.
+func New(u *url.URL, ctx context.Context, keys *utils.Keyring, tlsFiles *utils.TLSFiles, originator *nacha.Originator, cache *client.Client) (*Service, error) {
 
 	logger := log.New(os.Stderr, "[service] ", log.LstdFlags|log.Lshortfile)
 
@@ -39,14 +48,19 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	if tlsFiles != nil {
+		listener = tls.NewListener(listener, tlsFiles.ServerConfig(utils.RoleOperator))
+	}
 
 	// Set up multiplexor
 	mux := http.NewServeMux()
-	mux.HandleFunc(path, handle)
+	mux.HandleFunc(path, utils.RequireRole(handle, utils.RoleOperator))
+	mux.HandleFunc(achPath, utils.RequireRole(handleACH, utils.RoleOperator))
+	mux.HandleFunc("/", utils.NotFound)
 
 	// Set up server
 	server := &http.Server{
-		Handler: mux,
+		Handler: utils.RequestIds(keys.Middleware(mux)),
 	}
 
 	// Successfully initialize service
@@ -59,7 +73,7 @@ func New(u *url.URL, ctx context.Context) (*Service, error) {
 	}
 
 	// Initialize settlements manager
-	initManager()
+	initManager(originator, cache)
 
 	return s, nil
 }
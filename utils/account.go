@@ -0,0 +1,20 @@
+package utils
+
+import "time"
+
+// Account statuses. Only open accounts can send or receive payments,
+// frozen accounts can be reopened and closed accounts stay closed.
+const (
+	AccountOpen   = "open"
+	AccountFrozen = "frozen"
+	AccountClosed = "closed"
+)
+
+// Account is a bank account known to Polka.
+type Account struct {
+	BankId   uint16
+	Bank     string
+	Account  int
+	Status   string
+	OpenedAt time.Time
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Kinds of aliases.
+const (
+	AliasEmail = "email"
+	AliasPhone = "phone"
+)
+
+// Statuses of an alias. Enrolled aliases are pending until the code sent to
+// their owner is confirmed, and only verified aliases can be paid.
+const (
+	AliasPending  = "pending"
+	AliasVerified = "verified"
+)
+
+// AliasCodeTTL is how long the verification code of an alias can be confirmed for.
+const AliasCodeTTL = 15 * time.Minute
+
+// ErrInvalidAlias is returned for aliases that are neither emails nor phone numbers.
+var ErrInvalidAlias = errors.New("aliases must be an email or a phone number in international format, as in +14155550100")
+
+// Alias maps an email or phone number to the account it pays to.
+type Alias struct {
+	Alias      string // Normalized by the receiver
+	Kind       string // Assigned by the receiver
+	Bank       string
+	Account    int
+	Status     string // Assigned by the receiver
+	Code       string // Verification code, only returned when the alias is enrolled
+	CreatedAt  time.Time
+	VerifiedAt *time.Time
+}
+
+// NormalizeAlias returns an alias in the form it's stored in, with its kind.
+// Emails are lowercased, and phone numbers lose the spaces, dashes, dots and
+// parentheses between their digits.
+func NormalizeAlias(alias string) (string, string, error) {
+	alias = strings.TrimSpace(alias)
+
+	if strings.Contains(alias, "@") {
+		addr, err := mail.ParseAddress(alias)
+		// Aliases are part of paths, so they can't have slashes
+		if err != nil || addr.Address != alias || addr.Name != "" || strings.Contains(alias, "/") {
+			return "", "", ErrInvalidAlias
+		}
+		return strings.ToLower(alias), AliasEmail, nil
+	}
+
+	if !strings.HasPrefix(alias, "+") {
+		return "", "", ErrInvalidAlias
+	}
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			return -1
+		default:
+			return 'x'
+		}
+	}, alias[1:])
+	// E.164 numbers have up to 15 digits, and don't start with 0
+	if strings.Contains(digits, "x") || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", "", ErrInvalidAlias
+	}
+	return "+" + digits, AliasPhone, nil
+}
+
+// NewAliasCode returns a random six digit verification code.
+func NewAliasCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err) // crypto/rand failing means the system is unusable
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// HashAliasCode returns the hex-encoded digest a verification code is stored as.
+func HashAliasCode(alias, code string) string {
+	sum := sha256.Sum256([]byte(alias + "|" + code))
+	return hex.EncodeToString(sum[:])
+}
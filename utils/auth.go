@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Roles of API keys. Bank keys belong to a single bank, and only originate
+// its payments. Operators run settlement and administer banks, while
+// service keys are used by Polka's own services to update the cache.
+const (
+	RoleBank     = "bank"
+	RoleOperator = "operator"
+	RoleService  = "service"
+)
+
+// Headers of signed requests. The signature is the hex-encoded HMAC-SHA256
+// of the method, the path with the query, the timestamp and the SHA-256 of
+// the body, separated by newlines.
+const (
+	KeyHeader              = "Polka-Key"
+	TimestampHeader        = "Polka-Timestamp"
+	RequestSignatureHeader = "Polka-Request-Signature"
+)
+
+// MaxBodyBytes caps the bodies of signed requests, which are read whole to be
+// hashed. It's above the caps of the endpoints taking the largest bodies.
+const MaxBodyBytes = 4 << 20
+
+// requestTolerance is how far the timestamp of a signed request can be from
+// the time it's verified at, which bounds how long it can be replayed for.
+const requestTolerance = 5 * time.Minute
+
+var (
+	// ErrUnauthenticated is returned for requests that aren't signed with a known key.
+	ErrUnauthenticated = errors.New("request isn't signed with a valid API key")
+	// ErrForbidden is returned when the caller's key doesn't allow the request.
+	ErrForbidden = errors.New("API key isn't allowed to make this request")
+	// ErrBodyTooLarge is returned for signed requests whose body is over MaxBodyBytes.
+	ErrBodyTooLarge = fmt.Errorf("request body is over %d bytes", MaxBodyBytes)
+)
+
+type callerKey struct{}
+
+// APIKey identifies a participant. Bank is only set for keys with the bank role.
+type APIKey struct {
+	Id     string
+	Secret string
+	Role   string
+	Bank   string
+}
+
+// Keyring holds the API keys a service accepts, indexed by id.
+type Keyring struct {
+	keys map[string]*APIKey
+}
+
+// LoadKeyring reads a json list of API keys from path.
+func LoadKeyring(path string) (*Keyring, error) {
+	var keys []*APIKey
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+
+	kr := &Keyring{keys: make(map[string]*APIKey, len(keys))}
+	for _, key := range keys {
+		switch {
+		case key.Id == "" || key.Secret == "":
+			return nil, fmt.Errorf("invalid keyring %s: keys need an id and a secret", path)
+		case key.Role == RoleBank && key.Bank == "":
+			return nil, fmt.Errorf("invalid keyring %s: bank key %q has no bank", path, key.Id)
+		case key.Role != RoleBank && key.Role != RoleOperator && key.Role != RoleService:
+			return nil, fmt.Errorf("invalid keyring %s: key %q has unknown role %q", path, key.Id, key.Role)
+		}
+		if _, exists := kr.keys[key.Id]; exists {
+			return nil, fmt.Errorf("invalid keyring %s: key %q appears twice", path, key.Id)
+		}
+		kr.keys[key.Id] = key
+	}
+
+	return kr, nil
+}
+
+// Get returns the key with the given id.
+func (kr *Keyring) Get(id string) (*APIKey, bool) {
+	key, exists := kr.keys[id]
+	return key, exists
+}
+
+// SignRequest signs a request with the key at the given time. The request's
+// body is read, and replaced so that it can still be sent.
+func SignRequest(req *http.Request, key *APIKey, at time.Time) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(KeyHeader, key.Id)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(RequestSignatureHeader, requestMac(key.Secret, req, timestamp, body))
+	return nil
+}
+
+// Authenticate returns the key a request was signed with, or an error
+// wrapping ErrUnauthenticated, or ErrBodyTooLarge. The request's body is
+// read, and replaced so that it can still be handled.
+func (kr *Keyring) Authenticate(req *http.Request, now time.Time) (*APIKey, error) {
+
+	key, exists := kr.keys[req.Header.Get(KeyHeader)]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown key", ErrUnauthenticated)
+	}
+
+	timestamp := req.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp", ErrUnauthenticated)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > requestTolerance || age < -requestTolerance {
+		return nil, fmt.Errorf("%w: timestamp too far from now", ErrUnauthenticated)
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	mac := req.Header.Get(RequestSignatureHeader)
+	if !hmac.Equal([]byte(mac), []byte(requestMac(key.Secret, req, timestamp, body))) {
+		return nil, fmt.Errorf("%w: signature doesn't match", ErrUnauthenticated)
+	}
+
+	return key, nil
+}
+
+// Middleware only passes on requests signed with a key of the keyring,
+// which handlers get with CallerOf. Requests to the public paths don't
+// need to be signed.
+func (kr *Keyring) Middleware(next http.Handler, public ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, path := range public {
+			if req.URL.Path == path {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+
+		key, err := kr.Authenticate(req, time.Now())
+		if errors.Is(err, ErrBodyTooLarge) {
+			WriteError(w, req, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		if err != nil {
+			WriteError(w, req, http.StatusUnauthorized, err)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(WithCaller(req.Context(), key)))
+	})
+}
+
+// WithCaller returns a copy of ctx carrying the key its request was signed with.
+func WithCaller(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, callerKey{}, key)
+}
+
+// CallerOf returns the key a request handled behind Middleware was signed with.
+func CallerOf(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(callerKey{}).(*APIKey)
+	return key
+}
+
+// HasRole tells whether the key has any of the given roles.
+func (key *APIKey) HasRole(roles ...string) bool {
+	if key == nil {
+		return false
+	}
+	for _, role := range roles {
+		if key.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole only passes on requests whose caller has one of the given roles.
+func RequireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !CallerOf(req.Context()).HasRole(roles...) {
+			WriteError(w, req, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// SignRPC returns the signature of a gRPC call to the full method, as in
+// /polka.v1.Payments/Submit, at the given timestamp. Like the body of HTTP
+// requests, the marshalled request message is hashed into the signature, so
+// that a captured signature can't be sent with another message. Streaming
+// calls have no single message, and are signed with a nil one.
+func SignRPC(key *APIKey, method, timestamp string, message []byte) string {
+	digest := sha256.Sum256(message)
+
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	fmt.Fprintf(mac, "RPC\n%s\n%s\n%x", method, timestamp, digest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuthenticateRPC returns the key a gRPC call was signed with, given the
+// values of the signature headers sent as its metadata and its marshalled
+// request message, or an error wrapping ErrUnauthenticated.
+func (kr *Keyring) AuthenticateRPC(method, keyId, timestamp, signature string, message []byte, now time.Time) (*APIKey, error) {
+
+	key, exists := kr.keys[keyId]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown key", ErrUnauthenticated)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp", ErrUnauthenticated)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > requestTolerance || age < -requestTolerance {
+		return nil, fmt.Errorf("%w: timestamp too far from now", ErrUnauthenticated)
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignRPC(key, method, timestamp, message))) {
+		return nil, fmt.Errorf("%w: signature doesn't match", ErrUnauthenticated)
+	}
+
+	return key, nil
+}
+
+// SigningTransport signs every request it sends with Key.
+type SigningTransport struct {
+	Base http.RoundTripper
+	Key  *APIKey
+}
+
+// RoundTrip signs a copy of the request and sends it with the base transport.
+func (st *SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+	if err := SignRequest(signed, st.Key, time.Now()); err != nil {
+		return nil, err
+	}
+
+	base := st.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// readBody reads the body of a request, up to MaxBodyBytes, and replaces it with a copy.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, req.Body, MaxBodyBytes))
+	req.Body.Close()
+	if err != nil && len(body) == MaxBodyBytes {
+		return nil, ErrBodyTooLarge // The reader stops at the cap
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// requestMac returns the hex-encoded signature of a request.
+func requestMac(secret string, req *http.Request, timestamp string, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", req.Method, req.URL.RequestURI(), timestamp, digest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,28 +1,141 @@
 package utils
 
+import "time"
+
 // Balance transfers data to update the database accounts ledger.
+// It also carries account balances and statuses out of the cache.
 type Balance struct {
 	BankId   uint16
 	BankName string
 	Account  uint32
-	Balance  int32
+	Currency string // Empty if the account has no position yet
+	Balance  Money
+	Status   string
+}
+
+// AccountStatus tells the cache that an account's status changed.
+// The cache applies each id at most once.
+type AccountStatus struct {
+	Id      string
+	Name    string
+	Account uint32
+	Status  string
 }
 
 // BankBalance transfers bank balance data from the cache to the database.
+// Each bank has a balance in each currency it has a position in.
 type BankBalance struct {
-	BankId  uint16
-	Name    string
-	Balance int64
+	BankId   uint16
+	Name     string
+	Currency string // Empty if the bank has no position yet
+	Balance  Money
+}
+
+// Backup transfers the balances that changed in the cache to the database,
+// together with the ids of the updates applied since the last backup, which
+// are written in the same transaction. Unsettled holds the ids among them of
+// payment and batch updates, which are kept until settled, and Settled the
+// ids settled since the last backup. Done is sent the outcome.
+type Backup struct {
+	Banks     []*BankBalance
+	Accounts  []*Balance
+	Applied   []string
+	Unsettled []string
+	Settled   []string
+	Done      chan error
+}
+
+// Applied transfers the update ids the cache applied from the database when
+// it starts: when each id in the dedup window was applied, and the ids of the
+// payment and batch updates not settled yet, in the order they were applied.
+type Applied struct {
+	Ids       map[string]time.Time
+	Unsettled []string
 }
 
 // SRBalance captures data from the api and feeds it into the cache.
+// The cache applies each id at most once.
 type SRBalance struct {
+	Id       string
 	Sender   *bankInfo
 	Receiver *bankInfo
-	Amount   int32
+	Amount   Money
+	Currency string
 }
 
 type bankInfo struct {
 	Name    string
 	Account uint32
 }
+
+// NewSRBalance returns the balance update caused by a payment.
+func NewSRBalance(paymnt *Payment) *SRBalance {
+	return &SRBalance{
+		Id: paymnt.Id,
+		Sender: &bankInfo{
+			Name:    paymnt.Sender.Name,
+			Account: uint32(paymnt.Sender.Account),
+		},
+		Receiver: &bankInfo{
+			Name:    paymnt.Receiver.Name,
+			Account: uint32(paymnt.Receiver.Account),
+		},
+		Amount:   paymnt.Amount,
+		Currency: paymnt.Currency,
+	}
+}
+
+// BatchBalance aggregates the balance updates caused by a batch of payments.
+// The cache applies each id at most once.
+type BatchBalance struct {
+	Id     string
+	Deltas []*AccountDelta
+}
+
+// AccountDelta is the net change of an account's balance in a currency.
+type AccountDelta struct {
+	Name     string
+	Account  uint32
+	Currency string
+	Amount   Money
+}
+
+// NewBatchBalance returns the net balance update caused by the payments,
+// with one delta per account and currency involved, or ErrOverflow if
+// any delta doesn't fit.
+func NewBatchBalance(id string, payments []*Payment) (*BatchBalance, error) {
+	type key struct {
+		name     string
+		account  uint32
+		currency string
+	}
+
+	batch := &BatchBalance{Id: id}
+	deltas := make(map[key]*AccountDelta)
+
+	// add sums an amount into the account's delta, allocating it if needed
+	add := func(info BankInfo, currency string, amount Money) (err error) {
+		k := key{info.Name, uint32(info.Account), currency}
+		if _, exists := deltas[k]; !exists {
+			deltas[k] = &AccountDelta{Name: k.name, Account: k.account, Currency: k.currency}
+			batch.Deltas = append(batch.Deltas, deltas[k])
+		}
+		deltas[k].Amount, err = deltas[k].Amount.Add(amount)
+		return err
+	}
+
+	for _, paymnt := range payments {
+		debit, err := paymnt.Amount.Neg()
+		if err != nil {
+			return nil, err
+		}
+		if err = add(paymnt.Sender, paymnt.Currency, debit); err != nil {
+			return nil, err
+		}
+		if err = add(paymnt.Receiver, paymnt.Currency, paymnt.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	return batch, nil
+}
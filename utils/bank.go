@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// Bank statuses. Suspended banks can't send or receive payments.
+const (
+	BankActive    = "active"
+	BankSuspended = "suspended"
+)
+
+var (
+	// ErrInvalidBIC is returned for business identifier codes that aren't well formed.
+	ErrInvalidBIC = errors.New("BICs have 8 or 11 characters, as in CHASUS33 or CHASUS33XXX")
+	// ErrInvalidRouting is returned for ABA routing numbers that aren't well formed.
+	ErrInvalidRouting = errors.New("routing numbers have 9 digits, the last of which is a check digit, as in 021000021")
+	// ErrInvalidSettlementAccount is returned for settlement accounts ACH entries can't be made to.
+	ErrInvalidSettlementAccount = errors.New("settlement accounts have up to 17 letters, digits and dashes")
+)
+
+// maxSettlementAccountLength is the length of account numbers in ACH entries.
+const maxSettlementAccountLength = 17
+
+// Bank is an entry of the bank registry.
+type Bank struct {
+	Id     uint16
+	Name   string
+	Status string
+	BIC    string // Identifies the bank in ISO 20022 messages, if it has one
+
+	// Where the bank's settlement position is paid to or collected from by ACH
+	Routing           string
+	SettlementAccount string
+}
+
+// NormalizeBIC returns a business identifier code in the form it's stored in,
+// uppercase and without the XXX branch code, which stands for the head office.
+func NormalizeBIC(bic string) (string, error) {
+	bic = strings.ToUpper(strings.TrimSpace(bic))
+	if len(bic) != 8 && len(bic) != 11 {
+		return "", ErrInvalidBIC
+	}
+	for i, c := range bic {
+		isLetter := c >= 'A' && c <= 'Z'
+		isDigit := c >= '0' && c <= '9'
+		// The institution and country codes are letters, the rest may have digits
+		if !isLetter && (i < 6 || !isDigit) {
+			return "", ErrInvalidBIC
+		}
+	}
+	return strings.TrimSuffix(bic, "XXX"), nil
+}
+
+// CheckRouting returns ErrInvalidRouting unless routing is an ABA routing number
+// whose check digit matches, which is 3, 7 and 1 times its digits in turn adding
+// up to a multiple of ten.
+func CheckRouting(routing string) error {
+	if len(routing) != 9 {
+		return ErrInvalidRouting
+	}
+	sum := 0
+	for i, c := range routing {
+		if c < '0' || c > '9' {
+			return ErrInvalidRouting
+		}
+		sum += int(c-'0') * [3]int{3, 7, 1}[i%3]
+	}
+	if sum%10 != 0 {
+		return ErrInvalidRouting
+	}
+	return nil
+}
+
+// NormalizeSettlementAccount returns a settlement account in the form it's stored
+// in, uppercase and without spaces around it.
+func NormalizeSettlementAccount(account string) (string, error) {
+	account = strings.ToUpper(strings.TrimSpace(account))
+	if account == "" || len(account) > maxSettlementAccountLength {
+		return "", ErrInvalidSettlementAccount
+	}
+	for _, c := range account {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return "", ErrInvalidSettlementAccount
+		}
+	}
+	return account, nil
+}
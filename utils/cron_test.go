@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Keeps the DST tests from depending on the system's zones
+)
+
+// bits returns the bit set of the values from lo to hi, every step.
+func bits(lo, hi, step int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Cron
+	}{
+		{"30 9 * * 1-5", Cron{
+			minute: bits(30, 30, 1), hour: bits(9, 9, 1), dom: bits(1, 31, 1), month: bits(1, 12, 1), dow: bits(1, 5, 1),
+			domStar: true,
+		}},
+		{"*/15 0,12 1 */3 7", Cron{
+			minute: bits(0, 59, 15), hour: bits(0, 0, 1) | bits(12, 12, 1), dom: bits(1, 1, 1), month: bits(1, 12, 3),
+			dow: bits(0, 0, 1) | bits(7, 7, 1), // Sunday as 7 matches Sunday as 0
+		}},
+		{"0 0 1-31/10 * *", Cron{
+			minute: bits(0, 0, 1), hour: bits(0, 0, 1), dom: bits(1, 31, 10), month: bits(1, 12, 1), dow: bits(0, 7, 1),
+			dowStar: true,
+		}},
+		// A value with a step starts a range up to the field's maximum
+		{"5/20 22-23 * 2,8 0", Cron{
+			minute: bits(5, 59, 20), hour: bits(22, 23, 1), dom: bits(1, 31, 1), month: bits(2, 2, 1) | bits(8, 8, 1), dow: bits(0, 0, 1),
+			domStar: true,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCron(tt.expr)
+		if err != nil || *got != tt.want {
+			t.Errorf("ParseCron(%q) = %+v, %v, want %+v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"-1 * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if c, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) = %+v, want an error", expr, c)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Fridays go on to Mondays
+		{"30 9 * * 1-5", time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)},
+		// Times are strictly after the given one
+		{"*/15 * * * *", time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC), time.Date(2026, 10, 18, 10, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 18, 10, 14, 59, 0, time.UTC), time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC)},
+		// Months without the day are skipped
+		{"0 0 31 * *", time.Date(2026, 9, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 12, 31, 23, 59, 30, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricted day fields match either day
+		{"0 8 13 * 5", time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 9, 8, 0, 0, 0, time.UTC)},
+		{"0 8 13 * 5", time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 13, 8, 0, 0, 0, time.UTC)},
+		// Day fields starting with a star match both days
+		{"0 8 */2 * 5", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 9, 8, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+// TestCronNextDST checks that expressions follow the wall clock of the
+// given time's location across daylight saving time changes.
+func TestCronNextDST(t *testing.T) {
+	nyc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks in Santiago spring forward at midnight
+	scl, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// 23 hours after the previous 9:00 when clocks spring forward
+		{"0 9 * * *", time.Date(2026, 3, 7, 9, 0, 0, 0, nyc), time.Date(2026, 3, 8, 9, 0, 0, 0, nyc)},
+		// 25 hours after it when they fall back
+		{"0 9 * * *", time.Date(2026, 10, 31, 9, 0, 0, 0, nyc), time.Date(2026, 11, 1, 9, 0, 0, 0, nyc)},
+		// Times that are skipped by the change don't match
+		{"30 2 * * *", time.Date(2026, 3, 7, 3, 0, 0, 0, nyc), time.Date(2026, 3, 9, 2, 30, 0, 0, nyc)},
+		{"0 3 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, nyc), time.Date(2026, 3, 8, 3, 0, 0, 0, nyc)},
+		{"0 12 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, scl), time.Date(2026, 9, 6, 12, 0, 0, 0, scl)},
+		{"0 0 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, scl), time.Date(2026, 9, 7, 0, 0, 0, 0, scl)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := c.Next(tt.from)
+		if !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+		if got.Location() != tt.from.Location() {
+			t.Errorf("%q.Next(%s) is in %s, want %s", tt.expr, tt.from, got.Location(), tt.from.Location())
+		}
+	}
+}
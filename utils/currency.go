@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// SettlementCurrency is the currency that net positions are converted to for settlement.
+const SettlementCurrency = "USD"
+
+// ParseRate reads an exchange rate, as stored in the fx_rates table.
+func ParseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate: %q", rate)
+	}
+	return r, nil
+}
+
+// Convert returns the amount times the rate, rounded half away from zero,
+// or ErrOverflow if the result doesn't fit.
+func Convert(amount Money, rate *big.Rat) (Money, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount)), rate)
+
+	// Add half a unit towards the sign, then truncate
+	half := big.NewRat(int64(product.Sign()), 2)
+	product.Add(product, half)
+
+	converted := new(big.Int).Quo(product.Num(), product.Denom())
+	if !converted.IsInt64() {
+		return 0, ErrOverflow
+	}
+	return Money(converted.Int64()), nil
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// RequestIdHeader carries the id of a request, set by the first service
+// handling it and passed on by the balancer, so that errors can be traced
+// across services.
+const RequestIdHeader = "Polka-Request-Id"
+
+// Codes of errors that aren't told apart by more than their status. Errors
+// clients act on, such as exceeded limits or frozen accounts, have their own.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthenticated  = "unauthenticated"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooLarge         = "too_large"
+	CodeUnprocessable    = "unprocessable"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal"
+	CodeNotImplemented   = "not_implemented"
+	CodeBadGateway       = "bad_gateway"
+	CodeUnavailable      = "unavailable"
+	CodeTimeout          = "timeout"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusTooManyRequests:       CodeTooManyRequests,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusNotImplemented:        CodeNotImplemented,
+	http.StatusBadGateway:            CodeBadGateway,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+	http.StatusGatewayTimeout:        CodeTimeout,
+}
+
+var (
+	// ErrInternal is answered instead of errors whose messages may hold
+	// details of the databases or other services, which are only logged.
+	ErrInternal = errors.New("internal error")
+	// ErrInvalidBody is answered for bodies that can't be decoded.
+	ErrInvalidBody = errors.New("invalid request body")
+	// ErrMethodNotAllowed is answered for methods a path doesn't handle.
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+type requestIdKey struct{}
+
+// Error is the json body of every error Polka's services answer with.
+// Details are only set for errors that have them, such as the bank and
+// account of a frozen account.
+type Error struct {
+	Code      string
+	Message   string
+	Details   map[string]string
+	RequestId string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeOf returns the code of errors with the HTTP status.
+func CodeOf(status int) string {
+	if code, exists := statusCodes[status]; exists {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// WriteError answers the request with err, coded by the status.
+func WriteError(w http.ResponseWriter, req *http.Request, status int, err error) {
+	WriteCodedError(w, req, status, CodeOf(status), err.Error(), nil)
+}
+
+// WriteCodedError answers the request with an error with its own code.
+func WriteCodedError(w http.ResponseWriter, req *http.Request, status int, code, message string, details map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestId: RequestIdOf(req.Context()),
+	})
+}
+
+// RequestIds gives every request an id, which is answered in RequestIdHeader
+// and passed on to the handlers, who get it with RequestIdOf. Requests that
+// already have one, as those forwarded by the balancer, keep it.
+func RequestIds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIdHeader)
+		if !IsValidId(id) {
+			id = NewId()
+			req.Header.Set(RequestIdHeader, id)
+		}
+		w.Header().Set(RequestIdHeader, id)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIdKey{}, id)))
+	})
+}
+
+// RequestIdOf returns the id of a request handled behind RequestIds.
+func RequestIdOf(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// NotFound answers requests to paths a service doesn't handle.
+func NotFound(w http.ResponseWriter, req *http.Request) {
+	WriteError(w, req, http.StatusNotFound, errors.New("no such path: "+req.URL.Path))
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+)
+
+var idPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// NewId returns a random version 4 UUID, used to identify payments.
+func NewId() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand failing means the system is unusable
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// IsValidId checks that the id is a lowercase UUID.
+func IsValidId(id string) bool {
+	return idPattern.MatchString(id)
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"errors"
+	"time"
+)
+
+// Statuses of a mandate. Active mandates execute on their schedule, paused ones
+// wait to be resumed, and ended or cancelled ones never execute again.
+const (
+	MandateActive    = "active"
+	MandatePaused    = "paused"
+	MandateEnded     = "ended"
+	MandateCancelled = "cancelled"
+)
+
+// Schedules of a mandate, besides cron expressions.
+const (
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+)
+
+// Statuses of an execution of a mandate. Pending executions were due,
+// but weren't stored yet, either because they're about to be or because
+// they failed and wait to be retried.
+const (
+	ExecutionPending  = "pending"
+	ExecutionExecuted = "executed"
+	ExecutionFailed   = "failed"
+)
+
+// Mandate is a standing order paying the same amount on a schedule.
+type Mandate struct {
+	Id       string // Assigned by the receiver
+	Sender   BankInfo
+	Receiver BankInfo
+	Amount   Money
+	Currency string    // Defaults to the settlement currency
+	Schedule string    // Weekly, monthly or a cron expression
+	StartAt  time.Time // Weekly and monthly mandates execute on the same weekday or day
+	EndAt    time.Time // Time after which it doesn't execute, unless zero
+	MaxCount int       // Number of executions after which it ends, unless zero
+	Status   string    // Assigned by the receiver
+	Count    int       // Number of executions due so far
+	NextAt   time.Time // Time of the next execution, zero once it ended
+}
+
+// MandateExecution is a single execution of a mandate, due at a time of its schedule.
+type MandateExecution struct {
+	MandateId string
+	DueAt     time.Time
+	Status    string
+	PaymentId string // Id of the payment it was executed as, if it was
+	Attempts  int
+	Reason    string // Why the last attempt failed, if it did
+}
+
+// Payment returns the payment executing the mandate at the given time.
+// The payment's time is the execution's due time, so that executing it
+// twice yields the same payment.
+func (m *Mandate) Payment(dueAt time.Time) *Payment {
+	return &Payment{
+		Sender:   m.Sender,
+		Receiver: m.Receiver,
+		Amount:   m.Amount,
+		Currency: m.Currency,
+		Time:     dueAt.UTC(),
+		Mandate:  m.Id,
+	}
+}
+
+// IsValidSchedule checks the schedule and bounds of the mandate.
+func (m *Mandate) IsValidSchedule() error {
+	if m.Schedule != ScheduleWeekly && m.Schedule != ScheduleMonthly {
+		if _, err := ParseCron(m.Schedule); err != nil {
+			return err
+		}
+	}
+	if m.MaxCount < 0 {
+		return errors.New("mandate can't have a negative maximum count")
+	}
+	if !m.EndAt.IsZero() && m.EndAt.Before(m.StartAt) {
+		return errors.New("mandate can't end before it starts")
+	}
+	return nil
+}
+
+// First returns the time of the first execution of the mandate,
+// or the zero time if it never executes.
+func (m *Mandate) First() time.Time {
+	return m.bound(m.after(m.start().Add(-time.Second)), 0)
+}
+
+// Next returns the time of the first execution after t, given the number
+// of executions so far, or the zero time if the mandate ends before it.
+func (m *Mandate) Next(t time.Time, count int) time.Time {
+	return m.bound(m.after(t), count)
+}
+
+// start returns the start time, to the second, since times are stored as such.
+func (m *Mandate) start() time.Time {
+	return m.StartAt.UTC().Truncate(time.Second)
+}
+
+// after returns the first time of the schedule after t, not earlier than the start.
+// Weekly and monthly times are counted from the start, so that they never drift.
+func (m *Mandate) after(t time.Time) time.Time {
+	start := m.start()
+
+	switch m.Schedule {
+	case ScheduleWeekly, ScheduleMonthly:
+		if t.Before(start) {
+			return start
+		}
+	default:
+		cron, err := ParseCron(m.Schedule)
+		if err != nil {
+			return time.Time{}
+		}
+		if t.Before(start) {
+			t = start.Add(-time.Second)
+		}
+		return cron.Next(t)
+	}
+
+	if m.Schedule == ScheduleWeekly {
+		weeks := int(t.Sub(start)/(7*24*time.Hour)) + 1
+		return start.AddDate(0, 0, 7*weeks)
+	}
+
+	months := (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+	next := addMonths(start, months)
+	for !next.After(t) {
+		months++
+		next = addMonths(start, months)
+	}
+	return next
+}
+
+// bound returns the time of the execution following count others,
+// or the zero time if the mandate ends before it.
+func (m *Mandate) bound(next time.Time, count int) time.Time {
+	if next.IsZero() {
+		return next
+	}
+	if m.MaxCount > 0 && count >= m.MaxCount {
+		return time.Time{}
+	}
+	if !m.EndAt.IsZero() && next.After(m.EndAt) {
+		return time.Time{}
+	}
+	return next
+}
+
+// addMonths moves t by n months, keeping its day unless the month is shorter,
+// in which case it moves to the month's last day.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// executions returns the times of up to n executions of a mandate,
+// each following the previous one, as the receiver schedules them.
+func executions(m *Mandate, n int) []time.Time {
+	var times []time.Time
+	for next := m.First(); !next.IsZero() && len(times) < n; next = m.Next(next, len(times)) {
+		times = append(times, next)
+	}
+	return times
+}
+
+func TestMandateExecutions(t *testing.T) {
+	day := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		mandate Mandate
+		want    []time.Time
+	}{
+		{
+			"weekly",
+			Mandate{Schedule: ScheduleWeekly, StartAt: day(2026, 10, 18, 9)},
+			[]time.Time{day(2026, 10, 18, 9), day(2026, 10, 25, 9), day(2026, 11, 1, 9), day(2026, 11, 8, 9)},
+		},
+		// Days missing from shorter months fall back to their last day,
+		// without drifting from the start
+		{
+			"monthly from the 31st",
+			Mandate{Schedule: ScheduleMonthly, StartAt: day(2026, 1, 31, 10)},
+			[]time.Time{day(2026, 1, 31, 10), day(2026, 2, 28, 10), day(2026, 3, 31, 10), day(2026, 4, 30, 10)},
+		},
+		{
+			"monthly across years",
+			Mandate{Schedule: ScheduleMonthly, StartAt: day(2027, 11, 29, 0)},
+			[]time.Time{day(2027, 11, 29, 0), day(2027, 12, 29, 0), day(2028, 1, 29, 0), day(2028, 2, 29, 0)},
+		},
+		// Cron mandates start with the first match from the start on
+		{
+			"cron",
+			Mandate{Schedule: "0 9 * * 1", StartAt: day(2026, 10, 18, 12)},
+			[]time.Time{day(2026, 10, 19, 9), day(2026, 10, 26, 9), day(2026, 11, 2, 9), day(2026, 11, 9, 9)},
+		},
+		{
+			"cron matching the start",
+			Mandate{Schedule: "0 9 * * 1", StartAt: day(2026, 10, 19, 9)},
+			[]time.Time{day(2026, 10, 19, 9), day(2026, 10, 26, 9), day(2026, 11, 2, 9), day(2026, 11, 9, 9)},
+		},
+		{
+			"maximum count",
+			Mandate{Schedule: ScheduleWeekly, StartAt: day(2026, 10, 18, 9), MaxCount: 2},
+			[]time.Time{day(2026, 10, 18, 9), day(2026, 10, 25, 9)},
+		},
+		// Executions at the end date are still due
+		{
+			"end date",
+			Mandate{Schedule: "0 9 * * 1", StartAt: day(2026, 10, 18, 12), EndAt: day(2026, 11, 2, 9)},
+			[]time.Time{day(2026, 10, 19, 9), day(2026, 10, 26, 9), day(2026, 11, 2, 9)},
+		},
+		{
+			"ends before the first execution",
+			Mandate{Schedule: "0 9 * * 1", StartAt: day(2026, 10, 18, 12), EndAt: day(2026, 10, 19, 8)},
+			nil,
+		},
+		// Starts are stored to the second
+		{
+			"start with nanoseconds",
+			Mandate{Schedule: ScheduleWeekly, StartAt: day(2026, 10, 18, 9).Add(500 * time.Millisecond)},
+			[]time.Time{day(2026, 10, 18, 9), day(2026, 10, 25, 9), day(2026, 11, 1, 9), day(2026, 11, 8, 9)},
+		},
+	}
+	for _, tt := range tests {
+		got := executions(&tt.mandate, 4)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: executions = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			if !got[i].Equal(want) {
+				t.Errorf("%s: execution %d = %s, want %s", tt.name, i+1, got[i], want)
+			}
+		}
+	}
+}
+
+// TestMandateNextCatchesUp checks the executions following a time long
+// after the previous one, as when the receiver was stopped.
+func TestMandateNextCatchesUp(t *testing.T) {
+	start := time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		schedule string
+		after    time.Time
+		want     time.Time
+	}{
+		{ScheduleWeekly, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC)},
+		{ScheduleWeekly, time.Date(2026, 3, 7, 10, 0, 0, 0, time.UTC), time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)},
+		{ScheduleMonthly, time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 6, 30, 10, 0, 0, 0, time.UTC)},
+		{ScheduleMonthly, time.Date(2026, 6, 30, 10, 0, 0, 0, time.UTC), time.Date(2026, 7, 31, 10, 0, 0, 0, time.UTC)},
+		{"0 12 * * *", time.Date(2026, 6, 15, 13, 0, 0, 0, time.UTC), time.Date(2026, 6, 16, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		m := &Mandate{Schedule: tt.schedule, StartAt: start}
+		if got := m.Next(tt.after, 1); !got.Equal(tt.want) {
+			t.Errorf("%s: Next(%s) = %s, want %s", tt.schedule, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestMandateIsValidSchedule(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		mandate Mandate
+		valid   bool
+	}{
+		{Mandate{Schedule: ScheduleWeekly, StartAt: start}, true},
+		{Mandate{Schedule: ScheduleMonthly, StartAt: start, EndAt: start.AddDate(1, 0, 0), MaxCount: 12}, true},
+		{Mandate{Schedule: "0 9 * * 1-5", StartAt: start}, true},
+		{Mandate{Schedule: "daily", StartAt: start}, false},
+		{Mandate{Schedule: "0 9 * *", StartAt: start}, false},
+		{Mandate{Schedule: ScheduleWeekly, StartAt: start, MaxCount: -1}, false},
+		{Mandate{Schedule: ScheduleWeekly, StartAt: start, EndAt: start.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.mandate.IsValidSchedule(); (err == nil) != tt.valid {
+			t.Errorf("IsValidSchedule(%+v) = %v, want valid %t", tt.mandate, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrOverflow is returned by operations whose result doesn't fit in Money.
+var ErrOverflow = errors.New("amount overflows")
+
+// Money is an amount in minor units, such as cents, of some currency.
+// Arithmetic on balances goes through its checked methods, so that
+// no balance ever silently wraps around.
+type Money int64
+
+// Add returns m + n, or ErrOverflow if the sum doesn't fit.
+func (m Money) Add(n Money) (Money, error) {
+	sum := m + n
+	if (n > 0 && sum < m) || (n < 0 && sum > m) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns m - n, or ErrOverflow if the difference doesn't fit.
+func (m Money) Sub(n Money) (Money, error) {
+	neg, err := n.Neg()
+	if err != nil {
+		return 0, err
+	}
+	return m.Add(neg)
+}
+
+// Neg returns -m, or ErrOverflow for the one amount that has no opposite.
+func (m Money) Neg() (Money, error) {
+	if m == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+	return -m, nil
+}
+
+// Sum adds up the amounts, or returns ErrOverflow if any partial sum doesn't fit.
+func Sum(amounts ...Money) (Money, error) {
+	var (
+		total Money
+		err   error
+	)
+	for _, amount := range amounts {
+		if total, err = total.Add(amount); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
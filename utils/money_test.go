@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		m, n    Money
+		want    Money
+		wantErr error
+	}{
+		{150, 250, 400, nil},
+		{150, -250, -100, nil},
+		{math.MaxInt64 - 1, 1, math.MaxInt64, nil},
+		{math.MaxInt64, 1, 0, ErrOverflow},
+		{math.MinInt64 + 1, -1, math.MinInt64, nil},
+		{math.MinInt64, -1, 0, ErrOverflow},
+		{math.MaxInt64, math.MinInt64, -1, nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.m.Add(tt.n)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("%d.Add(%d) = %d, %v, want %d, %v", tt.m, tt.n, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoneySub(t *testing.T) {
+	tests := []struct {
+		m, n    Money
+		want    Money
+		wantErr error
+	}{
+		{400, 250, 150, nil},
+		{-100, -250, 150, nil},
+		{math.MinInt64 + 1, 1, math.MinInt64, nil},
+		{math.MinInt64, 1, 0, ErrOverflow},
+		{math.MaxInt64, -1, 0, ErrOverflow},
+		// MinInt64 has no opposite, even when the difference would fit
+		{-1, math.MinInt64, 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := tt.m.Sub(tt.n)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("%d.Sub(%d) = %d, %v, want %d, %v", tt.m, tt.n, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		amounts []Money
+		want    Money
+		wantErr error
+	}{
+		{nil, 0, nil},
+		{[]Money{100, -30, 5}, 75, nil},
+		{[]Money{math.MaxInt64, -1, 1}, math.MaxInt64, nil},
+		// Partial sums are checked, even if the total would fit
+		{[]Money{math.MaxInt64, 1, -1}, 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := Sum(tt.amounts...)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("Sum(%v) = %d, %v, want %d, %v", tt.amounts, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		amount  Money
+		rate    string
+		want    Money
+		wantErr error
+	}{
+		{1000, "1", 1000, nil},
+		{1000, "1.0857", 1086, nil},
+		{3, "1.0857", 3, nil},
+		{5, "0.7331", 4, nil},
+		// Halves are rounded away from zero
+		{5, "0.5", 3, nil},
+		{-5, "0.5", -3, nil},
+		{7, "0.5", 4, nil},
+		{-7, "0.5", -4, nil},
+		{-5, "0.7331", -4, nil},
+		{0, "1.0857", 0, nil},
+		{math.MaxInt64, "1", math.MaxInt64, nil},
+		{math.MaxInt64, "1.0001", 0, ErrOverflow},
+		{math.MinInt64, "2", 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Convert(tt.amount, rate)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("Convert(%d, %s) = %d, %v, want %d, %v", tt.amount, tt.rate, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want *big.Rat
+	}{
+		{"1.0857", big.NewRat(10857, 10000)},
+		{"0.7331", big.NewRat(7331, 10000)},
+		{"0", nil},
+		{"-1.2", nil},
+		{"abc", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.rate)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("ParseRate(%q) = %s, want an error", tt.rate, got)
+		case tt.want != nil && (err != nil || got.Cmp(tt.want) != 0):
+			t.Errorf("ParseRate(%q) = %v, %v, want %s", tt.rate, got, err, tt.want)
+		}
+	}
+}
@@ -1,22 +1,119 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 )
 
-const maxPayment = 100000
+const maxPayment Money = 100000
+
+// Statuses of a payment. Received payments are held for review and only
+// reach the cache once validated. Validated payments are posted once the
+// cache applies them, included once they're part of a snapshot and settled
+// once that snapshot is settled.
+const (
+	PaymentReceived  = "received"
+	PaymentValidated = "validated"
+	PaymentPosted    = "posted"
+	PaymentIncluded  = "included"
+	PaymentSettled   = "settled"
+	PaymentReversed  = "reversed"
+	PaymentRejected  = "rejected"
+)
+
+// paymentTransitions maps each status to the statuses a payment can move to from it.
+var paymentTransitions = map[string][]string{
+	PaymentReceived:  {PaymentValidated, PaymentRejected},
+	PaymentValidated: {PaymentPosted, PaymentReversed, PaymentRejected},
+	PaymentPosted:    {PaymentIncluded, PaymentReversed},
+	PaymentIncluded:  {PaymentSettled, PaymentReversed},
+	PaymentSettled:   {PaymentReversed},
+}
+
+// PaymentEvent records a payment moving to a status.
+type PaymentEvent struct {
+	PaymentId string
+	Status    string
+	Time      time.Time
+	Detail    string // Why the payment moved, if there's more to say than the status
+}
 
 type Payment struct {
+	Id       string // Assigned by the receiver
 	Sender   BankInfo
 	Receiver BankInfo
-	Amount   int
+	Amount   Money
+	Currency string // Defaults to the settlement currency
 	Time     time.Time
+	Reverses string // Id of the reversed payment, if the payment is a reversal
+	Mandate  string // Id of the mandate the payment executes, if it does
+	Status   string // Assigned by the receiver
+	Reason   string // Why the payment was held for review, if it was
+}
+
+// IsPaymentStatus tells whether status is a status of a payment.
+func IsPaymentStatus(status string) bool {
+	switch status {
+	case PaymentReceived, PaymentValidated, PaymentPosted, PaymentIncluded,
+		PaymentSettled, PaymentReversed, PaymentRejected:
+		return true
+	}
+	return false
+}
+
+// IsApplied tells whether a payment with the given status moved money,
+// which it does from its validation until it's reversed.
+func IsApplied(status string) bool {
+	switch status {
+	case PaymentValidated, PaymentPosted, PaymentIncluded, PaymentSettled:
+		return true
+	}
+	return false
+}
+
+// StatusesBefore returns the statuses a payment can move to status from.
+func StatusesBefore(status string) []string {
+	var from []string
+	for source, targets := range paymentTransitions {
+		for _, target := range targets {
+			if target == status {
+				from = append(from, source)
+			}
+		}
+	}
+	return from
+}
+
+// PaymentPage is a page of payment search results.
+// NextCursor is empty on the last page.
+type PaymentPage struct {
+	Payments   []Payment
+	NextCursor string
+}
+
+// BatchResult reports the outcome of each payment in a batch, in order.
+type BatchResult struct {
+	BatchId string
+	Results []BatchItemResult
+}
+
+// BatchItemResult holds either the id and status assigned to a payment
+// in a batch or the reason it was rejected, with a code if it has one.
+type BatchItemResult struct {
+	Index  int
+	Id     string
+	Status string
+	Code   string
+	Error  string
 }
 
 type BankInfo struct {
 	Name    string
 	Account int
+	Alias   string // Email or phone number a payment's receiver can be given by instead
 }
 
 func (ct *Payment) IsValidPayment() error {
@@ -28,3 +125,26 @@ func (ct *Payment) IsValidPayment() error {
 	}
 	return nil
 }
+
+// DefaultCurrency sets the currency of payments sent without one to the settlement currency.
+func (ct *Payment) DefaultCurrency() {
+	if ct.Currency == "" {
+		ct.Currency = SettlementCurrency
+	}
+}
+
+// Hash returns a hex-encoded digest of the payment's fields, except the id.
+// Two requests carrying the same payment have the same hash.
+func (ct *Payment) Hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s|%d|%s|%d|%d|%s|%s",
+		ct.Sender.Name,
+		ct.Sender.Account,
+		ct.Receiver.Name,
+		ct.Receiver.Account,
+		ct.Amount,
+		ct.Currency,
+		ct.Time.UTC().Format(time.RFC3339Nano),
+	)))
+	return hex.EncodeToString(sum[:])
+}
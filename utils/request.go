@@ -0,0 +1,37 @@
+package utils
+
+import "time"
+
+// Statuses of a payment request. Requests wait for the payer to accept
+// or decline them, and expire if the payer does neither in time.
+const (
+	RequestRequested = "requested"
+	RequestAccepted  = "accepted"
+	RequestDeclined  = "declined"
+	RequestExpired   = "expired"
+)
+
+// PaymentRequest is a request for money made by the account to be paid.
+type PaymentRequest struct {
+	Id        string // Assigned by the receiver
+	Payee     BankInfo
+	Payer     BankInfo
+	Amount    Money
+	Currency  string // Defaults to the settlement currency
+	Memo      string
+	Status    string // Assigned by the receiver
+	PaymentId string // Id of the payment made on acceptance, if it was accepted
+	CreatedAt time.Time
+	ExpiresAt time.Time // Defaults to a week after its creation
+}
+
+// Payment returns the payment made when the request is accepted at the given time.
+func (r *PaymentRequest) Payment(at time.Time) *Payment {
+	return &Payment{
+		Sender:   r.Payer,
+		Receiver: r.Payee,
+		Amount:   r.Amount,
+		Currency: r.Currency,
+		Time:     at.UTC(),
+	}
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "time"
+
+// Statuses of a scheduled payment. Scheduled payments are executed once,
+// unless they're cancelled before their execution time.
+const (
+	ScheduleWaiting   = "scheduled"
+	ScheduleExecuted  = "executed"
+	ScheduleCancelled = "cancelled"
+	ScheduleFailed    = "failed"
+)
+
+// ScheduledPayment is a payment submitted now and executed at a later time.
+type ScheduledPayment struct {
+	Id        string // Assigned by the receiver
+	Sender    BankInfo
+	Receiver  BankInfo
+	Amount    Money
+	Currency  string // Defaults to the settlement currency
+	ExecuteAt time.Time
+	Status    string // Assigned by the receiver
+	PaymentId string // Id of the payment it was executed as, if it was
+	Reason    string // Why the last execution attempt failed, if it did
+}
+
+// Payment returns the payment the scheduled payment is executed as.
+// The payment's time is the execution time, so that executing the
+// same scheduled payment twice yields the same payment.
+func (s *ScheduledPayment) Payment() *Payment {
+	return &Payment{
+		Sender:   s.Sender,
+		Receiver: s.Receiver,
+		Amount:   s.Amount,
+		Currency: s.Currency,
+		Time:     s.ExecuteAt.UTC(),
+	}
+}
@@ -5,44 +5,64 @@ import (
 	"time"
 )
 
-// SnapBank stores bank data relevant to a snapshot.
+// SnapBank stores bank data relevant to a snapshot, in a single currency.
 // SnapBank does not include the bank's id or name.
 type SnapBank struct {
-	Balance  int64
-	Accounts map[uint32]int32
+	Balance  Money
+	Accounts map[uint32]Money
+}
+
+// SnapCurrency stores all banks' positions in a single currency,
+// which net to zero, and the rate converting it to the settlement currency.
+type SnapCurrency struct {
+	Rate  string
+	Banks map[string]*SnapBank
 }
 
 // snapshot stores a synchronized snapshort of all balances.
 // It stores integers and not pointers, since there's no need
-// for concurrent access. Banks is nil unless a snapshot has
-// been requested.
+// for concurrent access. Currencies is nil unless a snapshot
+// has been requested. Settlement holds each bank's positions
+// in all currencies, converted to the settlement currency.
 type Snapshot struct {
-	Banks     map[string]*SnapBank
-	Timestamp time.Time
+	Id                 string
+	Updates            []string // Ids of the payment and batch updates the snapshot covers
+	Currencies         map[string]*SnapCurrency
+	SettlementCurrency string
+	Settlement         map[string]Money
+	Timestamp          time.Time
 }
 
 func (snap *Snapshot) Print() {
 
 	fmt.Println("Snapshot: ")
-	fmt.Printf("Bank balances: \n{\n")
 
-	// Print bank balances
-	for name, bnk := range snap.Banks {
-		// NB bnk.Balance is a pointer to an int
-		fmt.Printf("\t%s: %d\n", name, bnk.Balance)
-	}
-	fmt.Println("}")
+	for currency, snapCur := range snap.Currencies {
+		fmt.Printf("Bank balances in %s: \n{\n", currency)
 
-	// If specified, print account balances
-	fmt.Printf("}\nAccount balances:\n{\n")
+		// Print bank balances
+		for name, bnk := range snapCur.Banks {
+			fmt.Printf("\t%s: %d\n", name, bnk.Balance)
+		}
+		fmt.Println("}")
+
+		fmt.Printf("Account balances in %s:\n{\n", currency)
 
-	for name, bnk := range snap.Banks {
-		fmt.Printf("\t%s: {\n", name)
+		for name, bnk := range snapCur.Banks {
+			fmt.Printf("\t%s: {\n", name)
 
-		for account, amount := range bnk.Accounts {
-			fmt.Printf("\t\t%d: %d\n", account, amount)
+			for account, amount := range bnk.Accounts {
+				fmt.Printf("\t\t%d: %d\n", account, amount)
+			}
+			fmt.Println("\t}")
 		}
-		fmt.Println("\t}")
+		fmt.Println("}")
+	}
+
+	// Print the settlement
+	fmt.Printf("Settlement in %s: \n{\n", snap.SettlementCurrency)
+	for name, balance := range snap.Settlement {
+		fmt.Printf("\t%s: %d\n", name, balance)
 	}
 	fmt.Println("}")
 }
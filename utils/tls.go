@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Roles of Polka's services in their certificates, which is the subject's
+// organizational unit. Banks and operators calling the balancer or the
+// settler have certificates with the RoleBank and RoleOperator units.
+const (
+	PeerBalancer = "balancer"
+	PeerReceiver = "receiver"
+	PeerCache    = "cache"
+	PeerSettler  = "settler"
+)
+
+// Variables configuring mutual TLS. TLS is off when none of them are set.
+const (
+	TLSCertEnv = "TLSCERT"
+	TLSKeyEnv  = "TLSKEY"
+	TLSCAEnv   = "TLSCA"
+)
+
+// tlsCheckInterval is how often the files are checked for changes, at most.
+const tlsCheckInterval = time.Second
+
+// ErrUnexpectedPeer is returned when a peer's certificate doesn't have any of the expected roles.
+var ErrUnexpectedPeer = errors.New("peer certificate doesn't have an expected role")
+
+// TLSFiles holds a certificate, its key and the CA certificates its peers are
+// verified against. The files are read again whenever they change, so that
+// certificates can be rotated without restarting services.
+type TLSFiles struct {
+	CertPath string
+	KeyPath  string
+	CAPath   string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  [3]time.Time
+	checkedAt time.Time
+}
+
+// LoadTLSFiles reads a certificate, its key and the CA certificates.
+func LoadTLSFiles(certPath, keyPath, caPath string) (*TLSFiles, error) {
+	tf := &TLSFiles{CertPath: certPath, KeyPath: keyPath, CAPath: caPath}
+	if err := tf.reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// TLSFromEnv loads the files named by the TLS variables, and returns
+// nil if none are set, in which case services talk plain HTTP.
+func TLSFromEnv() (*TLSFiles, error) {
+	certPath, keyPath, caPath := os.Getenv(TLSCertEnv), os.Getenv(TLSKeyEnv), os.Getenv(TLSCAEnv)
+
+	if certPath == "" && keyPath == "" && caPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" || caPath == "" {
+		return nil, fmt.Errorf("%s, %s and %s must all be set to use TLS", TLSCertEnv, TLSKeyEnv, TLSCAEnv)
+	}
+	return LoadTLSFiles(certPath, keyPath, caPath)
+}
+
+// ServerConfig returns the config of a listener only accepting clients
+// whose certificates have one of the given roles. It's nil for nil files.
+func (tf *TLSFiles) ServerConfig(peers ...string) *tls.Config {
+	if tf == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Client certificates are verified against the current CA in VerifyConnection
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := tf.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return tf.verifyPeer(cs, "", x509.ExtKeyUsageClientAuth, peers)
+		},
+	}
+}
+
+// ClientConfig returns the config of a client only talking to servers
+// whose certificates have one of the given roles. It's nil for nil files.
+func (tf *TLSFiles) ClientConfig(peers ...string) *tls.Config {
+	if tf == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Server certificates are verified against the current CA in VerifyConnection
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := tf.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return tf.verifyPeer(cs, cs.ServerName, x509.ExtKeyUsageServerAuth, peers)
+		},
+	}
+}
+
+// PeerRoles returns the roles of a certificate.
+func PeerRoles(cert *x509.Certificate) []string {
+	return cert.Subject.OrganizationalUnit
+}
+
+// verifyPeer verifies the peer's certificate chain against the current CA,
+// as well as its host name if set, and checks that it has an expected role.
+func (tf *TLSFiles) verifyPeer(cs tls.ConnectionState, host string, usage x509.ExtKeyUsage, peers []string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer sent no certificate")
+	}
+	_, pool := tf.current()
+
+	leaf := cs.PeerCertificates[0]
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return err
+	}
+
+	for _, role := range PeerRoles(leaf) {
+		for _, peer := range peers {
+			if role == peer {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %q has roles %v, expected one of %v", ErrUnexpectedPeer, leaf.Subject.CommonName, PeerRoles(leaf), peers)
+}
+
+// current returns the certificate and CA pool, reading the files again
+// if they changed. The previous ones are kept if the new files are invalid,
+// for instance while they're being replaced.
+func (tf *TLSFiles) current() (*tls.Certificate, *x509.CertPool) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	if time.Since(tf.checkedAt) >= tlsCheckInterval {
+		tf.checkedAt = time.Now()
+		if modTimes, err := tf.stat(); err == nil && modTimes != tf.modTimes {
+			tf.load()
+		}
+	}
+	return tf.cert, tf.pool
+}
+
+// reload reads the files under the lock.
+func (tf *TLSFiles) reload() error {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+
+	tf.checkedAt = time.Now()
+	return tf.load()
+}
+
+// load reads the files, only replacing the certificate and CA pool if all are valid.
+func (tf *TLSFiles) load() error {
+	modTimes, err := tf.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(tf.CertPath, tf.KeyPath)
+	if err != nil {
+		return err
+	}
+	caPem, err := os.ReadFile(tf.CAPath)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		return fmt.Errorf("no CA certificates in %s", tf.CAPath)
+	}
+
+	tf.cert, tf.pool, tf.modTimes = &cert, pool, modTimes
+	return nil
+}
+
+// stat returns the modification times of the files.
+func (tf *TLSFiles) stat() (modTimes [3]time.Time, err error) {
+	for i, path := range []string{tf.CertPath, tf.KeyPath, tf.CAPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}